@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+// checkSSHConfig checks the SSH config of the given [user@]hosts, or of all
+// Host stanzas, without connecting, exiting non-zero if problems are found.
+func checkSSHConfig(args []string) {
+	hosts := args
+	if len(hosts) == 0 {
+		var err error
+		if hosts, err = ssh_config.Hosts(); err != nil {
+			log.Fatalf("Could not read SSH config: %v", err)
+		}
+		if len(hosts) == 0 {
+			log.Fatalf("No hosts found in SSH config.")
+		}
+	}
+
+	failed := false
+	for _, h := range hosts {
+		user, host, ok := strings.Cut(h, "@")
+		if !ok {
+			user, host = "", user
+		}
+		problems := ssh_config.Check(host, user)
+		if len(problems) == 0 {
+			printCheck(&check{name: h, result: pass, detail: "ok"})
+			continue
+		}
+		for _, p := range problems {
+			c := &check{name: h, result: fail, detail: p.Msg}
+			if p.Warning {
+				c.result = warn
+			}
+			failed = failed || c.result == fail
+			printCheck(c)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
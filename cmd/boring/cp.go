@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+	"github.com/pkg/sftp"
+)
+
+// fileSystem abstracts over the local and the remote side of a copy
+type fileSystem interface {
+	Stat(p string) (fs.FileInfo, error)
+	ReadDir(p string) ([]fs.FileInfo, error)
+	Open(p string) (io.ReadCloser, error)
+	Create(p string, perm fs.FileMode) (io.WriteCloser, error)
+	Mkdir(p string, perm fs.FileMode) error
+	Join(elem ...string) string
+	Base(p string) string
+}
+
+type localFS struct{}
+
+func (localFS) Stat(p string) (fs.FileInfo, error)   { return os.Stat(p) }
+func (localFS) Open(p string) (io.ReadCloser, error) { return os.Open(p) }
+func (localFS) Join(elem ...string) string           { return filepath.Join(elem...) }
+func (localFS) Base(p string) string                 { return filepath.Base(p) }
+
+func (localFS) ReadDir(p string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		i, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, i)
+	}
+	return infos, nil
+}
+
+func (localFS) Create(p string, perm fs.FileMode) (io.WriteCloser, error) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	// The mode is subject to the umask, and not changed for existing files
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localFS) Mkdir(p string, perm fs.FileMode) error {
+	if err := os.Mkdir(p, perm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Chmod(p, perm)
+}
+
+type remoteFS struct {
+	*sftp.Client
+}
+
+func (r remoteFS) Open(p string) (io.ReadCloser, error) { return r.Client.Open(p) }
+func (r remoteFS) Base(p string) string                 { return path.Base(p) }
+
+func (r remoteFS) Create(p string, perm fs.FileMode) (io.WriteCloser, error) {
+	f, err := r.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r remoteFS) Mkdir(p string, perm fs.FileMode) error {
+	if err := r.Client.Mkdir(p); err != nil {
+		if i, serr := r.Stat(p); serr != nil || !i.IsDir() {
+			return err
+		}
+	}
+	return r.Chmod(p, perm)
+}
+
+// copier copies files and directories from src to dst, keeping their
+// permissions
+type copier struct {
+	src, dst  fileSystem
+	recursive bool
+	files     int
+	bytes     int64
+}
+
+// copyFiles copies files between the local machine and a host over SFTP,
+// like scp. The remote side is given as [user@]host:path and connected to
+// the same way tunnels connect to their host.
+func copyFiles(args []string) {
+	var recursive bool
+	var paths []string
+	for _, a := range args {
+		switch a {
+		case "-r", "--recursive":
+			recursive = true
+		default:
+			paths = append(paths, a)
+		}
+	}
+	if len(paths) != 2 {
+		log.Fatalf("'cp' requires exactly one source and one destination argument.")
+	}
+	srcHost, srcPath := splitRemote(paths[0])
+	dstHost, dstPath := splitRemote(paths[1])
+	if (srcHost == "") == (dstHost == "") {
+		log.Fatalf("Exactly one of source and destination must be remote, " +
+			"given as '[user@]host:path'.")
+	}
+	host := cmp.Or(srcHost, dstHost)
+
+	c, err := tunnel.Connect(host)
+	if err != nil {
+		log.Fatalf("Could not connect to '%s': %v", host, err)
+	}
+	defer c.Close()
+	client, err := sftp.NewClient(c.Client)
+	if err != nil {
+		log.Fatalf("Could not start SFTP on '%s': %v", host, err)
+	}
+	defer client.Close()
+
+	cp := &copier{src: localFS{}, dst: remoteFS{client}, recursive: recursive}
+	if srcHost != "" {
+		cp.src, cp.dst = cp.dst, cp.src
+	}
+	if err := cp.copy(srcPath, dstPath); err != nil {
+		log.Fatalf("Could not copy: %v", err)
+	}
+	log.Infof("Copied %d file(s), %d bytes.", cp.files, cp.bytes)
+}
+
+// splitRemote splits a [user@]host:path argument. Like scp, a colon
+// following a slash belongs to a local path.
+func splitRemote(arg string) (host, p string) {
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.ContainsAny(arg[:i], `/\`) {
+		return "", arg
+	}
+	if runtime.GOOS == "windows" && i == 1 {
+		// Drive letter
+		return "", arg
+	}
+	if p = arg[i+1:]; p == "" {
+		// The remote home directory
+		p = "."
+	}
+	return arg[:i], p
+}
+
+// copy copies src to dst, or into dst if it is an existing directory
+func (c *copier) copy(src, dst string) error {
+	info, err := c.src.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !c.recursive {
+		return fmt.Errorf("%s is a directory, use -r to copy it", src)
+	}
+	if d, err := c.dst.Stat(dst); err == nil && d.IsDir() {
+		dst = c.dst.Join(dst, c.src.Base(src))
+	}
+	return c.copyEntry(src, dst, info)
+}
+
+func (c *copier) copyEntry(src, dst string, info fs.FileInfo) error {
+	switch {
+	case info.IsDir():
+		return c.copyDir(src, dst, info)
+	case info.Mode().IsRegular():
+		return c.copyFile(src, dst, info)
+	default:
+		log.Warningf("Skipping %s, not a regular file or directory.", src)
+		return nil
+	}
+}
+
+func (c *copier) copyDir(src, dst string, info fs.FileInfo) error {
+	if err := c.dst.Mkdir(dst, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("could not create %s: %v", dst, err)
+	}
+	entries, err := c.src.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// Names come from the remote side when copying from it, a
+		// malicious server could make them point anywhere
+		target := c.dst.Join(dst, e.Name())
+		if !plainName(e.Name()) || c.dst.Join(target, "..") != c.dst.Join(dst) {
+			return fmt.Errorf("refusing to copy %q in %s, not a plain file name", e.Name(), src)
+		}
+		if err := c.copyEntry(c.src.Join(src, e.Name()), target, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plainName reports whether name names an entry within a directory,
+// rather than the directory itself, its parent or a path
+func plainName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// copyFile streams src to dst, so that large files are not held in memory
+func (c *copier) copyFile(src, dst string, info fs.FileInfo) error {
+	r, err := c.src.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := c.dst.Create(dst, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", dst, err)
+	}
+	n, err := io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("could not copy %s: %v", src, err)
+	}
+	log.Debugf("Copied %s to %s", src, dst)
+	c.files++
+	c.bytes += n
+	return nil
+}
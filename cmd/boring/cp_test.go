@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// namedInfo is a directory entry as listed by a server
+type namedInfo struct {
+	name string
+	dir  bool
+}
+
+func (i namedInfo) Name() string       { return i.name }
+func (i namedInfo) Size() int64        { return 0 }
+func (i namedInfo) ModTime() time.Time { return time.Time{} }
+func (i namedInfo) IsDir() bool        { return i.dir }
+func (i namedInfo) Sys() any           { return nil }
+
+func (i namedInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// listingFS lists the given names in every directory
+type listingFS struct {
+	localFS
+	names []string
+}
+
+func (l listingFS) ReadDir(string) ([]fs.FileInfo, error) {
+	infos := make([]fs.FileInfo, len(l.names))
+	for i, n := range l.names {
+		infos[i] = namedInfo{name: n}
+	}
+	return infos, nil
+}
+
+func TestCopyDirRejectsPaths(t *testing.T) {
+	for _, name := range []string{"..", ".", "../escaped", "/tmp/escaped", `..\escaped`, ""} {
+		dst := filepath.Join(t.TempDir(), "dst")
+		c := &copier{src: listingFS{names: []string{name}}, dst: localFS{}, recursive: true}
+		err := c.copyDir("dir", dst, namedInfo{name: "dir", dir: true})
+		if err == nil || !strings.Contains(err.Error(), "refusing") {
+			t.Errorf("%q: expected refusal, got %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "escaped")); err == nil {
+			t.Errorf("%q: wrote outside of the destination", name)
+		}
+	}
+}
@@ -31,6 +31,15 @@ func (e *compatError) Error() string {
 		e.daemonHash, e.cliHash)
 }
 
+// tokenError indicates that the token for commands could not be read
+type tokenError struct {
+	err error
+}
+
+func (e *tokenError) Error() string {
+	return fmt.Sprintf("could not read token: %v", e.err)
+}
+
 // connectDaemon connects to the daemon on the default socket
 func connectDaemon() (net.Conn, error) {
 	return net.Dial("unix", daemon.Socket)
@@ -41,7 +50,7 @@ func connectDaemon() (net.Conn, error) {
 // also check if the daemon is compatible with the CLI binary, and
 // relaunch it if necessary.
 func ensureDaemon(ctx context.Context) error {
-	launching := false
+	launching, reexeced := false, false
 	wait := time.NewTimer(0)
 	waitTime := 4 * time.Millisecond
 
@@ -54,6 +63,11 @@ func ensureDaemon(ctx context.Context) error {
 			if err == nil {
 				return nil
 			}
+			// Retrying or launching a daemon would not help
+			var te *tokenError
+			if errors.As(err, &te) {
+				return err
+			}
 			var ce *compatError
 			if errors.As(err, &ce) {
 				b := "unknown daemon build"
@@ -63,6 +77,18 @@ func ensureDaemon(ctx context.Context) error {
 				}
 				log.Infof("Detected %s (CLI: %s#%s%s), restarting daemon...",
 					b, log.Green, ce.cliHash, log.Reset)
+				// First try to let the daemon replace itself, keeping its
+				// tunnels. Old daemons might not support this, and the new
+				// process could still be incompatible, so only try once.
+				if !reexeced {
+					reexeced = true
+					err := reexecDaemon()
+					if err == nil {
+						wait.Reset(waitTime)
+						continue
+					}
+					log.Debugf("Could not re-exec daemon: %v", err)
+				}
 				// Terminate and wait for restart
 				if err := killDaemon(ctx); err != nil {
 					info := "Please kill the old daemon process manually (e.g., `killall boring`)." +
@@ -89,12 +115,27 @@ func ensureDaemon(ctx context.Context) error {
 }
 
 func sendCmd(cmd daemon.Cmd) (*daemon.Resp, error) {
+	resp, err := sendCmdOnce(cmd)
+	// A daemon that just started may have replaced the token after we read it
+	if err == nil && !resp.Success && resp.Error == daemon.InvalidToken.Error() &&
+		daemon.TokenFile != "" {
+		resp, err = sendCmdOnce(cmd)
+	}
+	return resp, err
+}
+
+func sendCmdOnce(cmd daemon.Cmd) (*daemon.Resp, error) {
 	conn, err := connectDaemon()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
+	// Read as late as possible, see the retry in sendCmd
+	if cmd.Token, err = daemon.ReadToken(); err != nil {
+		return nil, &tokenError{err}
+	}
+
 	var resp daemon.Resp
 	if err := ipc.Write(cmd, conn); err != nil {
 		return nil, err
@@ -124,6 +165,19 @@ func probeDaemon() error {
 	return nil
 }
 
+// reexecDaemon asks the daemon to replace itself by the current executable,
+// handing over its socket and running tunnels
+func reexecDaemon() error {
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Reexec})
+	if err != nil {
+		return fmt.Errorf("could not send re-exec command: %v", err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
+
 // killDaemon sends a shutdown command to the daemon and waits for it to exit
 func killDaemon(ctx context.Context) error {
 	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Shutdown})
@@ -153,9 +207,9 @@ func killDaemon(ctx context.Context) error {
 
 // launchDaemon starts a new daemon process, invoking the OS-specific launch function.
 func launchDaemon() error {
-	ex, err := os.Executable()
+	ex, err := daemon.Executable()
 	if err != nil {
-		return fmt.Errorf("could not determine executable path: %v", err)
+		return err
 	}
 	pid, err := launchDaemonOS(ex, daemon.Flag)
 	if err != nil {
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/table"
+)
+
+// debugDaemon prints runtime stats of the running daemon, and a dump of
+// its goroutines with --goroutines. Does not start a daemon.
+func debugDaemon(args []string) {
+	cmd := daemon.Cmd{Kind: daemon.Debug}
+	for _, a := range args {
+		if a != "--goroutines" {
+			log.Fatalf("Unknown argument '%s'.", a)
+		}
+		cmd.Dump = true
+	}
+
+	resp, err := sendCmd(cmd)
+	if err != nil {
+		log.Exitf(exitDaemon, "Daemon not reachable: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Could not get daemon stats: %s", resp.Error)
+	}
+	s := resp.Stats
+	if s == nil {
+		log.Fatalf("Daemon does not support stats, restart it.")
+	}
+
+	files := "unknown"
+	if s.OpenFiles >= 0 {
+		files = fmt.Sprint(s.OpenFiles)
+	}
+	log.Emitf("Commit      %s\n", resp.Info.Commit)
+	log.Emitf("Tunnels     %d\n", s.Tunnels)
+	log.Emitf("Goroutines  %d\n", s.Goroutines)
+	log.Emitf("Open files  %s\n", files)
+
+	if len(s.Conns) > 0 {
+		tbl := table.New("Name", "Conns")
+		for _, n := range slices.Sorted(maps.Keys(s.Conns)) {
+			tbl.AddRow(n, s.Conns[n])
+		}
+		log.Emitf("\n%v", tbl)
+	}
+	if s.Dump != "" {
+		log.Emitf("\n%s", strings.TrimRight(s.Dump, "\n")+"\n")
+	}
+}
@@ -0,0 +1,177 @@
+//
+// Diagnostics for common setup problems, works without a running daemon.
+//
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/alebeck/boring/internal/agent"
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+type checkResult int
+
+const (
+	pass checkResult = iota
+	warn
+	fail
+)
+
+type check struct {
+	name   string
+	run    func() (checkResult, string)
+	result checkResult
+	detail string
+}
+
+func runDoctor() {
+	checks := []*check{
+		{name: "Daemon", run: checkDaemon},
+		{name: "Socket", run: checkSocket},
+		{name: "Socket directory", run: func() (checkResult, string) {
+			return checkDirWritable(filepath.Dir(daemon.Socket))
+		}},
+		{name: "Log directory", run: func() (checkResult, string) {
+			return checkDirWritable(filepath.Dir(daemon.LogFile))
+		}},
+		{name: "Config", run: checkConfig},
+		{name: "SSH agent", run: checkAgent},
+		{name: "Key files", run: checkKeyFiles},
+		{name: "Known hosts", run: checkKnownHosts},
+	}
+
+	failed := false
+	for _, c := range checks {
+		c.result, c.detail = c.run()
+		failed = failed || c.result == fail
+		printCheck(c)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printCheck(c *check) {
+	var label string
+	switch c.result {
+	case pass:
+		label = log.Bold + log.Green + "pass" + log.Reset
+	case warn:
+		label = log.Bold + log.Yellow + "warn" + log.Reset
+	default:
+		label = log.Bold + log.Red + "fail" + log.Reset
+	}
+	log.Emitf("%s  %-17s %s\n", label, c.name, c.detail)
+}
+
+func checkDaemon() (checkResult, string) {
+	err := probeDaemon()
+	if err == nil {
+		return pass, fmt.Sprintf("reachable at %s", daemon.Socket)
+	}
+	var ce *compatError
+	if errors.As(err, &ce) {
+		return warn, fmt.Sprintf("running, but %v; it will be restarted on next use", err)
+	}
+	return warn, fmt.Sprintf("not running (%v); it will be started on demand", err)
+}
+
+func checkSocket() (checkResult, string) {
+	info, err := os.Lstat(daemon.Socket)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pass, fmt.Sprintf("%s does not exist yet", daemon.Socket)
+	}
+	if err != nil {
+		return fail, fmt.Sprintf("cannot stat %s: %v", daemon.Socket, err)
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fail, fmt.Sprintf("%s is not a socket, please remove it", daemon.Socket)
+	}
+	if info.Mode().Perm()&0o002 != 0 {
+		return warn, fmt.Sprintf("%s is world-writable (%v), other users can control the daemon",
+			daemon.Socket, info.Mode().Perm())
+	}
+	return pass, fmt.Sprintf("%s (%v)", daemon.Socket, info.Mode().Perm())
+}
+
+// checkDirWritable verifies that files can be created in dir
+func checkDirWritable(dir string) (checkResult, string) {
+	f, err := os.CreateTemp(dir, ".boring-doctor-*")
+	if err != nil {
+		return fail, fmt.Sprintf("%s is not writable: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return pass, fmt.Sprintf("%s is writable", dir)
+}
+
+func checkConfig() (checkResult, string) {
+	conf, err := config.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return warn, fmt.Sprintf("%s does not exist, run 'boring edit' to create it", config.Path)
+	}
+	if err != nil {
+		return fail, err.Error()
+	}
+	return pass, fmt.Sprintf("%s (%d tunnels)", config.Path, len(conf.Tunnels))
+}
+
+func checkAgent() (checkResult, string) {
+	if os.Getenv("BORING_NO_AGENT") != "" {
+		return pass, "disabled by BORING_NO_AGENT"
+	}
+	sigs, err := agent.GetSigners()
+	if err != nil {
+		if os.Getenv("BORING_REQUIRE_AGENT") != "" {
+			return fail, fmt.Sprintf("required by BORING_REQUIRE_AGENT, but not available: %v", err)
+		}
+		return warn, fmt.Sprintf("not available: %v", err)
+	}
+	if len(sigs) == 0 {
+		return warn, "running, but holds no keys"
+	}
+	return pass, fmt.Sprintf("running, %d key(s)", len(sigs))
+}
+
+func checkKeyFiles() (checkResult, string) {
+	var found []string
+	for _, k := range ssh_config.DefaultIdentities() {
+		p := paths.ReplaceTilde(k)
+		f, err := os.Open(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fail, fmt.Sprintf("%s is not readable: %v", k, err)
+		}
+		f.Close()
+		found = append(found, k)
+	}
+	if len(found) == 0 {
+		return warn, "no default key files found, make sure keys are set via 'IdentityFile' or the agent"
+	}
+	return pass, fmt.Sprintf("found %v", found)
+}
+
+func checkKnownHosts() (checkResult, string) {
+	p := paths.ReplaceTilde("~/.ssh/known_hosts")
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return warn, "~/.ssh/known_hosts does not exist, host keys cannot be verified"
+	}
+	if err != nil {
+		return fail, fmt.Sprintf("~/.ssh/known_hosts is not readable: %v", err)
+	}
+	f.Close()
+	return pass, "~/.ssh/known_hosts is readable"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alebeck/boring/internal/daemon"
+)
+
+// Exit codes, documented in the README, so that scripts can tell why
+// a command failed
+const (
+	exitFailure  = 1 // any other failure
+	exitNotFound = 2 // no (running) tunnel matches
+	exitDaemon   = 3 // daemon could not be started or reached
+	exitAuth     = 4 // SSH authentication failed
+	exitHostKey  = 5 // host key unknown, changed or revoked
+	exitBind     = 6 // tunnel address could not be bound
+	exitConnect  = 7 // SSH server could not be reached
+	exitConfig   = 8 // SSH config invalid or unsupported
+)
+
+var kindCodes = map[daemon.ErrKind]int{
+	daemon.ErrNotFound: exitNotFound,
+	daemon.ErrAuth:     exitAuth,
+	daemon.ErrHostKey:  exitHostKey,
+	daemon.ErrBind:     exitBind,
+	daemon.ErrConnect:  exitConnect,
+	daemon.ErrConfig:   exitConfig,
+}
+
+// opError indicates a failed operation, which was already logged
+type opError struct {
+	code int
+}
+
+func (e *opError) Error() string {
+	return fmt.Sprintf("operation failed (exit code %d)", e.code)
+}
+
+// respError is the error of a failed daemon response
+func respError(resp *daemon.Resp) *opError {
+	if c, ok := kindCodes[resp.ErrKind]; ok {
+		return &opError{c}
+	}
+	return &opError{exitFailure}
+}
+
+// exitCode combines the codes of several failed operations, keeping
+// a specific code only if all failed the same way
+func exitCode(errs []*opError) int {
+	code := 0
+	for _, e := range errs {
+		if code != 0 && e.code != code {
+			return exitFailure
+		}
+		code = e.code
+	}
+	return code
+}
+
+// daemonError indicates that the daemon could not be started or reached
+type daemonError struct {
+	err error
+}
+
+func (e *daemonError) Error() string { return e.err.Error() }
+func (e *daemonError) Unwrap() error { return e.err }
+
+// startupCode is the exit code for an error returned by prepare
+func startupCode(err error) int {
+	var de *daemonError
+	if errors.As(err, &de) {
+		return exitDaemon
+	}
+	return exitFailure
+}
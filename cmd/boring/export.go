@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// exportTunnels writes the running tunnels, or those matching any of the
+// patterns, as a config file to stdout or the file given with -o. This way,
+// tunnels set up on the fly, e.g. renamed, can be kept in the config.
+func exportTunnels(args []string) {
+	var out string
+	var pats []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-o" || args[i] == "--output":
+			if i+1 == len(args) {
+				log.Fatalf("'%s' requires a file argument.", args[i])
+			}
+			i++
+			out = args[i]
+		case strings.HasPrefix(args[i], "-"):
+			log.Fatalf("Unknown flag '%s' for 'export'.", args[i])
+		default:
+			pats = append(pats, args[i])
+		}
+	}
+
+	// The config goes to stdout, so messages must not
+	if out == "" {
+		log.Init(os.Stderr, isTerm, false)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+	defer cancel()
+	if err := ensureDaemon(ctx); err != nil {
+		log.Exitf(exitDaemon, "Could not start daemon: %v", err)
+	}
+	ts, err := getRunningTunnels("")
+	if err != nil {
+		log.Exitf(exitDaemon, "Could not list tunnels: %v", err)
+	}
+	if len(pats) > 0 {
+		keep, notMatched := filterByPatterns(ts, pats)
+		for _, pat := range notMatched {
+			log.Warningf("No running tunnels match pattern '%s'.", pat)
+		}
+		for name := range ts {
+			if !keep[name] {
+				delete(ts, name)
+			}
+		}
+	}
+	if len(ts) == 0 {
+		log.Exitf(exitNotFound, "No running tunnels to export.")
+	}
+
+	var descs []tunnel.Desc
+	for _, t := range sortTunnels(ts) {
+		descs = append(descs, *t)
+	}
+	var buf bytes.Buffer
+	if err := config.Export(&buf, descs); err != nil {
+		log.Fatalf("Could not export tunnels: %v", err)
+	}
+	if out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	if err := os.WriteFile(out, buf.Bytes(), 0600); err != nil {
+		log.Fatalf("Could not write %s: %v", out, err)
+	}
+	log.Infof("Exported %d tunnel(s) to %s.", len(descs), out)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// printFeatures lists the algorithms and SSH config keywords boring
+// supports, as implemented by the SSH library and the config parser
+func printFeatures() {
+	sup, insec := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	for _, a := range []struct {
+		keyword    string
+		sup, insec []string
+	}{
+		{"Ciphers", sup.Ciphers, insec.Ciphers},
+		{"MACs", sup.MACs, insec.MACs},
+		{"KexAlgorithms", sup.KeyExchanges, insec.KeyExchanges},
+		{"HostKeyAlgorithms", sup.HostKeys, insec.HostKeys},
+		{"PubkeyAcceptedAlgorithms", sup.PublicKeyAuths, insec.PublicKeyAuths},
+	} {
+		log.Emitf("%s:\n", a.keyword)
+		for _, name := range a.sup {
+			log.Emitf("  %s\n", name)
+		}
+		for _, name := range a.insec {
+			log.Emitf("  %s %s(insecure, only if configured)%s\n", name, log.Yellow, log.Reset)
+		}
+		log.Emitf("\n")
+	}
+
+	log.Emitf("SSH config keywords:\n")
+	for _, k := range ssh_config.Keywords() {
+		log.Emitf("  %s\n", k)
+	}
+	log.Emitf("Other keywords are ignored, see 'boring check'.\n")
+
+	unsup := ssh_config.UnsupportedKeywords()
+	if len(unsup) == 0 {
+		return
+	}
+	log.Emitf("\nNot supported:\n")
+	for _, k := range slices.Sorted(maps.Keys(unsup)) {
+		log.Emitf("  %-28s %s# %s%s\n", k, log.Blue, unsup[k], log.Reset)
+	}
+}
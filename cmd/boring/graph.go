@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+// graphChain prints the hops on the way to a [user@]host as a tree, or
+// with --dot in Graphviz format, e.g. to document and review access paths.
+// Nothing is connected to.
+func graphChain(args []string) {
+	dot := len(args) > 0 && args[0] == "--dot"
+	if dot {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		log.Fatalf("'graph' requires exactly one '[user@]host' argument.")
+	}
+	user, host, ok := strings.Cut(args[0], "@")
+	if !ok {
+		user, host = "", user
+	}
+
+	hops, err := ssh_config.Chain(host, user)
+	if err != nil {
+		log.Fatalf("Could not resolve the jump chain to '%s': %v", host, err)
+	}
+	var b strings.Builder
+	if dot {
+		writeDot(&b, host, hops)
+	} else {
+		writeTree(&b, hops)
+	}
+	log.Emitf("%s", b.String())
+}
+
+// hopLines describes a hop in a few lines, the first naming it
+func hopLines(h *ssh_config.ChainHop) []string {
+	lines := []string{fmt.Sprintf("%s  %s", h.Alias, h.String())}
+	if h.HostNameCmd != "" {
+		lines = append(lines, "host name from: "+h.HostNameCmd)
+	} else if h.SRVLookup {
+		lines = append(lines, "SRV record looked up when connecting")
+	}
+	auth := "none"
+	if len(h.Auth) > 0 {
+		auth = strings.Join(h.Auth, ", ")
+	}
+	return append(lines, "auth: "+auth)
+}
+
+// writeTree writes the chain as a tree starting at the local machine
+func writeTree(w io.Writer, hops []ssh_config.ChainHop) {
+	fmt.Fprintln(w, "local")
+	indent := ""
+	for i := range hops {
+		for j, l := range hopLines(&hops[i]) {
+			if j == 0 {
+				fmt.Fprintf(w, "%s└── %s\n", indent, l)
+			} else {
+				fmt.Fprintf(w, "%s    %s\n", indent, l)
+			}
+		}
+		indent += "    "
+	}
+}
+
+// writeDot writes the chain as a Graphviz digraph named after host
+func writeDot(w io.Writer, host string, hops []ssh_config.ChainHop) {
+	fmt.Fprintf(w, "digraph %s {\n", dotQuote(host))
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	fmt.Fprintln(w, "\tlocal [shape=ellipse];")
+	prev := "local"
+	for i := range hops {
+		id := fmt.Sprintf("hop%d", i)
+		fmt.Fprintf(w, "\t%s [label=%s];\n", id, dotQuote(strings.Join(hopLines(&hops[i]), "\n")))
+		fmt.Fprintf(w, "\t%s -> %s;\n", prev, id)
+		prev = id
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotQuote quotes s as a DOT string, with line breaks as \n escapes
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
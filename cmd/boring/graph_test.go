@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+var testChain = []ssh_config.ChainHop{
+	{Alias: "bastion", HostName: "10.0.0.1", Port: 22, User: "ops", Auth: []string{"agent"}},
+	{Alias: "db", HostName: "db.internal", HostNameCmd: `discover "db"`, Port: 2222, User: "app"},
+}
+
+func TestWriteTree(t *testing.T) {
+	var b strings.Builder
+	writeTree(&b, testChain)
+	want := `local
+└── bastion  ops@10.0.0.1:22
+    auth: agent
+    └── db  app@db.internal:2222
+        host name from: discover "db"
+        auth: none
+`
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+	var b strings.Builder
+	writeDot(&b, "db", testChain)
+	want := `digraph "db" {
+	rankdir=LR;
+	node [shape=box];
+	local [shape=ellipse];
+	hop0 [label="bastion  ops@10.0.0.1:22\nauth: agent"];
+	local -> hop0;
+	hop1 [label="db  app@db.internal:2222\nhost name from: discover \"db\"\nauth: none"];
+	hop0 -> hop1;
+}
+`
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -28,6 +28,13 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Forward stdio to a remote address, logs must not go to stdout
+	if len(os.Args) > 1 && os.Args[1] == "-W" {
+		log.Init(os.Stderr, true, false)
+		forwardStdio(os.Args[2:])
+		os.Exit(0)
+	}
+
 	initLogging()
 
 	if len(os.Args) < 2 {
@@ -48,10 +55,36 @@ func main() {
 				" or an '--all/-a' or '-g/--group <group>' flag.")
 		}
 		controlTunnels(os.Args[2:], daemon.Close)
+	case "rename":
+		renameTunnel(os.Args[2:])
+	case "arm", "disarm":
+		armTunnel(os.Args[2:], os.Args[1] == "arm")
+	case "reconnect":
+		reconnectTunnel(os.Args[2:])
 	case "list", "l", "ls":
 		listTunnels(os.Args[2:])
 	case "edit", "e":
 		editConfig()
+	case "export":
+		exportTunnels(os.Args[2:])
+	case "cp":
+		copyFiles(os.Args[2:])
+	case "shell":
+		openShell(os.Args[2:])
+	case "trace":
+		traceHops(os.Args[2:])
+	case "graph":
+		graphChain(os.Args[2:])
+	case "doctor":
+		runDoctor()
+	case "debug":
+		debugDaemon(os.Args[2:])
+	case "ssh-config":
+		dumpSSHConfig(os.Args[2:])
+	case "check":
+		checkSSHConfig(os.Args[2:])
+	case "features":
+		printFeatures()
 	case "version", "v":
 		printVersion()
 	case "help", "h":
@@ -96,13 +129,42 @@ func printVersion() {
 func printUsage() {
 	log.Printf("The `boring` SSH tunnel manager\n\n")
 	log.Printf("Usage:\n")
-	log.Printf("  boring list, l [-g <group>]    List all tunnels\n")
-	log.Printf(`  boring open, o (-a | -g <group> | <patterns>...)
+	log.Printf(`  boring list, l [-g <group> | :<port>]
+                                 List all tunnels, or those on a local port` + "\n")
+	log.Printf(`  boring open, o [-w] (-a | -g <group> | <patterns>...)
     <patterns>...                Open tunnels matching any glob pattern
     -a, --all                    Open all tunnels
-    -g, --group <group>          Open all tunnels in a group` + "\n")
-	log.Printf("  boring close, c                Close tunnels (same options as 'open')\n")
+    -g, --group <group>          Open all tunnels in a group
+    -w, --wait                   Wait until the tunnels forward connections` + "\n")
+	log.Printf(`  boring close, c                Close tunnels (same options as 'open'),
+                                 or those on a local port with ':<port>'` + "\n")
+	log.Printf("  boring rename <name> <new>     Rename a running tunnel, keeping its connections\n")
+	log.Printf("  boring arm <name>              Start listening locally for a disarmed tunnel\n")
+	log.Printf("  boring disarm <name>           Stop listening locally, keeping the connection\n")
+	log.Printf("  boring reconnect <name>        Re-connect a tunnel now instead of waiting to retry\n")
 	log.Printf("  boring edit, e                 Edit the configuration file\n")
+	log.Printf(`  boring export [-o <file>] [<patterns>...]
+                                 Print running tunnels as a config file to keep them
+    -o, --output <file>          Write to a file instead of stdout` + "\n")
+	log.Printf(`  boring ssh-config [--origin] [user@]host
+                                 Print the effective SSH config for a host as JSON
+    --origin                     Print raw options with the file and line they come from` + "\n")
+	log.Printf(`  boring check [[user@]host...]  Check the SSH config of hosts without connecting,
+                                 defaults to all Host stanzas` + "\n")
+	log.Printf("  boring features                List the supported algorithms and SSH config keywords\n")
+	log.Printf(`  boring cp [-r] <src> <dst>     Copy files to or from a host over SFTP,
+                                 the remote side given as [user@]host:path
+    -r, --recursive              Copy directories recursively` + "\n")
+	log.Printf("  boring shell [user@]host       Open an interactive shell on a host\n")
+	log.Printf(`  boring trace [user@]host       Connect to each jump host on the way to a host in turn,
+                                 reporting where the chain breaks` + "\n")
+	log.Printf(`  boring graph [--dot] [user@]host
+                                 Print the jump hosts on the way to a host as a tree
+    --dot                        Print a Graphviz graph instead` + "\n")
+	log.Printf("  boring doctor                  Diagnose common setup problems\n")
+	log.Printf(`  boring debug [--goroutines]    Show runtime stats of the daemon
+    --goroutines                 Include a dump of all goroutines` + "\n")
+	log.Printf("  boring -W <host:port> <host>   Forward stdio to a remote address (e.g. as ProxyCommand)\n")
 	log.Printf("  boring version, v              Show the version number\n")
 	log.Printf("  boring help, h                 Show this help message\n")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/alebeck/boring/internal/agent"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// openShell runs an interactive shell on a host, connecting like the
+// tunnels do, and exits with the exit status of the shell
+func openShell(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("'shell' requires exactly one [user@]host argument.")
+	}
+	host := args[0]
+
+	c, err := tunnel.Connect(host)
+	if err != nil {
+		log.Fatalf("Could not connect to '%s': %v", host, err)
+	}
+	code, err := runShell(c)
+	c.Close()
+	if err != nil {
+		log.Fatalf("Shell on '%s' failed: %v", host, err)
+	}
+	os.Exit(code)
+}
+
+// runShell attaches stdio to a shell session on c. If stdin is a
+// terminal, the session gets a PTY of the same size and the terminal is
+// put into raw mode, which is undone before returning, even on panic.
+// The agent is forwarded for as long as the session runs if ForwardAgent
+// is set for the host.
+func runShell(c *tunnel.Client) (int, error) {
+	s, err := c.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	if c.AgentSock != "" {
+		stop, err := agent.Forward(c.Client, s, c.AgentSock)
+		if err != nil {
+			log.Warningf("Could not forward agent: %v", err)
+		} else {
+			defer stop()
+		}
+	}
+	s.Stdin, s.Stdout, s.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		w, h, err := term.GetSize(fd)
+		if err != nil {
+			w, h = 80, 24
+		}
+		tt := os.Getenv("TERM")
+		if tt == "" {
+			tt = "xterm"
+		}
+		modes := ssh.TerminalModes{ssh.ECHO: 1}
+		if err := s.RequestPty(tt, h, w, modes); err != nil {
+			return 0, err
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return 0, err
+		}
+		defer term.Restore(fd, state)
+		stop := watchTermSize(fd, s)
+		defer stop()
+	}
+
+	if err := s.Shell(); err != nil {
+		return 0, err
+	}
+	err = s.Wait()
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/crypto/ssh"
+
+// watchTermSize is a no-op, as there is no signal for size changes of
+// the console under Windows
+func watchTermSize(fd int, s *ssh.Session) (stop func()) {
+	return func() {}
+}
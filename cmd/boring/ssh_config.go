@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+)
+
+// dumpSSHConfig prints the effective SSH config for a [user@]host as JSON,
+// or with --origin, the raw options along with where they were set.
+func dumpSSHConfig(args []string) {
+	origin := len(args) > 0 && args[0] == "--origin"
+	if origin {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		log.Fatalf("'ssh-config' requires exactly one '[user@]host' argument.")
+	}
+	user, host, ok := strings.Cut(args[0], "@")
+	if !ok {
+		user, host = "", user
+	}
+
+	if origin {
+		printOrigins(host, user)
+		return
+	}
+
+	sc, err := ssh_config.ParseSSHConfig(host, user)
+	if err != nil {
+		log.Fatalf("Could not parse SSH config for '%s': %v", host, err)
+	}
+	// Same overrides and fallbacks as when connecting
+	if user != "" {
+		sc.User = user
+	}
+	sc.EnsureUser()
+
+	b, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		log.Fatalf("Could not encode SSH config: %v", err)
+	}
+	log.Emitf("%s\n", b)
+}
+
+func printOrigins(host, user string) {
+	opts, err := ssh_config.Resolve(host, user)
+	if err != nil {
+		log.Fatalf("Could not parse SSH config for '%s': %v", host, err)
+	}
+	for _, o := range opts {
+		from := "(default)"
+		if o.Origin != nil {
+			from = fmt.Sprintf("%s:%d", o.Origin.File, o.Origin.Line)
+		}
+		log.Emitf("%-22s %s  %s# %s%s\n", o.Key, o.Value, log.Blue, from, log.Reset)
+	}
+}
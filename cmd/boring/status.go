@@ -16,6 +16,26 @@ func status(t *tunnel.Desc) string {
 		return log.Yellow + "reconn" + log.Reset
 	}
 
+	// Open, but connections would fail
+	if t.SSHDown {
+		return log.Yellow + "ssh-down" + log.Reset
+	}
+	if t.ListenerDown {
+		return log.Red + "lsn-down" + log.Reset
+	}
+	// Connected, but not listening until armed
+	if t.Disarmed {
+		return log.Blue + "disarmed" + log.Reset
+	}
+	// Registered, but not connected outside of its schedule
+	if t.Scheduled {
+		return log.Blue + "waiting" + log.Reset
+	}
+	// Listening, but connecting on demand
+	if t.Standby {
+		return log.Blue + "standby" + log.Reset
+	}
+
 	// Tunnel is open, show uptime
 	since := time.Since(t.LastConn)
 	days := int(since / (24 * time.Hour))
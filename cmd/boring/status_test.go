@@ -23,6 +23,38 @@ func TestStatusReconn(t *testing.T) {
 	}
 }
 
+func TestStatusDown(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Open, SSHDown: true}
+	if s := status(d); s != "ssh-down" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+	d = &tunnel.Desc{Status: tunnel.Open, ListenerDown: true}
+	if s := status(d); s != "lsn-down" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
+func TestStatusStandby(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Open, Standby: true}
+	if s := status(d); s != "standby" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
+func TestStatusScheduled(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Open, Scheduled: true}
+	if s := status(d); s != "waiting" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
+func TestStatusDisarmed(t *testing.T) {
+	d := &tunnel.Desc{Status: tunnel.Open, Disarmed: true}
+	if s := status(d); s != "disarmed" {
+		t.Fatalf("incorrect status: %s", s)
+	}
+}
+
 func TestStatusUptimeMins(t *testing.T) {
 	log.Init(io.Discard, true, false)
 	l := 7*time.Minute + 21*time.Second
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// forwardStdio connects to a host and forwards stdin and stdout to an
+// address on the remote side, like `ssh -W`. This allows using boring as
+// a ProxyCommand. Stdout carries data, so logs must go to stderr.
+func forwardStdio(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("'-W' requires exactly one 'host:port' and one 'host' argument.")
+	}
+	addr, host := args[0], args[1]
+
+	c, err := tunnel.Connect(host)
+	if err != nil {
+		log.Fatalf("Could not connect to '%s': %v", host, err)
+	}
+	defer c.Close()
+
+	conn, err := c.Dial("tcp", addr)
+	if err != nil {
+		log.Fatalf("Could not dial %s via '%s': %v", addr, host, err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(conn, os.Stdin)
+		// Signal EOF to the remote, but keep receiving until it closes
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(done)
+	}()
+	<-done
+}
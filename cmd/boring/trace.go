@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// traceHops connects to each hop of the jump chain to a host in order,
+// reporting reachability and latency per hop, and exits non-zero at the
+// first hop that cannot be reached
+func traceHops(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("'trace' requires exactly one [user@]host argument.")
+	}
+	host := args[0]
+
+	n := 0
+	err := tunnel.Trace(host, func(h *tunnel.HopTrace) {
+		n++
+		c := &check{name: fmt.Sprintf("%d %s", n, h.Addr), result: pass}
+		if h.Err != nil {
+			c.result = fail
+		}
+		c.detail = traceDetail(h)
+		printCheck(c)
+	})
+	if err != nil {
+		if n == 0 {
+			log.Errorf("Could not resolve '%s': %v", host, err)
+		}
+		os.Exit(1)
+	}
+}
+
+func traceDetail(h *tunnel.HopTrace) string {
+	// Hops in a LAN are reached in well under a millisecond
+	ms := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	switch {
+	case h.Err == nil:
+		return fmt.Sprintf("tcp %v, ssh as %s %v", ms(h.Dial), h.User, ms(h.Handshake))
+	case h.Stage == "tcp", h.Stage == "tls":
+		return fmt.Sprintf("%s failed after %v: %v", h.Stage, ms(h.Dial), h.Err)
+	}
+	return fmt.Sprintf("tcp %v, ssh as %s failed after %v: %v",
+		ms(h.Dial), h.User, ms(h.Handshake), h.Err)
+}
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alebeck/boring/internal/config"
@@ -21,8 +23,6 @@ import (
 
 const daemonTimeout = 10 * time.Second
 
-var errOpFailed = errors.New("operation failed")
-
 // prepare loads the configuration and ensures the daemon is running
 func prepare() (*config.Config, error) {
 	var conf *config.Config
@@ -49,7 +49,7 @@ func prepare() (*config.Config, error) {
 
 	g.Go(func() error {
 		if err := ensureDaemon(ctx); err != nil {
-			return err
+			return &daemonError{err}
 		}
 		return nil
 	})
@@ -66,6 +66,22 @@ func prepare() (*config.Config, error) {
 func controlTunnels(args []string, kind daemon.CmdKind) {
 	var groupFilter string
 
+	// Opening may wait for the tunnels to be ready, e.g. in scripts
+	var wait bool
+	if kind == daemon.Open {
+		args = slices.DeleteFunc(slices.Clone(args), func(a string) bool {
+			if a == "-w" || a == "--wait" {
+				wait = true
+				return true
+			}
+			return false
+		})
+		if len(args) == 0 {
+			log.Fatalf("'open' requires at least one 'pattern' argument," +
+				" or an '--all/-a' or '-g/--group <group>' flag.")
+		}
+	}
+
 	if args[0] == "--all" || args[0] == "-a" {
 		if len(args) != 1 {
 			log.Fatalf("'--all' does not take any additional arguments.")
@@ -78,17 +94,23 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 		groupFilter = args[1]
 	}
 
+	// Local port selectors are matched by the daemon
+	var ports []string
+	if kind == daemon.Close && groupFilter == "" {
+		ports, args = splitPortSelectors(args)
+	}
+
 	conf, err := prepare()
 	if err != nil {
-		log.Fatalf("Startup: %s", err.Error())
+		log.Exitf(startupCode(err), "Startup: %s", err.Error())
 	}
 
 	// Get available tunnels for requested command
 	ts := conf.TunnelsMap
 	if kind == daemon.Close {
-		ts, err = getRunningTunnels()
+		ts, err = getRunningTunnels("")
 		if err != nil {
-			log.Fatalf("Could not get running tunnels: %v", err)
+			log.Exitf(exitDaemon, "Could not get running tunnels: %v", err)
 		}
 	}
 
@@ -106,9 +128,9 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 		}
 		keep = filterByGroup(ts, filterValue)
 		if len(keep) == 0 {
-			log.Fatalf("No %stunnels in group '%s'.", m, groupFilter)
+			log.Exitf(exitNotFound, "No %stunnels in group '%s'.", m, groupFilter)
 		}
-	} else {
+	} else if len(args) > 0 {
 		var notMatched []string
 		keep, notMatched = filterByPatterns(ts, args)
 
@@ -117,7 +139,7 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 			if len(args) > 1 {
 				msg = fmt.Sprintf("No %stunnels match any provided pattern.", m)
 			}
-			log.Fatalf("%s", msg)
+			log.Exitf(exitNotFound, "%s", msg)
 		}
 
 		// If tunnels were matched, do print a warning for unmatched patterns
@@ -126,30 +148,45 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 		}
 	}
 
-	// Issue concurrent commands for all tunnels
-	var g errgroup.Group
+	// Issue concurrent commands for all tunnels. The errors are just for
+	// determining the exit code really, a detailed message will have been
+	// logged to the user.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []*opError
+	run := func(op func() *opError) {
+		wg.Go(func() {
+			if err := op(); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				failed = append(failed, err)
+			}
+		})
+	}
 	for n := range keep {
-		g.Go(func() error {
+		run(func() *opError {
 			if kind == daemon.Open {
-				return openTunnel(ts[n])
+				return openTunnel(ts[n], wait)
 			} else if kind == daemon.Close {
 				return closeTunnel(ts[n])
 			}
 			panic("unknown command kind: " + kind.String())
 		})
 	}
-	// This is just for determining the exit code really,
-	// a detailed message will have been logged to the user.
-	if err := g.Wait(); err != nil {
-		os.Exit(1)
+	for _, p := range ports {
+		run(func() *opError { return closeByPort(p) })
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		os.Exit(exitCode(failed))
 	}
 }
 
-func openTunnel(t *tunnel.Desc) error {
-	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Open, Tunnel: t})
+func openTunnel(t *tunnel.Desc, wait bool) *opError {
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Open, Tunnel: t, Wait: wait})
 	if err != nil {
 		log.Errorf("Could not transmit 'open' command: %v", err)
-		return errOpFailed
+		return &opError{exitDaemon}
 	}
 	if !resp.Success {
 		// cannot use errors.Is because error is transmitted as string over IPC
@@ -158,32 +195,141 @@ func openTunnel(t *tunnel.Desc) error {
 			return nil
 		}
 		log.Errorf("Could not open tunnel '%v': %v", t.Name, resp.Error)
-		return errOpFailed
+		return respError(resp)
 	}
 
 	log.Infof("Opened tunnel '%s': %s %v %s via %s.", log.Green+log.Bold+t.Name+log.Reset,
 		t.LocalAddress, t.Mode, t.RemoteAddress, t.Host)
+	if b := resp.Tunnels[t.Name].Banner; b != "" {
+		log.Emitf("%s", b)
+	}
 	return nil
 }
 
-func closeTunnel(t *tunnel.Desc) error {
+func closeTunnel(t *tunnel.Desc) *opError {
 	// Daemon only needs the name, so simplify
 	t = &tunnel.Desc{Name: t.Name}
 	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Close, Tunnel: t})
 	if err != nil {
 		log.Errorf("Could not transmit 'close' command: %v", err)
-		return errOpFailed
+		return &opError{exitDaemon}
 	}
 	if !resp.Success {
 		log.Errorf("Tunnel '%v' could not be closed: %v", t.Name, resp.Error)
-		return errOpFailed
+		return respError(resp)
 	}
 	log.Infof("Closed tunnel '%s'.", log.Green+log.Bold+t.Name+log.Reset)
 	return nil
 }
 
-func getRunningTunnels() (map[string]*tunnel.Desc, error) {
-	resp, err := sendCmd(daemon.Cmd{Kind: daemon.List})
+// renameTunnel renames a running tunnel without interrupting it. Does not
+// start a daemon, as there would be nothing to rename.
+func renameTunnel(args []string) {
+	if len(args) != 2 {
+		log.Fatalf("'rename' requires exactly one 'name' and one 'new name' argument.")
+	}
+	name, newName := args[0], args[1]
+	if err := config.ValidateName(newName); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Rename, Tunnel: &tunnel.Desc{Name: name}, Name: newName})
+	if err != nil {
+		log.Exitf(exitDaemon, "Daemon not reachable: %v", err)
+	}
+	if !resp.Success {
+		log.Errorf("Tunnel '%v' could not be renamed: %v", name, resp.Error)
+		os.Exit(respError(resp).code)
+	}
+	log.Infof("Renamed tunnel '%s' to '%s'.", name, log.Green+log.Bold+newName+log.Reset)
+}
+
+// armTunnel binds (armed) or closes the local listener of a running tunnel,
+// keeping its connection. Does not start a daemon either.
+func armTunnel(args []string, armed bool) {
+	verb := map[bool]string{true: "arm", false: "disarm"}[armed]
+	if len(args) != 1 {
+		log.Fatalf("'%v' requires exactly one 'name' argument.", verb)
+	}
+	name := args[0]
+	kind := daemon.Disarm
+	if armed {
+		kind = daemon.Arm
+	}
+
+	resp, err := sendCmd(daemon.Cmd{Kind: kind, Tunnel: &tunnel.Desc{Name: name}})
+	if err != nil {
+		log.Exitf(exitDaemon, "Daemon not reachable: %v", err)
+	}
+	if !resp.Success {
+		log.Errorf("Tunnel '%v' could not be %ved: %v", name, verb, resp.Error)
+		os.Exit(respError(resp).code)
+	}
+	if armed {
+		log.Infof("Armed tunnel '%s', listening.", log.Green+log.Bold+name+log.Reset)
+	} else {
+		log.Infof("Disarmed tunnel '%s', not listening.", log.Green+log.Bold+name+log.Reset)
+	}
+}
+
+// reconnectTunnel makes a running tunnel that waits to re-connect try right
+// away, e.g. after a VPN came back. Does not start a daemon either.
+func reconnectTunnel(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("'reconnect' requires exactly one 'name' argument.")
+	}
+	name := args[0]
+
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Reconnect, Tunnel: &tunnel.Desc{Name: name}})
+	if err != nil {
+		log.Exitf(exitDaemon, "Daemon not reachable: %v", err)
+	}
+	if !resp.Success {
+		log.Errorf("Tunnel '%v' could not be re-connected: %v", name, resp.Error)
+		os.Exit(respError(resp).code)
+	}
+	if t := resp.Tunnels[name]; !resp.Retried {
+		log.Infof("Tunnel '%s' is not re-connecting (%s), nothing to do.",
+			log.Green+log.Bold+name+log.Reset, status(&t))
+		return
+	}
+	log.Infof("Re-connecting tunnel '%s' now.", log.Green+log.Bold+name+log.Reset)
+}
+
+// closeByPort closes the running tunnels bound to the given local port
+func closeByPort(port string) *opError {
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Close, Port: port})
+	if err != nil {
+		log.Errorf("Could not transmit 'close' command: %v", err)
+		return &opError{exitDaemon}
+	}
+	for _, n := range slices.Sorted(maps.Keys(resp.Tunnels)) {
+		log.Infof("Closed tunnel '%s' on local port %s.", log.Green+log.Bold+n+log.Reset, port)
+	}
+	if !resp.Success {
+		log.Errorf("Tunnels on local port %s could not be closed: %v", port, resp.Error)
+		return respError(resp)
+	}
+	return nil
+}
+
+// splitPortSelectors separates the ports of local port selectors, see
+// tunnel.ParsePortSelector, from other arguments
+func splitPortSelectors(args []string) (ports, rest []string) {
+	for _, a := range args {
+		if p, ok := tunnel.ParsePortSelector(a); ok {
+			ports = append(ports, p)
+		} else {
+			rest = append(rest, a)
+		}
+	}
+	return
+}
+
+// getRunningTunnels lists the running tunnels, only those bound to the
+// given local port if it is not empty
+func getRunningTunnels(port string) (map[string]*tunnel.Desc, error) {
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.List, Port: port})
 	if err != nil {
 		return nil, err
 	}
@@ -198,24 +344,39 @@ func getRunningTunnels() (map[string]*tunnel.Desc, error) {
 }
 
 func listTunnels(args []string) {
-	var groupFilter string
+	var groupFilter, port string
 	if len(args) > 0 && (args[0] == "-g" || args[0] == "--group") {
 		if len(args) != 2 {
 			log.Fatalf("'-g/--group' requires exactly one group name argument.")
 		}
 		groupFilter = args[1]
+	} else if len(args) == 1 && strings.HasPrefix(args[0], ":") {
+		var ok bool
+		if port, ok = tunnel.ParsePortSelector(args[0]); !ok {
+			log.Fatalf("Invalid local port selector '%s'.", args[0])
+		}
 	} else if len(args) > 0 {
-		log.Fatalf("Unknown arguments for 'list'. Use '-g <group>' to filter by group.")
+		log.Fatalf("Unknown arguments for 'list'. Use '-g <group>' to filter by group" +
+			" or ':<port>' by local port.")
 	}
 
 	conf, err := prepare()
 	if err != nil {
-		log.Fatalf("Startup: %s", err.Error())
+		log.Exitf(startupCode(err), "Startup: %s", err.Error())
 	}
 
-	ts, err := getRunningTunnels()
+	ts, err := getRunningTunnels(port)
 	if err != nil {
-		log.Fatalf("Could not list tunnels: %v", err)
+		log.Exitf(exitDaemon, "Could not list tunnels: %v", err)
+	}
+
+	// Only running tunnels are bound to a port
+	if port != "" {
+		if len(ts) == 0 {
+			log.Exitf(exitNotFound, "No running tunnels on local port %s.", port)
+		}
+		printTunnelList(sortTunnels(ts))
+		return
 	}
 
 	if len(ts) == 0 && len(conf.Tunnels) == 0 {
@@ -238,16 +399,60 @@ func listTunnels(args []string) {
 			}
 		}
 		if len(filtered) == 0 {
-			log.Fatalf("No tunnels in group '%s'.", groupFilter)
+			log.Exitf(exitNotFound, "No tunnels in group '%s'.", groupFilter)
 		}
 		all = filtered
 	}
 
 	printTunnelList(all)
+	printDisconnects(all)
+	printSchedules(all)
+}
+
+// printDisconnects tells why the server disconnected tunnels that are
+// re-connecting or on standby
+func printDisconnects(all []*tunnel.Desc) {
+	first := true
+	for _, t := range all {
+		if t.Disconnect == nil {
+			continue
+		}
+		if first {
+			log.Emitf("\n")
+			first = false
+		}
+		log.Emitf("%s%s%s: disconnected by server (%v)\n",
+			log.Yellow, t.Name, log.Reset, t.Disconnect)
+	}
+}
+
+// printSchedules tells when tunnels with a schedule connect and disconnect
+// next, which they only follow once opened
+func printSchedules(all []*tunnel.Desc) {
+	const layout = "2006-01-02 15:04"
+	first := true
+	for _, t := range all {
+		if t.Schedule == "" {
+			continue
+		}
+		if first {
+			log.Emitf("\n")
+			first = false
+		}
+		switch {
+		case t.Status == tunnel.Closed:
+			log.Emitf("%s: scheduled by '%s' once opened\n", t.Name, t.Schedule)
+		case t.Scheduled && !t.NextOpen.IsZero():
+			log.Emitf("%s: connects at %s until %s\n", t.Name,
+				t.NextOpen.Local().Format(layout), t.NextClose.Local().Format(layout))
+		case !t.NextClose.IsZero():
+			log.Emitf("%s: disconnects at %s\n", t.Name, t.NextClose.Local().Format(layout))
+		}
+	}
 }
 
 // orderTunnelsForList combines configured and running tunnels into an ordered slice.
-// Config order is preserved; running-but-not-configured tunnels are appended sorted by name.
+// Config order is preserved; running-but-not-configured tunnels are appended, see sortTunnels.
 func orderTunnelsForList(conf []tunnel.Desc, ts map[string]*tunnel.Desc) []*tunnel.Desc {
 	var all []*tunnel.Desc
 	visited := make(map[string]bool)
@@ -260,19 +465,27 @@ func orderTunnelsForList(conf []tunnel.Desc, ts map[string]*tunnel.Desc) []*tunn
 		}
 		all = append(all, t)
 	}
-	var extra []string
-	for name := range ts {
-		if !visited[name] {
-			extra = append(extra, name)
+	for _, t := range sortTunnels(ts) {
+		if !visited[t.Name] {
+			all = append(all, t)
 		}
 	}
-	sort.Strings(extra)
-	for _, name := range extra {
-		all = append(all, ts[name])
-	}
 	return all
 }
 
+// sortTunnels returns the tunnels sorted by name, so that the order does not
+// depend on map iteration.
+func sortTunnels(ts map[string]*tunnel.Desc) []*tunnel.Desc {
+	s := make([]*tunnel.Desc, 0, len(ts))
+	for _, t := range ts {
+		s = append(s, t)
+	}
+	slices.SortFunc(s, func(a, b *tunnel.Desc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return s
+}
+
 func printTunnelList(all []*tunnel.Desc) {
 	// If any tunnel has a non-empty group, use grouped display
 	hasGroups := false
@@ -318,11 +531,36 @@ func printTunnelList(all []*tunnel.Desc) {
 func tunnelTable(tunnels []*tunnel.Desc) *table.Table {
 	tbl := table.New("Status", "Name", "Local", "", "Remote", "Via")
 	for _, t := range tunnels {
-		tbl.AddRow(status(t), t.Name, t.LocalAddress, t.Mode, t.RemoteAddress, t.Host)
+		remote := string(t.RemoteAddress)
+		if t.Mode == tunnel.Sni {
+			remote = sniRemote(t)
+		}
+		if t.AllocatedPort != 0 {
+			remote += fmt.Sprintf(" (port %d)", t.AllocatedPort)
+		}
+		if t.Queued > 0 {
+			remote += fmt.Sprintf(" (%d queued)", t.Queued)
+		}
+		tbl.AddRow(status(t), t.Name, t.LocalAddress, t.Mode, remote, t.Host)
 	}
 	return tbl
 }
 
+// sniRemote describes the targets of a tunnel in sni mode, i.e., its
+// routes and the default target, if any
+func sniRemote(t *tunnel.Desc) string {
+	routes := fmt.Sprintf("%d by SNI", len(t.Routes))
+	if len(t.Routes) == 1 {
+		for _, r := range t.Routes {
+			routes = r + " by SNI"
+		}
+	}
+	if t.RemoteAddress == "" {
+		return routes
+	}
+	return fmt.Sprintf("%s (+%s)", t.RemoteAddress, routes)
+}
+
 func filterByPatterns(ts map[string]*tunnel.Desc, pats []string) (map[string]bool, []string) {
 	keep := make(map[string]bool, len(ts))
 	var notMatched []string
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func TestOrderTunnelsForList(t *testing.T) {
+	conf := []tunnel.Desc{{Name: "zeta"}, {Name: "alpha"}}
+	now := time.Now()
+	ts := map[string]*tunnel.Desc{
+		"alpha":  {Name: "alpha", Status: tunnel.Open},
+		"db-2":   {Name: "db-2", LastConn: now},
+		"db-1":   {Name: "db-1", LastConn: now.Add(time.Minute)},
+		"beta":   {Name: "beta"},
+		"db-1-a": {Name: "db-1-a"},
+	}
+	want := []string{"zeta", "alpha", "beta", "db-1", "db-1-a", "db-2"}
+
+	// Repeat, as map iteration order is randomized
+	for range 20 {
+		all := orderTunnelsForList(conf, ts)
+		if len(all) != len(want) {
+			t.Fatalf("expected %d tunnels, got %d", len(want), len(all))
+		}
+		for i, d := range all {
+			if d.Name != want[i] {
+				t.Fatalf("position %d: expected %s, got %s", i, want[i], d.Name)
+			}
+		}
+		if all[1].Status != tunnel.Open {
+			t.Fatalf("expected running description for configured tunnel")
+		}
+	}
+}
@@ -3,7 +3,7 @@ _boring() {
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
-    local commands=("open" "close" "list" "edit" "version" "help")
+    local commands=("open" "close" "list" "rename" "arm" "disarm" "reconnect" "edit" "export" "ssh-config" "check" "features" "cp" "shell" "trace" "graph" "doctor" "debug" "version" "help")
 
     _boring_get_names() {
         local status="$1"
@@ -50,8 +50,10 @@ _boring() {
             COMPREPLY=()
         elif [[ "$cmd" == "open" || "$cmd" == "o" ]]; then
             _boring_get_names "closed"
-        elif [[ "$cmd" == "close" || "$cmd" == "c" ]]; then
+        elif [[ "$cmd" == "close" || "$cmd" == "c" || "$cmd" == "export" || ( ( "$cmd" == "rename" || "$cmd" == "arm" || "$cmd" == "disarm" || "$cmd" == "reconnect" ) && $COMP_CWORD -eq 2 ) ]]; then
             _boring_get_names "open"
+        elif [[ "$cmd" == "cp" ]]; then
+            COMPREPLY=($(compgen -f -- "$cur"))
         fi
     fi
 }
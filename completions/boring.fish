@@ -34,7 +34,7 @@ function __boring_complete
     set arguments (commandline -opc)[3..-1]
 
     if test (count $command) -eq 0
-        printf "%s\n" open close list edit version help
+        printf "%s\n" open close list rename arm disarm reconnect edit export ssh-config check features cp shell trace graph doctor debug version help
         return
     end
 
@@ -51,8 +51,14 @@ function __boring_complete
     switch $command
         case open o
             __boring_get_names closed $arguments
-        case close c
+        case close c export
             __boring_get_names open $arguments
+        case rename arm disarm reconnect
+            if test (count $arguments) -eq 0
+                __boring_get_names open
+            end
+        case cp
+            __fish_complete_path (commandline -ct)
     end
 end
 
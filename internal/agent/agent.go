@@ -1,51 +1,114 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 
+	"github.com/alebeck/boring/internal/log"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
 var (
-	// Keep a single agent instance for all connection attempts
-	inst agent.ExtendedAgent
-	mu   sync.Mutex
+	// Keep a single instance per agent socket for all connection attempts
+	insts = make(map[string]agent.ExtendedAgent)
+	mu    sync.Mutex
 )
 
-func getAgent() (agent.ExtendedAgent, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if inst != nil {
-		return inst, nil
+// sockets returns the agent sockets to query, which are those listed in
+// BORING_AGENT_SOCKS, separated like PATH, or else SSH_AUTH_SOCK
+func sockets() ([]string, error) {
+	if v := os.Getenv("BORING_AGENT_SOCKS"); v != "" {
+		var socks []string
+		for _, s := range filepath.SplitList(v) {
+			if s != "" {
+				socks = append(socks, s)
+			}
+		}
+		if len(socks) > 0 {
+			return socks, nil
+		}
 	}
-
 	sock := os.Getenv("SSH_AUTH_SOCK")
 	if sock == "" {
 		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
 	}
+	return []string{sock}, nil
+}
+
+func getAgent(sock string) (agent.ExtendedAgent, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if inst, ok := insts[sock]; ok {
+		return inst, nil
+	}
 
 	conn, err := net.Dial("unix", sock)
 	if err != nil {
 		return nil, fmt.Errorf("could not dial agent: %v", err)
 	}
 
-	inst = agent.NewClient(conn)
-	return inst, nil
+	insts[sock] = agent.NewClient(conn)
+	return insts[sock], nil
+}
+
+// forget drops the instance of sock, so that it is dialed anew next time
+func forget(sock string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(insts, sock)
 }
 
+// GetSigners returns the keys of all agents, each key only once. Agents
+// that cannot be reached are skipped, so this only fails if all of them do.
 func GetSigners() ([]ssh.Signer, error) {
-	agent, err := getAgent()
+	socks, err := sockets()
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []ssh.Signer
+	var errs []error
+	seen := make(map[string]struct{})
+	for _, sock := range socks {
+		sigs, err := getSigners(sock)
+		if err != nil {
+			if len(socks) > 1 {
+				log.Warningf("Skipping agent %v: %v", sock, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		for _, s := range sigs {
+			k := string(s.PublicKey().Marshal())
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			signers = append(signers, s)
+		}
+	}
+
+	if len(errs) == len(socks) {
+		return nil, errors.Join(errs...)
+	}
+	return signers, nil
+}
+
+func getSigners(sock string) ([]ssh.Signer, error) {
+	agent, err := getAgent(sock)
 	if err != nil {
 		return nil, err
 	}
 
 	signers, err := agent.Signers()
 	if err != nil {
+		forget(sock)
 		return nil, fmt.Errorf("could not retrieve signers from agent: %v", err)
 	}
 
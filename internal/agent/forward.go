@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const forwardChannel = "auth-agent@openssh.com"
+
+// Forward requests agent forwarding for session s on c, and relays the
+// channels that the server opens for it to the agent at sock. Once stop is
+// called, open channels are closed and further ones rejected, so that the
+// agent is only exposed for the lifetime of the session. Can be used once
+// per client.
+func Forward(c *ssh.Client, s *ssh.Session, sock string) (stop func(), err error) {
+	chans := c.HandleChannelOpen(forwardChannel)
+	if chans == nil {
+		return nil, errors.New("agent forwarding already set up")
+	}
+
+	var mu sync.Mutex
+	open := make(map[ssh.Channel]struct{})
+	stopped := false
+	go func() {
+		for nc := range chans {
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				nc.Reject(ssh.Prohibited, "agent forwarding ended")
+				continue
+			}
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				mu.Unlock()
+				continue
+			}
+			open[ch] = struct{}{}
+			mu.Unlock()
+
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				relay(ch, sock)
+				mu.Lock()
+				delete(open, ch)
+				mu.Unlock()
+			}()
+		}
+	}()
+	stop = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		for ch := range open {
+			ch.Close()
+		}
+	}
+
+	if err := agent.RequestAgentForwarding(s); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+// relay passes an agent channel on to a new connection to the agent
+func relay(ch ssh.Channel, sock string) {
+	defer ch.Close()
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		log.Warningf("Could not dial forwarded agent: %v", err)
+		return
+	}
+	defer conn.Close()
+	go func() {
+		io.Copy(conn, ch)
+		conn.Close()
+	}()
+	io.Copy(ch, conn)
+}
@@ -4,23 +4,32 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/alebeck/boring/internal/paths"
+	"github.com/alebeck/boring/internal/ssh_config"
 	"github.com/alebeck/boring/internal/tunnel"
 )
 
 const (
 	fileName   = ".boring.toml"
 	socksLabel = "[SOCKS]"
+	maxLabels  = 8
 )
 
-var defaultKeepAliveInterval = 2 * 60 // seconds
+var (
+	defaultKeepAliveInterval = 2 * 60 // seconds
+	defaultReconnectJitter   = 0.2
+)
 
 var Path string
 
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 // Config represents the application configuration as parsed from ./boring.toml
 type Config struct {
 	// Tunnels is a list of tunnel descriptions
@@ -28,8 +37,17 @@ type Config struct {
 	// KeepAlive allows to specify a global keep alive interval,
 	// (in seconds) overriding the default one. `0` indicates
 	// no keep alive.
-	KeepAlive  *int                    `toml:"keep_alive"`
-	TunnelsMap map[string]*tunnel.Desc `toml:"-"`
+	KeepAlive *int `toml:"keep_alive"`
+	// ReconnectJitter is the fraction by which re-connect wait
+	// times are randomized, e.g. `0.2` for ±20%.
+	ReconnectJitter *float64 `toml:"reconnect_jitter"`
+	// SecurityProfile restricts the offered algorithms for all
+	// tunnels, can be "default", "modern" or "fips".
+	SecurityProfile string `toml:"security_profile"`
+	// ClientVersion replaces the identification string sent to
+	// servers for all tunnels, e.g. "SSH-2.0-OpenSSH_9.9".
+	ClientVersion string                  `toml:"client_version"`
+	TunnelsMap    map[string]*tunnel.Desc `toml:"-"`
 }
 
 func init() {
@@ -54,19 +72,55 @@ func getConfigHome() string {
 
 // Load parses the boring configuration file
 func Load() (*Config, error) {
-	cfg := Config{KeepAlive: &defaultKeepAliveInterval}
+	cfg := Config{
+		KeepAlive:       &defaultKeepAliveInterval,
+		ReconnectJitter: &defaultReconnectJitter,
+	}
 
 	if _, err := toml.DecodeFile(Path, &cfg); err != nil {
 		return nil, fmt.Errorf("could not decode config file: %w", err)
 	}
 
-	// Set global keep alive interval for all tunnels
-	// that don't specify one on their own.
+	if err := resolveAliases(cfg.Tunnels); err != nil {
+		return nil, err
+	}
+
+	// Set global keep alive interval, re-connect jitter, security profile
+	// and client version for all tunnels that don't specify them on their own.
 	for i := range cfg.Tunnels {
 		t := &cfg.Tunnels[i]
 		if t.KeepAlive == nil {
 			t.KeepAlive = cfg.KeepAlive
 		}
+		if t.Jitter == nil {
+			t.Jitter = cfg.ReconnectJitter
+		}
+		if *t.Jitter < 0 || *t.Jitter >= 1 {
+			return nil, fmt.Errorf("reconnect_jitter must be in [0, 1), found %v", *t.Jitter)
+		}
+		if t.RetrySchedule != nil && len(t.RetrySchedule) == 0 {
+			return nil, fmt.Errorf("reconnect_schedule of tunnel '%v' must not be empty", t.Name)
+		}
+		for _, s := range t.RetrySchedule {
+			if s <= 0 {
+				return nil, fmt.Errorf("reconnect_schedule of tunnel '%v' must only contain positive intervals, found %v", t.Name, s)
+			}
+		}
+		if t.Profile == "" {
+			t.Profile = cfg.SecurityProfile
+		}
+		if !ssh_config.ValidProfile(t.Profile) {
+			return nil, fmt.Errorf("unknown security_profile %q", t.Profile)
+		}
+		if t.ClientVersion == "" {
+			t.ClientVersion = cfg.ClientVersion
+		}
+		if err := ssh_config.ValidateClientVersion(t.ClientVersion); err != nil {
+			return nil, fmt.Errorf("invalid client_version %q: %v", t.ClientVersion, err)
+		}
+		if err := validateLabels(t.Labels); err != nil {
+			return nil, fmt.Errorf("invalid labels of tunnel '%v': %v", t.Name, err)
+		}
 	}
 
 	// Expand environment variables for a pre-defined set of fields
@@ -109,11 +163,8 @@ func buildTunnelsMap(tunnels []tunnel.Desc) (map[string]*tunnel.Desc, error) {
 		if _, exists := m[t.Name]; exists {
 			return nil, fmt.Errorf("found duplicated tunnel name '%v'", t.Name)
 		}
-		if t.Name == "" || strings.Contains(t.Name, " ") ||
-			specialPrefix(t.Name) || containsGlob(t.Name) {
-			return nil, fmt.Errorf("tunnel names cannot be empty, contain spaces,"+
-				" start with special characters, or contain glob characters '*?['."+
-				" Found '%v'.", t.Name)
+		if err := ValidateName(t.Name); err != nil {
+			return nil, err
 		}
 		if t.Group != "" && (strings.Contains(t.Group, " ") ||
 			specialPrefix(t.Group) || containsGlob(t.Group) || t.Group == "default") {
@@ -126,6 +177,76 @@ func buildTunnelsMap(tunnels []tunnel.Desc) (map[string]*tunnel.Desc, error) {
 	return m, nil
 }
 
+// ValidateName checks that name can be told apart from the patterns, port
+// selectors and flags that select tunnels on the command line
+func ValidateName(name string) error {
+	if name == "" || strings.Contains(name, " ") ||
+		specialPrefix(name) || containsGlob(name) {
+		return fmt.Errorf("tunnel names cannot be empty, contain spaces,"+
+			" start with special characters, or contain glob characters '*?['."+
+			" Found '%v'.", name)
+	}
+	return nil
+}
+
+// inherited are the indices of the settings in tunnel.Desc tagged
+// alias:"inherit", see resolveAliases
+var inherited = func() (idx []int) {
+	typ := reflect.TypeFor[tunnel.Desc]()
+	for i := range typ.NumField() {
+		if typ.Field(i).Tag.Get("alias") == "inherit" {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}()
+
+// resolveAliases fills in the connection settings of tunnels that alias
+// another tunnel from the aliased one, unless they set them on their own.
+// This way, several tunnels to the same host only differ in their ports.
+func resolveAliases(tunnels []tunnel.Desc) error {
+	byName := make(map[string]*tunnel.Desc, len(tunnels))
+	for i := range tunnels {
+		byName[tunnels[i].Name] = &tunnels[i]
+	}
+	for i := range tunnels {
+		t := &tunnels[i]
+		if t.Alias == "" {
+			continue
+		}
+		a, ok := byName[t.Alias]
+		if !ok {
+			return fmt.Errorf("tunnel '%v' aliases unknown tunnel '%v'", t.Name, t.Alias)
+		}
+		if a.Alias != "" {
+			return fmt.Errorf("tunnel '%v' aliases '%v', which is an alias itself",
+				t.Name, t.Alias)
+		}
+		tv, av := reflect.ValueOf(t).Elem(), reflect.ValueOf(a).Elem()
+		for _, f := range inherited {
+			if tv.Field(f).IsZero() {
+				tv.Field(f).Set(av.Field(f))
+			}
+		}
+	}
+	return nil
+}
+
+// validateLabels checks that labels can be told apart in log messages,
+// where they are written as name=value, so names are plain identifiers,
+// and that their number is bounded to keep the messages short
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("at most %d are allowed, found %d", maxLabels, len(labels))
+	}
+	for k := range labels {
+		if !labelNameRe.MatchString(k) {
+			return fmt.Errorf("%q is not a valid label name", k)
+		}
+	}
+	return nil
+}
+
 func specialPrefix(s string) bool {
 	if s == "" {
 		return false
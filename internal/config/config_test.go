@@ -1,8 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"maps"
+	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/alebeck/boring/internal/tunnel"
 )
 
 func TestLoadMissingFile(t *testing.T) {
@@ -104,3 +112,207 @@ func TestExpandFieldsNotExpanded(t *testing.T) {
 		t.Errorf("Group = %q, want it left literal", tun.Group)
 	}
 }
+
+func TestReconnectJitter(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_jitter.toml")
+
+	want := map[string]float64{"global": 0.5, "own": 0.1}
+	for name, j := range want {
+		tun := cfg.TunnelsMap[name]
+		if tun.Jitter == nil || *tun.Jitter != j {
+			t.Errorf("%s: Jitter = %v, want %v", name, tun.Jitter, j)
+		}
+	}
+}
+
+func TestReconnectJitterInvalid(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = "../../test/testdata/config/invalid/reconnect_jitter.toml"
+	if _, err := Load(); err == nil {
+		t.Error("expected error for out-of-range reconnect_jitter")
+	}
+}
+
+func TestReconnectSchedule(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_schedule.toml")
+
+	if s := cfg.TunnelsMap["default"].RetrySchedule; s != nil {
+		t.Errorf("default: RetrySchedule = %v, want nil", s)
+	}
+	for _, name := range []string{"own", "aliased"} {
+		if s := cfg.TunnelsMap[name].RetrySchedule; !slices.Equal(s, []int{1, 5, 30}) {
+			t.Errorf("%s: RetrySchedule = %v, want [1 5 30]", name, s)
+		}
+	}
+}
+
+func TestReconnectScheduleInvalid(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	for _, f := range []string{"reconnect_schedule.toml", "reconnect_schedule_empty.toml"} {
+		Path = "../../test/testdata/config/invalid/" + f
+		if _, err := Load(); err == nil {
+			t.Errorf("%s: expected error for invalid reconnect_schedule", f)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_labels.toml")
+
+	want := tunnel.Labels{"team": "infra", "ticket": "OPS-1"}
+	if l := cfg.TunnelsMap["db"].Labels; !maps.Equal(l, want) {
+		t.Errorf("Labels = %v, want %v", l, want)
+	}
+}
+
+func TestLabelsInvalid(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	for _, f := range []string{"labels_name.toml", "labels_count.toml"} {
+		Path = "../../test/testdata/config/invalid/" + f
+		if _, err := Load(); err == nil {
+			t.Errorf("%s: expected error for invalid labels", f)
+		}
+	}
+}
+
+func TestSecurityProfile(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_profile.toml")
+
+	want := map[string]string{"global": "fips", "own": "modern"}
+	for name, p := range want {
+		if tun := cfg.TunnelsMap[name]; tun.Profile != p {
+			t.Errorf("%s: Profile = %q, want %q", name, tun.Profile, p)
+		}
+	}
+}
+
+func TestSecurityProfileInvalid(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = "../../test/testdata/config/invalid/security_profile.toml"
+	if _, err := Load(); err == nil {
+		t.Error("expected error for unknown security_profile")
+	}
+}
+
+func TestClientVersion(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_client_version.toml")
+
+	want := map[string]string{"global": "SSH-2.0-OpenSSH_9.9", "own": "SSH-2.0-PuTTY_Release_0.81"}
+	for name, v := range want {
+		if tun := cfg.TunnelsMap[name]; tun.ClientVersion != v {
+			t.Errorf("%s: ClientVersion = %q, want %q", name, tun.ClientVersion, v)
+		}
+	}
+}
+
+func TestClientVersionInvalid(t *testing.T) {
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = "../../test/testdata/config/invalid/client_version.toml"
+	if _, err := Load(); err == nil {
+		t.Error("expected error for client_version without SSH-2.0- prefix")
+	}
+}
+
+func TestAlias(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_alias.toml")
+
+	admin := cfg.TunnelsMap["web-admin"]
+	if admin.Host != "example.com" || admin.User != "alice" ||
+		admin.IdentityFile != "~/.ssh/id_web" || admin.Port.String() != "2222" {
+		t.Errorf("web-admin did not inherit connection settings: %+v", admin)
+	}
+	if admin.KeepAlive == nil || *admin.KeepAlive != 30 {
+		t.Errorf("web-admin: KeepAlive = %v, want 30", admin.KeepAlive)
+	}
+	if admin.LocalAddress.String() != "8081" || admin.RemoteAddress.String() != "8000" {
+		t.Errorf("web-admin: addresses %v -> %v overridden", admin.LocalAddress, admin.RemoteAddress)
+	}
+	if admin.Resolver != "corp" || admin.LogFile != "web.log" || admin.CopyBufSize != 65536 {
+		t.Errorf("web-admin did not inherit resolver, log file and buffer size: %+v", admin)
+	}
+	if admin.MaxConns != 0 {
+		t.Errorf("web-admin: MaxConns = %d, want own", admin.MaxConns)
+	}
+	if bob := cfg.TunnelsMap["web-bob"]; bob.User != "bob" || bob.Host != "example.com" {
+		t.Errorf("web-bob: User = %q, Host = %q, want own user", bob.User, bob.Host)
+	}
+}
+
+// Each setting must be declared whether aliases inherit it, so that new
+// ones are not left out of resolveAliases by accident
+func TestAliasTags(t *testing.T) {
+	typ := reflect.TypeFor[tunnel.Desc]()
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		if f.Tag.Get("toml") == "-" {
+			continue
+		}
+		if a := f.Tag.Get("alias"); a != "inherit" && a != "own" {
+			t.Errorf("%s: alias tag %q, want \"inherit\" or \"own\"", f.Name, a)
+		}
+	}
+}
+
+func TestAliasInvalid(t *testing.T) {
+	for _, f := range []string{"alias_unknown.toml", "alias_chain.toml"} {
+		orig := Path
+		t.Cleanup(func() { Path = orig })
+		Path = "../../test/testdata/config/invalid/" + f
+		if _, err := Load(); err == nil {
+			t.Errorf("%s: expected error", f)
+		}
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	for _, f := range []string{"config.toml", "config_alias.toml", "config_labels.toml"} {
+		cfg := loadFixture(t, "../../test/testdata/config/"+f)
+
+		var buf bytes.Buffer
+		if err := Export(&buf, cfg.Tunnels); err != nil {
+			t.Fatalf("%s: Export() error: %v", f, err)
+		}
+		path := filepath.Join(t.TempDir(), "exported.toml")
+		if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got := loadFixture(t, path)
+
+		if len(got.Tunnels) != len(cfg.Tunnels) {
+			t.Fatalf("%s: got %d tunnels, want %d", f, len(got.Tunnels), len(cfg.Tunnels))
+		}
+		for i := range cfg.Tunnels {
+			want := cfg.Tunnels[i]
+			want.Alias = ""
+			if !reflect.DeepEqual(got.Tunnels[i], want) {
+				t.Errorf("%s: tunnel %d changed:\n got %+v\nwant %+v\n%s",
+					f, i, got.Tunnels[i], want, buf.String())
+			}
+		}
+	}
+}
+
+func TestExportAliasAlone(t *testing.T) {
+	cfg := loadFixture(t, "../../test/testdata/config/config_alias.toml")
+
+	// The aliased tunnel is not needed to load the exported one
+	var buf bytes.Buffer
+	if err := Export(&buf, []tunnel.Desc{*cfg.TunnelsMap["web-admin"]}); err != nil {
+		t.Fatalf("Export() error: %v", err)
+	}
+	if strings.Contains(buf.String(), "alias") {
+		t.Errorf("expected alias to be resolved:\n%s", buf.String())
+	}
+	path := filepath.Join(t.TempDir(), "exported.toml")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if admin := loadFixture(t, path).TunnelsMap["web-admin"]; admin.Host != "example.com" {
+		t.Errorf("web-admin: Host = %q, want example.com", admin.Host)
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// Export writes tunnels as a config file that loads back into the same
+// tunnels. Options are written in the order of tunnel.Desc, leaving out
+// unset ones and runtime state, so that the result reads like a config
+// written by hand. Settings taken from an aliased tunnel or the global
+// options are written for each tunnel.
+func Export(w io.Writer, tunnels []tunnel.Desc) error {
+	var buf bytes.Buffer
+	for i, t := range tunnels {
+		// Settings of aliases are resolved already, and placeholders for
+		// unused addresses are set again on loading
+		t.Alias = ""
+		switch t.Mode {
+		case tunnel.Socks:
+			t.RemoteAddress = ""
+		case tunnel.RemoteSocks:
+			t.LocalAddress = ""
+		}
+
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("[[tunnels]]\n")
+		var tables []reflect.StructField
+		v := reflect.ValueOf(t)
+		for _, f := range reflect.VisibleFields(v.Type()) {
+			key, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+			if key == "" || key == "-" || v.FieldByIndex(f.Index).IsZero() {
+				continue
+			}
+			// Tables must follow all keys of the tunnel
+			if f.Type.Kind() == reflect.Map {
+				tables = append(tables, f)
+				continue
+			}
+			b, err := toml.Marshal(map[string]any{key: v.FieldByIndex(f.Index).Interface()})
+			if err != nil {
+				return fmt.Errorf("tunnel '%v': %v: %w", t.Name, key, err)
+			}
+			buf.Write(b)
+		}
+		for _, f := range tables {
+			key, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+			b, err := toml.Marshal(v.FieldByIndex(f.Index).Interface())
+			if err != nil {
+				return fmt.Errorf("tunnel '%v': %v: %w", t.Name, key, err)
+			}
+			fmt.Fprintf(&buf, "\n[tunnels.%s]\n", key)
+			buf.Write(b)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -14,14 +14,26 @@ const (
 	Close
 	List
 	Shutdown
+	Reexec
+	Debug
+	Rename
+	Arm
+	Disarm
+	Reconnect
 )
 
 var cmdKindNames = map[CmdKind]string{
-	Nop:      "Nop",
-	Open:     "Open",
-	Close:    "Close",
-	List:     "List",
-	Shutdown: "Shutdown",
+	Nop:       "Nop",
+	Open:      "Open",
+	Close:     "Close",
+	List:      "List",
+	Shutdown:  "Shutdown",
+	Reexec:    "Reexec",
+	Debug:     "Debug",
+	Rename:    "Rename",
+	Arm:       "Arm",
+	Disarm:    "Disarm",
+	Reconnect: "Reconnect",
 }
 
 func (k CmdKind) String() string {
@@ -36,4 +48,9 @@ func (k CmdKind) String() string {
 type Cmd struct {
 	Kind   CmdKind      `json:"kind"`
 	Tunnel *tunnel.Desc `json:"tunnel,omitempty"`
+	Token  string       `json:"token,omitempty"` // see TokenFile
+	Dump   bool         `json:"dump,omitempty"`  // with Debug, include goroutines
+	Name   string       `json:"name,omitempty"`  // with Rename, the new name
+	Wait   bool         `json:"wait,omitempty"`  // with Open, until the tunnel is ready
+	Port   string       `json:"port,omitempty"`  // with Close and List, selects tunnels by local port
 }
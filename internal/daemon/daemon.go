@@ -2,15 +2,19 @@ package daemon
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,12 +28,24 @@ const (
 	Flag        = "--daemon"
 	sockName    = "boringd.sock"
 	logFileName = "boringd.log"
+
+	// readyTimeout is how long opening with Cmd.Wait waits for a tunnel
+	// to be ready, see tunnel.WaitReady
+	readyTimeout = 30 * time.Second
+
+	// maxOpening bounds how many tunnels establish their connections at
+	// once, e.g. when a batch of tunnels is opened or restored. Batches
+	// are not serialized, but neither do they flood the network.
+	maxOpening = 8
 )
 
 var (
 	LogFile        string
 	Socket         string
+	StateFile      string
+	TokenFile      string
 	AlreadyRunning = errors.New("already running")
+	NotRunning     = errors.New("tunnel not running")
 )
 
 func init() {
@@ -39,6 +55,28 @@ func init() {
 	if Socket = os.Getenv("BORING_SOCK"); Socket == "" {
 		Socket = filepath.Join(os.TempDir(), sockName)
 	}
+	StateFile = os.Getenv("BORING_STATE_FILE")
+	TokenFile = os.Getenv("BORING_TOKEN_FILE")
+}
+
+// Executable returns the binary that runs the daemon, which is the current
+// executable unless overridden by $BORING_DAEMON_BIN, e.g., when packaging
+// wraps the binary or os.Executable points to a replaced or symlinked file.
+func Executable() (string, error) {
+	p := os.Getenv("BORING_DAEMON_BIN")
+	if p == "" {
+		ex, err := os.Executable()
+		if err != nil {
+			return "", fmt.Errorf("could not determine executable path: %v", err)
+		}
+		return ex, nil
+	}
+	// Fails unless p is an executable file
+	p, err := exec.LookPath(p)
+	if err != nil {
+		return "", fmt.Errorf("invalid BORING_DAEMON_BIN: %v", err)
+	}
+	return filepath.Abs(p)
 }
 
 type daemon struct {
@@ -49,15 +87,41 @@ type daemon struct {
 	// TODO: write proper concurrent map structure for this
 	tunnels map[string]*tunnel.Tunnel
 	mutex   sync.RWMutex
+	// Names of tunnels being opened, guarded by mutex. The channels are
+	// closed once the tunnel is running or failed to open.
+	opening map[string]chan struct{}
+	// Slots for tunnels being opened, see maxOpening
+	openSlots chan struct{}
 
 	once sync.Once
 	wg   sync.WaitGroup
+
+	// Set while starting a new process to hand over to, see reexec
+	handingOver atomic.Bool
+	// Set if the daemon hands over to a new process after stopping
+	handover *handover
+	// Set if the daemon runs inside another process, which cannot be re-exec'd
+	inProcess bool
+
+	// Set if running tunnels are persisted, to be restored on the next start
+	stateFile string
+	stateMu   sync.Mutex
+
+	// Set if commands must carry this token, see TokenFile
+	token string
 }
 
 func newDaemon(parent context.Context, ln net.Listener) (*daemon, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(parent)
 	tunnels := make(map[string]*tunnel.Tunnel)
-	d := &daemon{ctx: ctx, cancel: cancel, ln: ln, tunnels: tunnels}
+	d := &daemon{
+		ctx:       ctx,
+		cancel:    cancel,
+		ln:        ln,
+		tunnels:   tunnels,
+		opening:   make(map[string]chan struct{}),
+		openSlots: make(chan struct{}, maxOpening),
+	}
 
 	go func() {
 		// Parent-driven shutdown
@@ -96,6 +160,7 @@ func respond(conn net.Conn, opErr error, ts map[string]tunnel.Desc) {
 	if opErr != nil {
 		resp.Success = false
 		resp.Error = opErr.Error()
+		resp.ErrKind = errKind(opErr)
 	}
 	if err := ipc.Write(resp, conn); err != nil {
 		log.Errorf("could not send response: %v", err)
@@ -125,65 +190,178 @@ func (d *daemon) handleConn(conn net.Conn) {
 		}
 		return
 	}
+	if d.token != "" && subtle.ConstantTimeCompare([]byte(cmd.Token), []byte(d.token)) != 1 {
+		log.Warningf("Rejected %v command with invalid token", cmd.Kind)
+		respond(conn, InvalidToken, nil)
+		return
+	}
+	// Keep the token out of logs
+	cmd.Token = ""
 	log.Debugf("Received command %v", cmd)
 
-	if (cmd.Kind == Open || cmd.Kind == Close) && cmd.Tunnel == nil {
+	if (cmd.Kind == Open || cmd.Kind == Close && cmd.Port == "" || cmd.Kind == Rename ||
+		cmd.Kind == Arm || cmd.Kind == Disarm || cmd.Kind == Reconnect) && cmd.Tunnel == nil {
 		err := fmt.Errorf("no tunnel specified")
 		respond(conn, err, nil)
 		return
 	}
+	// The new process takes over the tunnels as they were when it started
+	if d.handingOver.Load() && cmd.Kind != Nop && cmd.Kind != List && cmd.Kind != Debug {
+		respond(conn, fmt.Errorf("re-exec in progress"), nil)
+		return
+	}
 
 	// Execute command
 	switch cmd.Kind {
 	case Nop:
 		respond(conn, nil, nil)
 	case Open:
-		d.openTunnel(conn, cmd.Tunnel)
+		d.openTunnel(conn, cmd.Tunnel, cmd.Wait)
 	case Close:
-		d.closeTunnel(conn, cmd.Tunnel)
+		if cmd.Port != "" {
+			d.closeByPort(conn, cmd.Port)
+		} else {
+			d.closeTunnel(conn, cmd.Tunnel)
+		}
 	case List:
-		d.listTunnels(conn)
+		d.listTunnels(conn, cmd.Port)
 	case Shutdown:
 		log.Infof("Shutdown command received.")
 		respond(conn, nil, nil)
 		d.stop()
+	case Reexec:
+		d.reexec(conn)
+	case Debug:
+		d.debug(conn, cmd.Dump)
+	case Rename:
+		d.renameTunnel(conn, cmd.Tunnel, cmd.Name)
+	case Arm, Disarm:
+		d.armTunnel(conn, cmd.Tunnel, cmd.Kind == Arm)
+	case Reconnect:
+		d.reconnectTunnel(conn, cmd.Tunnel)
 	default:
 		err := fmt.Errorf("unknown command: %v", cmd.Kind)
 		respond(conn, err, nil)
 	}
 }
 
-func (d *daemon) openTunnel(conn net.Conn, desc *tunnel.Desc) {
-	var err error
-	defer func() { respond(conn, err, nil) }()
+// openTunnel opens the tunnel desc. With wait, it responds once the tunnel
+// is ready, also if it was running already, or with the reason why it is
+// not. The tunnel keeps running in that case.
+func (d *daemon) openTunnel(conn net.Conn, desc *tunnel.Desc, wait bool) {
+	err := d.open(desc)
+	if err != nil && !(wait && errors.Is(err, AlreadyRunning)) {
+		respond(conn, err, nil)
+		return
+	}
+	if wait {
+		t := d.running(desc.Name)
+		// Closed right away, or another command failed to open it
+		if t == nil {
+			respond(conn, fmt.Errorf("%w: tunnel closed", tunnel.NotReady), nil)
+			return
+		}
+		if rerr := t.WaitReady(readyTimeout); rerr != nil {
+			log.Errorf("%v: %v", desc.Name, rerr)
+			respond(conn, rerr, nil)
+			return
+		}
+		if err != nil {
+			respond(conn, err, nil)
+			return
+		}
+	}
+	// Respond with the opened tunnel, so that its banner can be shown
+	ts := d.snapshot()
+	if t, ok := ts[desc.Name]; ok {
+		respond(conn, nil, map[string]tunnel.Desc{t.Name: t})
+		return
+	}
+	respond(conn, nil, nil)
+}
 
-	d.mutex.RLock()
+// open opens a tunnel from desc. Tunnels of a batch are opened by
+// concurrent calls, of which at most maxOpening connect at once. The name
+// is reserved while connecting, so that concurrent calls for the same
+// tunnel do not both open it.
+func (d *daemon) open(desc *tunnel.Desc) error {
+	d.mutex.Lock()
 	_, exists := d.tunnels[desc.Name]
-	d.mutex.RUnlock()
-	if exists {
-		err = AlreadyRunning
-		log.Errorf("%v: could not open: %v", desc.Name, err)
-		return
+	_, opening := d.opening[desc.Name]
+	handingOver := d.handingOver.Load()
+	if !exists && !opening && !handingOver {
+		d.opening[desc.Name] = make(chan struct{})
+	}
+	d.mutex.Unlock()
+	if handingOver {
+		return fmt.Errorf("re-exec in progress")
+	}
+	if exists || opening {
+		log.Errorf("%v: could not open: %v", desc.Name, AlreadyRunning)
+		return AlreadyRunning
 	}
 
 	t := tunnel.FromDesc(desc)
-	if err = t.Open(); err != nil {
-		log.Errorf("%v: could not open: %v", t.Name, err)
-		return
-	}
+	d.openSlots <- struct{}{}
+	err := t.Open()
+	<-d.openSlots
 
 	d.mutex.Lock()
-	d.tunnels[t.Name] = t
+	close(d.opening[desc.Name])
+	delete(d.opening, desc.Name)
+	if err == nil {
+		d.tunnels[desc.Name] = t
+	}
 	d.mutex.Unlock()
+	if err != nil {
+		log.Errorf("%v: could not open: %v", desc.Name, err)
+		return err
+	}
+	d.saveState()
 
 	// Register closing logic
 	go func() {
 		<-t.Closed
-		d.mutex.Lock()
-		delete(d.tunnels, t.Name)
-		d.mutex.Unlock()
-		log.Infof("Closed tunnel %s", t.Name)
+		d.remove(t)
 	}()
+	return nil
+}
+
+// running returns the running tunnel of the given name, or nil if there is
+// none. If another command is opening it, that is waited for.
+func (d *daemon) running(name string) *tunnel.Tunnel {
+	d.mutex.RLock()
+	t, ok := d.tunnels[name]
+	done, opening := d.opening[name]
+	d.mutex.RUnlock()
+	if ok || !opening {
+		return t
+	}
+	select {
+	case <-done:
+	case <-d.ctx.Done():
+		return nil
+	}
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.tunnels[name]
+}
+
+// remove forgets about the closed tunnel t, unless it was already replaced.
+// The state is saved either way, so that it is up to date once this returns,
+// even if a concurrent call removed t.
+func (d *daemon) remove(t *tunnel.Tunnel) {
+	d.mutex.Lock()
+	name := t.Name()
+	removed := d.tunnels[name] == t
+	if removed {
+		delete(d.tunnels, name)
+	}
+	d.mutex.Unlock()
+	d.saveState()
+	if removed {
+		log.Infof("Closed tunnel %s", name)
+	}
 }
 
 func (d *daemon) closeTunnel(conn net.Conn, q *tunnel.Desc) {
@@ -194,37 +372,279 @@ func (d *daemon) closeTunnel(conn net.Conn, q *tunnel.Desc) {
 	t, ok := d.tunnels[q.Name]
 	d.mutex.RUnlock()
 	if !ok {
-		err = fmt.Errorf("tunnel not running")
+		err = NotRunning
 		log.Errorf("%v: could not close tunnel: %v", q.Name, err)
 		return
 	}
+	err = d.close(t)
+}
 
-	if err = t.Close(); err != nil {
-		log.Errorf("%v: could not close tunnel: %v", t.Name, err)
+// closeByPort closes all tunnels bound to the given local port, responding
+// with the closed ones
+func (d *daemon) closeByPort(conn net.Conn, port string) {
+	var ts []*tunnel.Tunnel
+	d.mutex.RLock()
+	for _, t := range d.tunnels {
+		if t.LocalPort() == port {
+			ts = append(ts, t)
+		}
+	}
+	d.mutex.RUnlock()
+	if len(ts) == 0 {
+		respond(conn, fmt.Errorf("%w on local port %s", NotRunning, port), nil)
 		return
 	}
+
+	var err error
+	closed := make(map[string]tunnel.Desc, len(ts))
+	for _, t := range ts {
+		if cerr := d.close(t); cerr != nil {
+			err = cerr
+			continue
+		}
+		desc := t.Describe()
+		closed[desc.Name] = desc
+	}
+	respond(conn, err, closed)
+}
+
+// close closes t and waits until it is gone from the list and state
+func (d *daemon) close(t *tunnel.Tunnel) error {
+	if err := t.Close(); err != nil {
+		log.Errorf("%v: could not close tunnel: %v", t.Name(), err)
+		return err
+	}
 	<-t.Closed
+	d.remove(t)
+	return nil
+}
+
+// renameTunnel gives the running tunnel q the given name, which must not
+// be taken by another running tunnel. The tunnel keeps running, and so do
+// its connections.
+func (d *daemon) renameTunnel(conn net.Conn, q *tunnel.Desc, name string) {
+	var err error
+	defer func() { respond(conn, err, nil) }()
+
+	d.mutex.Lock()
+	t, ok := d.tunnels[q.Name]
+	_, taken := d.tunnels[name]
+	_, opening := d.opening[name]
+	taken = taken || opening
+	switch {
+	case name == "":
+		err = fmt.Errorf("no new name specified")
+	case !ok:
+		err = NotRunning
+	case taken:
+		err = fmt.Errorf("'%v' %w", name, AlreadyRunning)
+	default:
+		delete(d.tunnels, q.Name)
+		t.Rename(name)
+		d.tunnels[name] = t
+	}
+	d.mutex.Unlock()
+	if err != nil {
+		log.Errorf("%v: could not rename tunnel: %v", q.Name, err)
+		return
+	}
+	log.Infof("%v: renamed to %v", q.Name, name)
+	d.saveState()
 }
 
-func (d *daemon) listTunnels(conn net.Conn) {
+func (d *daemon) armTunnel(conn net.Conn, q *tunnel.Desc, armed bool) {
+	var err error
+	defer func() { respond(conn, err, nil) }()
+
+	d.mutex.RLock()
+	t, ok := d.tunnels[q.Name]
+	d.mutex.RUnlock()
+	if !ok {
+		err = NotRunning
+	} else {
+		err = t.SetArmed(armed)
+	}
+	if err != nil {
+		verb := map[bool]string{true: "arm", false: "disarm"}[armed]
+		log.Errorf("%v: could not %v tunnel: %v", q.Name, verb, err)
+		return
+	}
+	d.saveState()
+}
+
+// reconnectTunnel makes a tunnel that waits to re-connect try right away.
+// The response tells whether it was re-connecting, and holds the tunnel, so
+// that clients can show its status otherwise.
+func (d *daemon) reconnectTunnel(conn net.Conn, q *tunnel.Desc) {
 	d.mutex.RLock()
+	t, ok := d.tunnels[q.Name]
+	d.mutex.RUnlock()
+	if !ok {
+		respond(conn, NotRunning, nil)
+		return
+	}
+	resp := Resp{
+		Success: true,
+		Retried: t.RetryNow(),
+		Tunnels: map[string]tunnel.Desc{q.Name: t.Describe()},
+		Info:    Info{Commit: buildinfo.Commit},
+	}
+	if err := ipc.Write(resp, conn); err != nil {
+		log.Errorf("could not send response: %v", err)
+	}
+}
+
+// listTunnels responds with the running tunnels, only those bound to the
+// given local port unless it is empty
+func (d *daemon) listTunnels(conn net.Conn, port string) {
+	ts := d.snapshot()
+	if port != "" {
+		maps.DeleteFunc(ts, func(_ string, t tunnel.Desc) bool {
+			return t.LocalPort() != port
+		})
+	}
+	respond(conn, nil, ts)
+}
+
+func (d *daemon) snapshot() map[string]tunnel.Desc {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
 	ts := make(map[string]tunnel.Desc, len(d.tunnels))
 	for n, t := range d.tunnels {
-		ts[n] = *t.Desc
+		ts[n] = t.Describe()
 	}
+	return ts
+}
+
+// reexec starts a new daemon process to hand over the listener and all
+// running tunnels to, and stops serving once it is ready. If it does not
+// get ready, this process keeps serving as before.
+func (d *daemon) reexec(conn net.Conn) {
+	if d.inProcess {
+		respond(conn, fmt.Errorf("re-exec not supported in-process"), nil)
+		return
+	}
+	if !d.handingOver.CompareAndSwap(false, true) {
+		respond(conn, fmt.Errorf("re-exec in progress"), nil)
+		return
+	}
+	log.Infof("Re-exec command received.")
+	// Tunnels being opened would be missing in the new process
+	d.mutex.RLock()
+	opening := len(d.opening)
 	d.mutex.RUnlock()
-	respond(conn, nil, ts)
+	var h *handover
+	err := fmt.Errorf("%d tunnel(s) being opened, try again", opening)
+	if opening == 0 {
+		h, err = startHandover(d.ln, d.snapshot())
+	}
+	if err != nil {
+		d.handingOver.Store(false)
+		log.Errorf("Could not re-exec: %v", err)
+		respond(conn, err, nil)
+		return
+	}
+	d.handover = h
+	respond(conn, nil, nil)
+	d.stop()
+}
+
+// handOver lets the tunnels stop listening, so that the new process can
+// open them, and waits for their connections to be done. ctx cuts the wait
+// short, ending the connections with the process.
+func (d *daemon) handOver(ctx context.Context) {
+	d.mutex.Lock()
+	ts := make([]*tunnel.Tunnel, 0, len(d.tunnels))
+	for _, t := range d.tunnels {
+		ts = append(ts, t)
+	}
+	d.mutex.Unlock()
+
+	free := make([]<-chan struct{}, len(ts))
+	for i, t := range ts {
+		free[i] = t.Drain()
+	}
+	for _, f := range free {
+		<-f
+	}
+	d.handover.release()
+	log.Infof("Handed over to new daemon process, draining %d tunnel(s)", len(ts))
+
+	for _, t := range ts {
+		select {
+		case <-t.Closed:
+		case <-ctx.Done():
+			log.Infof("Not waiting for tunnels to drain: %v", ctx.Err())
+			return
+		}
+	}
+	d.wg.Wait()
+	log.Infof("Done.")
+}
+
+// restore re-opens tunnels handed over by a previous daemon process or
+// saved in the state file. Tunnels that cannot be opened anymore, e.g.,
+// because their address is taken, are skipped and dropped from the state.
+func (d *daemon) restore(descs []tunnel.Desc) {
+	var wg sync.WaitGroup
+	for i := range descs {
+		// Connections of the previous process are gone
+		descs[i].Conns, descs[i].PeakConns, descs[i].Queued = 0, 0, 0
+		wg.Add(1)
+		go func(desc *tunnel.Desc) {
+			defer wg.Done()
+			if err := d.open(desc); err == nil {
+				log.Infof("%v: restored tunnel", desc.Name)
+			}
+		}(&descs[i])
+	}
+	wg.Wait()
+	d.saveState()
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 }
 
 func initLogging(path string) {
-	logFile, err := os.OpenFile(
-		path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	logFile, err := openLogFile(path)
 	if err != nil {
 		log.Fatalf("Failed to open log file: %v", err)
 	}
 	log.Init(logFile, true, runtime.GOOS != "windows")
 }
 
+// reopenLogs reopens the log file at its path, and those of tunnels,
+// whenever SIGHUP is received, so that they can be rotated by external
+// tools like logrotate. The handler is registered before returning, as
+// SIGHUP terminates the process otherwise.
+func reopenLogs(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go handleHangups(ctx, path, hup)
+}
+
+func handleHangups(ctx context.Context, path string, hup chan os.Signal) {
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.ReopenFiles()
+			f, err := openLogFile(path)
+			if err != nil {
+				log.Errorf("Could not reopen log file: %v", err)
+				continue
+			}
+			if old, ok := log.SetWriter(f).(io.Closer); ok {
+				old.Close()
+			}
+			log.Infof("Reopened log file")
+		}
+	}
+}
+
 func listen() (l net.Listener, err error) {
 	l, err = net.Listen("unix", Socket)
 	if err == nil {
@@ -270,21 +690,69 @@ func (d *daemon) stop() {
 	})
 }
 
+// Serve handles commands on ln until ctx is done or a shutdown command is
+// received, and closes all tunnels before returning. Unlike Run, it leaves
+// logging, signals and the socket to the caller, so that the daemon can be
+// run in-process, e.g., in tests.
+func Serve(ctx context.Context, ln net.Listener) {
+	d, cleanup := newDaemon(ctx, ln)
+	d.inProcess = true
+	d.serve()
+	cleanup()
+}
+
 func Run() {
 	initLogging(LogFile)
-	log.Infof("Daemon starting")
+	log.Infof("Daemon starting (PID %d)", os.Getpid())
 
-	ln, err := listen()
+	// Handle signals before clients can reach us
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	reopenLogs(ctx, LogFile)
+
+	ln, tk, err := inherit()
 	if err != nil {
-		log.Fatalf("Failed to setup listener: %v", err)
+		log.Fatalf("Failed to take over from previous daemon: %v", err)
+	}
+	if ln == nil {
+		if ln, err = listen(); err != nil {
+			log.Fatalf("Failed to setup listener: %v", err)
+		}
 	}
 	log.Infof("Listening on %s", ln.Addr())
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
-	defer cancel()
-
 	d, cleanup := newDaemon(ctx, ln)
-	defer cleanup()
+	d.stateFile = StateFile
+	// Only after listening succeeded, so the token of a running daemon is kept
+	if TokenFile != "" {
+		if d.token, err = writeToken(TokenFile); err != nil {
+			log.Fatalf("Failed to write token file: %v", err)
+		}
+	}
+	var restore []tunnel.Desc
+	if tk != nil {
+		restore = tk.tunnels
+		// The previous process keeps serving until we are ready, and
+		// releases the tunnels' addresses only then
+		if err := tk.ready(); err != nil {
+			log.Warningf("Previous daemon did not hand over cleanly: %v", err)
+		}
+	} else if StateFile != "" {
+		if restore, err = loadState(StateFile); err != nil {
+			log.Errorf("Could not load state: %v", err)
+		}
+	}
+	if len(restore) > 0 {
+		go d.restore(restore)
+	}
 
 	d.serve()
+	if d.handover != nil {
+		d.handOver(ctx)
+		return
+	}
+	cleanup()
+	if d.token != "" {
+		os.Remove(TokenFile)
+	}
 }
@@ -0,0 +1,37 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+	"github.com/alebeck/boring/internal/tunnel"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// errKind categorizes err for the client. Authentication failures are
+// only reported as text by x/crypto, SSH config errors wrap sentinels.
+func errKind(err error) ErrKind {
+	var ke *knownhosts.KeyError
+	var re *knownhosts.RevokedError
+	var oe *net.OpError
+	var de *net.DNSError
+	switch {
+	case errors.Is(err, NotRunning):
+		return ErrNotFound
+	case errors.As(err, &ke), errors.As(err, &re), errors.Is(err, ssh_config.NoHostKeyAlgos),
+		errors.Is(err, ssh_config.HostKeyRejected):
+		return ErrHostKey
+	case errors.Is(err, ssh_config.NoKeys), strings.Contains(err.Error(), "ssh: unable to authenticate"):
+		return ErrAuth
+	case errors.Is(err, ssh_config.InvalidOption), errors.Is(err, ssh_config.Unsupported),
+		errors.Is(err, ssh_config.JumpLoop):
+		return ErrConfig
+	case errors.Is(err, tunnel.CannotListen):
+		return ErrBind
+	case errors.As(err, &oe), errors.As(err, &de):
+		return ErrConnect
+	}
+	return ErrOther
+}
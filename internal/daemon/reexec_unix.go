@@ -0,0 +1,235 @@
+//go:build linux || darwin
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/alebeck/boring/internal/ipc"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+const (
+	listenerFDEnv = "BORING_LISTENER_FD"
+	handoverFDEnv = "BORING_HANDOVER_FD"
+
+	// handoverTimeout is how long the new daemon process may take to get
+	// ready, and how long it waits for the tunnels to be released
+	handoverTimeout = 10 * time.Second
+)
+
+// handoverMsg is sent over the handover socket. The old process sends the
+// tunnels, which the new one acknowledges with Ready. Closing the socket
+// then releases the tunnels, see handover.release.
+type handoverMsg struct {
+	Tunnels []tunnel.Desc `json:"tunnels,omitempty"`
+	Ready   bool          `json:"ready,omitempty"`
+}
+
+// handover is a new daemon process that is ready to take over the
+// listener and the running tunnels
+type handover struct {
+	conn net.Conn
+}
+
+// startHandover starts the daemon executable in daemon mode, passing the
+// listener as file descriptor 3 and a socket to hand over the tunnels as
+// file descriptor 4, and waits for it to get ready. Nothing is torn down,
+// so that the current process keeps serving if this fails.
+func startHandover(ln net.Listener, ts map[string]tunnel.Desc) (_ *handover, err error) {
+	ul, ok := ln.(*net.UnixListener)
+	if !ok {
+		return nil, fmt.Errorf("listener of type %T cannot be handed over", ln)
+	}
+	descs := make([]tunnel.Desc, 0, len(ts))
+	for _, t := range ts {
+		descs = append(descs, t)
+	}
+	lf, err := ul.File()
+	if err != nil {
+		return nil, fmt.Errorf("could not get listener file: %v", err)
+	}
+	defer lf.Close()
+	conn, theirs, err := socketPair()
+	if err != nil {
+		return nil, fmt.Errorf("could not create handover socket: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
+	ex, err := Executable()
+	if err != nil {
+		theirs.Close()
+		return nil, err
+	}
+	cmd := exec.Command(ex, Flag)
+	cmd.Env = append(os.Environ(), listenerFDEnv+"=3", handoverFDEnv+"=4")
+	cmd.ExtraFiles = []*os.File{lf, theirs}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	// The socket file must survive closing our listener, unless we keep it
+	ul.SetUnlinkOnClose(false)
+	err = cmd.Start()
+	// Only the new process may hold its end, so that reading fails if it exits
+	theirs.Close()
+	// Passing the listener put it into blocking mode, which our listener
+	// shares, so that closing it could not interrupt Accept anymore
+	if rc, rcErr := ul.SyscallConn(); rcErr == nil {
+		rc.Control(func(fd uintptr) { syscall.SetNonblock(int(fd), true) })
+	}
+	if err != nil {
+		ul.SetUnlinkOnClose(true)
+		return nil, fmt.Errorf("could not start new daemon process: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			ul.SetUnlinkOnClose(true)
+			cmd.Process.Kill()
+			cmd.Wait()
+			return
+		}
+		cmd.Process.Release()
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(handoverTimeout))
+	if err = ipc.Write(handoverMsg{Tunnels: descs}, conn); err != nil {
+		return nil, fmt.Errorf("could not hand over tunnels: %v", err)
+	}
+	var msg handoverMsg
+	if err = ipc.Read(&msg, conn); err == nil && !msg.Ready {
+		err = errors.New("not ready")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new daemon process did not take over: %v", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &handover{conn: conn}, nil
+}
+
+// release lets the new process open the tunnels, once they stopped
+// listening here
+func (h *handover) release() {
+	h.conn.Close()
+}
+
+// socketPair returns the two ends of a connected Unix socket, the second
+// one as a file to be passed to a child process
+func socketPair() (net.Conn, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	ours := os.NewFile(uintptr(fds[0]), "handover")
+	defer ours.Close()
+	conn, err := net.FileConn(ours)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return conn, os.NewFile(uintptr(fds[1]), "handover"), nil
+}
+
+// takeover is the side of the new process of a handover
+type takeover struct {
+	conn    net.Conn
+	tunnels []tunnel.Desc
+}
+
+// ready tells the previous process that we took over, and waits for it to
+// release the tunnels, so that their addresses are free
+func (t *takeover) ready() error {
+	defer t.conn.Close()
+	_ = t.conn.SetDeadline(time.Now().Add(handoverTimeout))
+	if err := ipc.Write(handoverMsg{Ready: true}, t.conn); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, t.conn); err != nil {
+		return fmt.Errorf("tunnels not released: %v", err)
+	}
+	return nil
+}
+
+// inherit returns the listener and tunnels handed over by a previous
+// daemon process, if any. A nil listener means we were started normally.
+func inherit() (net.Listener, *takeover, error) {
+	lnFD, hoFD := os.Getenv(listenerFDEnv), os.Getenv(handoverFDEnv)
+	if lnFD == "" {
+		return nil, nil, nil
+	}
+	// Don't pass these on to processes started by us
+	os.Unsetenv(listenerFDEnv)
+	os.Unsetenv(handoverFDEnv)
+
+	ln, err := inheritListener(lnFD)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := inheritConn(hoFD)
+	if err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(handoverTimeout))
+	var msg handoverMsg
+	if err := ipc.Read(&msg, conn); err != nil {
+		conn.Close()
+		ln.Close()
+		return nil, nil, fmt.Errorf("could not receive tunnels: %v", err)
+	}
+	return ln, &takeover{conn: conn, tunnels: msg.Tunnels}, nil
+}
+
+func inheritListener(fdStr string) (net.Listener, error) {
+	f, err := inheritFile(fdStr, "listener")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not use inherited listener: %v", err)
+	}
+	// We are responsible for removing the socket file from now on
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+	return ln, nil
+}
+
+func inheritConn(fdStr string) (net.Conn, error) {
+	f, err := inheritFile(fdStr, "handover")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not use handover socket: %v", err)
+	}
+	return conn, nil
+}
+
+func inheritFile(fdStr, name string) (*os.File, error) {
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s fd %q", name, fdStr)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
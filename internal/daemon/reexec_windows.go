@@ -0,0 +1,30 @@
+//go:build windows
+
+package daemon
+
+import (
+	"errors"
+	"net"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+type handover struct{}
+
+func startHandover(net.Listener, map[string]tunnel.Desc) (*handover, error) {
+	return nil, errors.New("re-exec is not supported on Windows")
+}
+
+func (h *handover) release() {}
+
+type takeover struct {
+	tunnels []tunnel.Desc
+}
+
+func (t *takeover) ready() error {
+	return nil
+}
+
+func inherit() (net.Listener, *takeover, error) {
+	return nil, nil, nil
+}
@@ -10,10 +10,27 @@ type Info struct {
 	Commit string `json:"commit"`
 }
 
+// ErrKind categorizes the error of a failed command, so that clients can
+// tell failure modes apart without parsing messages
+type ErrKind int
+
+const (
+	ErrOther    ErrKind = iota
+	ErrNotFound         // tunnel not running
+	ErrAuth             // SSH authentication failed
+	ErrHostKey          // host key unknown, changed or revoked
+	ErrBind             // tunnel address could not be bound
+	ErrConnect          // SSH server could not be reached
+	ErrConfig           // SSH config invalid or unsupported
+)
+
 // Resp represents a response from the daemon
 type Resp struct {
 	Success bool                   `json:"success"`
 	Error   string                 `json:"error,omitempty"`
+	ErrKind ErrKind                `json:"error_kind,omitempty"`
 	Tunnels map[string]tunnel.Desc `json:"tunnels,omitempty"`
 	Info    Info                   `json:"info,omitempty"`
+	Stats   *Stats                 `json:"stats,omitempty"`
+	Retried bool                   `json:"retried,omitempty"` // with Reconnect, if it was re-connecting
 }
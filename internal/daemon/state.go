@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// saveState writes the running tunnels to the state file, if configured.
+// Tunnels closed while the daemon stops are kept, so that they are
+// restored on the next start.
+func (d *daemon) saveState() {
+	if d.stateFile == "" || d.ctx.Err() != nil {
+		return
+	}
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	ts := d.snapshot()
+	descs := make([]tunnel.Desc, 0, len(ts))
+	for _, t := range ts {
+		descs = append(descs, t)
+	}
+	slices.SortFunc(descs, func(a, b tunnel.Desc) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	b, err := json.MarshalIndent(descs, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(d.stateFile, b)
+	}
+	if err != nil {
+		log.Errorf("Could not save state: %v", err)
+	}
+}
+
+// loadState reads the tunnels saved in the state file at path. A missing
+// file means there is nothing to restore.
+func loadState(path string) ([]tunnel.Desc, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var descs []tunnel.Desc
+	if err := json.Unmarshal(b, &descs); err != nil {
+		return nil, err
+	}
+	return descs, nil
+}
+
+// writeFileAtomic replaces the file at path with data, such that it is
+// never left partially written
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+
+	"github.com/alebeck/boring/internal/buildinfo"
+	"github.com/alebeck/boring/internal/ipc"
+	"github.com/alebeck/boring/internal/log"
+)
+
+// Stats describes the runtime state of the daemon, for debugging
+type Stats struct {
+	Tunnels    int            `json:"tunnels"`
+	Goroutines int            `json:"goroutines"`
+	OpenFiles  int            `json:"open_files"` // -1 if unknown
+	Conns      map[string]int `json:"conns,omitempty"`
+	Dump       string         `json:"dump,omitempty"`
+}
+
+// debug responds with the runtime stats of the daemon, including a dump
+// of all goroutines if requested
+func (d *daemon) debug(conn net.Conn, dump bool) {
+	ts := d.snapshot()
+	s := &Stats{
+		Tunnels:    len(ts),
+		Goroutines: runtime.NumGoroutine(),
+		OpenFiles:  openFiles(),
+		Conns:      make(map[string]int, len(ts)),
+	}
+	for n, t := range ts {
+		s.Conns[n] = t.Conns
+	}
+	if dump {
+		var b strings.Builder
+		if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+			log.Errorf("Could not dump goroutines: %v", err)
+		}
+		s.Dump = b.String()
+	}
+
+	resp := Resp{Success: true, Info: Info{Commit: buildinfo.Commit}, Stats: s}
+	if err := ipc.Write(resp, conn); err != nil {
+		log.Errorf("could not send response: %v", err)
+	}
+}
+
+// openFiles counts the open file descriptors of the process, if the
+// system lists them in a directory
+func openFiles() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if es, err := os.ReadDir(dir); err == nil {
+			// Without the one used for reading the directory
+			return len(es) - 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// InvalidToken is returned for commands that lack the token of the daemon
+var InvalidToken = errors.New("invalid token")
+
+// writeToken generates a new token and writes it to path, readable only by
+// the current user
+func writeToken(path string) (string, error) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	log.AddSecret(token)
+	// Written to a new file created with mode 0600, see writeFileAtomic
+	if err := writeFileAtomic(path, []byte(token+"\n")); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ReadToken returns the token that commands must carry, or an empty string
+// if TokenFile is not set or does not exist yet. It refuses token files
+// that other users could read or write, as these cannot be trusted.
+func ReadToken() (string, error) {
+	if TokenFile == "" {
+		return "", nil
+	}
+	f, err := os.Open(TokenFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("token file %s is accessible by other users", TokenFile)
+	}
+	b := make([]byte, 128)
+	n, _ := f.Read(b)
+	token := strings.TrimSpace(string(b[:n]))
+	log.AddSecret(token)
+	return token, nil
+}
@@ -0,0 +1,105 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Files opened by OpenFile, to be reopened by ReopenFiles
+var (
+	files   = make(map[*File]struct{})
+	filesMu sync.Mutex
+)
+
+// File is a log of its own, e.g. of a single tunnel, which is rotated like
+// the main log. A nil *File logs to the main log.
+type File struct {
+	path string
+	l    *logger
+}
+
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+// OpenFile opens the log at path for appending, creating it if needed
+func OpenFile(path string) (*File, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lf := &File{path: path, l: &logger{writer: f, debug: instance.debug, interactive: true}}
+	filesMu.Lock()
+	files[lf] = struct{}{}
+	filesMu.Unlock()
+	return lf, nil
+}
+
+// Close closes the file, further messages go to the main log
+func (f *File) Close() error {
+	filesMu.Lock()
+	delete(files, f)
+	filesMu.Unlock()
+	f.l.mutex.Lock()
+	defer f.l.mutex.Unlock()
+	w := f.l.writer
+	f.l.writer = instance
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReopenFiles reopens all open files at their paths, so that they can be
+// rotated by external tools like the main log
+func ReopenFiles() {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+	for f := range files {
+		nf, err := openFile(f.path)
+		if err != nil {
+			Errorf("Could not reopen log file %v: %v", f.path, err)
+			continue
+		}
+		f.l.mutex.Lock()
+		old := f.l.writer
+		f.l.writer = nf
+		f.l.mutex.Unlock()
+		if c, ok := old.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func (f *File) Debugf(format string, a ...any) {
+	if f == nil {
+		Debugf(format, a...)
+		return
+	}
+	f.l.debugf(format, a...)
+}
+
+func (f *File) Infof(format string, a ...any) {
+	if f == nil {
+		Infof(format, a...)
+		return
+	}
+	f.l.infof(format, a...)
+}
+
+func (f *File) Warningf(format string, a ...any) {
+	if f == nil {
+		Warningf(format, a...)
+		return
+	}
+	f.l.warningf(format, a...)
+}
+
+func (f *File) Errorf(format string, a ...any) {
+	if f == nil {
+		Errorf(format, a...)
+		return
+	}
+	f.l.errorf(format, a...)
+}
@@ -38,6 +38,16 @@ func Init(w io.Writer, interactive bool, colors bool) {
 	}
 }
 
+// SetWriter replaces the output of the logger, e.g. after the log file
+// was moved away, and returns the previous one.
+func SetWriter(w io.Writer) io.Writer {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	old := instance.writer
+	instance.writer = w
+	return old
+}
+
 // Write implements io.Writer, locking and rotating as needed
 func (l *logger) Write(bytes []byte) (int, error) {
 	l.mutex.Lock()
@@ -65,56 +75,67 @@ func (l *logger) tryRotate() {
 	}
 }
 
-func timestamp() string {
+func (l *logger) timestamp() string {
 	currentTime := time.Now()
 	format := "15:04:05"
-	if instance.debug {
+	if l.debug {
 		format = "15:04:05.000"
 	}
 	return "[" + currentTime.Format(format) + "]"
 }
 
-func Debugf(format string, a ...any) {
-	if !instance.debug || !instance.interactive {
+func (l *logger) debugf(format string, a ...any) {
+	if !l.debug || !l.interactive {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s DEBUG %s\n", timestamp(), message)
+	message := redact(fmt.Sprintf(format, a...))
+	fmt.Fprintf(l, "%s DEBUG %s\n", l.timestamp(), message)
 }
 
-func Infof(format string, a ...any) {
-	if !instance.interactive {
+func (l *logger) infof(format string, a ...any) {
+	if !l.interactive {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s %sINFO%s %s\n", timestamp(), Bold+Blue, Reset, message)
+	message := redact(fmt.Sprintf(format, a...))
+	fmt.Fprintf(l, "%s %sINFO%s %s\n", l.timestamp(), Bold+Blue, Reset, message)
 }
 
-func Warningf(format string, a ...any) {
-	if !instance.interactive {
+func (l *logger) warningf(format string, a ...any) {
+	if !l.interactive {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s %sWARNING%s %s\n", timestamp(), Bold+Yellow, Reset, message)
+	message := redact(fmt.Sprintf(format, a...))
+	fmt.Fprintf(l, "%s %sWARNING%s %s\n", l.timestamp(), Bold+Yellow, Reset, message)
 }
 
-func Errorf(format string, a ...any) {
-	if !instance.interactive {
+func (l *logger) errorf(format string, a ...any) {
+	if !l.interactive {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s %sERROR%s %s\n", timestamp(), Bold+Red, Reset, message)
+	message := redact(fmt.Sprintf(format, a...))
+	fmt.Fprintf(l, "%s %sERROR%s %s\n", l.timestamp(), Bold+Red, Reset, message)
 }
 
+func Debugf(format string, a ...any)   { instance.debugf(format, a...) }
+func Infof(format string, a ...any)    { instance.infof(format, a...) }
+func Warningf(format string, a ...any) { instance.warningf(format, a...) }
+func Errorf(format string, a ...any)   { instance.errorf(format, a...) }
+
 func Fatalf(format string, a ...any) {
+	Exitf(1, format, a...)
+}
+
+// Exitf is like Fatalf, but exits with the given code
+func Exitf(code int, format string, a ...any) {
 	if instance.interactive {
-		message := fmt.Sprintf(format, a...)
-		fmt.Fprintf(instance, "%s %sFATAL%s %s\n", timestamp(), Bold+Red, Reset, message)
+		message := redact(fmt.Sprintf(format, a...))
+		fmt.Fprintf(instance, "%s %sFATAL%s %s\n", instance.timestamp(), Bold+Red, Reset, message)
 	}
-	os.Exit(1)
+	os.Exit(code)
 }
 
-// Printf writes a message without any formatting
+// Printf writes a message without any formatting. Like Emitf, it is meant
+// for output the user asked for, so secrets are not redacted.
 func Printf(format string, a ...any) {
 	if instance.interactive {
 		fmt.Fprintf(instance, format, a...)
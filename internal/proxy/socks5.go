@@ -90,6 +90,10 @@ type Server struct {
 	// Username and Password, if set, are the credential clients must provide.
 	Username string
 	Password string
+
+	// Copy optionally copies data between clients and targets, e.g., with
+	// pooled buffers. If nil, io.Copy is used.
+	Copy func(dst io.Writer, src io.Reader) (int64, error)
 }
 
 func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -223,22 +227,39 @@ func (c *Conn) handleTCP() error {
 	}
 	c.clientConn.Write(buf)
 
+	// Pass on EOF as a half-close and wait for both directions, so that
+	// peers can still respond after they finished sending
 	errc := make(chan error, 2)
-	go func() {
-		_, err := io.Copy(c.clientConn, srv)
-		if err != nil {
-			err = fmt.Errorf("from backend to client: %w", err)
-		}
-		errc <- err
-	}()
-	go func() {
-		_, err := io.Copy(srv, c.clientConn)
-		if err != nil {
-			err = fmt.Errorf("from client to backend: %w", err)
+	copyFn := io.Copy
+	if c.srv.Copy != nil {
+		copyFn = c.srv.Copy
+	}
+	pipe := func(dst, src net.Conn, dir string) {
+		if _, err := copyFn(dst, src); err != nil {
+			c.clientConn.Close()
+			srv.Close()
+			errc <- fmt.Errorf("from %s: %w", dir, err)
+			return
 		}
-		errc <- err
-	}()
-	return <-errc
+		CloseWrite(dst)
+		errc <- nil
+	}
+	go pipe(c.clientConn, srv, "backend to client")
+	go pipe(srv, c.clientConn, "client to backend")
+	err = <-errc
+	if err2 := <-errc; err == nil {
+		err = err2
+	}
+	return err
+}
+
+// CloseWrite signals EOF to the peer of c, while data can still be read
+// from it. Connections that cannot be half-closed are closed entirely.
+func CloseWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
 }
 
 func (c *Conn) handleUDP() error {
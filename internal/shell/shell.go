@@ -0,0 +1,60 @@
+// Package shell runs commands given in the configuration, e.g., the
+// LocalCommand of a tunnel or a KnownHostsCommand, in the user's shell.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Timeout bounds how long commands may run, so that ones waiting for input
+// do not block connecting forever
+const Timeout = 30 * time.Second
+
+// Command returns a command running command in the user's shell, $SHELL or
+// /bin/sh, or in cmd on Windows
+func Command(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+	}
+	return exec.CommandContext(ctx, sh, "-c", command)
+}
+
+// Quote quotes s as a single argument for the shell of Command, so that it
+// can be substituted into commands without being interpreted by the shell
+func Quote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Output runs command in the shell for at most Timeout and returns what it
+// printed to stdout. If it fails, the error includes what it printed to
+// stderr.
+func Output(command string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := Command(ctx, command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,35 @@
+package shell
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are written for sh")
+	}
+	t.Setenv("SHELL", "")
+	for _, s := range []string{"plain", "", "it's", "$(touch x); `y` \\ \"z\" *"} {
+		out, err := Output("printf %s " + Quote(s))
+		if err != nil || string(out) != s {
+			t.Errorf("%q: got %q, %v", s, out, err)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are written for sh")
+	}
+	t.Setenv("SHELL", "")
+	out, err := Output("echo out; echo err >&2")
+	if err != nil || string(out) != "out\n" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	_, err = Output("echo 'no such thing' >&2; exit 3")
+	if err == nil || !strings.Contains(err.Error(), "exit status 3: no such thing") {
+		t.Errorf("expected error with stderr, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package ssh_config
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthKey records which key a server accepted during authentication.
+// The publickey method of x/crypto only signs with keys that the server
+// has accepted beforehand, so the key that signed last is the one that
+// authenticated the connection.
+type AuthKey struct {
+	mu  sync.Mutex
+	key ssh.PublicKey
+}
+
+func (a *AuthKey) set(k ssh.PublicKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.key = k
+}
+
+// String describes the accepted key by type and fingerprint, or returns
+// an empty string if no key was accepted yet.
+func (a *AuthKey) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.key == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %s", a.key.Type(), ssh.FingerprintSHA256(a.key))
+}
+
+// recordSigners wraps sigs so that signing records the key in a. The
+// wrappers keep the interfaces of the wrapped signers, as x/crypto picks
+// signature algorithms based on them.
+func recordSigners(sigs []ssh.Signer, a *AuthKey) []ssh.Signer {
+	return wrapSigners(sigs, a, "")
+}
+
+// recordRetrySigners is like recordSigners, but additionally logs that
+// the retry of host alias succeeded once a key is accepted.
+func recordRetrySigners(sigs []ssh.Signer, a *AuthKey, alias string) []ssh.Signer {
+	return wrapSigners(sigs, a, alias)
+}
+
+func wrapSigners(sigs []ssh.Signer, a *AuthKey, retryOf string) []ssh.Signer {
+	wrapped := make([]ssh.Signer, len(sigs))
+	for i, s := range sigs {
+		rs := recordingSigner{Signer: s, auth: a, retryOf: retryOf}
+		switch as := s.(type) {
+		case ssh.MultiAlgorithmSigner:
+			wrapped[i] = &recordingMultiSigner{recordingAlgSigner{rs, as}, as}
+		case ssh.AlgorithmSigner:
+			wrapped[i] = &recordingAlgSigner{rs, as}
+		default:
+			wrapped[i] = &rs
+		}
+	}
+	return wrapped
+}
+
+type recordingSigner struct {
+	ssh.Signer
+	auth    *AuthKey
+	retryOf string
+}
+
+// record is called when signing, i.e., after the server accepted the key
+func (s *recordingSigner) record() {
+	s.auth.set(s.PublicKey())
+	if s.retryOf != "" {
+		log.Infof("%s: authentication succeeded after re-querying ssh-agent, using key %s",
+			s.retryOf, ssh.FingerprintSHA256(s.PublicKey()))
+	}
+}
+
+func (s *recordingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.record()
+	return s.Signer.Sign(rand, data)
+}
+
+type recordingAlgSigner struct {
+	recordingSigner
+	as ssh.AlgorithmSigner
+}
+
+func (s *recordingAlgSigner) SignWithAlgorithm(rand io.Reader, data []byte, alg string) (*ssh.Signature, error) {
+	s.record()
+	return s.as.SignWithAlgorithm(rand, data, alg)
+}
+
+type recordingMultiSigner struct {
+	recordingAlgSigner
+	ms ssh.MultiAlgorithmSigner
+}
+
+func (s *recordingMultiSigner) Algorithms() []string {
+	return s.ms.Algorithms()
+}
@@ -0,0 +1,37 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRecordSigners(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AuthKey{}
+	if a.String() != "" {
+		t.Errorf("expected no key before signing, got %q", a)
+	}
+
+	w := recordSigners([]ssh.Signer{s}, a)[0]
+	if _, ok := w.(ssh.AlgorithmSigner); !ok {
+		t.Fatal("wrapped signer lost AlgorithmSigner interface")
+	}
+	if _, err := w.Sign(rand.Reader, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	want := "ssh-ed25519 " + ssh.FingerprintSHA256(s.PublicKey())
+	if a.String() != want {
+		t.Errorf("got %q, want %q", a, want)
+	}
+}
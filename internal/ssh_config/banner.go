@@ -0,0 +1,36 @@
+package ssh_config
+
+import "sync"
+
+// Banner records the message a server sent before authentication, e.g. a
+// legal notice that compliance rules require to be shown to the user
+type Banner struct {
+	mu  sync.Mutex
+	msg string
+}
+
+// set is the BannerCallback of a hop. The banner of the latest connection
+// replaces that of earlier ones, so re-connecting does not repeat it.
+func (b *Banner) set(msg string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.msg = msg
+	return nil
+}
+
+// String returns the banner, or an empty string if the server sent none
+func (b *Banner) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.msg
+}
+
+// showBanner reports whether banners are shown at the configured LogLevel,
+// which ssh(1) does from INFO on
+func (sc *SSHConfig) showBanner() bool {
+	switch sc.LogLevel {
+	case "QUIET", "FATAL", "ERROR":
+		return false
+	}
+	return true
+}
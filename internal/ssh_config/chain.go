@@ -0,0 +1,109 @@
+package ssh_config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alebeck/boring/internal/paths"
+)
+
+// ChainHop is a hop on the way to a host as configured, each reached
+// through the one before it
+type ChainHop struct {
+	Alias       string   `json:"alias"`
+	HostName    string   `json:"hostname"`
+	HostNameCmd string   `json:"hostname_command,omitempty"` // run instead when connecting
+	SRVLookup   bool     `json:"srv_lookup,omitempty"`       // looked up when connecting
+	Port        int      `json:"port"`
+	User        string   `json:"user"`
+	Auth        []string `json:"auth"` // summary of how the hop authenticates
+}
+
+// Chain returns the hops to a [user@]host in the order they are connected
+// to, ending with the host itself. Like ToHops, it follows the ProxyJump of
+// the first jump host only, but it neither runs commands, looks up SRV
+// records nor loads keys, so that the path can be reviewed without
+// connecting.
+func Chain(alias, user string) ([]ChainHop, error) {
+	sc, err := ParseSSHConfig(alias, user)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		sc.User = user
+	}
+	sc.EnsureUser()
+	return sc.chainImpl(false, 0)
+}
+
+func (sc *SSHConfig) chainImpl(ignoreIntermediate bool, depth int) ([]ChainHop, error) {
+	if depth > maxJumpRecursions {
+		return nil, JumpLoop
+	}
+	var hops []ChainHop
+	if !ignoreIntermediate {
+		for i, j := range sc.Jumps {
+			jc, err := sc.jumpConfig(j)
+			if err != nil {
+				return nil, err
+			}
+			hs, err := jc.chainImpl(i != 0, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hops = append(hops, hs...)
+		}
+	}
+	return append(hops, ChainHop{
+		Alias:       sc.Alias,
+		HostName:    sc.HostName,
+		HostNameCmd: sc.HostNameCmd,
+		SRVLookup:   sc.SRVLookup,
+		Port:        sc.Port,
+		User:        sc.User,
+		Auth:        sc.authSummary(),
+	}), nil
+}
+
+// authSummary describes the credentials offered to the host and whether
+// its key is checked, as far as the config tells without loading keys
+func (sc *SSHConfig) authSummary() (s []string) {
+	if sc.IdentityEnv != "" {
+		s = append(s, "key from $"+sc.IdentityEnv)
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(paths.ReplaceTilde(p))
+		return err == nil
+	}
+	for _, f := range sc.IdentityFiles {
+		if exists(f) {
+			s = append(s, "key "+f)
+		}
+	}
+	for _, f := range sc.CertificateFiles {
+		if exists(f) {
+			s = append(s, "certificate "+f)
+		}
+	}
+	switch {
+	case sc.NoAgent:
+	case sc.IdentitiesOnly:
+		s = append(s, "agent keys of identity files")
+	default:
+		s = append(s, "agent")
+	}
+	if sc.KeyCheck == off {
+		s = append(s, "host key not checked")
+	}
+	return s
+}
+
+// String formats the hop like ssh(1) addresses it, user@host:port
+func (h *ChainHop) String() string {
+	host := h.HostName
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return fmt.Sprintf("%s@%s:%d", h.User, host, h.Port)
+}
@@ -0,0 +1,66 @@
+package ssh_config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+	useSSHConfig(t, `Host target
+	HostName 10.0.0.3
+	User app
+	ProxyJump ops@outer:2222,inner
+Host outer
+	HostName 10.0.0.1
+	ProxyJump edge
+	IdentityFile `+priv+`
+	IdentitiesOnly yes
+Host inner
+	HostName 10.0.0.2
+	User admin
+	ProxyJump ignored
+	StrictHostKeyChecking no
+Host loop
+	ProxyJump loop
+Host *
+	User nobody
+	IdentityFile /nonexistent/id_test
+	UseAgent no
+`)
+
+	hops, err := Chain("target", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ProxyJump of the first jump host is followed, that of others
+	// is not, like when connecting
+	var got []string
+	for _, h := range hops {
+		got = append(got, h.Alias+" "+h.String())
+	}
+	want := []string{
+		"edge nobody@edge:22",
+		"outer ops@10.0.0.1:2222",
+		"inner admin@10.0.0.2:22",
+		"target app@10.0.0.3:22",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if a := hops[1].Auth; !slices.Equal(a, []string{"key " + priv}) {
+		t.Errorf("outer: unexpected auth %q", a)
+	}
+	if a := hops[2].Auth; !slices.Equal(a, []string{"host key not checked"}) {
+		t.Errorf("inner: unexpected auth %q", a)
+	}
+
+	if hops, err := Chain("target", "root"); err != nil || hops[3].User != "root" {
+		t.Errorf("expected user root for target, got %v, %+v", err, hops)
+	}
+	if _, err := Chain("loop", ""); !errors.Is(err, JumpLoop) {
+		t.Errorf("loop: expected %v, got %v", JumpLoop, err)
+	}
+}
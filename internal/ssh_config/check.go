@@ -0,0 +1,172 @@
+package ssh_config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	ossh_config "github.com/alebeck/ssh_config"
+)
+
+// Keys that structure the config rather than set options
+var structuralKeys = []string{"host", "match", "include"}
+
+// Why options that users may rely on cannot be supported
+var unsupportedReasons = map[string]string{
+	// x/crypto has no host-based authentication, and its AuthMethod
+	// interface cannot be implemented outside of it
+	"hostbasedauthentication":     "host-based authentication is not implemented by Go's SSH library",
+	"hostbasedacceptedalgorithms": "host-based authentication is not implemented by Go's SSH library",
+}
+
+// Problem is an issue found when checking the SSH config of a host.
+// Warnings do not prevent connecting.
+type Problem struct {
+	Warning bool
+	Msg     string
+}
+
+// Hosts returns the aliases of all Host stanzas in the order they appear,
+// skipping patterns with wildcards.
+func Hosts() ([]string, error) {
+	var hosts []string
+	var walk func(file string, depth int) error
+	walk = func(file string, depth int) error {
+		b, err := os.ReadFile(file)
+		if os.IsNotExist(err) && depth == 0 && file != overrideConfig() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cfg, err := ossh_config.DecodeBytes(b)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, block := range cfg.Blocks {
+			if h, ok := block.(*ossh_config.Host); ok {
+				for _, p := range h.Patterns {
+					a := p.String()
+					if !strings.ContainsAny(a, "*?") && !slices.Contains(hosts, a) {
+						hosts = append(hosts, a)
+					}
+				}
+			}
+			for _, n := range block.GetNodes() {
+				inc, ok := n.(*ossh_config.Include)
+				if !ok || depth >= maxJumpRecursions {
+					continue
+				}
+				for _, f := range includedFiles(inc, file) {
+					if err := walk(f, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, f := range configFiles() {
+		if err := walk(f, 0); err != nil {
+			return nil, err
+		}
+	}
+	return hosts, nil
+}
+
+// Check prepares the hops to alias like connecting does, without dialing,
+// and reports problems with its SSH config. Unlike connecting, it neither
+// runs commands from the config nor looks up SRV records.
+func Check(alias, user string) (problems []Problem) {
+	add := func(warning bool, format string, a ...any) {
+		problems = append(problems, Problem{warning, fmt.Sprintf(format, a...)})
+	}
+
+	sc, err := ParseSSHConfig(alias, user)
+	if err != nil {
+		add(false, "%v", err)
+		return
+	}
+	if user != "" {
+		sc.User = user
+	}
+	sc.EnsureUser()
+	sc.dryRun = true
+
+	// Options from the system config are not the user's concern
+	if opts, err := setOptions(alias, user); err == nil {
+		for _, o := range opts {
+			k := strings.ToLower(o.Key)
+			if slices.Contains(structuralKeys, k) || isSystemConfig(o.Origin.File) ||
+				slices.ContainsFunc(resolvedKeys, func(r string) bool { return strings.EqualFold(r, k) }) {
+				continue
+			}
+			msg := fmt.Sprintf("%s:%d: %s is not supported, ignoring", o.Origin.File, o.Origin.Line, o.Key)
+			if r, ok := unsupportedReasons[k]; ok {
+				msg += " (" + r + ")"
+			}
+			add(true, "%s", msg)
+		}
+	}
+
+	// Key files from the defaults may be missing, configured ones must not
+	for i, o := range sc.identityOrigins {
+		if _, _, ok := loadIdentity(sc.IdentityFiles[i]); !ok {
+			add(false, "%s:%d: key file %s not found or invalid", o.File, o.Line, sc.IdentityFiles[i])
+		}
+	}
+
+	// Post-quantum key exchanges are pointed out where they are configured.
+	// Algorithms removed from the defaults are not configured.
+	if set, err := findOptions(strings.ToLower(alias), user, "KexAlgorithms", false); err == nil {
+		for _, o := range set {
+			if strings.HasPrefix(o.Value, "-") {
+				continue
+			}
+			if w := pqKexWarning(split(strings.TrimLeft(o.Value, "+^"))); w != "" {
+				add(true, "%s:%d: %s", o.Origin.File, o.Origin.Line, w)
+			}
+		}
+	}
+
+	// Host names printed by commands are only known when connecting
+	chain, err := sc.chainImpl(false, 0)
+	if err != nil {
+		add(false, "%v", err)
+		return
+	}
+	for _, h := range chain {
+		if h.HostNameCmd != "" {
+			add(true, "%s: HostNameCommand is set, not run to check its host name", h.Alias)
+		}
+	}
+
+	hops, err := sc.ToHops()
+	if err != nil {
+		add(false, "%v", err)
+		return
+	}
+
+	// Only the first hop is resolved locally, the others by the jump hosts
+	if first := chain[0]; len(hops) > 1 && first.HostNameCmd == "" && !first.SRVLookup {
+		h := hops[0].HostName
+		if _, ok, err := LookupHost(context.Background(), h); ok && err != nil {
+			add(false, "jump host %s cannot be resolved: %v", h, err)
+		} else if !ok {
+			if _, err := net.LookupHost(h); err != nil {
+				add(false, "jump host %s cannot be resolved: %v", h, err)
+			}
+		}
+	}
+	return
+}
+
+// isSystemConfig reports whether file is part of the system-wide config
+func isSystemConfig(file string) bool {
+	return strings.HasPrefix(filepath.Clean(file), "/etc/ssh")
+}
@@ -0,0 +1,97 @@
+package ssh_config
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHosts(t *testing.T) {
+	useSSHConfig(t, `Host a b *.example.com
+	User x
+Host c ?
+	User y
+Host a
+	Port 2222
+`)
+	hosts, err := Hosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(hosts, want) {
+		t.Errorf("got %v, want %v", hosts, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	runs := filepath.Join(t.TempDir(), "runs")
+	lookupSRV = func(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+		t.Errorf("looked up SRV record of %s", name)
+		return "", nil, nil
+	}
+	t.Cleanup(func() { lookupSRV = net.DefaultResolver.LookupSRV })
+	useSSHConfig(t, `Host good badkey unsupported hostbased badjump pqkex badsecond
+	HostName 127.0.0.1
+Host badkey
+	IdentityFile /nonexistent/id_test
+Host unsupported
+	LocalForward 8080 localhost:80
+Host hostbased
+	HostbasedAuthentication yes
+Host badjump
+	ProxyJump jump.invalid
+Host badparse
+	StrictHostKeyChecking sometimes
+Host pqkex
+	KexAlgorithms sntrup761x25519-sha512@openssh.com,curve25519-sha256
+Host badsecond
+	IdentityFile `+priv+`
+	IdentityFile /nonexistent/id_second
+Host hostcmd
+	ProxyJump srvjump
+	HostNameCommand echo x >> `+runs+`; echo 127.0.0.1
+Host srvjump
+	SRVLookup yes
+Host *
+	StrictHostKeyChecking no
+	IdentityFile `+priv+`
+`)
+
+	cases := []struct {
+		host    string
+		warning bool
+		msg     string
+	}{
+		{"badkey", false, "key file /nonexistent/id_test not found"},
+		{"unsupported", true, "LocalForward is not supported"},
+		{"hostbased", true, "HostbasedAuthentication is not supported, ignoring (host-based"},
+		{"badjump", false, "jump host jump.invalid cannot be resolved"},
+		{"badparse", false, "unsupported StrictHostKeyChecking"},
+		{"pqkex", true, "post-quantum KexAlgorithms sntrup761x25519-sha512@openssh.com not supported"},
+		{"badsecond", false, ":17: key file /nonexistent/id_second not found"},
+		{"hostcmd", true, "hostcmd: HostNameCommand is set, not run"},
+	}
+
+	if ps := Check("good", ""); len(ps) != 0 {
+		t.Errorf("good: expected no problems, got %+v", ps)
+	}
+	for _, c := range cases {
+		ps := Check(c.host, "")
+		if len(ps) != 1 {
+			t.Errorf("%s: expected one problem, got %+v", c.host, ps)
+			continue
+		}
+		if ps[0].Warning != c.warning || !strings.Contains(ps[0].Msg, c.msg) {
+			t.Errorf("%s: expected %q (warning %v), got %+v", c.host, c.msg, c.warning, ps[0])
+		}
+	}
+	if _, err := os.Stat(runs); err == nil {
+		t.Error("expected HostNameCommand not to run")
+	}
+}
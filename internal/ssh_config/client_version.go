@@ -0,0 +1,38 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	versionPrefix = "SSH-2.0-"
+	// Banners are at most 255 characters including the trailing CR LF
+	maxVersionLen = 253
+)
+
+// ValidateClientVersion checks that v is a valid identification string
+// as sent by SSH clients, see RFC 4253 section 4.2: "SSH-2.0-", followed
+// by a software version without whitespace and minus signs, optionally
+// followed by a space and comments. An empty v stands for the default.
+func ValidateClientVersion(v string) error {
+	if v == "" {
+		return nil
+	}
+	if !strings.HasPrefix(v, versionPrefix) {
+		return fmt.Errorf("must start with %q", versionPrefix)
+	}
+	if len(v) > maxVersionLen {
+		return fmt.Errorf("must be at most %d characters long", maxVersionLen)
+	}
+	for _, c := range v {
+		if c < ' ' || c > '~' {
+			return fmt.Errorf("must only contain printable ASCII characters")
+		}
+	}
+	software, _, _ := strings.Cut(v[len(versionPrefix):], " ")
+	if software == "" || strings.Contains(software, "-") {
+		return fmt.Errorf("software version must be non-empty and not contain '-'")
+	}
+	return nil
+}
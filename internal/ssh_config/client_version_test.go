@@ -0,0 +1,31 @@
+package ssh_config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateClientVersion(t *testing.T) {
+	for _, v := range []string{
+		"",
+		"SSH-2.0-OpenSSH_9.9",
+		"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13.5",
+	} {
+		if err := ValidateClientVersion(v); err != nil {
+			t.Errorf("%q: %v", v, err)
+		}
+	}
+	for _, v := range []string{
+		"OpenSSH_9.9",
+		"SSH-1.99-OpenSSH_9.9",
+		"SSH-2.0-",
+		"SSH-2.0- comment",
+		"SSH-2.0-Open-SSH",
+		"SSH-2.0-OpenSSH\r\nX",
+		"SSH-2.0-" + strings.Repeat("x", 250),
+	} {
+		if err := ValidateClientVersion(v); err == nil {
+			t.Errorf("%q: accepted", v)
+		}
+	}
+}
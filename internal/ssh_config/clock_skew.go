@@ -0,0 +1,107 @@
+package ssh_config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxClockSkew is how recently a certificate may have expired, or become
+// valid, for a skewed clock on either side to be a likely cause. Certificates
+// that are not yet valid point at a clock that is behind in any case, as
+// they are usually issued to be valid right away.
+const maxClockSkew = 10 * time.Minute
+
+// now is replaced in tests
+var now = time.Now
+
+// certTime converts a validity bound of a certificate
+func certTime(t uint64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// certClockHint returns advice if the validity of certificate c suggests
+// that its rejection is due to a skewed clock, or "" if not. The local
+// clock is compared if local is set, otherwise that of the server, which
+// is unknown, so only certificates valid for a short while are suspicious.
+func certClockHint(c *ssh.Certificate, local bool) string {
+	t := now()
+	after := certTime(c.ValidAfter)
+	if t.Before(after) {
+		return fmt.Sprintf("certificate is only valid from %v, in %v by the local clock, "+
+			"which may be behind; check that NTP is synchronized",
+			after.UTC().Format(time.RFC3339), after.Sub(t).Round(time.Second))
+	}
+	if c.ValidBefore != ssh.CertTimeInfinity {
+		before := certTime(c.ValidBefore)
+		if !t.Before(before) && t.Sub(before) < maxClockSkew {
+			return fmt.Sprintf("certificate expired %v ago by the local clock, "+
+				"which may be ahead if it was renewed recently; check that NTP is synchronized",
+				t.Sub(before).Round(time.Second))
+		}
+		if !t.Before(before) {
+			return fmt.Sprintf("certificate expired at %v, renew it",
+				before.UTC().Format(time.RFC3339))
+		}
+		if !local && before.Sub(t) < maxClockSkew {
+			return fmt.Sprintf("certificate expires in %v, "+
+				"the server's clock may be ahead; check that NTP is synchronized on it",
+				before.Sub(t).Round(time.Second))
+		}
+	}
+	if !local && t.Sub(after) < maxClockSkew {
+		return fmt.Sprintf("certificate is valid since %v only, "+
+			"the server's clock may be behind; check that NTP is synchronized on it",
+			t.Sub(after).Round(time.Second))
+	}
+	return ""
+}
+
+// hostCertClock wraps a host key callback to explain host certificates
+// that are rejected as not yet valid or expired, which is often due to a
+// skewed clock rather than the certificate
+func hostCertClock(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(host, remote, key)
+		c, ok := key.(*ssh.Certificate)
+		if err == nil || !ok || !strings.Contains(err.Error(), "cert is not yet valid") &&
+			!strings.Contains(err.Error(), "cert has expired") {
+			return err
+		}
+		if hint := certClockHint(c, true); hint != "" {
+			log.Warningf("%v: host %s", host, hint)
+			return fmt.Errorf("%w; host %s", err, hint)
+		}
+		return err
+	}
+}
+
+// certsOf returns the certificates among the keys of sigs
+func certsOf(sigs []ssh.Signer) (certs []*ssh.Certificate) {
+	for _, s := range sigs {
+		if c, ok := s.PublicKey().(*ssh.Certificate); ok {
+			certs = append(certs, c)
+		}
+	}
+	return
+}
+
+// ClockSkewHint returns advice if authentication failed with err while
+// offering certificates whose validity suggests a skewed clock, or "" if
+// not. Servers do not tell why they reject a certificate, so the failure
+// looks like any other.
+func ClockSkewHint(err error, certs []*ssh.Certificate) string {
+	if err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		return ""
+	}
+	for _, c := range certs {
+		if hint := certClockHint(c, false); hint != "" {
+			return "user " + hint
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package ssh_config
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertClockHint(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return t0 }
+	defer func() { now = time.Now }()
+	at := func(d time.Duration) uint64 { return uint64(t0.Add(d).Unix()) }
+
+	tests := []struct {
+		after, before uint64
+		local         bool
+		want          string // part of the hint, "" if none
+	}{
+		{at(5 * time.Minute), at(time.Hour), true, "in 5m0s by the local clock, which may be behind"},
+		{at(-time.Hour), at(-2 * time.Minute), true, "expired 2m0s ago by the local clock"},
+		{at(-48 * time.Hour), at(-24 * time.Hour), true, "expired at 2025-12-31T12:00:00Z, renew it"},
+		{at(-time.Minute), at(time.Hour), true, ""},
+		{at(-time.Minute), at(time.Hour), false, "valid since 1m0s only, the server's clock may be behind"},
+		{at(-time.Hour), at(time.Minute), false, "expires in 1m0s, the server's clock may be ahead"},
+		{at(-time.Hour), at(time.Hour), false, ""},
+		{at(-time.Minute), ssh.CertTimeInfinity, false, "valid since 1m0s only"},
+		{at(-time.Hour), ssh.CertTimeInfinity, false, ""},
+	}
+	for _, tt := range tests {
+		c := &ssh.Certificate{ValidAfter: tt.after, ValidBefore: tt.before}
+		got := certClockHint(c, tt.local)
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%v to %v (local %v): got %q, want %q", tt.after, tt.before, tt.local, got, tt.want)
+		}
+	}
+}
+
+// Host certificates rejected for their validity are explained
+func TestHostCertClock(t *testing.T) {
+	c := &ssh.Certificate{Key: edPub(t), ValidAfter: uint64(time.Now().Add(time.Hour).Unix()),
+		ValidBefore: ssh.CertTimeInfinity}
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+	cb := hostCertClock(func(string, net.Addr, ssh.PublicKey) error {
+		return errors.New("ssh: cert is not yet valid")
+	})
+	if err := cb("host:22", addr, c); err == nil || !strings.Contains(err.Error(), "NTP") {
+		t.Errorf("expected a hint, got %v", err)
+	}
+	if err := cb("host:22", addr, c.Key); err == nil || strings.Contains(err.Error(), "NTP") {
+		t.Errorf("expected no hint for a plain key, got %v", err)
+	}
+
+	cb = hostCertClock(func(string, net.Addr, ssh.PublicKey) error { return nil })
+	if err := cb("host:22", addr, c); err != nil {
+		t.Errorf("expected accepted key, got %v", err)
+	}
+}
+
+func TestClockSkewHint(t *testing.T) {
+	fresh := &ssh.Certificate{Key: edPub(t), ValidAfter: uint64(time.Now().Unix()),
+		ValidBefore: ssh.CertTimeInfinity}
+	authErr := errors.New("ssh: handshake failed: ssh: unable to authenticate")
+	if hint := ClockSkewHint(authErr, []*ssh.Certificate{fresh}); !strings.Contains(hint, "user certificate") {
+		t.Errorf("expected a hint, got %q", hint)
+	}
+	if hint := ClockSkewHint(authErr, nil); hint != "" {
+		t.Errorf("expected no hint without certificates, got %q", hint)
+	}
+	if hint := ClockSkewHint(errors.New("connection reset"), []*ssh.Certificate{fresh}); hint != "" {
+		t.Errorf("expected no hint for other errors, got %q", hint)
+	}
+}
@@ -0,0 +1,63 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alebeck/boring/internal/paths"
+)
+
+// Key files tried if no IdentityFile is configured, like ssh(1)
+var defaultKeyNames = []string{
+	"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk",
+}
+
+// DefaultIdentities returns the key files tried if no IdentityFile is
+// configured: those listed in $BORING_DEFAULT_KEYS, separated by commas,
+// or else the standard ones in ~/.ssh, and in the directory of the SSH
+// config if it is overridden, as keys often live next to it.
+func DefaultIdentities() []string {
+	if env := os.Getenv("BORING_DEFAULT_KEYS"); env != "" {
+		var keys []string
+		for _, k := range strings.Split(env, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+
+	var keys []string
+	for _, n := range defaultKeyNames {
+		keys = append(keys, "~/.ssh/"+n)
+	}
+	if oc := overrideConfig(); oc != "" {
+		dir := filepath.Dir(paths.ReplaceTilde(oc))
+		if dir != paths.ReplaceTilde("~/.ssh") {
+			for _, n := range defaultKeyNames {
+				keys = append(keys, filepath.Join(dir, n))
+			}
+		}
+	}
+	return keys
+}
+
+// identityFiles returns the configured key files of alias along with where
+// each one is set, or the default ones, which have no origin, if there are
+// none
+func identityFiles(alias, user string, getAll func(string) []string) ([]string, []Origin) {
+	set, err := findOptions(alias, user, "IdentityFile", true)
+	if err != nil {
+		return getAll("IdentityFile"), nil
+	}
+	if len(set) == 0 {
+		return DefaultIdentities(), nil
+	}
+	files := make([]string, len(set))
+	origins := make([]Origin, len(set))
+	for i, o := range set {
+		files[i], origins[i] = o.Value, *o.Origin
+	}
+	return files, origins
+}
@@ -0,0 +1,40 @@
+package ssh_config
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDefaultIdentities(t *testing.T) {
+	useSSHConfig(t, `Host configured
+	IdentityFile ~/.ssh/id_work
+`)
+	t.Setenv("BORING_DEFAULT_KEYS", "")
+	dir := filepath.Dir(overrideConfig())
+
+	sc, err := ParseSSHConfig("other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(sc.IdentityFiles, "~/.ssh/id_ed25519_sk") ||
+		!slices.Contains(sc.IdentityFiles, filepath.Join(dir, "id_ed25519")) {
+		t.Errorf("default keys not tried: %v", sc.IdentityFiles)
+	}
+
+	t.Setenv("BORING_DEFAULT_KEYS", "/keys/a, ~/b")
+	if sc, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/keys/a", "~/b"}; !slices.Equal(sc.IdentityFiles, want) {
+		t.Errorf("got %v, want %v", sc.IdentityFiles, want)
+	}
+
+	// Configured keys are not replaced
+	if sc, err = ParseSSHConfig("configured", ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"~/.ssh/id_work"}; !slices.Equal(sc.IdentityFiles, want) {
+		t.Errorf("got %v, want %v", sc.IdentityFiles, want)
+	}
+}
@@ -0,0 +1,21 @@
+package ssh_config
+
+import "errors"
+
+// Errors wrapped by those of parsing the SSH config and preparing hops,
+// so that callers can tell failures apart with errors.Is
+var (
+	// NoHostKeyAlgos indicates that no usable host key of a host is known
+	NoHostKeyAlgos = errors.New("could not determine host key algorithms")
+	// NoKeys indicates that no keys to authenticate with were found
+	NoKeys = errors.New("no key files found")
+	// HostKeyRejected indicates a host key that is known, but not
+	// acceptable, e.g. a certificate signed with a disallowed algorithm
+	HostKeyRejected = errors.New("host key rejected")
+	// InvalidOption indicates an option with a malformed value
+	InvalidOption = errors.New("invalid")
+	// Unsupported indicates an option value that boring cannot honor
+	Unsupported = errors.New("unsupported")
+	// JumpLoop indicates that jump hosts nest too deeply, e.g. in a loop
+	JumpLoop = errors.New("maximum jump recursions exceeded")
+)
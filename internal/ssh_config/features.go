@@ -0,0 +1,24 @@
+package ssh_config
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Keywords returns the SSH config keywords boring implements, sorted
+// case-insensitively. Others are ignored when connecting.
+func Keywords() []string {
+	keys := slices.Clone(resolvedKeys)
+	keys = append(keys, "Host", "Match", "Include")
+	slices.SortFunc(keys, func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	return keys
+}
+
+// UnsupportedKeywords returns the reasons why well-known keywords, by their
+// lowercase name, cannot be supported
+func UnsupportedKeywords() map[string]string {
+	return maps.Clone(unsupportedReasons)
+}
@@ -0,0 +1,27 @@
+package ssh_config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Keywords must list what the parser reads, so that it does not go stale
+func TestKeywords(t *testing.T) {
+	keys := Keywords()
+	for _, k := range append(slices.Clone(resolvedKeys), "Host", "Include") {
+		if !slices.Contains(keys, k) {
+			t.Errorf("missing keyword %s", k)
+		}
+	}
+	if !slices.IsSortedFunc(keys, func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}) {
+		t.Errorf("keywords not sorted: %v", keys)
+	}
+	for k := range UnsupportedKeywords() {
+		if slices.ContainsFunc(keys, func(s string) bool { return strings.EqualFold(s, k) }) {
+			t.Errorf("%s listed as both supported and unsupported", k)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package ssh_config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/alebeck/boring/internal/paths"
+)
+
+// forwardAgent returns the agent socket that a ForwardAgent value of v
+// forwards to sessions, or "" if none. Like in ssh(1), v is yes, no, a
+// socket path or an environment variable holding one, e.g. $SSH_AUTH_SOCK.
+func forwardAgent(v string) string {
+	switch strings.ToLower(v) {
+	case "", "no":
+		return ""
+	case "yes":
+		return os.Getenv("SSH_AUTH_SOCK")
+	}
+	if strings.HasPrefix(v, "$") {
+		return os.Getenv(v[1:])
+	}
+	return paths.ReplaceTilde(v)
+}
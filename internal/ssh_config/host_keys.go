@@ -0,0 +1,211 @@
+package ssh_config
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// OpenSSH extensions to announce and prove host keys, see PROTOCOL 2.5
+const (
+	hostKeysReq      = "hostkeys-00@openssh.com"
+	hostKeysProveReq = "hostkeys-prove-00@openssh.com"
+)
+
+// Serializes updates, so that concurrent connections to a host do not
+// record the same key twice
+var hostKeysMu sync.Mutex
+
+// HostKeyUpdater learns the host keys a server announces after
+// authentication, like UpdateHostKeys of ssh(1). Keys are only recorded
+// for hosts that are known by a plain key, once the server proved that it
+// holds them, so that planned key rotations do not break connecting.
+type HostKeyUpdater struct {
+	files  []string // known_hosts files to check keys against
+	target string   // known_hosts file new keys go to
+	hash   bool
+}
+
+func (sc *SSHConfig) hostKeyUpdater() *HostKeyUpdater {
+	if !sc.UpdateHostKeys || sc.KeyCheck != strict || sc.KnownHostsTarget == "" {
+		return nil
+	}
+	return &HostKeyUpdater{sc.KnownHostsFiles, sc.KnownHostsTarget, sc.HashKnownHosts}
+}
+
+// Intercept handles host key announcements among the global requests of
+// conn, which is connected to addr, and passes on all other requests
+func (u *HostKeyUpdater) Intercept(conn ssh.Conn, addr string, in <-chan *ssh.Request) <-chan *ssh.Request {
+	addr = stripZone(addr)
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for r := range in {
+			if r.Type != hostKeysReq {
+				out <- r
+				continue
+			}
+			if r.WantReply {
+				_ = r.Reply(false, nil)
+			}
+			// Proving needs a reply from the server, don't block requests
+			go func() {
+				if err := u.update(conn, addr, r.Payload); err != nil {
+					log.Warningf("%v: could not update host keys: %v", addr, err)
+				}
+			}()
+		}
+	}()
+	return out
+}
+
+// update records the keys in payload that are new for addr, after the
+// server proved possession of them
+func (u *HostKeyUpdater) update(conn ssh.Conn, addr string, payload []byte) error {
+	hostKeysMu.Lock()
+	defer hostKeysMu.Unlock()
+
+	// Only the files are updated, hosts known from a KnownHostsCommand
+	// alone have no plain keys here and are left alone below
+	cb, err := knownHostsCallback(u.files, "")
+	if err != nil {
+		return err
+	}
+	cb = zonelessCallback(cb)
+	blobs, err := parseStrings(payload)
+	if err != nil {
+		return fmt.Errorf("invalid announcement: %v", err)
+	}
+
+	var newKeys []ssh.PublicKey
+	for _, b := range blobs {
+		k, err := ssh.ParsePublicKey(b)
+		if err != nil {
+			log.Debugf("%v: ignoring announced host key: %v", addr, err)
+			continue
+		}
+		if _, ok := k.(*ssh.Certificate); ok {
+			continue
+		}
+		var ke *knownhosts.KeyError
+		err = cb(addr, conn.RemoteAddr(), k)
+		if err == nil {
+			continue
+		}
+		if !errors.As(err, &ke) {
+			// E.g., a revoked key
+			log.Debugf("%v: ignoring announced host key: %v", addr, err)
+			continue
+		}
+		if len(ke.Want) == 0 {
+			// Host is not known by a plain key, e.g., trusted through a CA
+			return nil
+		}
+		newKeys = append(newKeys, k)
+	}
+	if len(newKeys) == 0 {
+		return nil
+	}
+
+	var req []byte
+	for _, k := range newKeys {
+		req = appendString(req, k.Marshal())
+	}
+	ok, reply, err := conn.SendRequest(hostKeysProveReq, true, req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("server refused to prove host keys")
+	}
+	sigs, err := parseStrings(reply)
+	if err != nil || len(sigs) != len(newKeys) {
+		return fmt.Errorf("invalid proof")
+	}
+
+	var proven []ssh.PublicKey
+	for i, k := range newKeys {
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(sigs[i], &sig); err != nil {
+			return fmt.Errorf("invalid proof: %v", err)
+		}
+		data := appendString(nil, []byte(hostKeysProveReq))
+		data = appendString(data, conn.SessionID())
+		data = appendString(data, k.Marshal())
+		if err := k.Verify(data, &sig); err != nil {
+			log.Warningf("%v: server could not prove %v host key %v, ignoring it",
+				addr, k.Type(), ssh.FingerprintSHA256(k))
+			continue
+		}
+		proven = append(proven, k)
+	}
+	return u.record(addr, proven)
+}
+
+// record appends keys for addr to the target known_hosts file
+func (u *HostKeyUpdater) record(addr string, keys []ssh.PublicKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	target := paths.ReplaceTilde(u.target)
+	host := knownhosts.Normalize(addr)
+	if u.hash {
+		host = knownhosts.HashHostname(host)
+	}
+
+	var lines []byte
+	if b, err := os.ReadFile(target); err == nil && len(b) > 0 && b[len(b)-1] != '\n' {
+		lines = append(lines, '\n')
+	}
+	for _, k := range keys {
+		lines = append(lines, knownhosts.Line([]string{host}, k)+"\n"...)
+	}
+
+	f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(lines); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		log.Infof("%v: learned %v host key %v, added to %v",
+			addr, k.Type(), ssh.FingerprintSHA256(k), u.target)
+	}
+	return nil
+}
+
+// parseStrings splits a sequence of SSH wire strings
+func parseStrings(b []byte) ([][]byte, error) {
+	var ss [][]byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated string")
+		}
+		n := binary.BigEndian.Uint32(b)
+		if uint64(len(b)-4) < uint64(n) {
+			return nil, fmt.Errorf("truncated string")
+		}
+		ss = append(ss, b[4:4+n])
+		b = b[4+n:]
+	}
+	return ss, nil
+}
+
+func appendString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,154 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// proveConn answers proof requests like a server holding signers
+type proveConn struct {
+	ssh.Conn
+	signers map[string]ssh.Signer // by marshaled public key
+	proofs  int
+}
+
+func (c *proveConn) SessionID() []byte { return []byte("session") }
+func (c *proveConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+}
+
+func (c *proveConn) SendRequest(name string, _ bool, payload []byte) (bool, []byte, error) {
+	c.proofs++
+	blobs, err := parseStrings(payload)
+	if name != hostKeysProveReq || err != nil {
+		return false, nil, nil
+	}
+	var reply []byte
+	for _, b := range blobs {
+		data := appendString(nil, []byte(hostKeysProveReq))
+		data = appendString(data, c.SessionID())
+		data = appendString(data, b)
+		sig, err := c.signers[string(b)].Sign(rand.Reader, data)
+		if err != nil {
+			return false, nil, err
+		}
+		reply = appendString(reply, ssh.Marshal(sig))
+	}
+	return true, reply, nil
+}
+
+func edSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func announce(keys ...ssh.PublicKey) []byte {
+	var b []byte
+	for _, k := range keys {
+		b = appendString(b, k.Marshal())
+	}
+	return b
+}
+
+func TestUpdateHostKeys(t *testing.T) {
+	known, added := edSigner(t), edSigner(t)
+	p := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(testHostPort)}, known.PublicKey())
+	if err := os.WriteFile(p, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	u := &HostKeyUpdater{files: []string{p}, target: p}
+	conn := &proveConn{signers: map[string]ssh.Signer{
+		string(known.PublicKey().Marshal()): known,
+		string(added.PublicKey().Marshal()): added,
+	}}
+
+	// Announcing the key again must not record it twice
+	for range 2 {
+		payload := announce(known.PublicKey(), added.PublicKey())
+		if err := u.update(conn, testHostPort, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conn.proofs != 1 {
+		t.Errorf("expected 1 proof request, got %d", conn.proofs)
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected new key to be appended, got:\n%s", b)
+	}
+	cb, err := knownhosts.New(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(testHostPort, conn.RemoteAddr(), added.PublicKey()); err != nil {
+		t.Errorf("new key not accepted: %v", err)
+	}
+}
+
+func TestUpdateHostKeysUnproven(t *testing.T) {
+	known, added := edSigner(t), edSigner(t)
+	p := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(testHostPort)}, known.PublicKey()) + "\n"
+	if err := os.WriteFile(p, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	u := &HostKeyUpdater{files: []string{p}, target: p}
+	// The server signs with a key other than the announced one
+	conn := &proveConn{signers: map[string]ssh.Signer{
+		string(added.PublicKey().Marshal()): edSigner(t),
+	}}
+
+	if err := u.update(conn, testHostPort, announce(added.PublicKey())); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(p); string(b) != line {
+		t.Errorf("unproven key was recorded:\n%s", b)
+	}
+}
+
+func TestUpdateHostKeysUnknownHost(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(p, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	u := &HostKeyUpdater{files: []string{p}, target: p}
+	conn := &proveConn{}
+
+	if err := u.update(conn, testHostPort, announce(edPub(t))); err != nil {
+		t.Fatal(err)
+	}
+	if conn.proofs != 0 {
+		t.Errorf("keys of an unknown host must not be learned")
+	}
+}
+
+func TestHostKeyUpdaterEnabled(t *testing.T) {
+	sc := &SSHConfig{UpdateHostKeys: true, KnownHostsTarget: "~/.ssh/known_hosts"}
+	if sc.hostKeyUpdater() == nil {
+		t.Error("expected updater with UpdateHostKeys")
+	}
+	sc.KeyCheck = off
+	if sc.hostKeyUpdater() != nil {
+		t.Error("expected no updater without host key checking")
+	}
+}
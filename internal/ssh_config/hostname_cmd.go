@@ -0,0 +1,60 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/shell"
+)
+
+// HostNames caches the host names printed by HostNameCommand, so that the
+// command runs once for as long as the cache is kept, e.g., for the lifetime
+// of a tunnel rather than on every re-connect. The zero value is empty.
+type HostNames struct {
+	mu sync.Mutex
+	m  map[string]string // by expanded command
+}
+
+// resolveHostName sets the host name to the output of HostNameCommand,
+// running it only if its result is not cached in HostNames yet
+func (sc *SSHConfig) resolveHostName() error {
+	h := sc.HostNames
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if name, ok := h.m[sc.HostNameCmd]; ok {
+		sc.HostName = name
+		return nil
+	}
+	name, err := runHostNameCommand(sc.HostNameCmd)
+	if err != nil {
+		return fmt.Errorf("HostNameCommand %q: %w", sc.HostNameCmd, err)
+	}
+	log.Debugf("%s: HostNameCommand resolved host name %s", sc.Alias, name)
+	if h.m == nil {
+		h.m = make(map[string]string)
+	}
+	h.m[sc.HostNameCmd] = name
+	sc.HostName = name
+	return nil
+}
+
+// runHostNameCommand runs a HostNameCommand in a shell and returns its
+// output, which must be a single host name or address
+func runHostNameCommand(command string) (string, error) {
+	out, err := shell.Output(command)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(string(out))
+	if name == "" {
+		return "", fmt.Errorf("printed no host name")
+	}
+	if strings.ContainsAny(name, " \t\r\n") {
+		return "", fmt.Errorf("printed more than a host name: %q", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,43 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ToS byte values of the IPQoS keywords, as in OpenSSH's readconf.c
+var ipqosValues = map[string]int{
+	"af11": 0x28, "af12": 0x30, "af13": 0x38,
+	"af21": 0x48, "af22": 0x50, "af23": 0x58,
+	"af31": 0x68, "af32": 0x70, "af33": 0x78,
+	"af41": 0x88, "af42": 0x90, "af43": 0x98,
+	"cs0": 0x00, "cs1": 0x20, "cs2": 0x40, "cs3": 0x60,
+	"cs4": 0x80, "cs5": 0xa0, "cs6": 0xc0, "cs7": 0xe0,
+	"ef": 0xb8, "le": 0x04,
+	"lowdelay": 0x10, "throughput": 0x08, "reliability": 0x04,
+	"none": 0,
+}
+
+// parseIPQoS parses an IPQoS value of the form `<interactive> [<bulk>]`
+// into ToS bytes. If only one value is given, it is used for both. A zero
+// value means that the ToS should be left untouched.
+func parseIPQoS(s string) (interactive, bulk int, err error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, fmt.Errorf("%w IPQoS %q", InvalidOption, s)
+	}
+	vals := make([]int, len(fields))
+	for i, f := range fields {
+		v, ok := ipqosValues[strings.ToLower(f)]
+		if !ok {
+			n, err := strconv.ParseUint(f, 0, 8)
+			if err != nil {
+				return 0, 0, fmt.Errorf("%w IPQoS %q: unknown value %q", InvalidOption, s, f)
+			}
+			v = int(n)
+		}
+		vals[i] = v
+	}
+	return vals[0], vals[len(vals)-1], nil
+}
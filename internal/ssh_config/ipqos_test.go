@@ -0,0 +1,34 @@
+package ssh_config
+
+import "testing"
+
+func TestParseIPQoS(t *testing.T) {
+	cases := []struct {
+		in                string
+		interactive, bulk int
+	}{
+		{"af21 cs1", 0x48, 0x20},
+		{"ef", 0xb8, 0xb8},
+		{"lowdelay throughput", 0x10, 0x08},
+		{"AF41 none", 0x88, 0},
+		{"0x10 32", 0x10, 0x20},
+	}
+	for _, c := range cases {
+		i, b, err := parseIPQoS(c.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.in, err)
+			continue
+		}
+		if i != c.interactive || b != c.bulk {
+			t.Errorf("%q: got (%#x, %#x), want (%#x, %#x)", c.in, i, b, c.interactive, c.bulk)
+		}
+	}
+}
+
+func TestParseIPQoSInvalid(t *testing.T) {
+	for _, in := range []string{"", "af99", "256", "ef cs1 cs2", "-1"} {
+		if _, _, err := parseIPQoS(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
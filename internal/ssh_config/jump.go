@@ -1,7 +1,9 @@
 package ssh_config
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -13,19 +15,37 @@ type jumpSpec struct {
 	port int
 }
 
+func (j *jumpSpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Host string `json:"host"`
+		User string `json:"user,omitempty"`
+		Port int    `json:"port,omitempty"`
+	}{j.host, j.user, j.port})
+}
+
 func parseProxyJump(s string) (*jumpSpec, error) {
-	// Format: [user@]host[:port]
+	// Format: [user@]host[:port], where IPv6 addresses with a port are
+	// enclosed in brackets, e.g. [fe80::1%eth0]:22
 	var portInt int
 	var err error
-	host, port, _ := strings.Cut(s, ":")
+	user, host, fnd := strings.Cut(s, "@")
+	if !fnd {
+		user, host = host, user
+	}
+	port := ""
+	if strings.HasPrefix(host, "[") {
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			host, port = h, p
+		} else if host, fnd = strings.CutSuffix(host[1:], "]"); !fnd {
+			return nil, fmt.Errorf("could not parse host: %v", err)
+		}
+	} else if strings.Count(host, ":") == 1 {
+		host, port, _ = strings.Cut(host, ":")
+	}
 	if port != "" {
 		if portInt, err = strconv.Atoi(port); err != nil {
 			return nil, fmt.Errorf("could not parse port: %v", err)
 		}
 	}
-	user, host, fnd := strings.Cut(host, "@")
-	if !fnd {
-		user, host = host, user
-	}
 	return &jumpSpec{host: host, user: user, port: portInt}, nil
 }
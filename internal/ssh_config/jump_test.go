@@ -0,0 +1,30 @@
+package ssh_config
+
+import "testing"
+
+func TestParseProxyJump(t *testing.T) {
+	cases := []struct {
+		in         string
+		user, host string
+		port       int
+	}{
+		{"jump", "", "jump", 0},
+		{"alice@jump:2222", "alice", "jump", 2222},
+		{"fe80::1%eth0", "", "fe80::1%eth0", 0},
+		{"[fe80::1%eth0]", "", "fe80::1%eth0", 0},
+		{"alice@[fe80::1%eth0]:2222", "alice", "fe80::1%eth0", 2222},
+	}
+	for _, c := range cases {
+		j, err := parseProxyJump(c.in)
+		if err != nil {
+			t.Errorf("%s: %v", c.in, err)
+			continue
+		}
+		if j.user != c.user || j.host != c.host || j.port != c.port {
+			t.Errorf("%s: got %+v", c.in, *j)
+		}
+	}
+	if _, err := parseProxyJump("[fe80::1"); err == nil {
+		t.Error("expected error for unclosed bracket")
+	}
+}
@@ -0,0 +1,98 @@
+package ssh_config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	ossh_config "github.com/alebeck/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// pqKex are the post-quantum hybrid key exchanges of OpenSSH. Hardened
+// configs may require them, so it matters if they are left out.
+var pqKex = []string{
+	"mlkem768x25519-sha256",
+	"sntrup761x25519-sha512",
+	"sntrup761x25519-sha512@openssh.com",
+}
+
+// kexAliases groups the names under which OpenSSH offers the same key
+// exchange, of which the SSH library may support only some
+var kexAliases = [][]string{
+	{"sntrup761x25519-sha512", "sntrup761x25519-sha512@openssh.com"},
+	{"curve25519-sha256", "curve25519-sha256@libssh.org"},
+}
+
+// supportedKex reports whether the SSH library implements a key exchange.
+// It offers curve25519-sha256@libssh.org along with curve25519-sha256, so
+// the former is not listed.
+func supportedKex(algo string) bool {
+	return slices.Contains(ssh.SupportedAlgorithms().KeyExchanges, algo) ||
+		slices.Contains(ssh.InsecureAlgorithms().KeyExchanges, algo)
+}
+
+// kexName returns the name under which the SSH library supports a key
+// exchange, or "" if it does not support it under any of its names
+func kexName(algo string) string {
+	if supportedKex(algo) {
+		return algo
+	}
+	for _, names := range kexAliases {
+		if !slices.Contains(names, algo) {
+			continue
+		}
+		for _, n := range names {
+			if supportedKex(n) {
+				return n
+			}
+		}
+	}
+	return ""
+}
+
+// pqKexWarning returns a warning if algos name post-quantum key exchanges,
+// but the SSH library supports none of them, so that connections silently
+// fall back to classical ones. Otherwise, it returns "".
+func pqKexWarning(algos []string) string {
+	var missing []string
+	for _, a := range algos {
+		if !slices.Contains(pqKex, a) {
+			continue
+		}
+		if kexName(a) != "" {
+			return ""
+		}
+		missing = append(missing, a)
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("post-quantum KexAlgorithms %s not supported by this build, "+
+		"connecting without post-quantum key exchange", strings.Join(missing, ","))
+}
+
+// kexAlgos returns the configured key exchanges under the names the SSH
+// library supports, as it silently ignores others. The defaults are left
+// to the library.
+func kexAlgos(alias string, get func(string) string) ([]string, error) {
+	const key = "KexAlgorithms"
+	v := get(key)
+	if v == ossh_config.Default(key) {
+		return split(v), nil
+	}
+	if w := pqKexWarning(split(v)); w != "" {
+		log.Warningf("%s: %s", alias, w)
+	}
+	var algos []string
+	for _, a := range split(v) {
+		if n := kexName(a); n != "" && !slices.Contains(algos, n) {
+			algos = append(algos, n)
+		}
+	}
+	if len(algos) == 0 {
+		return nil, fmt.Errorf("%w %s '%s', none are supported by this build", Unsupported, key, v)
+	}
+	return algos, nil
+}
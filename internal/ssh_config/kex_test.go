@@ -0,0 +1,66 @@
+package ssh_config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestKexAlgos(t *testing.T) {
+	useSSHConfig(t, `Host pq
+	KexAlgorithms mlkem768x25519-sha256,sntrup761x25519-sha512
+Host sntrup
+	KexAlgorithms sntrup761x25519-sha512@openssh.com,curve25519-sha256@libssh.org
+Host pqonly
+	KexAlgorithms sntrup761x25519-sha512,sntrup761x25519-sha512@openssh.com
+Host *
+	HostName 127.0.0.1
+`)
+
+	for _, c := range []struct {
+		alias string
+		want  []string
+	}{
+		{"pq", []string{"mlkem768x25519-sha256"}},
+		// Aliases are offered under the name the library supports
+		{"sntrup", []string{"curve25519-sha256"}},
+	} {
+		sc, err := ParseSSHConfig(c.alias, "")
+		if err != nil {
+			t.Fatalf("%s: %v", c.alias, err)
+		}
+		if !slices.Equal(sc.KexAlgos, c.want) {
+			t.Errorf("%s: got %v, want %v", c.alias, sc.KexAlgos, c.want)
+		}
+	}
+
+	// The defaults are kept, the library picks what it supports
+	sc, err := ParseSSHConfig("other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(sc.KexAlgos, "sntrup761x25519-sha512@openssh.com") {
+		t.Errorf("expected defaults, got %v", sc.KexAlgos)
+	}
+
+	if _, err := ParseSSHConfig("pqonly", ""); !errors.Is(err, Unsupported) {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+}
+
+func TestPQKexWarning(t *testing.T) {
+	for _, c := range []struct {
+		algos []string
+		want  string
+	}{
+		{[]string{"curve25519-sha256"}, ""},
+		{[]string{"mlkem768x25519-sha256", "sntrup761x25519-sha512"}, ""},
+		{[]string{"sntrup761x25519-sha512", "curve25519-sha256"}, "sntrup761x25519-sha512 not supported"},
+	} {
+		w := pqKexWarning(c.algos)
+		if c.want == "" && w != "" || !strings.Contains(w, c.want) {
+			t.Errorf("%v: got %q, want %q", c.algos, w, c.want)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package ssh_config
 import (
 	"errors"
 	"net"
+	"strconv"
 	"strings"
 
+	"github.com/alebeck/boring/internal/log"
+
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
@@ -69,3 +72,64 @@ func extractHostKeyAlgos(cb ssh.HostKeyCallback, host string) (res []string) {
 	}
 	return
 }
+
+// stripZone removes the zone of a link-local IPv6 address from addr, e.g.,
+// "[fe80::1%eth0]:22" becomes "[fe80::1]:22". The zone is needed to dial,
+// but names a local interface, so it is not part of hosts in known_hosts.
+func stripZone(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, _, _ = strings.Cut(addr, "%")
+		return host
+	}
+	if h, _, ok := strings.Cut(host, "%"); ok {
+		return net.JoinHostPort(h, port)
+	}
+	return addr
+}
+
+// zonelessCallback checks host keys against cb without the zones of
+// link-local IPv6 addresses, see stripZone
+func zonelessCallback(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		if a, ok := remote.(*net.TCPAddr); ok && a.Zone != "" {
+			remote = &net.TCPAddr{IP: a.IP, Port: a.Port}
+		}
+		return cb(stripZone(host), remote, key)
+	}
+}
+
+// checkHostIP wraps a host key callback to also look up the key by the IP
+// address connected to, like CheckHostIP in ssh(1). A different key known
+// for the address may indicate DNS spoofing, which is warned about. The
+// connection is only rejected based on the host name, as it is without.
+func checkHostIP(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		if err := cb(host, remote, key); err != nil {
+			return err
+		}
+		// The address is unknown if a jump host resolved the name, and
+		// certificates are trusted by their CA only, like in ssh(1)
+		a, ok := remote.(*net.TCPAddr)
+		if !ok || a.IP == nil || a.IP.IsUnspecified() {
+			return nil
+		}
+		if _, ok := key.(*ssh.Certificate); ok {
+			return nil
+		}
+		if h, _, err := net.SplitHostPort(host); err != nil || net.ParseIP(h) != nil {
+			return nil
+		}
+
+		ipHost := net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+		var ke *knownhosts.KeyError
+		err := cb(ipHost, remote, key)
+		if errors.As(err, &ke) && len(ke.Want) > 0 {
+			log.Warningf("%v: host key differs from the one known for IP address %v "+
+				"(%v:%d), which may indicate DNS spoofing", host, a.IP, ke.Want[0].Filename, ke.Want[0].Line)
+		} else if err != nil {
+			log.Debugf("%v: host key not known for IP address %v: %v", host, a.IP, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package ssh_config
+
+import (
+	"os"
+
+	"github.com/alebeck/boring/internal/shell"
+)
+
+// runKnownHostsCommand runs a KnownHostsCommand in a shell and writes its
+// output, lines in known_hosts format, to a temporary file for knownhosts
+// to read, which the caller must remove
+func runKnownHostsCommand(command string) (string, error) {
+	out, err := shell.Output(command)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "boring-known-hosts-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(out); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
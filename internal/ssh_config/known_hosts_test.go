@@ -5,9 +5,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/alebeck/boring/internal/log"
@@ -126,3 +128,94 @@ func TestMakeCallbackAndAlgos(t *testing.T) {
 		t.Fatalf("not narrowed to pinned type: got %v, want %v", algs, want)
 	}
 }
+
+func TestStripZone(t *testing.T) {
+	for in, want := range map[string]string{
+		"[fe80::1%eth0]:22": "[fe80::1]:22",
+		"fe80::1%eth0":      "fe80::1",
+		"[::1]:2222":        "[::1]:2222",
+		"127.0.0.1:22":      "127.0.0.1:22",
+	} {
+		if got := stripZone(in); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
+
+// Link-local hosts are known without their zone, which is only used to dial
+func TestZonelessCallback(t *testing.T) {
+	key := edPub(t)
+	cb := zonelessCallback(callbackFor(t, knownhosts.Line([]string{"[fe80::1]:22"}, key)+"\n"))
+	remote := &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 22, Zone: "eth0"}
+	if err := cb("[fe80::1%eth0]:22", remote, key); err != nil {
+		t.Errorf("host key not accepted: %v", err)
+	}
+	if err := cb("[fe80::1%eth0]:22", remote, edPub(t)); err == nil {
+		t.Error("other key accepted")
+	}
+}
+
+// Keys printed by a KnownHostsCommand are trusted in addition to the files
+func TestKnownHostsCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	key := edPub(t)
+	p := filepath.Join(t.TempDir(), "keys")
+	line := knownhosts.Line([]string{testHostPort}, key) + "\n"
+	if err := os.WriteFile(p, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+
+	cb, err := knownHostsCallback(nil, "cat "+p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(testHostPort, addr, key); err != nil {
+		t.Errorf("key from command not accepted: %v", err)
+	}
+
+	// A failing command leaves only the files
+	cb, err = knownHostsCallback(nil, "echo oops >&2; exit 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb(testHostPort, addr, key); err == nil {
+		t.Error("expected unknown key without command output")
+	}
+}
+
+// With CheckHostIP, the key is also looked up by the address connected to,
+// but the host name alone decides whether it is accepted
+func TestCheckHostIP(t *testing.T) {
+	key := edPub(t)
+	known := knownhosts.Line([]string{"example.com:22"}, key) + "\n" +
+		knownhosts.Line([]string{"192.0.2.1:22"}, edPub(t)) + "\n"
+	var checked []string
+	inner := callbackFor(t, known)
+	cb := checkHostIP(func(host string, remote net.Addr, key ssh.PublicKey) error {
+		checked = append(checked, host)
+		return inner(host, remote, key)
+	})
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	if err := cb("example.com:22", remote, key); err != nil {
+		t.Errorf("host key not accepted: %v", err)
+	}
+	if want := []string{"example.com:22", "192.0.2.1:22"}; !reflect.DeepEqual(checked, want) {
+		t.Errorf("checked %v, want %v", checked, want)
+	}
+	if err := cb("example.com:22", remote, edPub(t)); err == nil {
+		t.Error("other key accepted")
+	}
+
+	// Nothing to compare if connecting to the address directly, or to an
+	// unknown one through a jump host
+	checked = nil
+	cb("192.0.2.1:22", remote, key)
+	cb("example.com:22", &net.TCPAddr{Port: 22}, key)
+	if len(checked) != 2 {
+		t.Errorf("checked %v, want only the hosts", checked)
+	}
+}
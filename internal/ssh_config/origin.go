@@ -0,0 +1,273 @@
+package ssh_config
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	ossh_config "github.com/alebeck/ssh_config"
+)
+
+// Options that boring reads from the SSH config, in the order they are reported
+var resolvedKeys = []string{
+	"HostName", "User", "Port", "ProxyJump", "ConnectTimeout",
+	"ConnectionAttempts", "ServerAliveCountMax",
+	"StrictHostKeyChecking", "UserKnownHostsFile", "GlobalKnownHostsFile",
+	"IdentitiesOnly", "UseAgent", "IdentityFile", "CertificateFile",
+	"Ciphers", "MACs", "HostKeyAlgorithms", "KexAlgorithms",
+	"CASignatureAlgorithms", "RekeyLimit", "IPQoS", "UpdateHostKeys",
+	"HashKnownHosts", "KnownHostsCommand", "CheckHostIP", "LogLevel",
+	"PubkeyAcceptedAlgorithms", "PubkeyAcceptedKeyTypes", "HostNameCommand",
+	"ForwardAgent", "TLS", "TLSServerName", "TLSCAFile", "TLSCertificateFile",
+	"TLSKeyFile", "TLSPinnedPubKey", "SRVLookup",
+}
+
+// Origin is the location an option was set at
+type Origin struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Option is a resolved SSH config option, without a location if it
+// is a default value.
+type Option struct {
+	Key    string  `json:"key"`
+	Value  string  `json:"value"`
+	Origin *Origin `json:"origin,omitempty"`
+}
+
+// Resolve returns the raw options for alias as boring reads them, along
+// with the location each one was taken from. Multi-valued options like
+// IdentityFile yield one entry per value.
+func Resolve(alias, user string) ([]Option, error) {
+	us := ossh_config.MakeDefaultUserSettings()
+	if oc := overrideConfig(); oc != "" {
+		us.ConfigFinder(func() string { return oc })
+	}
+	alias = strings.ToLower(alias)
+
+	var opts []Option
+	for _, key := range resolvedKeys {
+		// Same as in ParseSSHConfig. ossh_config.SupportsMultiple would be
+		// the canonical check, but it never matches due to key casing.
+		multi := key == "IdentityFile" || key == "CertificateFile"
+		set, err := findOptions(alias, user, key, multi)
+		if err != nil {
+			return nil, err
+		}
+		if len(set) > 0 {
+			opts = append(opts, set...)
+			continue
+		}
+
+		// Not set anywhere, so the defaults apply
+		var vals []string
+		switch {
+		case key == "IdentityFile":
+			vals = DefaultIdentities()
+		case multi:
+			if vals, err = us.GetAllStrict(alias, key, user); err != nil {
+				return nil, err
+			}
+		default:
+			v, err := us.GetStrict(alias, key, user)
+			if err != nil {
+				return nil, err
+			}
+			if v != "" {
+				vals = []string{v}
+			}
+		}
+		for _, v := range vals {
+			opts = append(opts, Option{Key: key, Value: v})
+		}
+	}
+	return opts, nil
+}
+
+// optionWalker looks up where and to what a key is set, following the same
+// order as the ssh_config library: the first matching stanza wins (or all
+// of them for multi-valued keys), and `Match final` stanzas come last.
+type optionWalker struct {
+	key    string
+	multi  bool
+	ctx    *ossh_config.MatchContext
+	found  []Option
+	finals []finalBlock
+	// If set, all options are collected instead of a single key
+	all bool
+}
+
+type finalBlock struct {
+	file  string
+	block ossh_config.Block
+}
+
+// findOptions returns the values key is set to for alias, each with its
+// origin, or none if the key is not set
+func findOptions(alias, user, key string, multi bool) ([]Option, error) {
+	w := &optionWalker{
+		key:   key,
+		multi: multi,
+		ctx:   ossh_config.NewMatchContext(alias, user),
+	}
+	return w.walk()
+}
+
+// setOptions returns all options set in the stanzas matching alias
+func setOptions(alias, user string) ([]Option, error) {
+	w := &optionWalker{
+		all: true,
+		ctx: ossh_config.NewMatchContext(strings.ToLower(alias), user),
+	}
+	return w.walk()
+}
+
+// configFiles returns the SSH config files in the order they are read
+func configFiles() []string {
+	if oc := overrideConfig(); oc != "" {
+		return []string{oc}
+	}
+	return []string{userConfigPath(), "/etc/ssh/ssh_config"}
+}
+
+func (w *optionWalker) walk() ([]Option, error) {
+	for _, f := range configFiles() {
+		done, err := w.walkFile(f, 0)
+		if err != nil {
+			return nil, err
+		}
+		if done || !w.all && len(w.found) > 0 {
+			return w.found, nil
+		}
+	}
+
+	for _, fb := range w.finals {
+		if !fb.block.Matches(w.ctx) {
+			continue
+		}
+		if w.walkNodes(fb.file, fb.block.GetNodes(), 0, false) {
+			break
+		}
+	}
+	return w.found, nil
+}
+
+// walkFile looks up the key in file, returning true if the lookup is done
+func (w *optionWalker) walkFile(file string, depth int) (bool, error) {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) && depth == 0 && file != overrideConfig() {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	cfg, err := ossh_config.DecodeBytes(b)
+	if err != nil {
+		return false, err
+	}
+
+	for _, block := range cfg.Blocks {
+		if block.IsFinal() {
+			w.finals = append(w.finals, finalBlock{file, block})
+			continue
+		}
+		if !block.Matches(w.ctx) {
+			continue
+		}
+		if w.walkNodes(file, block.GetNodes(), depth, true) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (w *optionWalker) walkNodes(file string, nodes []ossh_config.Node, depth int, update bool) bool {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *ossh_config.KV:
+			k := strings.ToLower(n.Key)
+			origin := &Origin{File: file, Line: n.Pos().Line}
+			if w.all {
+				w.found = append(w.found, Option{Key: n.Key, Value: n.Value, Origin: origin})
+			} else if strings.EqualFold(k, w.key) {
+				w.found = append(w.found, Option{Key: w.key, Value: n.Value, Origin: origin})
+				if !w.multi {
+					return true
+				}
+			}
+			if update {
+				w.updateCtx(k, n.Value)
+			}
+		case *ossh_config.Include:
+			for _, inc := range includedFiles(n, file) {
+				// Errors were already reported when parsing the config
+				if done, _ := w.walkFile(inc, depth+1); done {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// updateCtx mirrors how the ssh_config library tracks values for Match
+func (w *optionWalker) updateCtx(key, val string) {
+	switch key {
+	case "user":
+		if w.ctx.User == "" {
+			w.ctx.User = val
+		}
+	case "hostname":
+		if w.ctx.Host == "" {
+			w.ctx.Host = val
+		}
+	}
+}
+
+// includedFiles expands the globs of an Include directive like the
+// ssh_config library does
+func includedFiles(inc *ossh_config.Include, from string) (files []string) {
+	// The directives are not exported, so we take them from the string form
+	dirs := strings.TrimSpace(inc.String())
+	if inc.Comment != "" {
+		dirs = strings.TrimSuffix(dirs, " #"+inc.Comment)
+	}
+	dirs = strings.TrimSpace(strings.TrimPrefix(dirs, "Include"))
+	dirs = strings.TrimPrefix(dirs, "=")
+
+	system := isSystemConfig(from)
+	for _, d := range strings.Fields(dirs) {
+		var p string
+		switch {
+		case filepath.IsAbs(d):
+			p = d
+		case system:
+			p = filepath.Join("/etc/ssh", d)
+		case strings.HasPrefix(d, "~/"):
+			p = filepath.Join(homeDir(), d[2:])
+		default:
+			p = filepath.Join(homeDir(), ".ssh", d)
+		}
+		matches, _ := filepath.Glob(p)
+		for _, m := range matches {
+			if !slices.Contains(files, m) {
+				files = append(files, m)
+			}
+		}
+	}
+	return
+}
+
+func userConfigPath() string {
+	return filepath.Join(homeDir(), ".ssh", "config")
+}
+
+func homeDir() string {
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+	return os.Getenv("HOME")
+}
@@ -0,0 +1,55 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveOrigins(t *testing.T) {
+	inc := filepath.Join(t.TempDir(), "included")
+	if err := os.WriteFile(inc, []byte("Host web\n\tUser deploy\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	useSSHConfig(t, `Include `+inc+`
+Host web
+	User ignored
+	IdentityFile ~/.ssh/a
+Host *
+	IdentityFile ~/.ssh/b
+Match final all
+	Port 2222
+`)
+
+	opts, err := Resolve("WEB", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Origin{
+		"User":     {inc, 2},
+		"Port":     {overrideConfig(), 8},
+		"~/.ssh/a": {overrideConfig(), 4},
+		"~/.ssh/b": {overrideConfig(), 6},
+	}
+	for _, o := range opts {
+		k := o.Key
+		if k == "IdentityFile" {
+			k = o.Value
+		}
+		w, ok := want[k]
+		if !ok {
+			if o.Origin != nil {
+				t.Errorf("%s: expected default, got %+v", o.Key, *o.Origin)
+			}
+			continue
+		}
+		delete(want, k)
+		if o.Origin == nil || *o.Origin != w {
+			t.Errorf("%s: got origin %v, want %+v", k, o.Origin, w)
+		}
+	}
+	for k := range want {
+		t.Errorf("%s: not resolved", k)
+	}
+}
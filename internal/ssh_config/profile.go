@@ -0,0 +1,110 @@
+package ssh_config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	ossh_config "github.com/alebeck/ssh_config"
+)
+
+// profile restricts the algorithms offered to a server
+type profile struct {
+	ciphers, macs, kex, hostKeys []string
+}
+
+var modernHostKeys = []string{
+	"ssh-ed25519-cert-v01@openssh.com", "sk-ssh-ed25519-cert-v01@openssh.com",
+	"ecdsa-sha2-nistp256-cert-v01@openssh.com", "ecdsa-sha2-nistp384-cert-v01@openssh.com",
+	"ecdsa-sha2-nistp521-cert-v01@openssh.com", "sk-ecdsa-sha2-nistp256-cert-v01@openssh.com",
+	"rsa-sha2-512-cert-v01@openssh.com", "rsa-sha2-256-cert-v01@openssh.com",
+	"ssh-ed25519", "sk-ssh-ed25519@openssh.com",
+	"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+	"sk-ecdsa-sha2-nistp256@openssh.com", "rsa-sha2-512", "rsa-sha2-256",
+}
+
+// profiles maps security profile names to their allowlists, the default
+// profile imposes no restrictions.
+var profiles = map[string]*profile{
+	"default": nil,
+	"modern": {
+		ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+		},
+		macs: []string{
+			"hmac-sha2-512-etm@openssh.com", "hmac-sha2-256-etm@openssh.com",
+		},
+		kex: []string{
+			"mlkem768x25519-sha256", "sntrup761x25519-sha512", "sntrup761x25519-sha512@openssh.com",
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+		},
+		hostKeys: modernHostKeys,
+	},
+	"fips": {
+		ciphers: []string{
+			"aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		macs: []string{
+			"hmac-sha2-512-etm@openssh.com", "hmac-sha2-256-etm@openssh.com",
+			"hmac-sha2-512", "hmac-sha2-256",
+		},
+		kex: []string{
+			"ecdh-sha2-nistp521", "ecdh-sha2-nistp384", "ecdh-sha2-nistp256",
+			"diffie-hellman-group-exchange-sha256", "diffie-hellman-group18-sha512",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+		hostKeys: slices.DeleteFunc(slices.Clone(modernHostKeys), func(a string) bool {
+			return strings.Contains(a, "ed25519")
+		}),
+	},
+}
+
+// ValidProfile reports whether name is a known security profile
+func ValidProfile(name string) bool {
+	_, ok := profiles[name]
+	return name == "" || ok
+}
+
+// applyProfile restricts the configured algorithms to those allowed by
+// the security profile, warning about explicitly configured ones that
+// are dropped.
+func (sc *SSHConfig) applyProfile() error {
+	p, ok := profiles[sc.SecurityProfile]
+	if !ok && sc.SecurityProfile != "" {
+		return fmt.Errorf("%w security profile %q", InvalidOption, sc.SecurityProfile)
+	}
+	if p == nil {
+		return nil
+	}
+
+	restrict := func(key string, algos *[]string, allowed []string) error {
+		explicit := strings.Join(*algos, ",") != ossh_config.Default(key)
+		var kept []string
+		for _, a := range *algos {
+			if slices.Contains(allowed, a) {
+				kept = append(kept, a)
+			} else if explicit {
+				log.Warningf("%s: %s '%s' not allowed by security profile '%s', ignoring",
+					sc.Alias, key, a, sc.SecurityProfile)
+			}
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("none of %s allowed by security profile '%s'", key, sc.SecurityProfile)
+		}
+		*algos = kept
+		return nil
+	}
+
+	if err := restrict("Ciphers", &sc.Ciphers, p.ciphers); err != nil {
+		return err
+	}
+	if err := restrict("MACs", &sc.Macs, p.macs); err != nil {
+		return err
+	}
+	if err := restrict("KexAlgorithms", &sc.KexAlgos, p.kex); err != nil {
+		return err
+	}
+	return restrict("HostKeyAlgorithms", &sc.HostKeyAlgos, p.hostKeys)
+}
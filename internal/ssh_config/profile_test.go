@@ -0,0 +1,58 @@
+package ssh_config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	useSSHConfig(t, `Host legacy
+	Ciphers aes128-cbc,aes256-ctr
+	KexAlgorithms diffie-hellman-group1-sha1
+Host *
+	Port 22
+`)
+
+	// Defaults are narrowed down to the allowlist
+	c, err := ParseSSHConfig("other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SecurityProfile = "modern"
+	if err := c.applyProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(c.Ciphers, "aes128-ctr") || !slices.Contains(c.Ciphers, "chacha20-poly1305@openssh.com") {
+		t.Errorf("unexpected ciphers for modern profile: %v", c.Ciphers)
+	}
+	if slices.Contains(c.HostKeyAlgos, "ssh-rsa") {
+		t.Errorf("unexpected host key algorithms: %v", c.HostKeyAlgos)
+	}
+
+	// Explicit algorithms outside the allowlist are dropped
+	if c, err = ParseSSHConfig("legacy", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.SecurityProfile = "fips"
+	c.KexAlgos = []string{"ecdh-sha2-nistp256"}
+	if err := c.applyProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(c.Ciphers, []string{"aes256-ctr"}) {
+		t.Errorf("got ciphers %v, want [aes256-ctr]", c.Ciphers)
+	}
+
+	// Nothing left
+	if c, err = ParseSSHConfig("legacy", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.SecurityProfile = "fips"
+	if err := c.applyProfile(); err == nil {
+		t.Error("expected error when no key exchange is allowed")
+	}
+
+	c.SecurityProfile = "paranoid"
+	if err := c.applyProfile(); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+}
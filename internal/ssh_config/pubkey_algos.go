@@ -0,0 +1,114 @@
+package ssh_config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	ossh_config "github.com/alebeck/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// RSA signature algorithms, by preference, along with those of certificates
+var rsaAlgos = []struct{ plain, cert string }{
+	{ssh.KeyAlgoRSASHA512, ssh.CertAlgoRSASHA512v01},
+	{ssh.KeyAlgoRSASHA256, ssh.CertAlgoRSASHA256v01},
+	{ssh.KeyAlgoRSA, ssh.CertAlgoRSAv01},
+}
+
+// pubkeyAlgos returns the PubkeyAcceptedAlgorithms, or the obsolete
+// PubkeyAcceptedKeyTypes if only that is set. Unlike for other algorithm
+// lists, the ssh_config library does not resolve "+", "-" and "^" here.
+func pubkeyAlgos(get func(string) string) []string {
+	const key = "PubkeyAcceptedAlgorithms"
+	v := get(key)
+	if v == ossh_config.Default(key) {
+		v = get("PubkeyAcceptedKeyTypes")
+	}
+	def := split(ossh_config.Default(key))
+	if v == "" {
+		return def
+	}
+	algos := split(v[1:])
+	switch v[0] {
+	case '+':
+		return append(def, algos...)
+	case '-':
+		return slices.DeleteFunc(def, func(a string) bool { return slices.Contains(algos, a) })
+	case '^':
+		rest := slices.DeleteFunc(def, func(a string) bool { return slices.Contains(algos, a) })
+		return append(algos, rest...)
+	}
+	return split(v)
+}
+
+// acceptedSigners drops the signers whose key types are not accepted by
+// PubkeyAcceptedAlgorithms, and restricts RSA keys to the accepted
+// signature algorithms. In particular, ssh-rsa (SHA-1) signatures are only
+// made if enabled explicitly, like in ssh(1).
+func (sc *SSHConfig) acceptedSigners(sigs []ssh.Signer) []ssh.Signer {
+	if sc.PubkeyAlgos == nil {
+		return sigs
+	}
+	var out []ssh.Signer
+	for _, s := range sigs {
+		k := s.PublicKey()
+		c, isCert := k.(*ssh.Certificate)
+		if isCert {
+			k = c.Key
+		}
+		if k.Type() != ssh.KeyAlgoRSA {
+			if slices.Contains(sc.PubkeyAlgos, s.PublicKey().Type()) {
+				out = append(out, s)
+			} else {
+				log.Debugf("%s: skipping key %s, type not in PubkeyAcceptedAlgorithms", sc.Alias, s)
+			}
+			continue
+		}
+
+		var algos []string
+		for _, a := range rsaAlgos {
+			if isCert && slices.Contains(sc.PubkeyAlgos, a.cert) ||
+				!isCert && slices.Contains(sc.PubkeyAlgos, a.plain) {
+				algos = append(algos, a.plain)
+			}
+		}
+		as, ok := s.(ssh.AlgorithmSigner)
+		if !ok && slices.Contains(algos, ssh.KeyAlgoRSA) {
+			// Can only sign with ssh-rsa anyway
+			out = append(out, s)
+			continue
+		}
+		if !ok || len(algos) == 0 {
+			log.Debugf("%s: skipping key %s, no signature algorithm in PubkeyAcceptedAlgorithms", sc.Alias, s)
+			continue
+		}
+		ms, err := ssh.NewSignerWithAlgorithms(as, algos)
+		if err != nil {
+			log.Debugf("%s: skipping key %s: %v", sc.Alias, s, err)
+			continue
+		}
+		out = append(out, ms)
+	}
+	return out
+}
+
+// SSHRSAHint returns advice if err stems from a server that only supports
+// the ssh-rsa (SHA-1) algorithm, which is disabled by default, or "" if not
+func SSHRSAHint(err error) string {
+	if err == nil {
+		return ""
+	}
+	var ne *ssh.AlgorithmNegotiationError
+	if errors.As(err, &ne) && ne.What == "host key" &&
+		slices.Contains(ne.RequestedAlgorithms, ssh.KeyAlgoRSA) {
+		return "the server only offers ssh-rsa (SHA-1) host keys, " +
+			"add 'HostKeyAlgorithms +ssh-rsa' to its SSH config to accept them"
+	}
+	if strings.Contains(err.Error(), `server only supports "ssh-rsa"`) {
+		return "the server only accepts ssh-rsa (SHA-1) signatures, " +
+			"add 'PubkeyAcceptedAlgorithms +ssh-rsa' to its SSH config to allow them"
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	ossh_config "github.com/alebeck/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsePubkeyAlgos(t *testing.T) {
+	useSSHConfig(t, `Host legacy
+	PubkeyAcceptedAlgorithms +ssh-rsa
+Host obsolete
+	PubkeyAcceptedKeyTypes ssh-ed25519,ssh-rsa
+Host nored
+	PubkeyAcceptedAlgorithms -ssh-ed25519
+Host *
+	HostName 127.0.0.1
+`)
+
+	cases := []struct {
+		alias          string
+		rsa, ed25519   bool
+		sha2Signatures bool
+	}{
+		{"default", false, true, true},
+		{"legacy", true, true, true},
+		{"obsolete", true, true, false},
+		{"nored", false, false, true},
+	}
+	for _, c := range cases {
+		sc, err := ParseSSHConfig(c.alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slices.Contains(sc.PubkeyAlgos, ssh.KeyAlgoRSA); got != c.rsa {
+			t.Errorf("%s: ssh-rsa accepted %v, want %v", c.alias, got, c.rsa)
+		}
+		if got := slices.Contains(sc.PubkeyAlgos, ssh.KeyAlgoED25519); got != c.ed25519 {
+			t.Errorf("%s: ssh-ed25519 accepted %v, want %v", c.alias, got, c.ed25519)
+		}
+		if got := slices.Contains(sc.PubkeyAlgos, ssh.KeyAlgoRSASHA256); got != c.sha2Signatures {
+			t.Errorf("%s: rsa-sha2-256 accepted %v, want %v", c.alias, got, c.sha2Signatures)
+		}
+	}
+}
+
+// RSA keys only sign with ssh-rsa (SHA-1) if accepted explicitly, keys of
+// other types are dropped if not accepted
+func TestAcceptedSigners(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := ssh.NewSignerFromKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		accepted []string
+		n        int
+		rsaAlgos []string
+	}{
+		{split(ossh_config.Default("PubkeyAcceptedAlgorithms")), 2, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}},
+		{[]string{ssh.KeyAlgoRSA}, 1, []string{ssh.KeyAlgoRSA}},
+		{[]string{ssh.KeyAlgoED25519}, 1, nil},
+	}
+	for _, c := range cases {
+		sc := &SSHConfig{PubkeyAlgos: c.accepted}
+		sigs := sc.acceptedSigners([]ssh.Signer{rsaSig, edSig})
+		if len(sigs) != c.n {
+			t.Errorf("%v: got %d signers, want %d", c.accepted, len(sigs), c.n)
+			continue
+		}
+		if c.rsaAlgos == nil {
+			continue
+		}
+		ms, ok := sigs[0].(ssh.MultiAlgorithmSigner)
+		if !ok || !slices.Equal(ms.Algorithms(), c.rsaAlgos) {
+			t.Errorf("%v: RSA key signs with %v, want %v", c.accepted, sigs[0], c.rsaAlgos)
+		}
+	}
+}
+
+func TestSSHRSAHint(t *testing.T) {
+	hostKeyErr := fmt.Errorf("ssh: handshake failed: %w", &ssh.AlgorithmNegotiationError{
+		What:                "host key",
+		RequestedAlgorithms: []string{ssh.KeyAlgoRSA},
+		SupportedAlgorithms: []string{ssh.KeyAlgoED25519},
+	})
+	sigErr := fmt.Errorf(`ssh: handshake failed: ssh: no common public key signature algorithm, ` +
+		`server only supports "ssh-rsa" for key type "ssh-rsa", signer only supports [rsa-sha2-512]`)
+
+	for err, want := range map[error]string{
+		hostKeyErr:                     "HostKeyAlgorithms +ssh-rsa",
+		sigErr:                         "PubkeyAcceptedAlgorithms +ssh-rsa",
+		fmt.Errorf("connection reset"): "",
+	} {
+		if got := SSHRSAHint(err); want == "" && got != "" || !strings.Contains(got, want) {
+			t.Errorf("%v: got hint %q, want %q", err, got, want)
+		}
+	}
+}
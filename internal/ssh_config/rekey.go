@@ -0,0 +1,105 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseRekeyLimit parses a RekeyLimit value of the form `<bytes> [<time>]`.
+// Bytes may carry a K, M or G suffix, "default" yields 0, which makes x/crypto
+// choose a cipher-dependent threshold. Note that x/crypto clamps thresholds
+// to a minimum of 256 bytes. The time part is returned as parsed, a zero
+// value corresponds to "none".
+func parseRekeyLimit(s string) (bytes uint64, interval time.Duration, err error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, fmt.Errorf("%w RekeyLimit %q", InvalidOption, s)
+	}
+	if fields[0] != "default" {
+		if bytes, err = parseBytes(fields[0]); err != nil {
+			return 0, 0, fmt.Errorf("%w RekeyLimit %q: %v", InvalidOption, s, err)
+		}
+	}
+	if len(fields) == 2 && fields[1] != "none" {
+		if interval, err = parseTime(fields[1]); err != nil {
+			return 0, 0, fmt.Errorf("%w RekeyLimit %q: %v", InvalidOption, s, err)
+		}
+	}
+	return
+}
+
+// parseBytes parses a byte count with an optional K, M or G suffix
+func parseBytes(s string) (uint64, error) {
+	mult := uint64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad byte count %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseTime parses an OpenSSH time format (see sshd_config(5)), i.e., a
+// sequence of numbers with optional s, m, h, d or w qualifiers, seconds
+// being the default, e.g. "90", "1h30m" or "2w".
+func parseTime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+	var total time.Duration
+	num := ""
+	flush := func(unit time.Duration) error {
+		if num == "" {
+			return fmt.Errorf("bad time %q", s)
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return fmt.Errorf("bad time %q", s)
+		}
+		total += time.Duration(n) * unit
+		num = ""
+		return nil
+	}
+	for _, r := range strings.ToLower(s) {
+		var unit time.Duration
+		switch r {
+		case 's':
+			unit = time.Second
+		case 'm':
+			unit = time.Minute
+		case 'h':
+			unit = time.Hour
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		default:
+			if r < '0' || r > '9' {
+				return 0, fmt.Errorf("bad time %q", s)
+			}
+			num += string(r)
+			continue
+		}
+		if err := flush(unit); err != nil {
+			return 0, err
+		}
+	}
+	if num != "" {
+		if err := flush(time.Second); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
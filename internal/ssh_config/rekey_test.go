@@ -0,0 +1,79 @@
+package ssh_config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRekeyLimit(t *testing.T) {
+	cases := []struct {
+		in       string
+		bytes    uint64
+		interval time.Duration
+	}{
+		{"default none", 0, 0},
+		{"default", 0, 0},
+		{"1024", 1024, 0},
+		{"512K", 512 << 10, 0},
+		{"100M 1h", 100 << 20, time.Hour},
+		{"2G 90", 2 << 30, 90 * time.Second},
+		{"1g 1h30m", 1 << 30, 90 * time.Minute},
+	}
+	for _, c := range cases {
+		b, i, err := parseRekeyLimit(c.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.in, err)
+			continue
+		}
+		if b != c.bytes || i != c.interval {
+			t.Errorf("%q: got (%d, %v), want (%d, %v)", c.in, b, i, c.bytes, c.interval)
+		}
+	}
+}
+
+func TestParseRekeyLimitInvalid(t *testing.T) {
+	for _, in := range []string{"", "abc", "1T", "1G 1y", "1G 1h 2", "-5"} {
+		if _, _, err := parseRekeyLimit(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	cases := map[string]time.Duration{
+		"0":     0,
+		"600":   10 * time.Minute,
+		"10m":   10 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"1w2d":  9 * 24 * time.Hour,
+		"1H5S":  time.Hour + 5*time.Second,
+		"1m30":  90 * time.Second,
+	}
+	for in, want := range cases {
+		got, err := parseTime(in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: got %v, want %v", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "h", "1x", "1hm"} {
+		if _, err := parseTime(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestParseSSHConfigRekeyLimit(t *testing.T) {
+	useSSHConfig(t, "Host myhost\n\tRekeyLimit 64M\n")
+
+	sc, err := ParseSSHConfig("myhost", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.RekeyThreshold != 64<<20 {
+		t.Errorf("RekeyThreshold = %d, want %d", sc.RekeyThreshold, 64<<20)
+	}
+}
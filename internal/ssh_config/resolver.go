@@ -0,0 +1,44 @@
+package ssh_config
+
+import (
+	"context"
+	"sync"
+)
+
+// Resolver resolves host names that the system resolver does not know,
+// e.g., MagicDNS names of a Tailscale network.
+type Resolver interface {
+	// LookupHost returns the addresses of host, which are dialed in order
+	// until one connects. If ok is false, the host is not handled and the
+	// system resolver is used instead. ctx ends with the connect timeout of
+	// the hop or when the tunnel is closed.
+	LookupHost(ctx context.Context, host string) (addrs []string, ok bool, err error)
+}
+
+var (
+	resolver   Resolver
+	resolverMu sync.RWMutex
+)
+
+// RegisterResolver sets the resolver consulted for the HostName of the
+// first hop, replacing any previous one. Passing nil restores the
+// default of using the system resolver only. As the package is internal,
+// resolvers can only be registered within this module, e.g. by a build of
+// boring that wires in Tailscale's resolver, not by programs importing it.
+func RegisterResolver(r Resolver) {
+	resolverMu.Lock()
+	defer resolverMu.Unlock()
+	resolver = r
+}
+
+// LookupHost resolves host with the registered resolver. If ok is false,
+// the caller should fall back to the system resolver.
+func LookupHost(ctx context.Context, host string) (addrs []string, ok bool, err error) {
+	resolverMu.RLock()
+	r := resolver
+	resolverMu.RUnlock()
+	if r == nil {
+		return nil, false, nil
+	}
+	return r.LookupHost(ctx, host)
+}
@@ -0,0 +1,75 @@
+package ssh_config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+const srvTimeout = 10 * time.Second
+
+// lookupSRV is replaced in tests
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// resolveSRV sets the host name and port from the _ssh._tcp SRV record of
+// the host if SRVLookup is enabled, for hosts found by service discovery.
+// HostName and Port set in the config take precedence over the record. Like
+// HostNameCommand, the option is not part of ssh(1), and the record is only
+// looked up when resolving the config. Without a record, the host is
+// connected to as usual.
+func (sc *SSHConfig) resolveSRV(get func(string) string, user string) error {
+	switch v := strings.ToLower(get("SRVLookup")); v {
+	case "", "no":
+		return nil
+	case "yes":
+	default:
+		return fmt.Errorf("%w SRVLookup %q", InvalidOption, v)
+	}
+	hostSet := get("HostName") != ""
+	// The port has a default, so only its origin tells if it is set
+	port, err := findOptions(sc.Alias, user, "Port", false)
+	if err != nil {
+		return err
+	}
+	portSet := len(port) > 0
+	if hostSet && portSet {
+		return nil
+	}
+	if sc.SRVLookup = true; sc.HostNames == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), srvTimeout)
+	defer cancel()
+	_, addrs, err := lookupSRV(ctx, "ssh", "tcp", sc.HostName)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		log.Debugf("%s: no SRV record for %s", sc.Alias, sc.HostName)
+		return nil
+	}
+	if err != nil {
+		log.Warningf("%s: SRV lookup failed, connecting without: %v", sc.Alias, err)
+		return nil
+	}
+	// Records are ordered by priority, and by weight within a priority.
+	// A target of "." means that the service is not available.
+	if len(addrs) == 0 || addrs[0].Target == "." {
+		log.Debugf("%s: no SRV record for %s", sc.Alias, sc.HostName)
+		return nil
+	}
+	srv := addrs[0]
+	if !hostSet {
+		sc.HostName = strings.TrimSuffix(srv.Target, ".")
+	}
+	if !portSet {
+		sc.Port = int(srv.Port)
+	}
+	log.Debugf("%s: SRV record resolved %s", sc.Alias,
+		net.JoinHostPort(sc.HostName, fmt.Sprint(sc.Port)))
+	return nil
+}
@@ -0,0 +1,83 @@
+package ssh_config
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestResolveSRV(t *testing.T) {
+	useSSHConfig(t, `Host *.srv.test
+	SRVLookup yes
+Host fixed.srv.test
+	HostName fixed.example.com
+Host port.srv.test
+	Port 2022
+Host bad
+	SRVLookup maybe
+`)
+	var queried []string
+	lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		queried = append(queried, name)
+		if service != "ssh" || proto != "tcp" {
+			t.Errorf("unexpected service %s/%s", service, proto)
+		}
+		switch name {
+		case "missing.srv.test":
+			return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		case "gone.srv.test":
+			return "", []*net.SRV{{Target: ".", Port: 0}}, nil
+		}
+		return "", []*net.SRV{
+			{Target: "node1.example.com.", Port: 2201, Priority: 10},
+			{Target: "node2.example.com.", Port: 2202, Priority: 20},
+		}, nil
+	}
+	t.Cleanup(func() { lookupSRV = net.DefaultResolver.LookupSRV })
+
+	tests := []struct {
+		alias string
+		host  string
+		port  int
+	}{
+		{"app.srv.test", "node1.example.com", 2201},
+		{"fixed.srv.test", "fixed.example.com", 2201},
+		{"port.srv.test", "node1.example.com", 2022},
+		{"missing.srv.test", "missing.srv.test", 22},
+		{"gone.srv.test", "gone.srv.test", 22},
+		{"plain", "plain", 22},
+	}
+	for _, tt := range tests {
+		sc, err := ResolveSSHConfig(tt.alias, "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alias, err)
+		}
+		if sc.HostName != tt.host || sc.Port != tt.port {
+			t.Errorf("%s: expected %s:%d, got %s:%d", tt.alias, tt.host, tt.port, sc.HostName, sc.Port)
+		}
+	}
+	for _, name := range queried {
+		if name == "plain" {
+			t.Error("looked up SRV record without SRVLookup")
+		}
+	}
+
+	// Only resolving the config looks up records
+	queried = nil
+	sc, err := ParseSSHConfig("app.srv.test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queried) != 0 || sc.HostName != "app.srv.test" || !sc.SRVLookup {
+		t.Errorf("expected no lookup when parsing, got %s after querying %v", sc.HostName, queried)
+	}
+	if _, err := sc.ToHops(); err == nil || !strings.Contains(err.Error(), "SRV record was not looked up") {
+		t.Errorf("expected error connecting without looking up the record, got %v", err)
+	}
+
+	if _, err := ParseSSHConfig("bad", ""); !errors.Is(err, InvalidOption) {
+		t.Errorf("expected %v, got %v", InvalidOption, err)
+	}
+}
@@ -1,10 +1,15 @@
 package ssh_config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/user"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -22,7 +27,16 @@ const (
 	maxJumpRecursions = 20
 )
 
-var overrideConfig = os.Getenv("BORING_SSH_CONFIG")
+var (
+	noAgent      = os.Getenv("BORING_NO_AGENT") != ""
+	requireAgent = os.Getenv("BORING_REQUIRE_AGENT") != ""
+)
+
+// overrideConfig is read on each use, so that it can be set by tests
+// running tunnels in-process.
+func overrideConfig() string {
+	return os.Getenv("BORING_SSH_CONFIG")
+}
 
 type keyCheck int
 
@@ -36,68 +50,154 @@ const (
 	// TODO: support "accept-new" option?
 )
 
+func (k keyCheck) MarshalText() ([]byte, error) {
+	if k == off {
+		return []byte("no"), nil
+	}
+	return []byte("yes"), nil
+}
+
 // Hop holds information needed to establish a single SSH hop
 type Hop struct {
 	HostName string
 	Port     int
+	TOS      int // zero if not to be set
+	Attempts int // number of tries for the TCP connection
+	AuthKey  *AuthKey
+	Banner   *Banner            // nil if banners are not shown
+	HostKeys *HostKeyUpdater    // nil unless host keys are updated
+	AgentFwd string             // agent socket forwarded to sessions, "" if none
+	Certs    []*ssh.Certificate // offered for authentication
+	TLS      *tls.Config        // nil unless the connection is wrapped in TLS
 	*ssh.ClientConfig
 }
 
 // SSHConfig represents an SSH config read from, e.g., ~/.ssh/config
 type SSHConfig struct {
-	Alias            string
-	User             string
-	HostName         string
-	Port             int
-	KeyCheck         keyCheck
-	IdentitiesOnly   bool
-	IdentityFiles    []string
-	CertificateFiles []string
-	KnownHostsFiles  []string
-	Ciphers          []string
-	Macs             []string
-	HostKeyAlgos     []string
-	KexAlgos         []string
-	Jumps            []*jumpSpec
+	Alias            string      `json:"alias"`
+	User             string      `json:"user"`
+	HostName         string      `json:"hostname"`
+	HostNameCmd      string      `json:"hostname_command,omitempty"`
+	HostNames        *HostNames  `json:"-"` // cache for HostNameCmd, nil unless resolved
+	SRVLookup        bool        `json:"srv_lookup,omitempty"`
+	Port             int         `json:"port"`
+	KeyCheck         keyCheck    `json:"strict_host_key_checking"`
+	IdentitiesOnly   bool        `json:"identities_only"`
+	NoAgent          bool        `json:"no_agent"`
+	RequireAgent     bool        `json:"require_agent"`
+	SecurityProfile  string      `json:"security_profile,omitempty"`
+	ClientVersion    string      `json:"client_version,omitempty"`
+	IdentityFiles    []string    `json:"identity_files"`
+	identityOrigins  []Origin    // where IdentityFiles are set, nil for the defaults
+	IdentityEnv      string      `json:"identity_env,omitempty"`
+	PreferKeyType    bool        `json:"prefer_key_type,omitempty"`
+	CertificateFiles []string    `json:"certificate_files"`
+	KnownHostsFiles  []string    `json:"known_hosts_files"`
+	KnownHostsTarget string      `json:"known_hosts_target"` // first user file, new host keys go here
+	KnownHostsCmd    string      `json:"known_hosts_command,omitempty"`
+	UpdateHostKeys   bool        `json:"update_host_keys"`
+	HashKnownHosts   bool        `json:"hash_known_hosts"`
+	CheckHostIP      bool        `json:"check_host_ip"`
+	ForwardAgent     string      `json:"forward_agent,omitempty"` // agent socket, "" if not forwarded
+	LogLevel         string      `json:"log_level,omitempty"`
+	Ciphers          []string    `json:"ciphers"`
+	Macs             []string    `json:"macs"`
+	HostKeyAlgos     []string    `json:"host_key_algorithms"`
+	PubkeyAlgos      []string    `json:"pubkey_accepted_algorithms"`
+	KexAlgos         []string    `json:"kex_algorithms"`
+	CASigAlgos       []string    `json:"ca_signature_algorithms"`
+	RekeyThreshold   uint64      `json:"rekey_threshold"`
+	ConnectTimeout   int         `json:"connect_timeout"` // seconds
+	ConnAttempts     int         `json:"connection_attempts"`
+	AliveCountMax    int         `json:"server_alive_count_max"` // 0 never disconnects
+	TOS              int         `json:"tos"`
+	TLS              *TLSWrap    `json:"tls,omitempty"` // nil unless wrapped in TLS
+	Jumps            []*jumpSpec `json:"jumps"`
+	dryRun           bool        // hops are built by Check, without running commands
 }
 
 var (
 	hostnameTokens  = []string{"%%", "%h"}
 	proxyTokens     = []string{"%%", "h", "%n", "%p", "%r"}
 	identFileTokens = []string{
-		"%%", "%d", "%h", "%i", "%j", "%k",
+		"%%", "%C", "%d", "%h", "%i", "%j", "%k",
 		"%L", "%l", "%n", "%p", "%r", "%u",
 	}
+	knownHostsCmdTokens = append(slices.Clone(identFileTokens), "%H")
+	hostnameCmdTokens   = []string{"%%", "%d", "%h", "%i", "%L", "%l", "%n", "%p", "%r", "%u"}
 )
 
+// ParseSSHConfig reads the SSH config of a host without running its
+// HostNameCommand or looking up its SRV record, e.g., to show the config.
+// Use ResolveSSHConfig to connect.
 func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
+	return parseSSHConfig(alias, user, nil)
+}
+
+// ResolveSSHConfig reads the SSH config of a host like ParseSSHConfig, but
+// also runs its HostNameCommand and those of its jump hosts, caching their
+// output in names, which may be nil, and looks up SRV records. Like HostName, the resolved host name
+// is used for tokens like %h in the other options.
+func ResolveSSHConfig(alias, user string, names *HostNames) (*SSHConfig, error) {
+	if names == nil {
+		names = &HostNames{}
+	}
+	return parseSSHConfig(alias, user, names)
+}
+
+func parseSSHConfig(alias, user string, names *HostNames) (*SSHConfig, error) {
 	// We create a new ssh_config.UserSettings object at each connection so that
 	// config file changes are reflected immediately.
 	us := ossh_config.MakeDefaultUserSettings()
-	if overrideConfig != "" {
-		us.ConfigFinder(func() string { return overrideConfig })
+	if oc := overrideConfig(); oc != "" {
+		us.ConfigFinder(func() string { return oc })
 	}
 
+	// Like ssh(1), match host patterns against the lowercased alias
+	alias = strings.ToLower(alias)
+
 	// This is a "strict" dummy query to catch potential parsing errors early
 	if _, err := us.GetStrict(alias, "HostName", ""); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w SSH config: %w", InvalidOption, err)
 	}
 
 	// In the following, we always provide `user` since it is needed for `Match` matching
 	get := func(key string) string { return us.Get(alias, key, user) }
 	getAll := func(key string) []string { return us.GetAll(alias, key, user) }
 
-	c := &SSHConfig{Alias: alias}
+	c := &SSHConfig{Alias: alias, HostNames: names}
 	sub := makeSubst(alias)
 
-	if c.HostName = sub.apply(get("HostName"), hostnameTokens); c.HostName != "" {
-		sub["%h"] = c.HostName
+	// Like ssh(1), the alias is taken as host name if none is configured,
+	// e.g. for stanzas that only route through a ProxyJump
+	if c.HostName = sub.apply(get("HostName"), hostnameTokens); c.HostName == "" {
+		c.HostName = alias
 	}
 
-	c.User = get("User")
+	// A user given by the caller, e.g., inline in a ProxyJump, takes
+	// precedence, also for tokens like %r in the hop's IdentityFile
+	if c.User = user; c.User == "" {
+		c.User = get("User")
+	}
 	sub["%r"] = c.User
 	c.Port, _ = strconv.Atoi(get("Port"))
+	if err := c.resolveSRV(get, user); err != nil {
+		return nil, err
+	}
+	sub["%h"] = c.HostName
 	sub["%p"] = fmt.Sprintf("%d", c.Port)
+	// Not part of ssh(1), which needs "IgnoreUnknown HostNameCommand" to
+	// accept it. It is run before the other options are expanded, so that
+	// their %h is the host name it prints.
+	if cmd := get("HostNameCommand"); cmd != "" && cmd != "none" {
+		c.HostNameCmd = sub.apply(cmd, hostnameCmdTokens)
+		if names != nil {
+			if err := c.resolveHostName(); err != nil {
+				return nil, fmt.Errorf("%v: %w", alias, err)
+			}
+			sub["%h"] = c.HostName
+		}
+	}
 
 	s := get("StrictHostKeyChecking")
 	if s == "no" || s == "off" {
@@ -107,41 +207,140 @@ func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
 			"StrictHostKeyChecking 'accept-new' not supported, using 'yes'")
 	} else if s != "yes" && s != "ask" {
 		return nil, fmt.Errorf(
-			"unsupported StrictHostKeyChecking option '%v'", s)
+			"%w StrictHostKeyChecking option '%v'", Unsupported, s)
+	}
+
+	if strings.EqualFold(get("HostbasedAuthentication"), "yes") {
+		log.Warningf("%v: HostbasedAuthentication not supported, trying other methods", alias)
 	}
 
 	c.Ciphers = split(get("Ciphers"))
 	c.Macs = split(get("MACs"))
+	if err := rejectNone("Ciphers", c.Ciphers); err != nil {
+		return nil, err
+	}
+	if err := rejectNone("MACs", c.Macs); err != nil {
+		return nil, err
+	}
 	c.HostKeyAlgos = split(get("HostKeyAlgorithms"))
-	c.KexAlgos = split(get("KexAlgorithms"))
+	c.PubkeyAlgos = pubkeyAlgos(get)
+	kex, err := kexAlgos(alias, get)
+	if err != nil {
+		return nil, err
+	}
+	c.KexAlgos = kex
+	c.CASigAlgos = split(get("CASignatureAlgorithms"))
+
+	// x/crypto only supports rekeying based on transferred data
+	rk, interval, err := parseRekeyLimit(get("RekeyLimit"))
+	if err != nil {
+		return nil, err
+	}
+	c.RekeyThreshold = rk
+	if interval != 0 {
+		log.Warningf("RekeyLimit: time-based rekeying not supported, ignoring '%v'", interval)
+	}
+
+	if ct := get("ConnectTimeout"); ct != "" {
+		if c.ConnectTimeout, err = strconv.Atoi(ct); err != nil || c.ConnectTimeout < 0 {
+			return nil, fmt.Errorf("%w ConnectTimeout %q", InvalidOption, ct)
+		}
+	}
+
+	ca := get("ConnectionAttempts")
+	if c.ConnAttempts, err = strconv.Atoi(ca); err != nil || c.ConnAttempts < 1 {
+		return nil, fmt.Errorf("%w ConnectionAttempts %q", InvalidOption, ca)
+	}
+
+	am := get("ServerAliveCountMax")
+	if c.AliveCountMax, err = strconv.Atoi(am); err != nil || c.AliveCountMax < 0 {
+		return nil, fmt.Errorf("%w ServerAliveCountMax %q", InvalidOption, am)
+	}
+
+	// Tunnels are non-interactive sessions, so the bulk value applies
+	if q := get("IPQoS"); q != "" {
+		if _, c.TOS, err = parseIPQoS(q); err != nil {
+			return nil, err
+		}
+	}
 
 	// Jump hosts
 	pj := sub.apply(get("ProxyJump"), proxyTokens)
 	sub["%j"] = pj
+	sub.setConnHash()
 	if pj != "" {
 		for _, j := range split(pj) {
 			jump, err := parseProxyJump(j)
 			if err != nil {
-				return nil, fmt.Errorf("could not parse jump host: %v", err)
+				return nil, fmt.Errorf("%w ProxyJump %q: %v", InvalidOption, j, err)
 			}
 			c.Jumps = append(c.Jumps, jump)
 		}
 	}
 
 	c.IdentitiesOnly = get("IdentitiesOnly") == "yes"
-	c.IdentityFiles = sub.applyAll(getAll("IdentityFile"), identFileTokens)
+	c.NoAgent = noAgent || get("UseAgent") == "no"
+	c.RequireAgent = requireAgent
+	ids, origins := identityFiles(alias, user, getAll)
+	c.IdentityFiles, c.identityOrigins = sub.applyAll(ids, identFileTokens), origins
 	c.CertificateFiles = getAll("CertificateFile")
 
-	// Known hosts
-	hosts := getAll("GlobalKnownHostsFile")
-	hosts = append(hosts, sub.applyAll(getAll("UserKnownHostsFile"), identFileTokens)...)
-	for _, h := range hosts {
-		c.KnownHostsFiles = append(c.KnownHostsFiles, strings.Split(h, " ")...)
+	// Known hosts: like ssh(1), user files take precedence over global ones,
+	// and "none" disables the files of the respective scope
+	userHosts := knownHostsFiles(sub.apply(get("UserKnownHostsFile"), identFileTokens))
+	if len(userHosts) > 0 {
+		c.KnownHostsTarget = userHosts[0]
+	}
+	c.KnownHostsFiles = append(userHosts, knownHostsFiles(get("GlobalKnownHostsFile"))...)
+	// Keys of the host are known before connecting, so unlike ssh(1), the
+	// tokens of the offered key (%f, %K, %t) and %I are not available
+	if cmd := get("KnownHostsCommand"); cmd != "" && cmd != "none" {
+		sub["%H"] = knownhosts.Normalize(net.JoinHostPort(c.HostName, sub["%p"]))
+		c.KnownHostsCmd = sub.apply(cmd, knownHostsCmdTokens)
+	}
+	// "ask" needs confirmation, which boring cannot ask for
+	c.UpdateHostKeys = get("UpdateHostKeys") == "yes"
+	c.HashKnownHosts = get("HashKnownHosts") == "yes"
+	c.CheckHostIP = get("CheckHostIP") == "yes"
+	c.LogLevel = strings.ToUpper(get("LogLevel"))
+	c.ForwardAgent = forwardAgent(get("ForwardAgent"))
+	if c.TLS, err = parseTLSWrap(get, sub); err != nil {
+		return nil, err
 	}
 
 	return c, nil
 }
 
+// jumpConfig returns the SSH config of jump host j on the way to sc
+func (sc *SSHConfig) jumpConfig(j *jumpSpec) (*SSHConfig, error) {
+	jc, err := parseSSHConfig(j.host, j.user, sc.HostNames)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse SSH config for %v: %w", j.host, err)
+	}
+
+	// Replace jump user & port if provided inline
+	if j.user != "" {
+		jc.User = j.user
+	}
+	if j.port != 0 {
+		jc.Port = j.port
+	}
+
+	jc.EnsureUser()
+	jc.SecurityProfile = sc.SecurityProfile
+	jc.ClientVersion = sc.ClientVersion
+	jc.dryRun = sc.dryRun
+	return jc, nil
+}
+
+// knownHostsFiles splits a UserKnownHostsFile or GlobalKnownHostsFile value
+func knownHostsFiles(v string) []string {
+	if v == "none" {
+		return nil
+	}
+	return strings.Fields(v)
+}
+
 // ToHops creates an ordered series of Hops from an SSHConfig
 func (sc *SSHConfig) ToHops() ([]Hop, error) {
 	return sc.toHopsImpl(false, 0)
@@ -149,11 +348,20 @@ func (sc *SSHConfig) ToHops() ([]Hop, error) {
 
 func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, error) {
 	if depth > maxJumpRecursions {
-		return nil, fmt.Errorf("maximum jump recursions exceeded")
+		return nil, JumpLoop
 	}
 
+	if sc.HostNameCmd != "" && sc.HostNames == nil && !sc.dryRun {
+		return nil, fmt.Errorf("%v: HostNameCommand was not run, see ResolveSSHConfig", sc.Alias)
+	}
+	if sc.SRVLookup && sc.HostNames == nil && !sc.dryRun {
+		return nil, fmt.Errorf("%v: SRV record was not looked up, see ResolveSSHConfig", sc.Alias)
+	}
 	if err := sc.validate(); err != nil {
-		return nil, fmt.Errorf("%v: %v", sc.Alias, err)
+		return nil, fmt.Errorf("%v: %w", sc.Alias, err)
+	}
+	if err := sc.applyProfile(); err != nil {
+		return nil, fmt.Errorf("%v: %w", sc.Alias, err)
 	}
 
 	if ignoreIntermediate {
@@ -162,26 +370,11 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 
 	var hops []Hop
 	for i, j := range sc.Jumps {
-		jc, err := ParseSSHConfig(j.host, j.user)
+		jc, err := sc.jumpConfig(j)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse SSH config for %v: %v", j.host, err)
-		}
-
-		// Replace jump user & port if provided inline
-		if j.user != "" {
-			jc.User = j.user
-		}
-		if j.port != 0 {
-			jc.Port = j.port
-		}
-
-		// If hostname could not be resolved from ssh config, take it literally
-		if jc.HostName == "" {
-			jc.HostName = j.host
+			return nil, err
 		}
 
-		jc.EnsureUser()
-
 		// Recursively connect to first jump host, ignore jumps for subsequent connections;
 		// this corresponds to ssh(1) behavior
 		hs, err := jc.toHopsImpl(i != 0, depth+1)
@@ -196,32 +389,148 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 		return nil, err
 	}
 	log.Debugf("Trying %d key file(s)", len(sigs))
-	auth := []ssh.AuthMethod{ssh.PublicKeys(sigs...)}
+	authKey := &AuthKey{}
+	auth := []ssh.AuthMethod{ssh.PublicKeys(recordSigners(sigs, authKey)...)}
 
 	keyCallback, keyAlgos, err := sc.makeCallbackAndAlgos()
 	if err != nil {
 		return nil, err
 	}
+	tlsConf, err := sc.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", sc.Alias, err)
+	}
 
 	clientConf := &ssh.ClientConfig{
 		Config: ssh.Config{
-			Ciphers:      sc.Ciphers,
-			KeyExchanges: sc.KexAlgos,
-			MACs:         sc.Macs,
+			Ciphers:        sc.Ciphers,
+			KeyExchanges:   sc.KexAlgos,
+			MACs:           sc.Macs,
+			RekeyThreshold: sc.RekeyThreshold,
 		},
 		User:              sc.User,
+		ClientVersion:     sc.ClientVersion,
 		Auth:              auth,
+		AuthCallback:      sc.authCallback(sigs, authKey),
 		HostKeyAlgorithms: keyAlgos,
 		HostKeyCallback:   keyCallback,
-		Timeout:           sshConnTimeout,
+		Timeout:           sc.connectTimeout(),
+	}
+	var banner *Banner
+	if sc.showBanner() {
+		banner = &Banner{}
+		clientConf.BannerCallback = banner.set
+	}
+
+	hop := Hop{
+		HostName:     sc.HostName,
+		Port:         sc.Port,
+		TOS:          sc.TOS,
+		Attempts:     sc.ConnAttempts,
+		AuthKey:      authKey,
+		Banner:       banner,
+		HostKeys:     sc.hostKeyUpdater(),
+		AgentFwd:     sc.ForwardAgent,
+		Certs:        certsOf(sigs),
+		TLS:          tlsConf,
+		ClientConfig: clientConf,
 	}
-
-	hop := Hop{HostName: sc.HostName, Port: sc.Port, ClientConfig: clientConf}
 	hops = append(hops, hop)
 
 	return hops, nil
 }
 
+// authCallback returns a callback choosing the keys offered in each public
+// key attempt, see preferKeyType and retryAgentAuth.
+func (sc *SSHConfig) authCallback(sigs []ssh.Signer, authKey *AuthKey) ssh.ClientAuthCallback {
+	retry := sc.retryAgentAuth(sigs, authKey)
+	return func(ctx *ssh.ClientAuthContext) (ssh.AuthMethod, error) {
+		if sc.PreferKeyType && len(sigs) > 1 && !slices.Contains(ctx.TriedMethods, "publickey") &&
+			slices.Contains(ctx.AllowedMethods, "publickey") {
+			log.Debugf("%s: offering %s keys first, as used by the server's host key",
+				sc.Alias, keyType(ctx.Algorithms.HostKey))
+			return ssh.PublicKeys(recordSigners(preferKeyType(sigs, ctx.Algorithms.HostKey), authKey)...), nil
+		}
+		return retry(ctx)
+	}
+}
+
+// preferKeyType stably moves the signers whose keys are of the same type as
+// the negotiated host key algorithm to the front. A server typically accepts
+// the type of key it uses itself, so offering these first saves attempts
+// towards its MaxAuthTries.
+func preferKeyType(sigs []ssh.Signer, hostKeyAlgo string) []ssh.Signer {
+	want := keyType(hostKeyAlgo)
+	rank := func(s ssh.Signer) int {
+		k := s.PublicKey()
+		if c, ok := k.(*ssh.Certificate); ok {
+			k = c.Key
+		}
+		if keyType(k.Type()) == want {
+			return 0
+		}
+		return 1
+	}
+	sorted := slices.Clone(sigs)
+	slices.SortStableFunc(sorted, func(a, b ssh.Signer) int {
+		return rank(a) - rank(b)
+	})
+	return sorted
+}
+
+// keyType returns the type of key used by the public key algorithm algo,
+// e.g., ssh-rsa for rsa-sha2-512 and ssh-ed25519 for its certificates.
+func keyType(algo string) string {
+	algo = strings.TrimSuffix(algo, "-cert-v01@openssh.com")
+	if algo == ssh.KeyAlgoRSASHA256 || algo == ssh.KeyAlgoRSASHA512 {
+		return ssh.KeyAlgoRSA
+	}
+	return algo
+}
+
+// retryAgentAuth returns a callback which, after public key authentication
+// failed, queries ssh-agent once more and retries with the keys that were
+// not offered before. This covers agents that are still being populated,
+// e.g., during login.
+func (sc *SSHConfig) retryAgentAuth(offered []ssh.Signer, authKey *AuthKey) ssh.ClientAuthCallback {
+	return func(ctx *ssh.ClientAuthContext) (ssh.AuthMethod, error) {
+		// Retry only right after the first failure of the configured keys
+		n := 0
+		for _, m := range ctx.TriedMethods {
+			if m == "publickey" {
+				n++
+			}
+		}
+		if n != 1 || sc.NoAgent || !slices.Contains(ctx.AllowedMethods, "publickey") {
+			return nil, nil
+		}
+
+		sigs, err := sc.makeSigners()
+		if err != nil {
+			log.Debugf("%s: not retrying authentication: %v", sc.Alias, err)
+			return nil, nil
+		}
+		sigs = slices.DeleteFunc(sigs, func(s ssh.Signer) bool {
+			return slices.ContainsFunc(offered, func(o ssh.Signer) bool {
+				return bytes.Equal(s.PublicKey().Marshal(), o.PublicKey().Marshal())
+			})
+		})
+		if len(sigs) == 0 {
+			return nil, nil
+		}
+		log.Infof("%s: authentication failed, retrying with %d new key(s) from ssh-agent",
+			sc.Alias, len(sigs))
+		return ssh.PublicKeys(recordRetrySigners(sigs, authKey, sc.Alias)...), nil
+	}
+}
+
+func (sc *SSHConfig) connectTimeout() time.Duration {
+	if sc.ConnectTimeout == 0 {
+		return sshConnTimeout
+	}
+	return time.Duration(sc.ConnectTimeout) * time.Second
+}
+
 func (sc *SSHConfig) loadCerts() (certs []*ssh.Certificate) {
 	for _, f := range sc.CertificateFiles {
 		cert, err := loadCert(f)
@@ -240,8 +549,17 @@ type identity struct {
 	path   string // non-empty only for IdentityFiles
 }
 
-func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs []identity) {
-	cfgFP := make(map[string]struct{}, len(sc.IdentityFiles))
+func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs []identity, err error) {
+	cfgFP := make(map[string]struct{}, len(sc.IdentityFiles)+1)
+
+	if sc.IdentityEnv != "" {
+		if s, envErr := loadEnvKey(sc.IdentityEnv); envErr != nil {
+			log.Warningf("key from $%s could not be added: %v", sc.IdentityEnv, envErr)
+		} else {
+			cfgFP[keyFP(s.PublicKey())] = struct{}{}
+			fileIDs = append(fileIDs, identity{signer: s})
+		}
+	}
 
 	for _, f := range sc.IdentityFiles {
 		s, fp, ok := loadIdentity(f)
@@ -255,8 +573,14 @@ func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherID
 		}
 	}
 
-	if agSigs, err := agent.GetSigners(); err != nil {
-		log.Warningf("Unable to get keys from ssh-agent: %v", err)
+	if sc.NoAgent {
+		log.Debugf("%s: not using ssh-agent, disabled by UseAgent or BORING_NO_AGENT", sc.Alias)
+	} else if agSigs, agErr := agent.GetSigners(); agErr != nil {
+		if sc.RequireAgent {
+			err = fmt.Errorf("ssh-agent required by BORING_REQUIRE_AGENT, but unavailable: %v", agErr)
+			return
+		}
+		log.Warningf("Unable to get keys from ssh-agent: %v", agErr)
 	} else {
 		for _, s := range agSigs {
 			// Agent may return certificate identities (public key is a cert)
@@ -297,7 +621,10 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 	// + agent certificate identities (already certified signers)
 
 	// Load ID groups
-	fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs := sc.loadIDs()
+	fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs, err := sc.loadIDs()
+	if err != nil {
+		return nil, err
+	}
 
 	var sigs []ssh.Signer
 	idsForCert := append([]identity{}, agentCfgIDs...)
@@ -305,6 +632,11 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 	idsForCert = append(idsForCert, fileIDs...)
 
 	bind := func(c *ssh.Certificate) {
+		if !sc.caSigAllowed(c) {
+			log.Debugf("%s: skipping certificate signed with %s, not in CASignatureAlgorithms",
+				sc.Alias, c.Signature.Format)
+			return
+		}
 		for _, id := range idsForCert {
 			if certSig, err := certify(c, id.signer); err == nil {
 				sigs = append(sigs, certSig)
@@ -331,6 +663,11 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 
 	// Try already-certified agent identities (certificate signers)
 	for _, id := range agentCertIDs {
+		if c := id.signer.PublicKey().(*ssh.Certificate); !sc.caSigAllowed(c) {
+			log.Debugf("%s: skipping agent certificate signed with %s, not in CASignatureAlgorithms",
+				sc.Alias, c.Signature.Format)
+			continue
+		}
 		sigs = append(sigs, id.signer)
 	}
 
@@ -346,10 +683,13 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 	}
 
 	if len(sigs) == 0 {
-		return nil, fmt.Errorf("%s: no key files found", sc.Alias)
+		return nil, fmt.Errorf("%s: %w", sc.Alias, NoKeys)
 	}
 
 	sigs = dedupeSigners(sigs)
+	if sigs = sc.acceptedSigners(sigs); len(sigs) == 0 {
+		return nil, fmt.Errorf("%s: %w: the keys found are not of a type in PubkeyAcceptedAlgorithms", sc.Alias, NoKeys)
+	}
 
 	for _, sig := range sigs {
 		log.Debugf("%s: will try key %s", sc.Alias, sig)
@@ -360,27 +700,31 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 
 func (sc *SSHConfig) makeCallbackAndAlgos() (cb ssh.HostKeyCallback, algs []string, err error) {
 	if sc.KeyCheck == strict {
-		var hosts []string
-		for _, k := range sc.KnownHostsFiles {
-			k = paths.ReplaceTilde(k)
-			if _, err := os.Stat(k); err != nil {
-				log.Debugf("could not open known hosts file %v: %v", k, err)
-				continue
-			}
-			hosts = append(hosts, k)
+		command := sc.KnownHostsCmd
+		if sc.dryRun {
+			command = ""
 		}
-		if cb, err = knownhosts.New(hosts...); err != nil {
-			return nil, nil, fmt.Errorf("knownhosts: %v", err)
+		if cb, err = knownHostsCallback(sc.KnownHostsFiles, command); err != nil {
+			return nil, nil, err
 		}
+		cb = zonelessCallback(cb)
 		known := extractHostKeyAlgos(cb, net.JoinHostPort(sc.HostName, strconv.Itoa(sc.Port)))
 		algs = filter(sc.HostKeyAlgos, known)
+		// The keys may be printed by the command that was not run
+		if len(algs) == 0 && command != sc.KnownHostsCmd {
+			algs = sc.HostKeyAlgos
+		}
 		if len(algs) == 0 {
-			return nil, nil, fmt.Errorf("%v: could not determine host key algorithms: default are %v, "+
+			return nil, nil, fmt.Errorf("%v: %w: default are %v, "+
 				"available in known_hosts are %v. %v%vNote that boring does not automatically add keys to "+
-				"your known_hosts.%v", sc.Alias, sc.HostKeyAlgos, known, log.Bold, log.Red, log.Reset)
+				"your known_hosts.%v", sc.Alias, NoHostKeyAlgos, sc.HostKeyAlgos, known, log.Bold, log.Red, log.Reset)
 		}
 		log.Debugf("%v: key types in known_hosts: %v, configured: %v, trying: %v",
 			sc.Alias, known, sc.HostKeyAlgos, algs)
+		cb = hostCertClock(sc.checkHostCASig(cb))
+		if sc.CheckHostIP {
+			cb = checkHostIP(cb)
+		}
 	} else if sc.KeyCheck == off {
 		cb = ssh.InsecureIgnoreHostKey()
 		algs = sc.HostKeyAlgos
@@ -388,6 +732,36 @@ func (sc *SSHConfig) makeCallbackAndAlgos() (cb ssh.HostKeyCallback, algs []stri
 	return
 }
 
+// knownHostsCallback checks host keys against the given known_hosts files,
+// skipping those that do not exist, and against the output of command, if
+// given, see KnownHostsCommand in ssh_config(5)
+func knownHostsCallback(files []string, command string) (ssh.HostKeyCallback, error) {
+	var hosts []string
+	for _, k := range files {
+		k = paths.ReplaceTilde(k)
+		if _, err := os.Stat(k); err != nil {
+			log.Debugf("could not open known hosts file %v: %v", k, err)
+			continue
+		}
+		hosts = append(hosts, k)
+	}
+	if command != "" {
+		f, err := runKnownHostsCommand(command)
+		if err != nil {
+			// Like ssh(1), fall back to the files
+			log.Warningf("KnownHostsCommand failed: %v", err)
+		} else {
+			defer os.Remove(f)
+			hosts = append(hosts, f)
+		}
+	}
+	cb, err := knownhosts.New(hosts...)
+	if err != nil {
+		return nil, fmt.Errorf("knownhosts: %v", err)
+	}
+	return cb, nil
+}
+
 func (sc *SSHConfig) validate() error {
 	if sc.HostName == "" {
 		return fmt.Errorf("no host specified")
@@ -451,6 +825,39 @@ func loadPrivateKey(path string) (ssh.Signer, error) {
 	return signer, nil
 }
 
+// loadEnvKey parses the private key held by the environment variable
+// name, either PEM-encoded or as base64 of the PEM. If the key is
+// encrypted, the passphrase is read from name + "_PASSPHRASE".
+func loadEnvKey(name string) (ssh.Signer, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, fmt.Errorf("not set")
+	}
+	log.AddSecret(v)
+	key := []byte(v)
+	if !strings.Contains(v, "-----BEGIN") {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v), "")); err != nil {
+			return nil, fmt.Errorf("neither PEM nor base64: %v", err)
+		}
+	}
+	var signer ssh.Signer
+	var err error
+	if pass := os.Getenv(name + "_PASSPHRASE"); pass != "" {
+		log.AddSecret(pass)
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(pass))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("key is encrypted, but $%s_PASSPHRASE is not set", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("could not parse key: %v", err)
+	}
+	return signer, nil
+}
+
 func loadPublicKey(path string) (ssh.PublicKey, error) {
 	if path == "" {
 		return nil, fmt.Errorf("no key specified")
@@ -496,6 +903,27 @@ func certify(cert *ssh.Certificate, sig ssh.Signer) (ssh.Signer, error) {
 	return certSig, nil
 }
 
+// caSigAllowed reports whether the CA signature of a certificate uses one
+// of the algorithms allowed by CASignatureAlgorithms
+func (sc *SSHConfig) caSigAllowed(c *ssh.Certificate) bool {
+	if len(sc.CASigAlgos) == 0 || c.Signature == nil {
+		return true
+	}
+	return slices.Contains(sc.CASigAlgos, c.Signature.Format)
+}
+
+// checkHostCASig wraps a host key callback, rejecting host certificates
+// whose CA signature algorithm is not allowed by CASignatureAlgorithms
+func (sc *SSHConfig) checkHostCASig(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		if c, ok := key.(*ssh.Certificate); ok && !sc.caSigAllowed(c) {
+			return fmt.Errorf("%w: certificate signed with %s, not in CASignatureAlgorithms",
+				HostKeyRejected, c.Signature.Format)
+		}
+		return cb(host, remote, key)
+	}
+}
+
 func dedupeSigners(sigs []ssh.Signer) []ssh.Signer {
 	seen := make(map[string]struct{}, len(sigs))
 	out := make([]ssh.Signer, 0, len(sigs))
@@ -510,6 +938,17 @@ func dedupeSigners(sigs []ssh.Signer) []ssh.Signer {
 	return out
 }
 
+// rejectNone fails for the null cipher or MAC "none", which is meant for
+// debugging but not implemented by x/crypto, so that the handshake would
+// fail with a confusing error later on
+func rejectNone(key string, algos []string) error {
+	if slices.Contains(algos, "none") {
+		return fmt.Errorf("%w %s 'none', Go's SSH implementation does not"+
+			" implement it, remove it from the SSH config to connect", Unsupported, key)
+	}
+	return nil
+}
+
 func split(s string) []string {
 	return strings.Split(s, ",")
 }
@@ -1,13 +1,24 @@
 package ssh_config
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alebeck/boring/internal/log"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -52,6 +63,118 @@ func TestLoadIdentityPrivateKey(t *testing.T) {
 	}
 }
 
+func TestLoadEnvKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := ssh.NewSignerFromKey(priv)
+
+	check := func(name string) {
+		t.Helper()
+		s, err := loadEnvKey("BORING_TEST_KEY")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if keyFP(s.PublicKey()) != keyFP(want.PublicKey()) {
+			t.Fatalf("%s: loaded wrong key", name)
+		}
+	}
+
+	t.Setenv("BORING_TEST_KEY", string(pem.EncodeToMemory(plain)))
+	check("PEM")
+	t.Setenv("BORING_TEST_KEY", base64.StdEncoding.EncodeToString(pem.EncodeToMemory(plain)))
+	check("base64")
+
+	t.Setenv("BORING_TEST_KEY", string(pem.EncodeToMemory(enc)))
+	if _, err := loadEnvKey("BORING_TEST_KEY"); err == nil ||
+		!strings.Contains(err.Error(), "BORING_TEST_KEY_PASSPHRASE") {
+		t.Fatalf("expected missing passphrase error, got %v", err)
+	}
+	t.Setenv("BORING_TEST_KEY_PASSPHRASE", "secret")
+	check("encrypted")
+
+	t.Setenv("BORING_TEST_KEY", "")
+	if _, err := loadEnvKey("BORING_TEST_KEY"); err == nil {
+		t.Fatal("expected error for unset variable")
+	}
+}
+
+// The passphrase of a key from the environment must not end up in logs,
+// e.g. in the message of a failing command that references it
+func TestLoadEnvKeyRedacted(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pass = "planted-passphrase"
+	enc, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BORING_TEST_KEY", string(pem.EncodeToMemory(enc)))
+	t.Setenv("BORING_TEST_KEY_PASSPHRASE", pass)
+
+	var buf bytes.Buffer
+	log.Init(&buf, true, false)
+	t.Cleanup(func() { log.Init(io.Discard, false, false) })
+	if _, err := loadEnvKey("BORING_TEST_KEY"); err != nil {
+		t.Fatal(err)
+	}
+	log.Errorf("HostNameCommand %q failed", "lookup --key "+pass)
+	if strings.Contains(buf.String(), pass) || !strings.Contains(buf.String(), log.Redacted) {
+		t.Errorf("passphrase not redacted: %s", buf.String())
+	}
+}
+
+func TestPreferKeyType(t *testing.T) {
+	var sigs []ssh.Signer
+	for _, rsaKey := range []bool{true, false, true, false} {
+		var key any
+		var err error
+		if rsaKey {
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		} else {
+			_, key, err = ed25519.GenerateKey(rand.Reader)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs = append(sigs, s)
+	}
+
+	tests := []struct {
+		hostKeyAlgo string
+		want        []int
+	}{
+		{ssh.KeyAlgoED25519, []int{1, 3, 0, 2}},
+		{ssh.KeyAlgoRSASHA512, []int{0, 2, 1, 3}},
+		{ssh.CertAlgoED25519v01, []int{1, 3, 0, 2}},
+		{ssh.KeyAlgoECDSA256, []int{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		got := preferKeyType(sigs, tt.hostKeyAlgo)
+		for i, j := range tt.want {
+			if got[i] != sigs[j] {
+				t.Fatalf("%s: position %d: got %s key, want key %d",
+					tt.hostKeyAlgo, i, got[i].PublicKey().Type(), j)
+			}
+		}
+	}
+}
+
 // OpenSSH allows `IdentityFile foo.pub` when the private key is held by the agent
 // or a hardware token etc. loadIdentity must succeed and return the fingerprint
 // so that the agent's matching key isn't filtered out under IdentitiesOnly.
@@ -133,9 +256,7 @@ func TestParseSSHConfigDefaultPort(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	old := overrideConfig
-	overrideConfig = cfg
-	t.Cleanup(func() { overrideConfig = old })
+	t.Setenv("BORING_SSH_CONFIG", cfg)
 
 	sc, err := ParseSSHConfig("myhost", "bob")
 	if err != nil {
@@ -145,3 +266,762 @@ func TestParseSSHConfigDefaultPort(t *testing.T) {
 		t.Errorf("Port = %d, want 22", sc.Port)
 	}
 }
+
+// Like ssh(1), keywords are case-insensitive, may be separated from their
+// values by "=", and values may be quoted to contain spaces.
+func TestParseSSHConfigKeywordForms(t *testing.T) {
+	useSSHConfig(t, `HOST myhost
+	hostNAME=example.com
+	Port=2222
+	IdentityFile="~/my key"
+	IDENTITYFILE "/tmp/other key"
+	user = bob
+	ciphers=aes128-ctr,aes256-ctr
+`)
+
+	sc, err := ParseSSHConfig("myhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.HostName != "example.com" || sc.User != "bob" || sc.Port != 2222 {
+		t.Errorf("got HostName %q, User %q, Port %d", sc.HostName, sc.User, sc.Port)
+	}
+	if want := []string{"~/my key", "/tmp/other key"}; !slices.Equal(sc.IdentityFiles, want) {
+		t.Errorf("IdentityFiles = %q, want %q", sc.IdentityFiles, want)
+	}
+	if want := []string{"aes128-ctr", "aes256-ctr"}; !slices.Equal(sc.Ciphers, want) {
+		t.Errorf("Ciphers = %q, want %q", sc.Ciphers, want)
+	}
+
+	// Resolve reports the same values
+	opts, err := Resolve("myhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range opts {
+		if o.Origin != nil {
+			got = append(got, o.Key+"="+o.Value)
+		}
+	}
+	want := []string{"HostName=example.com", "User=bob", "Port=2222", "IdentityFile=~/my key",
+		"IdentityFile=/tmp/other key", "Ciphers=aes128-ctr,aes256-ctr"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Resolve = %q, want %q", got, want)
+	}
+}
+
+// useSSHConfig writes content to a temporary SSH config file and makes
+// ParseSSHConfig use it for the duration of the test.
+func useSSHConfig(t *testing.T, content string) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(cfg, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BORING_SSH_CONFIG", cfg)
+}
+
+// Host lines may hold multiple patterns, where a matching negated pattern
+// excludes the stanza regardless of the other patterns. Stanzas that are
+// skipped this way must not prevent later ones from applying.
+func TestParseSSHConfigHostPatterns(t *testing.T) {
+	useSSHConfig(t, `Host * !prod-*
+	User dev
+Host prod-* staging
+	User ops
+	Port 2222
+Host *.example.com !secret.example.com
+	HostName bastion.example.com
+`)
+
+	cases := []struct {
+		alias, user, hostName string
+		port                  int
+	}{
+		{"box", "dev", "box", 22},
+		{"prod-db", "ops", "prod-db", 2222},
+		{"staging", "dev", "staging", 2222},
+		{"web.example.com", "dev", "bastion.example.com", 22},
+		{"secret.example.com", "dev", "secret.example.com", 22},
+		// Aliases are matched case-insensitively, like in ssh(1)
+		{"PROD-DB", "ops", "prod-db", 2222},
+	}
+	for _, c := range cases {
+		sc, err := ParseSSHConfig(c.alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.User != c.user || sc.HostName != c.hostName || sc.Port != c.port {
+			t.Errorf("%s: got (%q, %q, %d), want (%q, %q, %d)", c.alias,
+				sc.User, sc.HostName, sc.Port, c.user, c.hostName, c.port)
+		}
+	}
+}
+
+// The null cipher and MAC fail early with a clear error
+func TestParseSSHConfigNone(t *testing.T) {
+	useSSHConfig(t, `Host nocipher
+	Ciphers none
+Host nomac
+	MACs hmac-sha2-256,none
+`)
+
+	for alias, key := range map[string]string{"nocipher": "Ciphers", "nomac": "MACs"} {
+		_, err := ParseSSHConfig(alias, "")
+		if !errors.Is(err, Unsupported) || !strings.Contains(err.Error(), key+" 'none'") {
+			t.Errorf("%s: expected error about %s none, got %v", alias, key, err)
+		}
+	}
+	if _, err := ParseSSHConfig("other", ""); err != nil {
+		t.Errorf("other: %v", err)
+	}
+}
+
+func TestParseSSHConfigKnownHostsCommand(t *testing.T) {
+	useSSHConfig(t, `Host cmd
+	HostName example.com
+	Port 2222
+	KnownHostsCommand /usr/bin/hostkeys %H %h %n
+Host nocmd
+	KnownHostsCommand none
+`)
+
+	sc, err := ParseSSHConfig("cmd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/usr/bin/hostkeys [example.com]:2222 example.com cmd"; sc.KnownHostsCmd != want {
+		t.Errorf("expected %q, got %q", want, sc.KnownHostsCmd)
+	}
+	if sc, err = ParseSSHConfig("nocmd", ""); err != nil || sc.KnownHostsCmd != "" {
+		t.Errorf("expected no command, got %q (%v)", sc.KnownHostsCmd, err)
+	}
+}
+
+// HostNameCommand is run when resolving the config to connect, once per
+// cache of host names
+func TestHostNameCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	runs := filepath.Join(t.TempDir(), "runs")
+	keys := t.TempDir()
+	useSSHConfig(t, `Host discovered
+	HostNameCommand echo x >> `+runs+`; echo " 127.0.0.%p "
+	Port 1
+Host empty
+	HostNameCommand true
+Host failing
+	HostNameCommand echo unknown service >&2; false
+Host tokens
+	HostNameCommand echo 127.0.0.2
+	IdentityFile `+filepath.Join(keys, "id_%h")+`
+	KnownHostsCommand echo %h %H
+Host *
+	User test
+	StrictHostKeyChecking no
+	IdentityFile `+priv+`
+`)
+
+	sc, err := ParseSSHConfig("discovered", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo x >> " + runs + `; echo " 127.0.0.1 "`; sc.HostNameCmd != want {
+		t.Errorf("expected %q, got %q", want, sc.HostNameCmd)
+	}
+	if sc.HostName != "discovered" {
+		t.Errorf("expected the command not to run when parsing, got host name %q", sc.HostName)
+	}
+	if _, err := sc.ToHops(); err == nil {
+		t.Error("expected error connecting without running the command")
+	}
+	names := &HostNames{}
+	for range 2 {
+		sc, err := ResolveSSHConfig("discovered", "", names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hops, err := sc.ToHops()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hops[0].HostName != "127.0.0.1" {
+			t.Errorf("expected host name 127.0.0.1, got %q", hops[0].HostName)
+		}
+	}
+	if b, _ := os.ReadFile(runs); string(b) != "x\n" {
+		t.Errorf("expected a single run, got %q", b)
+	}
+
+	// Other options see the printed host name as %h
+	if sc, err = ResolveSSHConfig("tokens", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(keys, "id_127.0.0.2"); sc.IdentityFiles[0] != want {
+		t.Errorf("expected identity file %q, got %q", want, sc.IdentityFiles[0])
+	}
+	if want := "echo 127.0.0.2 127.0.0.2"; sc.KnownHostsCmd != want {
+		t.Errorf("expected known hosts command %q, got %q", want, sc.KnownHostsCmd)
+	}
+
+	for alias, want := range map[string]string{"empty": "printed no host name", "failing": "unknown service"} {
+		if _, err := ResolveSSHConfig(alias, "", nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", alias, want, err)
+		}
+	}
+}
+
+func TestParseSSHConfigForwardAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "/run/agent.sock")
+	t.Setenv("OTHER_SOCK", "/run/other.sock")
+	useSSHConfig(t, `Host yes
+	ForwardAgent yes
+Host env
+	ForwardAgent $OTHER_SOCK
+Host path
+	ForwardAgent /run/path.sock
+`)
+
+	for alias, want := range map[string]string{
+		"yes": "/run/agent.sock", "env": "/run/other.sock", "path": "/run/path.sock", "no": "",
+	} {
+		sc, err := ParseSSHConfig(alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.ForwardAgent != want {
+			t.Errorf("%s: expected %q, got %q", alias, want, sc.ForwardAgent)
+		}
+	}
+}
+
+// Failures wrap sentinel errors, so that callers can tell them apart
+func TestParseSSHConfigErrors(t *testing.T) {
+	useSSHConfig(t, `Host badattempts
+	ConnectionAttempts 0
+Host badcheck
+	StrictHostKeyChecking sometimes
+Host loop
+	HostName 127.0.0.1
+	User test
+	Port 22
+	ProxyJump loop
+`)
+
+	for alias, want := range map[string]error{"badattempts": InvalidOption, "badcheck": Unsupported} {
+		if _, err := ParseSSHConfig(alias, ""); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", alias, want, err)
+		}
+	}
+
+	sc, err := ParseSSHConfig("loop", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.ToHops(); !errors.Is(err, JumpLoop) {
+		t.Errorf("loop: expected %v, got %v", JumpLoop, err)
+	}
+}
+
+// TLS options are validated when parsing, and the server name defaults
+// to the host name
+func TestParseSSHConfigTLS(t *testing.T) {
+	useSSHConfig(t, `Host wrapped
+	HostName ssh.example.com
+	TLS yes
+	TLSPinnedPubKey sha256//`+base64.StdEncoding.EncodeToString(make([]byte, 32))+`
+Host named
+	TLS yes
+	TLSServerName front.example.com
+Host badtls
+	TLS maybe
+Host badpin
+	TLS yes
+	TLSPinnedPubKey md5//AAAA
+Host keyonly
+	TLS yes
+	TLSKeyFile /tmp/key.pem
+`)
+
+	for _, alias := range []string{"badtls", "badpin", "keyonly"} {
+		if _, err := ParseSSHConfig(alias, ""); !errors.Is(err, InvalidOption) {
+			t.Errorf("%s: expected %v, got %v", alias, InvalidOption, err)
+		}
+	}
+
+	for alias, want := range map[string]string{"wrapped": "ssh.example.com", "named": "front.example.com"} {
+		sc, err := ParseSSHConfig(alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := sc.tlsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c == nil || c.ServerName != want {
+			t.Errorf("%s: expected server name %s, got %+v", alias, want, c)
+		}
+	}
+	sc, err := ParseSSHConfig("wrapped", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := sc.tlsConfig(); !c.InsecureSkipVerify || c.VerifyConnection == nil {
+		t.Error("expected the pin to replace verifying the chain")
+	}
+	if sc, _ := ParseSSHConfig("plain", ""); sc.TLS != nil {
+		t.Error("expected no TLS by default")
+	}
+}
+
+// Banners are recorded unless the LogLevel hides them, like in ssh(1)
+func TestToHopsBanner(t *testing.T) {
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	useSSHConfig(t, `Host quiet
+	LogLevel quiet
+Host *
+	HostName 127.0.0.1
+	User test
+	StrictHostKeyChecking no
+	IdentityFile `+priv+`
+`)
+
+	for alias, shown := range map[string]bool{"verbose": true, "quiet": false} {
+		sc, err := ParseSSHConfig(alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hops, err := sc.ToHops()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hops[0].Banner != nil && hops[0].BannerCallback != nil; got != shown {
+			t.Errorf("%s: banner shown %v, want %v", alias, got, shown)
+		}
+	}
+}
+
+// offeredKeys returns the keys a client with config cc offers for
+// authentication, by handshaking with a server that rejects them all
+func offeredKeys(t *testing.T, cc *ssh.ClientConfig) (keys []string) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if k := string(key.Marshal()); !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+			return nil, errors.New("rejected")
+		},
+	}
+	srv.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if c, err := l.Accept(); err == nil {
+			ssh.NewServerConn(c, srv)
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := ssh.NewClientConn(c, l.Addr().String(), cc); err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+	c.Close()
+	<-done
+	return
+}
+
+// Each hop authenticates with what its own stanza configures, e.g., a
+// certificate for the bastion and a plain key for the target, also when
+// the jump user is given inline
+func TestToHopsPerHopAuth(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	targetKey, _ := writeKeyPair(t, dir, "id_target")
+	bastionKey, bastionPub := writeKeyPair(t, dir, "id_jumper")
+
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(bastionPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"jumper"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "jumper-cert.pub")
+	if err := os.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	useSSHConfig(t, `Host target
+	IdentityFile `+targetKey+`
+	IdentitiesOnly yes
+	ProxyJump jumper@bastion:2222
+Host bastion
+	IdentityFile `+filepath.Join(dir, "id_%r")+`
+	CertificateFile `+certPath+`
+	IdentitiesOnly yes
+Host *
+	HostName 127.0.0.1
+	User test
+	StrictHostKeyChecking no
+`)
+
+	sc, err := ParseSSHConfig("target", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hops, err := sc.ToHops()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 {
+		t.Fatalf("expected 2 hops, got %d", len(hops))
+	}
+	if hops[0].User != "jumper" || hops[0].Port != 2222 {
+		t.Errorf("bastion: expected jumper on port 2222, got %s on %d", hops[0].User, hops[0].Port)
+	}
+	if len(hops[0].Certs) != 1 || len(hops[1].Certs) != 0 {
+		t.Errorf("expected a certificate for the bastion only, got %d and %d",
+			len(hops[0].Certs), len(hops[1].Certs))
+	}
+
+	readPub := func(path string) string {
+		b, err := os.ReadFile(path + ".pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, _, _, _, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(k.Marshal())
+	}
+	want := []string{string(cert.Marshal()), readPub(bastionKey)}
+	if got := offeredKeys(t, hops[0].ClientConfig); !slices.Equal(got, want) {
+		t.Errorf("bastion offered %d keys, want its certificate and key", len(got))
+	}
+	want = []string{readPub(targetKey)}
+	if got := offeredKeys(t, hops[1].ClientConfig); !slices.Equal(got, want) {
+		t.Errorf("target offered %d keys, want its key only", len(got))
+	}
+}
+
+// Stanzas that only route through a jump host take the alias as host name
+func TestParseSSHConfigRoutingStanza(t *testing.T) {
+	useSSHConfig(t, `Host jumpy
+	ProxyJump bob@bastion:2222
+`)
+
+	sc, err := ParseSSHConfig("Jumpy", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.HostName != "jumpy" {
+		t.Errorf("expected host name 'jumpy', got %q", sc.HostName)
+	}
+	if len(sc.Jumps) != 1 || sc.Jumps[0].host != "bastion" {
+		t.Errorf("expected jump via bastion, got %+v", sc.Jumps)
+	}
+	sc.EnsureUser()
+	if err := sc.validate(); err != nil {
+		t.Errorf("routing stanza rejected: %v", err)
+	}
+}
+
+func makeCert(t *testing.T, certType uint32) *ssh.Certificate {
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:         sshPub,
+		CertType:    certType,
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCASigAllowed(t *testing.T) {
+	cert := makeCert(t, ssh.UserCert)
+
+	sc := &SSHConfig{}
+	if !sc.caSigAllowed(cert) {
+		t.Error("expected certificate to be allowed without restrictions")
+	}
+	sc.CASigAlgos = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512}
+	if !sc.caSigAllowed(cert) {
+		t.Error("expected ed25519-signed certificate to be allowed")
+	}
+	sc.CASigAlgos = []string{ssh.KeyAlgoRSASHA512}
+	if sc.caSigAllowed(cert) {
+		t.Error("expected ed25519-signed certificate to be rejected")
+	}
+}
+
+func TestCheckHostCASig(t *testing.T) {
+	cert := makeCert(t, ssh.HostCert)
+	accept := func(string, net.Addr, ssh.PublicKey) error { return nil }
+
+	sc := &SSHConfig{CASigAlgos: []string{ssh.KeyAlgoRSASHA512}}
+	err := sc.checkHostCASig(accept)("host", nil, cert)
+	if err == nil || !strings.Contains(err.Error(), "CASignatureAlgorithms") {
+		t.Errorf("expected host certificate to be rejected, got %v", err)
+	}
+	// Plain host keys are not affected
+	if err := sc.checkHostCASig(accept)("host", nil, cert.Key); err != nil {
+		t.Errorf("expected plain host key to be accepted, got %v", err)
+	}
+}
+
+func TestParseSSHConfigUseAgent(t *testing.T) {
+	useSSHConfig(t, `Host noagent
+	UseAgent no
+Host *
+	User dev
+`)
+
+	c, err := ParseSSHConfig("noagent", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.NoAgent {
+		t.Error("expected agent to be disabled by UseAgent")
+	}
+
+	c, err = ParseSSHConfig("other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.NoAgent {
+		t.Error("expected agent to be enabled by default")
+	}
+
+	old := noAgent
+	noAgent = true
+	t.Cleanup(func() { noAgent = old })
+	if c, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !c.NoAgent {
+		t.Error("expected agent to be disabled by BORING_NO_AGENT")
+	}
+}
+
+// With the agent disabled, loadIDs must not touch SSH_AUTH_SOCK at all
+func TestLoadIDsNoAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "missing.sock"))
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+
+	sc := &SSHConfig{Alias: "test", NoAgent: true, IdentityFiles: []string{priv}}
+	fileIDs, certIDs, cfgIDs, otherIDs, err := sc.loadIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileIDs) != 1 || len(certIDs)+len(cfgIDs)+len(otherIDs) != 0 {
+		t.Fatalf("unexpected identities: %d file, %d cert, %d cfg, %d other",
+			len(fileIDs), len(certIDs), len(cfgIDs), len(otherIDs))
+	}
+}
+
+// An unreachable agent is a hard error if it is required
+func TestLoadIDsRequireAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "missing.sock"))
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+
+	sc := &SSHConfig{Alias: "test", IdentityFiles: []string{priv}}
+	if _, _, _, _, err := sc.loadIDs(); err != nil {
+		t.Fatalf("expected fallback to key files, got %v", err)
+	}
+
+	sc.RequireAgent = true
+	_, _, _, _, err := sc.loadIDs()
+	if err == nil || !strings.Contains(err.Error(), "BORING_REQUIRE_AGENT") {
+		t.Fatalf("expected agent error, got %v", err)
+	}
+}
+
+// Tunnels are non-interactive, so the bulk value of IPQoS must be used
+func TestParseSSHConfigIPQoS(t *testing.T) {
+	useSSHConfig(t, `Host qos
+	IPQoS af21 cs1
+Host bad
+	IPQoS nonsense
+`)
+
+	c, err := ParseSSHConfig("qos", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TOS != 0x20 {
+		t.Errorf("got ToS %#x, want %#x", c.TOS, 0x20)
+	}
+
+	if _, err = ParseSSHConfig("bad", ""); err == nil {
+		t.Error("expected error for invalid IPQoS")
+	}
+	if c, err = ParseSSHConfig("other", ""); err != nil || c.TOS != 0 {
+		t.Errorf("expected ToS to be unset, got %#x, %v", c.TOS, err)
+	}
+}
+
+// User known hosts files come first in their given order, followed by the
+// global ones. "none" drops the files of its scope.
+func TestParseSSHConfigKnownHosts(t *testing.T) {
+	useSSHConfig(t, `Host both
+	UserKnownHostsFile /u1  /u2
+	GlobalKnownHostsFile /g1
+Host nouser
+	UserKnownHostsFile none
+	GlobalKnownHostsFile /g1 /g2
+Host nothing
+	UserKnownHostsFile none
+	GlobalKnownHostsFile none
+Host *
+	UserKnownHostsFile /ignored
+`)
+
+	cases := []struct {
+		alias  string
+		files  []string
+		target string
+	}{
+		{"both", []string{"/u1", "/u2", "/g1"}, "/u1"},
+		{"nouser", []string{"/g1", "/g2"}, ""},
+		{"nothing", nil, ""},
+	}
+	for _, c := range cases {
+		sc, err := ParseSSHConfig(c.alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(sc.KnownHostsFiles, c.files) {
+			t.Errorf("%s: got files %v, want %v", c.alias, sc.KnownHostsFiles, c.files)
+		}
+		if sc.KnownHostsTarget != c.target {
+			t.Errorf("%s: got target %q, want %q", c.alias, sc.KnownHostsTarget, c.target)
+		}
+	}
+}
+
+func TestParseSSHConfigConnectTimeout(t *testing.T) {
+	useSSHConfig(t, `Host quick
+	ConnectTimeout 3
+`)
+
+	c, err := ParseSSHConfig("quick", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.connectTimeout(); got != 3*time.Second {
+		t.Errorf("got timeout %v, want 3s", got)
+	}
+
+	if c, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.connectTimeout(); got != sshConnTimeout {
+		t.Errorf("got timeout %v, want default %v", got, sshConnTimeout)
+	}
+}
+
+func TestParseSSHConfigConnectionAttempts(t *testing.T) {
+	useSSHConfig(t, `Host flaky
+	ConnectionAttempts 3
+Host broken
+	ConnectionAttempts 0
+`)
+
+	c, err := ParseSSHConfig("flaky", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ConnAttempts != 3 {
+		t.Errorf("got %d attempts, want 3", c.ConnAttempts)
+	}
+
+	if c, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.ConnAttempts != 1 {
+		t.Errorf("got %d attempts, want default 1", c.ConnAttempts)
+	}
+
+	if _, err = ParseSSHConfig("broken", ""); err == nil {
+		t.Error("expected error for ConnectionAttempts 0")
+	}
+}
+
+func TestParseSSHConfigServerAliveCountMax(t *testing.T) {
+	useSSHConfig(t, `Host nat
+	ServerAliveCountMax 0
+`)
+
+	c, err := ParseSSHConfig("nat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AliveCountMax != 0 {
+		t.Errorf("got %d, want 0", c.AliveCountMax)
+	}
+
+	if c, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.AliveCountMax != 3 {
+		t.Errorf("got %d, want default 3", c.AliveCountMax)
+	}
+}
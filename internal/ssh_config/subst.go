@@ -1,8 +1,11 @@
 package ssh_config
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"os"
 	"os/user"
+	"slices"
 	"strings"
 )
 
@@ -21,22 +24,39 @@ func makeSubst(alias string) subst {
 		s["%i"] = u.Uid
 	}
 	if h, err := os.Hostname(); err == nil {
-		s["%L"] = h
-		// TODO: %l (FQDN)
+		s["%l"] = h
+		s["%L"], _, _ = strings.Cut(h, ".")
 	}
 	return s
 }
 
+// setConnHash sets %C to the SHA1 hash of %l%h%p%r%j, like ssh(1) does.
+// It must be called once all of these are known.
+func (s subst) setConnHash() {
+	h := sha1.Sum([]byte(s["%l"] + s["%h"] + s["%p"] + s["%r"] + s["%j"]))
+	s["%C"] = hex.EncodeToString(h[:])
+}
+
+// apply replaces the tokens among keys in str in a single pass, so that
+// replaced text is not expanded again, e.g., "%%h" yields "%h". Other
+// tokens are kept, like the zone of link-local IPv6 addresses.
 func (s subst) apply(str string, keys []string) string {
 	if !strings.Contains(str, "%") {
 		return str
 	}
-	for _, k := range keys {
-		if r, ok := s[k]; ok {
-			str = strings.ReplaceAll(str, k, r)
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] == '%' && i+1 < len(str) {
+			k := str[i : i+2]
+			if r, ok := s[k]; ok && slices.Contains(keys, k) {
+				b.WriteString(r)
+				i++
+				continue
+			}
 		}
+		b.WriteByte(str[i])
 	}
-	return str
+	return b.String()
 }
 
 func (s subst) applyAll(strs []string, keys []string) []string {
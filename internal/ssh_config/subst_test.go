@@ -0,0 +1,55 @@
+package ssh_config
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+func TestConnHash(t *testing.T) {
+	useSSHConfig(t, `Host hashed
+	HostName example.com
+	User alice
+	Port 2222
+	IdentityFile /keys/%C
+`)
+
+	c, err := ParseSSHConfig("hashed", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := os.Hostname()
+	if err != nil {
+		t.Skip("no local hostname")
+	}
+	h := sha1.Sum([]byte(local + "example.com" + "2222" + "alice"))
+	want := "/keys/" + hex.EncodeToString(h[:])
+	if len(c.IdentityFiles) != 1 || c.IdentityFiles[0] != want {
+		t.Errorf("got %v, want [%s]", c.IdentityFiles, want)
+	}
+}
+
+// Zones of link-local IPv6 addresses are kept, and escaped percent signs
+// are not expanded again
+func TestSubstLinkLocal(t *testing.T) {
+	useSSHConfig(t, `Host device
+	HostName fe80::1%%eth0
+Host escaped
+	HostName %%h.example.com
+`)
+
+	for alias, want := range map[string]string{
+		"device":  "fe80::1%eth0",
+		"escaped": "%h.example.com",
+	} {
+		c, err := ParseSSHConfig(alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.HostName != want {
+			t.Errorf("%s: got %q, want %q", alias, c.HostName, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package ssh_config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alebeck/boring/internal/paths"
+)
+
+// pinPrefix is the format of TLSPinnedPubKey, as in curl's --pinnedpubkey
+const pinPrefix = "sha256//"
+
+// TLSWrap configures wrapping the connection to a host in TLS, for servers
+// that are only reachable through a TLS terminating proxy, e.g. on port 443.
+// Like HostNameCommand, the options are not part of ssh(1).
+type TLSWrap struct {
+	ServerName string `json:"server_name,omitempty"` // "" for the host name
+	CAFile     string `json:"ca_file,omitempty"`     // "" for the system roots
+	CertFile   string `json:"certificate_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	PinnedKey  string `json:"pinned_pubkey,omitempty"`
+}
+
+// parseTLSWrap reads the TLS options of a host, returning nil unless
+// TLS is enabled
+func parseTLSWrap(get func(string) string, sub subst) (*TLSWrap, error) {
+	switch v := strings.ToLower(get("TLS")); v {
+	case "", "no":
+		return nil, nil
+	case "yes":
+	default:
+		return nil, fmt.Errorf("%w TLS %q", InvalidOption, v)
+	}
+	path := func(key string) string {
+		return paths.ReplaceTilde(sub.apply(get(key), identFileTokens))
+	}
+	w := &TLSWrap{
+		ServerName: get("TLSServerName"),
+		CAFile:     path("TLSCAFile"),
+		CertFile:   path("TLSCertificateFile"),
+		KeyFile:    path("TLSKeyFile"),
+		PinnedKey:  get("TLSPinnedPubKey"),
+	}
+	if w.KeyFile != "" && w.CertFile == "" {
+		return nil, fmt.Errorf("%w TLSKeyFile: requires TLSCertificateFile", InvalidOption)
+	}
+	if w.PinnedKey != "" {
+		if _, err := parsePin(w.PinnedKey); err != nil {
+			return nil, fmt.Errorf("%w TLSPinnedPubKey %q: %v", InvalidOption, w.PinnedKey, err)
+		}
+	}
+	return w, nil
+}
+
+// parsePin decodes a pinned key, the SHA-256 hash of its DER encoding
+func parsePin(pin string) ([]byte, error) {
+	enc, ok := strings.CutPrefix(pin, pinPrefix)
+	if !ok {
+		return nil, fmt.Errorf("must be of the form %s<base64 hash>", pinPrefix)
+	}
+	sum, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("not a base64 encoded SHA-256 hash")
+	}
+	return sum, nil
+}
+
+// tlsConfig returns the config to wrap the connection to the host in, or
+// nil if it is not wrapped. The server certificate is verified against
+// the CA or the system roots, and must hold the pinned key if one is set.
+// A pinned key alone replaces the verification of the chain, which allows
+// self-signed certificates.
+func (sc *SSHConfig) tlsConfig() (*tls.Config, error) {
+	w := sc.TLS
+	if w == nil {
+		return nil, nil
+	}
+	c := &tls.Config{ServerName: w.ServerName, MinVersion: tls.VersionTLS12}
+	if c.ServerName == "" {
+		c.ServerName = sc.HostName
+	}
+	if w.CAFile != "" {
+		b, err := os.ReadFile(w.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLSCAFile: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("TLSCAFile %s: no certificates found", w.CAFile)
+		}
+	}
+	if w.CertFile != "" {
+		// Both may be in the same file
+		key := w.KeyFile
+		if key == "" {
+			key = w.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(w.CertFile, key)
+		if err != nil {
+			return nil, fmt.Errorf("TLSCertificateFile: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if w.PinnedKey != "" {
+		pin, _ := parsePin(w.PinnedKey)
+		c.InsecureSkipVerify = w.CAFile == ""
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("no server certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("server key %s%s does not match TLSPinnedPubKey",
+					pinPrefix, base64.StdEncoding.EncodeToString(sum[:]))
+			}
+			return nil
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// validateAddrs checks the addresses that the mode of the tunnel uses, so
+// that malformed ones are rejected, naming the field at fault, before
+// anything is bound or dialed
+func (d *Desc) validateAddrs() error {
+	if d.Mode == Tun {
+		return nil
+	}
+	usesLocal := d.Mode != RemoteSocks
+	// In sni mode, the remote address is the optional default target
+	usesRemote := d.Mode != Socks && !(d.Mode == Sni && d.RemoteAddress == "")
+	allowShort := d.Mode == Remote || d.Mode == RemoteSocks
+
+	if usesLocal {
+		if err := validateAddr(string(d.LocalAddress), !allowShort); err != nil {
+			return fmt.Errorf("local address: %w", err)
+		}
+	}
+	if usesRemote {
+		if err := validateAddr(string(d.RemoteAddress), allowShort); err != nil {
+			return fmt.Errorf("remote address: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateAddr checks that s is a port if allowShort is set, a host:port
+// network address or a Unix socket path
+func validateAddr(s string, allowShort bool) error {
+	if s == "" {
+		return errors.New("required in this mode")
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		if !allowShort {
+			return fmt.Errorf("bad remote forwarding specification, %q lacks a host, e.g. localhost:%s", s, s)
+		}
+		return validatePort(s)
+	}
+	if !strings.Contains(s, ":") {
+		// Without a separator, a bare word is more likely a host lacking
+		// its port than a socket in the daemon's working directory
+		if !strings.ContainsAny(s, `/\`) {
+			return fmt.Errorf("%q is neither host:port nor a Unix socket path, "+
+				"use ./%s for a socket in the working directory", s, s)
+		}
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		if ip := s[:strings.LastIndex(s, ":")]; net.ParseIP(ip) != nil && strings.Contains(ip, ":") {
+			return fmt.Errorf("%q: IPv6 addresses must be in brackets, e.g. [::1]:22", s)
+		}
+		return fmt.Errorf("%q is not of the form host:port", s)
+	}
+	if err := validatePort(port); err != nil {
+		return err
+	}
+	return validateHost(host)
+}
+
+func validatePort(port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("port %q is not a number from 0 to 65535", port)
+	}
+	return nil
+}
+
+// validateHost checks that host is empty, i.e. all interfaces, an IP
+// address or a host name
+func validateHost(host string) error {
+	if host == "" {
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		ip, _, _ := strings.Cut(host, "%")
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("host %q is not a valid IPv6 address", host)
+		}
+		return nil
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '.' || c == '_') {
+			return fmt.Errorf("host %q contains invalid character %q", host, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAddrs(t *testing.T) {
+	tests := []struct {
+		mode          Mode
+		local, remote string
+		err           string // part of the error, empty if valid
+	}{
+		{Local, "localhost:5432", "db:5432", ""},
+		{Local, "127.0.0.1:0", "[::1]:22", ""},
+		{Local, ":8080", "[fe80::1%eth0]:22", ""},
+		{Local, "/tmp/boring.sock", "./remote.sock", ""},
+		{Remote, "localhost:80", "8080", ""},
+		{Socks, "1080", "", ""},
+		{Socks, "localhost:1080", "[SOCKS]", ""},
+		{RemoteSocks, "[SOCKS]", "1080", ""},
+		{Udp, "localhost:53", "10.0.0.1:53", ""},
+		{Tun, "any", "7", ""},
+		{Local, "", "db:5432", "local address: required"},
+		{Remote, "localhost:80", "", "remote address: required"},
+		{Local, "localhost:notaport", "db:5432", `local address: port "notaport"`},
+		{Local, "localhost:-1", "db:5432", `local address: port "-1"`},
+		{Local, "localhost:65536", "db:5432", `local address: port "65536"`},
+		{Local, "localhost:", "db:5432", `local address: port ""`},
+		{Local, "localhost:5432", "5432", `remote address: bad remote forwarding specification, "5432" lacks a host`},
+		{Local, "localhost:5432", "db", `remote address: "db" is neither host:port`},
+		{Local, "::1:5432", "db:5432", "local address: \"::1:5432\": IPv6 addresses must be in brackets"},
+		{Local, "[::g]:5432", "db:5432", `local address: host "::g" is not a valid IPv6`},
+		{Local, "localhost:5432", "d b:5432", `remote address: host "d b" contains invalid character ' '`},
+		{Local, "localhost:5432", "db:5432:1", `remote address: "db:5432:1" is not of the form host:port`},
+	}
+	for _, tt := range tests {
+		d := &Desc{Mode: tt.mode, LocalAddress: StringOrInt(tt.local), RemoteAddress: StringOrInt(tt.remote)}
+		err := d.validateAddrs()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%q -> %q in mode %d: unexpected error: %v", tt.local, tt.remote, tt.mode, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q -> %q in mode %d: got %v, want %q", tt.local, tt.remote, tt.mode, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// parseAllowFrom parses a list of CIDR prefixes, where bare addresses
+// stand for themselves only
+func parseAllowFrom(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			a, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR prefix", e)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR prefix", e)
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// prepareAllowFrom parses allow_from, which needs peers with IP addresses,
+// so not a listener on a Unix socket
+func (t *Tunnel) prepareAllowFrom() (err error) {
+	if len(t.AllowFrom) == 0 {
+		return nil
+	}
+	if t.Mode == Tun {
+		return fmt.Errorf("allow_from is not supported in tun mode")
+	}
+	listen := t.localAddr
+	if t.Mode == Remote || t.Mode == RemoteSocks {
+		listen = t.remoteAddr
+	}
+	if listen.net == "unix" {
+		return fmt.Errorf("allow_from is not supported when listening on a Unix socket")
+	}
+	if t.allowFrom, err = parseAllowFrom(t.AllowFrom); err != nil {
+		return fmt.Errorf("allow_from: %w", err)
+	}
+	return nil
+}
+
+// allowed reports whether a connection from addr may use the tunnel, which
+// is the case if allow_from is not set or has a prefix containing it
+func (t *Tunnel) allowed(addr net.Addr) bool {
+	if len(t.allowFrom) == 0 {
+		return true
+	}
+	var ip netip.Addr
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	case *net.UDPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	default:
+		ap, err := netip.ParseAddrPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = ap.Addr()
+	}
+	ip = ip.Unmap()
+	for _, p := range t.allowFrom {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseAllowFrom(t *testing.T) {
+	for _, e := range []string{"", "10.0.0.0/33", "example.com", "::1/129", "10.0.0.0/x"} {
+		if _, err := parseAllowFrom([]string{e}); err == nil {
+			t.Errorf("%q: expected error", e)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tun := FromDesc(&Desc{AllowFrom: []string{"192.168.1.0/24", "10.1.2.3", "fd00::/8", "::ffff:172.16.0.0/108"}})
+	var err error
+	if tun.allowFrom, err = parseAllowFrom(tun.AllowFrom); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.77"), Port: 1234}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.2.1"), Port: 1234}, false},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.4")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.168.1.1")}, true}, // IPv4-mapped
+		{&net.TCPAddr{IP: net.ParseIP("172.16.5.5")}, true},
+		{&net.UDPAddr{IP: net.ParseIP("fd12::1")}, true},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1")}, false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, false},
+	} {
+		if got := tun.allowed(tc.addr); got != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.addr, got, tc.want)
+		}
+	}
+
+	if !FromDesc(&Desc{}).allowed(&net.UnixAddr{Name: "/tmp/sock"}) {
+		t.Errorf("expected all addresses to be allowed without allow_from")
+	}
+}
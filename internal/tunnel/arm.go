@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// NotArmable is returned when arming or disarming a tunnel without a local
+// listener, or one that connects on demand and so needs its listener
+var NotArmable = errors.New("only tunnels listening locally can be armed and disarmed")
+
+// gateListener binds the local listener only while armed, e.g. after an
+// approval step, while the SSH connection is held either way. Accept waits
+// while disarmed rather than failing, which would end the connection.
+type gateListener struct {
+	bind    func() (net.Listener, error)
+	addr    net.Addr
+	mu      sync.Mutex
+	l       net.Listener  // nil while disarmed
+	changed chan struct{} // closed on every change of l
+	closed  bool
+}
+
+func newGateListener(bind func() (net.Listener, error), addr net.Addr) *gateListener {
+	return &gateListener{bind: bind, addr: addr, changed: make(chan struct{})}
+}
+
+// notify wakes up Accept after l changed, must be called with mu held
+func (a *gateListener) notify() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// arm binds the listener, doing nothing if it is bound already
+func (a *gateListener) arm() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return net.ErrClosed
+	}
+	if a.l != nil {
+		return nil
+	}
+	l, err := a.bind()
+	if err != nil {
+		return err
+	}
+	a.l = l
+	a.notify()
+	return nil
+}
+
+// disarm closes the listener, leaving the connections accepted so far
+func (a *gateListener) disarm() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.l == nil {
+		return
+	}
+	a.l.Close()
+	a.l = nil
+	a.notify()
+}
+
+func (a *gateListener) Accept() (net.Conn, error) {
+	for {
+		a.mu.Lock()
+		if a.closed {
+			a.mu.Unlock()
+			return nil, net.ErrClosed
+		}
+		l, changed := a.l, a.changed
+		a.mu.Unlock()
+
+		if l == nil {
+			<-changed
+			continue
+		}
+		conn, err := l.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		a.mu.Lock()
+		disarmed := a.l != l && !a.closed
+		a.mu.Unlock()
+		if !disarmed {
+			return nil, err
+		}
+	}
+}
+
+func (a *gateListener) Close() (err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.l != nil {
+		err = a.l.Close()
+	}
+	a.notify()
+	return
+}
+
+// Addr returns the bound address, or the configured one while disarmed
+func (a *gateListener) Addr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.l != nil {
+		return a.l.Addr()
+	}
+	return a.addr
+}
+
+// armable reports whether the listener of the tunnel can be disarmed
+func (t *Tunnel) armable() bool {
+	return !t.OnDemand && (t.Mode == Local || t.Mode == Socks || t.Mode == Udp || t.Mode == Sni)
+}
+
+// SetArmed binds or closes the local listener of the running tunnel,
+// keeping its SSH connection. The state is kept across re-connects.
+func (t *Tunnel) SetArmed(armed bool) error {
+	if !t.armable() {
+		return NotArmable
+	}
+	t.armMu.Lock()
+	defer t.armMu.Unlock()
+	// While re-connecting, the next listener picks up the state
+	if t.gate != nil {
+		if armed {
+			if err := t.gate.arm(); err != nil && !errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("%w: %w", CannotListen, err)
+			}
+		} else {
+			t.gate.disarm()
+		}
+	}
+	if t.Disarmed == !armed {
+		return nil
+	}
+	t.update(func(d *Desc) { d.Disarmed = !armed })
+	if armed {
+		log.Infof("%v: armed", t.logName())
+	} else {
+		log.Infof("%v: disarmed, not listening", t.logName())
+	}
+	return nil
+}
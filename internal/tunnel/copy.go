@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// defaultCopyBuf is the buffer size io.Copy uses
+	defaultCopyBuf = 32 * 1024
+	minCopyBuf     = 1024
+	maxCopyBuf     = 16 * 1024 * 1024
+)
+
+// Pools by buffer size, shared by all tunnels using the same size
+var bufPools sync.Map
+
+// bufPool hands out copy buffers of a fixed size, so that connections do
+// not allocate their own
+type bufPool struct {
+	size int
+	p    sync.Pool
+}
+
+// copyBufPool returns the pool for buffers of the given size, or of the
+// default size if it is zero
+func copyBufPool(size int) *bufPool {
+	if size == 0 {
+		size = defaultCopyBuf
+	}
+	if p, ok := bufPools.Load(size); ok {
+		return p.(*bufPool)
+	}
+	p := &bufPool{size: size}
+	p.p.New = func() any {
+		b := make([]byte, size)
+		return &b
+	}
+	actual, _ := bufPools.LoadOrStore(size, p)
+	return actual.(*bufPool)
+}
+
+// copy copies from src to dst like io.Copy, using a buffer of the pool,
+// which is returned to it on all paths. Short writes fail the copy with
+// io.ErrShortWrite rather than dropping data.
+func (b *bufPool) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := b.p.Get().(*[]byte)
+	defer b.p.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+func validateCopyBuf(size int) error {
+	if size != 0 && (size < minCopyBuf || size > maxCopyBuf) {
+		return fmt.Errorf("copy_buffer_size must be from %d to %d bytes, found %d",
+			minCopyBuf, maxCopyBuf, size)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// slowWriter takes its time for every write
+type slowWriter struct {
+	bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return w.Buffer.Write(p)
+}
+
+// Data arrives intact through small buffers and a slow reader
+func TestTunnelSmallBuffer(t *testing.T) {
+	client, in := tcpPair(t)
+	out, server := tcpPair(t)
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+
+	done := make(chan struct{})
+	go func() {
+		tunnel(in, out, copyBufPool(minCopyBuf))
+		close(done)
+	}()
+	go func() {
+		client.Write(data)
+		client.CloseWrite()
+	}()
+
+	got := &slowWriter{}
+	buf := make([]byte, 100)
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.CopyBuffer(got, server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("got %d bytes, not the %d sent", got.Len(), len(data))
+	}
+	server.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel did not finish after both directions closed")
+	}
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) { return len(p) / 2, nil }
+
+// Writers accepting less than given fail the copy instead of losing data
+func TestCopyShortWrite(t *testing.T) {
+	_, err := copyBufPool(0).copy(shortWriter{}, bytes.NewReader(make([]byte, 10)))
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("expected %v, got %v", io.ErrShortWrite, err)
+	}
+}
+
+func TestCopyBufPool(t *testing.T) {
+	if copyBufPool(0) != copyBufPool(defaultCopyBuf) {
+		t.Errorf("default size does not share the pool")
+	}
+	if copyBufPool(minCopyBuf) == copyBufPool(defaultCopyBuf) {
+		t.Errorf("sizes share a pool")
+	}
+	for size, ok := range map[int]bool{0: true, minCopyBuf: true, maxCopyBuf: true,
+		minCopyBuf - 1: false, maxCopyBuf + 1: false, -1: false} {
+		if err := validateCopyBuf(size); (err == nil) != ok {
+			t.Errorf("%d: got %v", size, err)
+		}
+	}
+}
+
+// onlyWriter and onlyReader hide ReadFrom and WriteTo, so that buffers are
+// used like for SSH channels
+type onlyWriter struct{ io.Writer }
+type onlyReader struct{ io.Reader }
+
+// Compares allocating a buffer per connection like io.Copy, to pooled
+// buffers of several sizes, for connections transferring 1 MiB each
+func BenchmarkCopy(b *testing.B) {
+	data := make([]byte, 1024*1024)
+	dst := onlyWriter{io.Discard}
+	b.Run("io.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				io.Copy(dst, onlyReader{bytes.NewReader(data)})
+			}
+		})
+	})
+	for _, size := range []int{4 * 1024, defaultCopyBuf, 256 * 1024} {
+		b.Run(fmt.Sprintf("pooled-%dk", size/1024), func(b *testing.B) {
+			p := copyBufPool(size)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p.copy(dst, onlyReader{bytes.NewReader(data)})
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Reason codes of SSH_MSG_DISCONNECT, see RFC 4253 section 11.1
+const (
+	disconnectHostNotAllowed     = 1
+	disconnectProtocolError      = 2
+	disconnectKexFailed          = 3
+	disconnectMacError           = 5
+	disconnectCompressionError   = 6
+	disconnectServiceUnavailable = 7
+	disconnectVersionUnsupported = 8
+	disconnectHostKeyUnverified  = 9
+	disconnectConnectionLost     = 10
+	disconnectByApplication      = 11
+	disconnectTooManyConnections = 12
+	disconnectAuthCancelled      = 13
+	disconnectNoMoreAuthMethods  = 14
+	disconnectIllegalUserName    = 15
+)
+
+var disconnectReasons = map[uint32]string{
+	disconnectHostNotAllowed:     "host not allowed to connect",
+	disconnectProtocolError:      "protocol error",
+	disconnectKexFailed:          "key exchange failed",
+	disconnectMacError:           "MAC error",
+	disconnectCompressionError:   "compression error",
+	disconnectServiceUnavailable: "service not available",
+	disconnectVersionUnsupported: "protocol version not supported",
+	disconnectHostKeyUnverified:  "host key not verifiable",
+	disconnectConnectionLost:     "connection lost",
+	disconnectByApplication:      "by application",
+	disconnectTooManyConnections: "too many connections",
+	disconnectAuthCancelled:      "auth cancelled by user",
+	disconnectNoMoreAuthMethods:  "no more auth methods available",
+	disconnectIllegalUserName:    "illegal user name",
+}
+
+// Wait before re-connecting after the server deliberately closed the
+// connection, instead of trying right away
+const deliberateReconnectWait = maxReconnectWait
+
+// Disconnect is the reason the server gave for closing the connection
+type Disconnect struct {
+	Reason  uint32 `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+func (d *Disconnect) String() string {
+	r, ok := disconnectReasons[d.Reason]
+	if !ok {
+		r = fmt.Sprintf("reason %d", d.Reason)
+	}
+	if d.Message == "" {
+		return r
+	}
+	return fmt.Sprintf("%s: %s", r, d.Message)
+}
+
+// x/crypto does not export the type of disconnect errors, only its message
+var disconnectRe = regexp.MustCompile(`^ssh: disconnect, reason (\d+): (".*")$`)
+
+// parseDisconnect returns the disconnect message that err stems from, or
+// nil if the connection ended otherwise, e.g., on a transport error
+func parseDisconnect(err error) *Disconnect {
+	if err == nil {
+		return nil
+	}
+	m := disconnectRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return nil
+	}
+	reason, err := strconv.ParseUint(m[1], 10, 32)
+	if err != nil {
+		return nil
+	}
+	msg, err := strconv.Unquote(m[2])
+	if err != nil {
+		msg = m[2]
+	}
+	return &Disconnect{Reason: uint32(reason), Message: msg}
+}
+
+// reconnectWait returns how long to wait before re-connecting after the
+// server disconnected with d. Deliberate disconnects, e.g. by an admin or
+// on shutdown, are not retried right away to avoid hammering the server.
+// If ok is false, re-connecting would not succeed, e.g. because the user
+// is not allowed anymore.
+func (d *Disconnect) reconnectWait() (wait time.Duration, ok bool) {
+	if d == nil {
+		return 0, true
+	}
+	switch d.Reason {
+	case disconnectHostNotAllowed, disconnectVersionUnsupported,
+		disconnectHostKeyUnverified, disconnectAuthCancelled,
+		disconnectNoMoreAuthMethods, disconnectIllegalUserName:
+		return 0, false
+	case disconnectServiceUnavailable, disconnectByApplication,
+		disconnectTooManyConnections:
+		return deliberateReconnectWait, true
+	}
+	return 0, true
+}
@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Produces a disconnect error of x/crypto by exceeding the server's
+// authentication tries
+func serverDisconnect(t *testing.T) error {
+	c1, c2 := tcpPair(t)
+
+	conf := &ssh.ServerConfig{
+		MaxAuthTries: 1,
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		},
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.AddHostKey(signer)
+
+	go ssh.NewClientConn(c2, "", &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.RetryableAuthMethod(ssh.Password("wrong"), 3)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	_, _, _, err = ssh.NewServerConn(c1, conf)
+	var authErr *ssh.ServerAuthError
+	if !errors.As(err, &authErr) || len(authErr.Errors) == 0 {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+	return authErr.Errors[len(authErr.Errors)-1]
+}
+
+func TestParseDisconnect(t *testing.T) {
+	d := parseDisconnect(serverDisconnect(t))
+	if d == nil {
+		t.Fatal("disconnect not recognized")
+	}
+	if d.Reason != disconnectProtocolError || d.Message != "too many authentication failures" {
+		t.Errorf("got %+v", d)
+	}
+	if s := d.String(); s != "protocol error: too many authentication failures" {
+		t.Errorf("got %q", s)
+	}
+
+	for _, err := range []error{nil, errors.New("EOF"), net.ErrClosed} {
+		if d := parseDisconnect(err); d != nil {
+			t.Errorf("%v: got %+v", err, d)
+		}
+	}
+}
+
+func TestDisconnectReconnectWait(t *testing.T) {
+	tests := []struct {
+		d    *Disconnect
+		wait time.Duration
+		ok   bool
+	}{
+		{nil, 0, true},
+		{&Disconnect{Reason: disconnectConnectionLost}, 0, true},
+		{&Disconnect{Reason: disconnectProtocolError}, 0, true},
+		{&Disconnect{Reason: disconnectByApplication}, deliberateReconnectWait, true},
+		{&Disconnect{Reason: disconnectTooManyConnections}, deliberateReconnectWait, true},
+		{&Disconnect{Reason: disconnectIllegalUserName}, 0, false},
+		{&Disconnect{Reason: disconnectNoMoreAuthMethods}, 0, false},
+		{&Disconnect{Reason: 99}, 0, true},
+	}
+	for _, tt := range tests {
+		wait, ok := tt.d.reconnectWait()
+		if wait != tt.wait || ok != tt.ok {
+			t.Errorf("%v: got (%v, %v), want (%v, %v)", tt.d, wait, ok, tt.wait, tt.ok)
+		}
+	}
+}
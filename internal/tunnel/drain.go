@@ -0,0 +1,52 @@
+package tunnel
+
+// Drain stops the tunnel from accepting connections, and closes it once
+// the connections accepted so far are done, e.g., so that another process
+// can take over the tunnel without cutting them. The returned channel is
+// closed once the tunnel stopped listening, so that its addresses can be
+// bound again. Tunnels in tun mode are closed right away.
+func (t *Tunnel) Drain() <-chan struct{} {
+	if t.Mode == Tun {
+		t.Close()
+		return t.Closed
+	}
+	t.unbound = make(chan struct{})
+	t.draining.Store(true)
+	t.Close()
+
+	free := make(chan struct{})
+	go func() {
+		select {
+		case <-t.unbound:
+		case <-t.Closed:
+		}
+		close(free)
+	}()
+	return free
+}
+
+// drain stops listening and waits for the accepted connections to be
+// done, see Drain. Remote forwards are cancelled by run already.
+func (t *Tunnel) drain() {
+	if t.Mode != Remote && t.Mode != RemoteSocks {
+		t.closeListener()
+	}
+	// The bound listener of on-demand tunnels is closed by serveOnDemand
+	if !t.OnDemand {
+		t.unbind()
+	}
+	for {
+		d, changed := t.watch()
+		if d.Conns == 0 {
+			return
+		}
+		<-changed
+	}
+}
+
+// unbind tells Drain that the tunnel stopped listening
+func (t *Tunnel) unbind() {
+	if t.draining.Load() {
+		t.unbindOnce.Do(func() { close(t.unbound) })
+	}
+}
@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/alebeck/boring/internal/shell"
+)
+
+// runLocalCommand runs the LocalCommand of the tunnel in a shell once the
+// forward is established, like ssh(1) does with PermitLocalCommand. Its
+// output goes to the log.
+func (t *Tunnel) runLocalCommand() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shell.Timeout)
+	defer cancel()
+	out, err := shell.Command(ctx, t.expandTokens(t.LocalCommand)).CombinedOutput()
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			t.infof("local command: %s", l)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("local command failed: %v", err)
+	}
+	return nil
+}
+
+// expandTokens replaces %n by the name of the tunnel, %l by its local port,
+// %L by its local address, %h by its host and %% by a literal %. The values
+// are quoted for the shell, as the host, e.g., is not restricted to names.
+func (t *Tunnel) expandTokens(s string) string {
+	port := t.LocalPort()
+	if port != "" && t.listener != nil {
+		// The system may have chosen the port
+		if a, ok := t.listener.Addr().(*net.TCPAddr); ok {
+			port = strconv.Itoa(a.Port)
+		}
+	}
+	return strings.NewReplacer("%%", "%", "%n", shell.Quote(t.Name()), "%l", shell.Quote(port),
+		"%L", shell.Quote(t.LocalAddress.String()), "%h", shell.Quote(t.Host)).Replace(s)
+}
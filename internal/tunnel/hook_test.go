@@ -0,0 +1,18 @@
+package tunnel
+
+import (
+	"runtime"
+	"testing"
+)
+
+// Values of tokens are not interpreted by the shell
+func TestExpandTokens(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("quoted for sh")
+	}
+	tun := FromDesc(&Desc{Name: "db", Host: "$(reboot);h", LocalAddress: "5432", Mode: Local})
+	want := `notify 'db' '$(reboot);h' '5432' '5432' 100%`
+	if got := tun.expandTokens("notify %n %h %l %L 100%%"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
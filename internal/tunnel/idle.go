@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"net"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/proxy"
+)
+
+// activityConn wraps a forwarded connection and records the time of
+// each transfer on its tunnel, which is used for idle detection.
+type activityConn struct {
+	net.Conn
+	t *Tunnel
+}
+
+func (c *activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.t.touch()
+	}
+	return n, err
+}
+
+func (c *activityConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.t.touch()
+	}
+	return n, err
+}
+
+func (c *activityConn) CloseWrite() error {
+	return proxy.CloseWrite(c.Conn)
+}
+
+func (t *Tunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// watchIdle closes the tunnel once no connection was accepted and no data
+// was transferred for the configured idle timeout.
+func (t *Tunnel) watchIdle() {
+	timeout := time.Duration(t.IdleTimeout) * time.Second
+	for {
+		last := time.Unix(0, t.lastActive.Load())
+		remaining := timeout - time.Since(last)
+		if remaining <= 0 {
+			log.Infof("%v: closing after being idle for %v", t.logName(), timeout)
+			t.Close()
+			return
+		}
+		select {
+		case <-t.Closed:
+			return
+		case <-time.After(remaining):
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package tunnel
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// jitter randomizes d by up to ±frac of its value, so that tunnels
+// reconnecting through the same host spread out their attempts.
+func jitter(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + frac*(2*rand.Float64()-1)))
+}
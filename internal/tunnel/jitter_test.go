@@ -0,0 +1,26 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	d := 10 * time.Second
+	if j := jitter(d, 0); j != d {
+		t.Errorf("expected no jitter, got %v", j)
+	}
+
+	lo, hi := 8*time.Second, 12*time.Second
+	seen := map[time.Duration]struct{}{}
+	for range 100 {
+		j := jitter(d, 0.2)
+		if j < lo || j > hi {
+			t.Fatalf("jittered %v out of [%v, %v]", j, lo, hi)
+		}
+		seen[j] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Error("expected jittered values to differ")
+	}
+}
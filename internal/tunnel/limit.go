@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+
+	"github.com/alebeck/boring/internal/proxy"
+)
+
+// limitConn frees its slot on the tunnel once closed
+type limitConn struct {
+	net.Conn
+	t    *Tunnel
+	once sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.t.release)
+	return c.Conn.Close()
+}
+
+func (c *limitConn) CloseWrite() error {
+	return proxy.CloseWrite(c.Conn)
+}
+
+// acquire takes a connection slot, returning false if MaxConns is reached
+func (t *Tunnel) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.MaxConns > 0 && t.Conns >= t.MaxConns {
+		return false
+	}
+	t.Conns++
+	t.PeakConns = max(t.PeakConns, t.Conns)
+	return true
+}
+
+func (t *Tunnel) release() {
+	t.update(func(d *Desc) { d.Conns-- })
+	if t.OnDemand {
+		// Idle from now on, see watchDemand
+		t.touch()
+	}
+}
+
+// dialSlot waits for one of MaxDialing slots for establishing connections
+// to the target, so that bursts of connections queue up instead of all
+// being dialed at once. The returned function frees the slot.
+func (t *Tunnel) dialSlot() func() {
+	if t.dialing == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	t.Queued++
+	t.mu.Unlock()
+	t.dialing <- struct{}{}
+	t.mu.Lock()
+	t.Queued--
+	t.mu.Unlock()
+	return func() { <-t.dialing }
+}
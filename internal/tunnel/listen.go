@@ -0,0 +1,135 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// Loopback addresses that "localhost" binds to, the first one is required
+var loopbacks = []string{"127.0.0.1", "::1"}
+
+// listen is like net.Listen, but binds "localhost" to the loopback address
+// of each address family, see bindLocal.
+func listen(network, addr string) (net.Listener, error) {
+	if network != "tcp" {
+		return net.Listen(network, addr)
+	}
+	var ls []net.Listener
+	err := bindLocal(addr, func(a string) (string, error) {
+		l, err := net.Listen(network, a)
+		if err != nil {
+			return "", err
+		}
+		ls = append(ls, l)
+		return l.Addr().String(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ls) == 1 {
+		return ls[0], nil
+	}
+	return newMultiListener(ls), nil
+}
+
+// bindLocal calls bind with addr, or if its host is "localhost", with the
+// loopback address of each address family, as the system may resolve it to
+// only one of them. Only the first one is required. bind returns the address
+// it bound to, so that all of them use the same port if the system chose it.
+func bindLocal(addr string, bind func(addr string) (string, error)) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "localhost" {
+		_, err := bind(addr)
+		return err
+	}
+
+	for i, ip := range loopbacks {
+		bound, err := bind(net.JoinHostPort(ip, port))
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			if errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT) {
+				log.Debugf("not listening on %v: %v", ip, err)
+			} else {
+				log.Warningf("could not listen on %v: %v", ip, err)
+			}
+			continue
+		}
+		if i == 0 {
+			_, port, _ = net.SplitHostPort(bound)
+		}
+	}
+	return nil
+}
+
+// multiListener accepts connections from several listeners at once
+type multiListener struct {
+	ls      []net.Listener
+	results chan acceptResult
+	done    chan struct{}
+	once    sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(ls []net.Listener) *multiListener {
+	m := &multiListener{
+		ls:      ls,
+		results: make(chan acceptResult),
+		done:    make(chan struct{}),
+	}
+	for _, l := range ls {
+		go func() {
+			for {
+				conn, err := l.Accept()
+				select {
+				case m.results <- acceptResult{conn, err}:
+				case <-m.done:
+					if conn != nil {
+						conn.Close()
+					}
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	return m
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.results:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all listeners, returning the first error
+func (m *multiListener) Close() (err error) {
+	m.once.Do(func() {
+		close(m.done)
+		for _, l := range m.ls {
+			if e := l.Close(); err == nil {
+				err = e
+			}
+		}
+	})
+	return
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.ls[0].Addr()
+}
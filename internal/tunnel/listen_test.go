@@ -0,0 +1,42 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenLocalhostDualStack(t *testing.T) {
+	if l, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	} else {
+		l.Close()
+	}
+
+	l, err := listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		c, err := net.Dial("tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			t.Fatalf("%v not reachable: %v", ip, err)
+		}
+		s, err := l.Accept()
+		if err != nil {
+			t.Fatalf("could not accept: %v", err)
+		}
+		if got := s.LocalAddr().(*net.TCPAddr).IP.String(); got != ip {
+			t.Errorf("expected connection on %v, got %v", ip, got)
+		}
+		s.Close()
+		c.Close()
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Error("expected accept to fail after close")
+	}
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
+)
+
+// openLog opens the log file of the tunnel, if it has one. It stays open
+// across re-connects until the tunnel is closed.
+func (t *Tunnel) openLog() error {
+	if t.LogFile == "" || t.logFile != nil {
+		return nil
+	}
+	f, err := log.OpenFile(paths.ReplaceTilde(t.LogFile))
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %v", err)
+	}
+	t.logFile = f
+	return nil
+}
+
+// closeLog closes the log file, later messages go to the main log
+func (t *Tunnel) closeLog() {
+	if t.logFile != nil {
+		t.logFile.Close()
+	}
+}
+
+// Labels are key-value pairs attached to a tunnel, e.g. its team or
+// ticket, so that its activity can be correlated with other systems
+type Labels map[string]string
+
+// String formats the labels sorted by name, e.g. "[team=infra ticket=1]"
+func (l Labels) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, k := range slices.Sorted(maps.Keys(l)) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%s", k, l[k])
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// logName is the name of the tunnel in log messages, followed by its
+// labels if it has any
+func (t *Tunnel) logName() string {
+	if len(t.Labels) == 0 {
+		return t.Name()
+	}
+	return t.Name() + " " + t.Labels.String()
+}
+
+// Messages about connections, keep-alives and re-connects go to the log
+// file of the tunnel if it has one, so that chatty tunnels do not clutter
+// the main log. Lifecycle events are always logged to the main log.
+
+func (t *Tunnel) debugf(format string, a ...any) {
+	t.logFile.Debugf("%v: "+format, append([]any{t.logName()}, a...)...)
+}
+
+func (t *Tunnel) infof(format string, a ...any) {
+	t.logFile.Infof("%v: "+format, append([]any{t.logName()}, a...)...)
+}
+
+func (t *Tunnel) warningf(format string, a ...any) {
+	t.logFile.Warningf("%v: "+format, append([]any{t.logName()}, a...)...)
+}
+
+func (t *Tunnel) errorf(format string, a ...any) {
+	t.logFile.Errorf("%v: "+format, append([]any{t.logName()}, a...)...)
+}
@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// Connection messages go to the log file of the tunnel while it is open,
+// and to the main log before and after
+func TestTunnelLogFile(t *testing.T) {
+	var main bytes.Buffer
+	log.Init(&main, true, false)
+
+	path := filepath.Join(t.TempDir(), "tunnel.log")
+	tun := FromDesc(&Desc{Name: "chatty", LogFile: path})
+	tun.infof("before")
+	if err := tun.openLog(); err != nil {
+		t.Fatalf("could not open log: %v", err)
+	}
+	tun.infof("connected")
+
+	// Rotate like logrotate does
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	log.ReopenFiles()
+	tun.warningf("rotated")
+	tun.closeLog()
+	tun.errorf("after")
+
+	for _, c := range []struct {
+		path     string
+		contains []string
+	}{
+		{path + ".1", []string{"chatty: connected"}},
+		{path, []string{"chatty: rotated"}},
+	} {
+		b, err := os.ReadFile(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("%s does not contain %q: %s", c.path, s, b)
+			}
+		}
+		if strings.Count(string(b), "\n") != len(c.contains) {
+			t.Errorf("%s contains other messages: %s", c.path, b)
+		}
+	}
+	if m := main.String(); !strings.Contains(m, "chatty: before") ||
+		!strings.Contains(m, "chatty: after") || strings.Contains(m, "connected") {
+		t.Errorf("unexpected main log: %s", m)
+	}
+}
+
+// Labels follow the name of the tunnel in its messages, sorted by name
+func TestTunnelLogLabels(t *testing.T) {
+	var main bytes.Buffer
+	log.Init(&main, true, false)
+
+	tun := FromDesc(&Desc{Name: "db", Labels: Labels{"ticket": "OPS-1", "team": "infra"}})
+	tun.infof("connected")
+	if m := main.String(); !strings.Contains(m, "db [team=infra ticket=OPS-1]: connected") {
+		t.Errorf("labels not logged: %s", m)
+	}
+}
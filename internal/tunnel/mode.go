@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 )
 
@@ -12,6 +13,9 @@ const (
 	Remote
 	Socks
 	RemoteSocks
+	Tun
+	Udp
+	Sni
 )
 
 func (m *Mode) UnmarshalTOML(data any) error {
@@ -29,6 +33,12 @@ func (m *Mode) UnmarshalTOML(data any) error {
 		*m = Socks
 	case "socks-remote":
 		*m = RemoteSocks
+	case "tun":
+		*m = Tun
+	case "udp":
+		*m = Udp
+	case "sni":
+		*m = Sni
 	default:
 		return errors.New("invalid mode")
 	}
@@ -36,8 +46,29 @@ func (m *Mode) UnmarshalTOML(data any) error {
 	return nil
 }
 
+// MarshalTOML writes the mode as in the config file, while JSON keeps
+// the number the daemon and its clients exchange
+func (m Mode) MarshalTOML() ([]byte, error) {
+	name, ok := map[Mode]string{
+		Local:       "local",
+		Remote:      "remote",
+		Socks:       "socks",
+		RemoteSocks: "socks-remote",
+		Tun:         "tun",
+		Udp:         "udp",
+		Sni:         "sni",
+	}[m]
+	if !ok {
+		return nil, errors.New("invalid mode")
+	}
+	return []byte(strconv.Quote(name)), nil
+}
+
 func (m Mode) String() string {
-	if m == Local || m == Socks {
+	if m == Tun {
+		return "<->"
+	}
+	if m == Local || m == Socks || m == Udp || m == Sni {
 		return "->"
 	}
 	return "<-"
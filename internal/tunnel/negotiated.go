@@ -0,0 +1,34 @@
+package tunnel
+
+import (
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Negotiated holds the algorithms that were actually agreed upon with
+// the server of the final hop during the SSH handshake.
+type Negotiated struct {
+	ServerVersion string `json:"server_version"`
+	KeyExchange   string `json:"kex"`
+	HostKey       string `json:"host_key"`
+	Cipher        string `json:"cipher"`
+	MAC           string `json:"mac,omitempty"`
+	AuthKey       string `json:"auth_key,omitempty"`
+}
+
+func negotiatedFrom(c *ssh.Client, hop ssh_config.Hop) *Negotiated {
+	n := &Negotiated{ServerVersion: string(c.ServerVersion())}
+	if hop.AuthKey != nil {
+		n.AuthKey = hop.AuthKey.String()
+	}
+	if m, ok := c.Conn.(ssh.AlgorithmsConnMetadata); ok {
+		a := m.Algorithms()
+		n.KeyExchange = a.KeyExchange
+		n.HostKey = a.HostKey
+		// We report the client-to-server direction, which in practice
+		// always matches the opposite one. MAC is empty for AEAD ciphers.
+		n.Cipher = a.Write.Cipher
+		n.MAC = a.Write.MAC
+	}
+	return n
+}
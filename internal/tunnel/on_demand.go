@@ -0,0 +1,201 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// Disconnect after this period of inactivity if no idle timeout is set
+const defaultDemandIdle = 5 * time.Minute
+
+// demandListener passes the connections accepted on the bound listener to
+// the forwarding logic of a single SSH connection. Closing it ends that
+// connection's share, but leaves the bound listener open.
+type demandListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	addr  net.Addr
+}
+
+func (l *demandListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *demandListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *demandListener) Addr() net.Addr {
+	return l.addr
+}
+
+// openOnDemand binds the listener without connecting to the server, which
+// happens once the first connection is accepted, like socket activation
+func (t *Tunnel) openOnDemand() error {
+	if err := t.makeListener(); err != nil {
+		return fmt.Errorf("%w: %w", CannotListen, err)
+	}
+	t.bound = t.listener
+	t.stop = make(chan struct{})
+	t.Closed = make(chan struct{})
+	go t.serveOnDemand()
+	if t.TTL > 0 {
+		t.expireAfterTTL()
+	}
+
+	log.Infof("%v: listening on %v, connecting on demand", t.logName(), t.bound.Addr())
+	t.update(func(d *Desc) {
+		d.Status = Open
+		d.Standby = true
+		d.ListenerDown, d.SSHDown = false, false
+		d.LastConn = time.Now()
+	})
+	return nil
+}
+
+// serveOnDemand accepts connections on the bound listener until the tunnel
+// is stopped, connecting to the server whenever it is not connected
+func (t *Tunnel) serveOnDemand() {
+	go func() {
+		<-t.stop
+		t.bound.Close()
+		t.unbind()
+	}()
+
+	var l *demandListener
+	for {
+		conn, err := t.bound.Accept()
+		if err != nil {
+			select {
+			case <-t.stop:
+			default:
+				t.errorf("could not accept: %v", err)
+				t.update(func(d *Desc) { d.ListenerDown = true })
+			}
+			break
+		}
+		// Checked before connecting, rather than when forwarding
+		if !t.allowed(conn.RemoteAddr()) {
+			t.warningf("rejecting connection from %v, not in allow_from", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		for conn != nil {
+			if isDone(t.stop) {
+				conn.Close()
+				break
+			}
+			if l == nil || isDone(t.connDone) {
+				if l, err = t.connectOnDemand(); err != nil {
+					t.errorf("could not connect: %v", err)
+					conn.Close()
+					break
+				}
+			}
+			select {
+			case l.conns <- conn:
+				conn = nil
+			case <-t.connDone:
+				// Disconnected in the meantime, connect again
+			}
+		}
+	}
+
+	t.stopOnce.Do(func() { close(t.stop) })
+	if l != nil {
+		<-t.connDone
+	}
+	t.closeLog()
+	t.update(func(d *Desc) { d.Status = Closed })
+	close(t.Closed)
+}
+
+// connectOnDemand connects to the server and starts forwarding the
+// connections passed to the returned listener
+func (t *Tunnel) connectOnDemand() (*demandListener, error) {
+	if err := t.connectClient(); err != nil {
+		return nil, err
+	}
+	l := &demandListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+		addr:  t.bound.Addr(),
+	}
+	t.listener = l
+	if err := t.established(); err != nil {
+		t.client.Close()
+		return nil, err
+	}
+
+	t.connDone = make(chan struct{})
+	t.touch()
+	go t.watchDemand(t.connDone)
+	go t.run()
+
+	t.infof("connected on demand")
+	t.update(func(d *Desc) {
+		d.Standby = false
+		d.LastConn = time.Now()
+	})
+	return l, nil
+}
+
+// standby is called by run once the connection is gone
+func (t *Tunnel) standby() {
+	t.update(func(d *Desc) {
+		d.Standby = true
+		d.ListenerDown, d.SSHDown = false, false
+	})
+	close(t.connDone)
+}
+
+// watchDemand disconnects once no connection was open and no data was
+// transferred for the idle timeout, keeping the listener bound
+func (t *Tunnel) watchDemand(done chan struct{}) {
+	timeout := defaultDemandIdle
+	if t.IdleTimeout > 0 {
+		timeout = time.Duration(t.IdleTimeout) * time.Second
+	}
+	for {
+		remaining := timeout - time.Since(time.Unix(0, t.lastActive.Load()))
+		if remaining <= 0 {
+			if t.openConns() == 0 {
+				t.infof("disconnecting after being idle for %v", timeout)
+				t.client.Close()
+				return
+			}
+			remaining = timeout
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(remaining):
+		}
+	}
+}
+
+func (t *Tunnel) openConns() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Conns
+}
+
+func isDone(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
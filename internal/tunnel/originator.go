@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// directTCPIP is the payload of a direct-tcpip channel, see RFC 4254 7.2
+type directTCPIP struct {
+	Host     string
+	Port     uint32
+	OrigHost string
+	OrigPort uint32
+}
+
+// originator returns the address and port to announce as the origin of a
+// connection from addr. Peers without one, e.g. on Unix sockets, are
+// announced as 0.0.0.0:0, like ssh.Client.Dial does for all connections.
+func originator(addr net.Addr) (string, uint32) {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+	if ip == nil {
+		return net.IPv4zero.String(), 0
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return ip.String(), uint32(port)
+}
+
+// dialFrom connects to addr from the remote host like ssh.Client.Dial, but
+// announces from as the originator of the channel, like ssh(1) does, so
+// that logs of the server show where forwarded connections come from
+func dialFrom(c *ssh.Client, from net.Addr, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	msg := directTCPIP{Host: host, Port: uint32(port)}
+	msg.OrigHost, msg.OrigPort = originator(from)
+	ch, reqs, err := c.OpenChannel("direct-tcpip", ssh.Marshal(&msg))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return chanConn{ch}, nil
+}
+
+var errNoDeadline = errors.New("deadline not supported on forwarded connections")
+
+// chanConn is a forwarded connection over an SSH channel. Like the ones of
+// ssh.Client.Dial, it has zero addresses.
+type chanConn struct {
+	ssh.Channel
+}
+
+func (chanConn) LocalAddr() net.Addr              { return &net.TCPAddr{IP: net.IPv4zero} }
+func (chanConn) RemoteAddr() net.Addr             { return &net.TCPAddr{IP: net.IPv4zero} }
+func (chanConn) SetDeadline(time.Time) error      { return errNoDeadline }
+func (chanConn) SetReadDeadline(time.Time) error  { return errNoDeadline }
+func (chanConn) SetWriteDeadline(time.Time) error { return errNoDeadline }
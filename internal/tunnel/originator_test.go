@@ -0,0 +1,27 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+)
+
+func TestOriginator(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		host string
+		port uint32
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}, "192.0.2.1", 51234},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 1}, "192.0.2.1", 1},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, "2001:db8::1", 443},
+		{&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}, "127.0.0.1", 53},
+		{&net.UnixAddr{Name: "/tmp/s", Net: "unix"}, "0.0.0.0", 0},
+		{nil, "0.0.0.0", 0},
+	}
+	for _, tt := range tests {
+		host, port := originator(tt.addr)
+		if host != tt.host || port != tt.port {
+			t.Errorf("%v: got %s:%d, want %s:%d", tt.addr, host, port, tt.host, tt.port)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	mrand "math/rand/v2"
+	"time"
+)
+
+const (
+	paddingRequest = "ping@openssh.com"
+	maxPadding     = 64 // bytes
+	minPadInterval = 1  // seconds, so that padding does not flood the connection
+)
+
+// pad sends requests with random payloads of random size while the tunnel
+// is idle, so that network observers cannot tell idle periods and traffic
+// patterns apart, similar to ObscureKeystrokeTiming of OpenSSH. Servers
+// ignore unknown requests that want no reply.
+func (t *Tunnel) pad(cancel chan struct{}) {
+	interv := time.Duration(t.PadInterval) * time.Second
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-time.After(interv):
+		}
+		if time.Since(time.Unix(0, t.lastActive.Load())) < interv {
+			continue
+		}
+		payload := make([]byte, 1+mrand.IntN(maxPadding))
+		rand.Read(payload)
+		if _, _, err := t.client.SendRequest(paddingRequest, false, payload); err != nil {
+			t.debugf("stopping padding: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// portInUse replaces the error of binding an address that is already in
+// use by a clearer one, naming the process holding the port if it can be
+// identified
+func portInUse(network, addr string, err error) error {
+	if !errors.Is(err, syscall.EADDRINUSE) || (network != "tcp" && network != "udp") {
+		return err
+	}
+	_, port, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		return err
+	}
+	if owner := portOwner(network, port); owner != "" {
+		return fmt.Errorf("port %v already in use by %v", port, owner)
+	}
+	return fmt.Errorf("port %v already in use", port)
+}
@@ -0,0 +1,42 @@
+//go:build darwin
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const lsofTimeout = 2 * time.Second
+
+// portOwner returns the name and PID of the process bound to port, or ""
+// if it is unknown, asking lsof
+func portOwner(network, port string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), lsofTimeout)
+	defer cancel()
+	args := []string{"-nP", "-Fpc", "-i" + strings.ToUpper(network) + ":" + port}
+	if network == "tcp" {
+		args = append(args, "-sTCP:LISTEN")
+	}
+	out, err := exec.CommandContext(ctx, "lsof", args...).Output()
+	if err != nil {
+		return ""
+	}
+	// Fields are given one per line, prefixed by their name
+	var pid, cmd string
+	for _, l := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(l, "p") && pid == "":
+			pid = l[1:]
+		case strings.HasPrefix(l, "c") && cmd == "":
+			cmd = l[1:]
+		}
+	}
+	if pid == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s (PID %s)", cmd, pid)
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// State of listening sockets in /proc/net/tcp
+const tcpListen = "0A"
+
+// portOwner returns the name and PID of the process bound to port, or ""
+// if it is unknown, e.g. because the process belongs to another user. The
+// sockets bound to the port are looked up in /proc/net, then the process
+// having one of them open.
+func portOwner(network, port string) string {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return ""
+	}
+	inodes := make(map[string]bool)
+	for _, f := range []string{network, network + "6"} {
+		data, err := os.ReadFile(filepath.Join("/proc/net", f))
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(data), "\n")
+		for _, l := range lines[1:] {
+			// sl local_address rem_address st ... inode
+			fields := strings.Fields(l)
+			if len(fields) < 10 || (network == "tcp" && fields[3] != tcpListen) {
+				continue
+			}
+			_, hexPort, _ := strings.Cut(fields[1], ":")
+			if lp, err := strconv.ParseUint(hexPort, 16, 16); err == nil && lp == p {
+				inodes["socket:["+fields[9]+"]"] = true
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return ""
+	}
+
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, err := os.ReadDir(filepath.Join(proc, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if err != nil || !inodes[link] {
+				continue
+			}
+			comm, _ := os.ReadFile(filepath.Join(proc, "comm"))
+			return fmt.Sprintf("%s (PID %s)", strings.TrimSpace(string(comm)), filepath.Base(proc))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package tunnel
+
+// portOwner is not implemented on this platform
+func portOwner(network, port string) string {
+	return ""
+}
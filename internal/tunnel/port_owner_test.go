@@ -0,0 +1,38 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	_, err = listen("tcp", addr)
+	if err == nil {
+		t.Fatal("expected listening to fail")
+	}
+	err = portInUse("tcp", addr, err)
+	if !strings.HasPrefix(err.Error(), "port "+port+" already in use") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The process holding the port is this one
+	if runtime.GOOS == "linux" && !strings.HasSuffix(err.Error(), fmt.Sprintf("(PID %d)", os.Getpid())) {
+		t.Errorf("process not identified: %v", err)
+	}
+
+	// Other errors are kept
+	if _, err = listen("tcp", "127.0.0.1:-1"); portInUse("tcp", "127.0.0.1:-1", err) != err {
+		t.Errorf("error was replaced: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Signature starting headers of version 2 of the PROXY protocol, see
+// https://www.haproxy.org/download/3.0/doc/proxy-protocol.txt
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2Local = 0x20
+	proxyV2Proxy = 0x21
+	proxyV2TCP4  = 0x11
+	proxyV2TCP6  = 0x21
+)
+
+// proxyHeader returns the header of the given PROXY protocol version that
+// announces a connection from src to dst to the backend. Connections not
+// over TCP, e.g. on unix sockets, are announced without addresses, so the
+// backend uses its own.
+func proxyHeader(version int, src, dst net.Addr) []byte {
+	s, ok1 := src.(*net.TCPAddr)
+	d, ok2 := dst.(*net.TCPAddr)
+	known := ok1 && ok2
+	v4 := known && s.IP.To4() != nil && d.IP.To4() != nil
+
+	if version == 1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		proto, sIP, dIP := "TCP4", s.IP.String(), d.IP.String()
+		if !v4 {
+			proto, sIP, dIP = "TCP6", ipv6String(s.IP), ipv6String(d.IP)
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", proto, sIP, dIP, s.Port, d.Port)
+	}
+
+	h := append([]byte(nil), proxyV2Sig...)
+	if !known {
+		return append(h, proxyV2Local, 0, 0, 0)
+	}
+	family, sIP, dIP := byte(proxyV2TCP6), []byte(s.IP.To16()), []byte(d.IP.To16())
+	if v4 {
+		family, sIP, dIP = proxyV2TCP4, s.IP.To4(), d.IP.To4()
+	}
+	h = append(h, proxyV2Proxy, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(2*len(sIP)+4))
+	h = append(h, sIP...)
+	h = append(h, dIP...)
+	h = binary.BigEndian.AppendUint16(h, uint16(s.Port))
+	return binary.BigEndian.AppendUint16(h, uint16(d.Port))
+}
+
+// ipv6String formats ip as IPv6 address, also if it is an IPv4 one, which
+// is needed if source and destination are of different families
+func ipv6String(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,53 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestProxyHeaderV1(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	unix := &net.UnixAddr{Name: "/tmp/s", Net: "unix"}
+
+	tests := []struct {
+		src, dst net.Addr
+		want     string
+	}{
+		{v4, v4dst, "PROXY TCP4 192.0.2.1 127.0.0.1 51234 8080\r\n"},
+		{v6, v6, "PROXY TCP6 2001:db8::1 2001:db8::1 443 443\r\n"},
+		{v4, v6, "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::1 51234 443\r\n"},
+		{unix, unix, "PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(proxyHeader(1, tt.src, tt.dst)); got != tt.want {
+			t.Errorf("%v -> %v: got %q, want %q", tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestProxyHeaderV2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 0x1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0x50}
+	want := append(append([]byte(nil), proxyV2Sig...),
+		0x21, 0x11, 0, 12,
+		192, 0, 2, 1,
+		127, 0, 0, 1,
+		0x12, 0x34, 0, 0x50)
+	if got := proxyHeader(2, src, dst); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	if got := proxyHeader(2, v6, dst); len(got) != 16+36 || got[13] != 0x21 {
+		t.Errorf("unexpected TCP6 header %x", got)
+	}
+
+	unix := &net.UnixAddr{Name: "/tmp/s", Net: "unix"}
+	want = append(append([]byte(nil), proxyV2Sig...), 0x20, 0, 0, 0)
+	if got := proxyHeader(2, unix, unix); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
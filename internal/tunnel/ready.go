@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NotReady indicates a tunnel that does not forward connections in time
+var NotReady = errors.New("not ready")
+
+// WaitReady blocks until the tunnel forwards connections, or fails once
+// timeout has passed. Open returns when the tunnel is connected and
+// listening already, but a tunnel may be re-connecting by now, or wait for
+// its scheduled window. On-demand tunnels are ready while listening, as
+// they connect for the first connection they accept. Disarmed tunnels do
+// not get ready by themselves, so they fail right away.
+func (t *Tunnel) WaitReady(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		d, changed := t.watch()
+		switch {
+		case d.Status == Closed:
+			return fmt.Errorf("%w: tunnel closed", NotReady)
+		case d.Disarmed && t.armable():
+			return fmt.Errorf("%w: disarmed, not listening until armed", NotReady)
+		case d.Status == Open && !d.Scheduled && !d.ListenerDown:
+			return nil
+		}
+		select {
+		case <-changed:
+			continue
+		case <-timer.C:
+		}
+		if d.Scheduled {
+			return fmt.Errorf("%w: connecting at %v as scheduled", NotReady,
+				d.NextOpen.Format(time.DateTime))
+		}
+		if d.Status == Reconn {
+			return fmt.Errorf("%w after %v: re-connecting", NotReady, timeout)
+		}
+		return fmt.Errorf("%w after %v", NotReady, timeout)
+	}
+}
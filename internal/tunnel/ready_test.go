@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitReady(t *testing.T) {
+	tun := FromDesc(&Desc{Mode: Local, Status: Open})
+	if err := tun.WaitReady(time.Second); err != nil {
+		t.Errorf("open tunnel not ready: %v", err)
+	}
+
+	tun = FromDesc(&Desc{Mode: Local, Status: Open, Disarmed: true, OnDemand: true})
+	if err := tun.WaitReady(time.Second); err != nil {
+		t.Errorf("on-demand tunnel not ready: %v", err)
+	}
+
+	tun = FromDesc(&Desc{Mode: Local, Status: Reconn})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tun.update(func(d *Desc) { d.Status = Open })
+	}()
+	if err := tun.WaitReady(5 * time.Second); err != nil {
+		t.Errorf("re-connected tunnel not ready: %v", err)
+	}
+
+	tests := []struct {
+		desc Desc
+		want string
+	}{
+		{Desc{Mode: Local, Status: Reconn}, "re-connecting"},
+		{Desc{Mode: Local, Status: Open, Scheduled: true}, "as scheduled"},
+		{Desc{Mode: Local, Status: Open, Disarmed: true}, "disarmed"},
+		{Desc{Mode: Local}, "closed"},
+	}
+	for _, tt := range tests {
+		err := FromDesc(&tt.desc).WaitReady(50 * time.Millisecond)
+		if !errors.Is(err, NotReady) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected %v mentioning %q, got %v", tt.desc, NotReady, tt.want, err)
+		}
+	}
+}
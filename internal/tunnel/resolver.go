@@ -0,0 +1,52 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+const defaultDNSPort = "53"
+
+// newResolver returns a resolver querying the DNS server at addr, which
+// may omit the port. An empty addr yields the system resolver.
+func newResolver(addr string) (*net.Resolver, error) {
+	if addr == "" {
+		return net.DefaultResolver, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultDNSPort)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("must be an IP address, found %q", host)
+	}
+
+	var d net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
+
+// resolveAddr resolves the host part of a tcp address using r. Unix
+// sockets, IP addresses and empty hosts are returned as is.
+func resolveAddr(r *net.Resolver, a *address) (string, error) {
+	if a.net != "tcp" {
+		return a.addr, nil
+	}
+	host, port, err := net.SplitHostPort(a.addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return a.addr, nil
+	}
+	ips, err := r.LookupHost(context.Background(), host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0], port), nil
+}
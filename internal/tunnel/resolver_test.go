@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers A queries for any name with ip, until the returned
+// connection is closed.
+func serveDNS(t *testing.T, ip [4]byte) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) == 0 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+				Questions: req.Questions,
+			}
+			if q.Type == dnsmessage.TypeA {
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+					Body:   &dnsmessage.AResource{A: ip},
+				}}
+			}
+			b, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteToUDP(b, from)
+		}
+	}()
+	return conn
+}
+
+func TestNewResolver(t *testing.T) {
+	r, err := newResolver("")
+	if err != nil || r != net.DefaultResolver {
+		t.Errorf("expected system resolver, got %v, %v", r, err)
+	}
+	for _, a := range []string{"10.0.0.53", "10.0.0.53:5353", "[::1]:53"} {
+		if _, err := newResolver(a); err != nil {
+			t.Errorf("%s: %v", a, err)
+		}
+	}
+	if _, err := newResolver("dns.example.com"); err == nil {
+		t.Error("expected error for non-IP resolver")
+	}
+}
+
+func TestResolveAddr(t *testing.T) {
+	conn := serveDNS(t, [4]byte{127, 0, 0, 2})
+	defer conn.Close()
+
+	r, err := newResolver(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	cases := []struct {
+		in   address
+		want string
+	}{
+		{address{"db.internal.test:5432", "tcp"}, "127.0.0.2:5432"},
+		{address{"127.0.0.1:5432", "tcp"}, "127.0.0.1:5432"},
+		{address{":5432", "tcp"}, ":5432"},
+		{address{"/tmp/sock", "unix"}, "/tmp/sock"},
+	}
+	for _, c := range cases {
+		got, err := resolveAddr(r, &c.in)
+		if err != nil {
+			t.Errorf("%s: %v", c.in.addr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.in.addr, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+const (
+	// scheduleHorizon bounds the search for the next start of a schedule,
+	// which finds none for expressions like "0 0 30 2 *"
+	scheduleHorizon = 5 * 366 * 24 * time.Hour
+	// maxWindowMerges bounds merging overlapping windows, which would
+	// never end for windows longer than the schedule's interval
+	maxWindowMerges = 1000
+)
+
+var errWindowEnd = errors.New("scheduled window ended")
+
+// Shorthands for common schedules, as in cron(8)
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cron is a parsed cron expression, with the allowed values of each field
+// as bits. Times are matched in the local time zone, like cron(8) does.
+type cron struct {
+	min, hour, dom, month, dow uint64
+	// Like in cron(8), a day matches if either of day of month and day
+	// of week matches, unless one of them is unrestricted
+	domAny, dowAny bool
+}
+
+// parseCron parses an expression of the form "min hour dom month dow",
+// where each field is *, a value, a range a-b, any of them with a /step,
+// or a comma separated list of these
+func parseCron(expr string) (*cron, error) {
+	if m, ok := cronMacros[expr]; ok {
+		expr = m
+	}
+	f := strings.Fields(expr)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day month weekday), found %d", len(f))
+	}
+	c := &cron{domAny: f[2] == "*", dowAny: f[4] == "*"}
+	var err error
+	for i, p := range []struct {
+		bits     *uint64
+		name     string
+		min, max int
+	}{
+		{&c.min, "minute", 0, 59},
+		{&c.hour, "hour", 0, 23},
+		{&c.dom, "day of month", 1, 31},
+		{&c.month, "month", 1, 12},
+		{&c.dow, "day of week", 0, 7},
+	} {
+		if *p.bits, err = parseCronField(f[i], p.min, p.max); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.name, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				// Like cron(8), a/n means from a to the maximum
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool { return bits&(1<<v) != 0 }
+
+func (c *cron) dayMatches(t time.Time) bool {
+	if !has(c.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time the schedule matches after t, or the zero
+// time if it does not match within the horizon
+func (c *cron) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(scheduleHorizon)
+	for t.Before(limit) {
+		if !c.dayMatches(t) {
+			y, m, d := t.Date()
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(c.hour, t.Hour()) {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !has(c.min, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// window returns the window of the schedule that is open at now, or else
+// the next one, each lasting for the given duration. Overlapping windows
+// are merged. The start is zero if there is no next window.
+func (c *cron) window(now time.Time, d time.Duration) (start, end time.Time) {
+	if start = c.next(now.Add(-d)); start.IsZero() {
+		return
+	}
+	end = start.Add(d)
+	for i, s := 0, start; i < maxWindowMerges; i++ {
+		if s = c.next(s); s.IsZero() || s.After(end) {
+			break
+		}
+		end = s.Add(d)
+	}
+	return
+}
+
+// prepareSchedule parses the schedule of the tunnel, if it has one
+func (t *Tunnel) prepareSchedule() (err error) {
+	if t.Schedule == "" {
+		if t.Window != 0 {
+			return fmt.Errorf("schedule_window requires a schedule")
+		}
+		return nil
+	}
+	if t.OnDemand || t.IdleTimeout > 0 {
+		return fmt.Errorf("schedule is not supported with on_demand or idle_timeout")
+	}
+	if t.Window <= 0 {
+		return fmt.Errorf("schedule_window must be a positive number of seconds, found %d", t.Window)
+	}
+	if t.sched, err = parseCron(t.Schedule); err != nil {
+		return fmt.Errorf("schedule %q: %w", t.Schedule, err)
+	}
+	if start, _ := t.sched.window(time.Now(), t.window()); start.IsZero() {
+		return fmt.Errorf("schedule %q never matches", t.Schedule)
+	}
+	return nil
+}
+
+func (t *Tunnel) window() time.Duration {
+	return time.Duration(t.Window) * time.Second
+}
+
+// openScheduled registers the tunnel, which connects for each window of
+// its schedule, see runSchedule. Within a window, it connects right away,
+// so that errors are reported like for other tunnels.
+func (t *Tunnel) openScheduled() error {
+	t.stop = make(chan struct{})
+	t.Closed = make(chan struct{})
+	start, end := t.sched.window(time.Now(), t.window())
+	t.update(func(d *Desc) { d.NextOpen, d.NextClose = start, end })
+
+	var timer *time.Timer
+	if !start.After(time.Now()) {
+		timer = t.beginWindow(end)
+		if err := t.Open(); err != nil {
+			timer.Stop()
+			t.stop, t.Closed = nil, nil
+			return err
+		}
+	} else {
+		log.Infof("%v: connecting at %v as scheduled", t.logName(), start.Format(time.DateTime))
+		t.update(func(d *Desc) {
+			d.Status = Open
+			d.Scheduled = true
+			d.ListenerDown, d.SSHDown = false, false
+			d.LastConn = time.Now()
+		})
+	}
+	if t.TTL > 0 {
+		t.expireAfterTTL()
+	}
+	go t.runSchedule(timer)
+	return nil
+}
+
+// beginWindow prepares connecting for a window lasting until end, returning
+// the timer that closes windowEnd then
+func (t *Tunnel) beginWindow(end time.Time) *time.Timer {
+	windowEnd := make(chan struct{})
+	t.windowEnd, t.connDone = windowEnd, make(chan struct{})
+	t.update(func(d *Desc) { d.Scheduled = false })
+	return time.AfterFunc(time.Until(end), func() { close(windowEnd) })
+}
+
+// runSchedule connects at the start of every window of the schedule and
+// disconnects at its end, until the tunnel is closed. If timer is set,
+// the tunnel is connected for the current window already.
+func (t *Tunnel) runSchedule(timer *time.Timer) {
+	for {
+		if timer == nil {
+			start, end := t.sched.window(time.Now(), t.window())
+			if start.IsZero() {
+				t.warningf("schedule %q does not match anymore, closing", t.Schedule)
+				break
+			}
+			t.update(func(d *Desc) { d.NextOpen, d.NextClose = start, end })
+			if wait := time.Until(start); wait > 0 {
+				t.update(func(d *Desc) { d.Scheduled = true })
+				t.infof("connecting at %v as scheduled", start.Format(time.DateTime))
+				select {
+				case <-t.stop:
+				case <-time.After(wait):
+				}
+			}
+			if isDone(t.stop) {
+				break
+			}
+
+			timer = t.beginWindow(end)
+			t.infof("connecting until %v as scheduled", end.Format(time.DateTime))
+			err := t.Open()
+			if err != nil {
+				t.errorf("could not connect: %v", err)
+				err = t.reconnectLoop(0)
+			}
+			if err != nil {
+				if !errors.Is(err, errWindowEnd) {
+					t.errorf("could not connect in scheduled window: %v", err)
+				}
+				t.endWindow()
+			}
+		}
+
+		select {
+		case <-t.connDone:
+		case <-t.Closed:
+			timer.Stop()
+			return
+		}
+		// A window ending early, e.g. because re-connecting failed, is
+		// not started again
+		select {
+		case <-t.windowEnd:
+		case <-t.stop:
+			timer.Stop()
+		}
+		timer = nil
+		if isDone(t.stop) {
+			break
+		}
+	}
+
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.closeLog()
+	t.update(func(d *Desc) { d.Status = Closed })
+	close(t.Closed)
+}
+
+// endWindow is called once the connection of a window is gone, or could
+// not be established
+func (t *Tunnel) endWindow() {
+	t.update(func(d *Desc) {
+		d.Status = Open
+		d.Scheduled = true
+		d.ListenerDown, d.SSHDown = false, false
+	})
+	close(t.connDone)
+}
@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func at(s string) time.Time {
+	t, err := time.ParseInLocation(time.DateTime, s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCronNext(t *testing.T) {
+	for _, tc := range []struct {
+		expr, after, want string
+	}{
+		{"0 2 * * *", "2026-03-10 01:00:00", "2026-03-10 02:00:00"},
+		{"0 2 * * *", "2026-03-10 02:00:00", "2026-03-11 02:00:00"},
+		{"*/15 * * * *", "2026-03-10 02:01:30", "2026-03-10 02:15:00"},
+		{"30 8-10/2 * * *", "2026-03-10 09:00:00", "2026-03-10 10:30:00"},
+		{"0 0 1,15 * *", "2026-03-02 00:00:00", "2026-03-15 00:00:00"},
+		{"0 0 * * 7", "2026-03-10 00:00:00", "2026-03-15 00:00:00"},  // a Sunday
+		{"0 0 13 * 5", "2026-03-10 00:00:00", "2026-03-13 00:00:00"}, // day of month or week
+		{"0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"@monthly", "2026-12-05 00:00:00", "2027-01-01 00:00:00"},
+	} {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := c.next(at(tc.after)); !got.Equal(at(tc.want)) {
+			t.Errorf("%s after %s: got %v, want %s", tc.expr, tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 5-3 * * *",
+		"* * 0 * *", "*/0 * * * *", "a * * * *", "* * * 13 *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestCronWindow(t *testing.T) {
+	c, err := parseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := 2 * time.Hour
+
+	// Within a window, it is returned
+	start, end := c.window(at("2026-03-10 03:00:00"), d)
+	if !start.Equal(at("2026-03-10 02:00:00")) || !end.Equal(at("2026-03-10 04:00:00")) {
+		t.Errorf("got window %v to %v", start, end)
+	}
+	// At its end, the next one is
+	start, _ = c.window(at("2026-03-10 04:00:00"), d)
+	if !start.Equal(at("2026-03-11 02:00:00")) {
+		t.Errorf("got next window at %v", start)
+	}
+
+	// Overlapping windows are merged
+	if c, err = parseCron("0 * * * *"); err != nil {
+		t.Fatal(err)
+	}
+	start, end = c.window(at("2026-03-10 03:30:00"), 90*time.Minute)
+	if !start.Before(at("2026-03-10 03:30:00")) || !end.After(at("2026-03-10 05:00:00")) {
+		t.Errorf("windows not merged: %v to %v", start, end)
+	}
+}
+
+func TestPrepareSchedule(t *testing.T) {
+	for _, d := range []Desc{
+		{Schedule: "0 2 * * *"},
+		{Window: 60},
+		{Schedule: "0 2 * * *", Window: 60, OnDemand: true},
+		{Schedule: "0 2 * *", Window: 60},
+		{Schedule: "0 0 31 2 *", Window: 60},
+	} {
+		if err := FromDesc(&d).prepareSchedule(); err == nil {
+			t.Errorf("%+v: expected error", d)
+		}
+	}
+	tun := FromDesc(&Desc{Schedule: "@daily", Window: 60})
+	if err := tun.prepareSchedule(); err != nil || tun.sched == nil {
+		t.Errorf("expected schedule to be parsed, got %v", err)
+	}
+}
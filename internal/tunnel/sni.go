@@ -0,0 +1,163 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// sniPeekTimeout bounds the wait for the ClientHello of a connection in sni
+// mode. Clients that do not speak first are routed as without SNI after it.
+const sniPeekTimeout = 10 * time.Second
+
+// noSNIReject is the value of no_sni that closes connections without SNI
+const noSNIReject = "reject"
+
+// Routes maps TLS server names, or patterns like *.example.com matching
+// their subdomains, to the remote targets of a tunnel in sni mode
+type Routes map[string]string
+
+var errPeeked = errors.New("peeked")
+
+// readOnlyConn lets a TLS server read the ClientHello, but not respond
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// peekSNI reads the ClientHello of conn without terminating TLS, returning
+// the server name it asks for, "" if none or not TLS, and the bytes read,
+// which must be sent on to the target first
+func peekSNI(conn net.Conn) (string, []byte, error) {
+	var peeked bytes.Buffer
+	var sni string
+	conn.SetReadDeadline(time.Now().Add(sniPeekTimeout))
+	err := tls.Server(readOnlyConn{conn, io.TeeReader(conn, &peeked)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni = h.ServerName
+			return nil, errPeeked
+		},
+	}).Handshake()
+	conn.SetReadDeadline(time.Time{})
+
+	// Anything but a ClientHello, e.g., plain HTTP, has no SNI. Only if
+	// nothing arrived at all, the connection is of no use.
+	var ne net.Error
+	if !errors.Is(err, errPeeked) && peeked.Len() == 0 &&
+		!(errors.As(err, &ne) && ne.Timeout()) {
+		return "", nil, err
+	}
+	return strings.ToLower(sni), peeked.Bytes(), nil
+}
+
+// prepareRoutes parses the routes and no_sni target of a tunnel in sni mode
+func (t *Tunnel) prepareRoutes() error {
+	if len(t.Routes) == 0 {
+		return fmt.Errorf("routes: required in sni mode")
+	}
+	t.routes = make(map[string]*address, len(t.Routes))
+	for name, target := range t.Routes {
+		if err := validateServerName(name); err != nil {
+			return fmt.Errorf("routes: %w", err)
+		}
+		if err := validateAddr(target, false); err != nil {
+			return fmt.Errorf("routes: %v: %w", name, err)
+		}
+		t.routes[strings.ToLower(name)], _ = parseAddr(target, false)
+	}
+	if t.NoSNI != "" && t.NoSNI != noSNIReject {
+		if err := validateAddr(t.NoSNI, false); err != nil {
+			return fmt.Errorf("no_sni: %w", err)
+		}
+		t.noSNI, _ = parseAddr(t.NoSNI, false)
+	}
+	return nil
+}
+
+func validateServerName(name string) error {
+	host := strings.TrimPrefix(name, "*.")
+	if host == "" || strings.ContainsAny(host, ":*") {
+		return fmt.Errorf("%q is not a server name or *.domain pattern", name)
+	}
+	return validateHost(host)
+}
+
+// route returns the target for a connection asking for server name sni:
+// the route of the name, else of the closest pattern, else the remote
+// address. Connections without SNI go to no_sni, if set. Returns nil if
+// there is no target.
+func (t *Tunnel) route(sni string) *address {
+	if sni == "" {
+		if t.NoSNI == noSNIReject {
+			return nil
+		}
+		if t.noSNI != nil {
+			return t.noSNI
+		}
+	} else {
+		if a, ok := t.routes[sni]; ok {
+			return a
+		}
+		for d := sni; ; {
+			_, rest, ok := strings.Cut(d, ".")
+			if !ok {
+				break
+			}
+			if a, ok := t.routes["*."+rest]; ok {
+				return a
+			}
+			d = rest
+		}
+	}
+	if t.RemoteAddress == "" {
+		return nil
+	}
+	return t.remoteAddr
+}
+
+func (t *Tunnel) handleSNI() {
+	for {
+		conn1, err := t.accept()
+		if err != nil {
+			t.errorf("could not accept: %v", err)
+			return
+		}
+		go t.waitFor(func() {
+			sni, peeked, err := peekSNI(conn1)
+			if err != nil {
+				t.debugf("could not read ClientHello from %v: %v", conn1.RemoteAddr(), err)
+				conn1.Close()
+				return
+			}
+			addr := t.route(sni)
+			if addr == nil {
+				t.warningf("rejecting connection from %v, no route for server name %q",
+					conn1.RemoteAddr(), sni)
+				conn1.Close()
+				return
+			}
+			t.debugf("routing connection for server name %q to %v", sni, addr.addr)
+			conn2, err := t.dial(conn1.RemoteAddr(), addr.net, addr.addr)
+			if err != nil {
+				t.errorf("could not dial: %v", err)
+				conn1.Close()
+				return
+			}
+			if _, err := conn2.Write(peeked); err != nil {
+				t.errorf("could not forward ClientHello: %v", err)
+				conn1.Close()
+				conn2.Close()
+				return
+			}
+			tunnel(conn1, conn2, copyBufPool(t.CopyBufSize))
+		})
+	}
+}
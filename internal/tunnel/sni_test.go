@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// The server name is read from the ClientHello, which is kept intact
+func TestPeekSNI(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "DB.example.com"}).Handshake()
+		client.Close()
+	}()
+
+	sni, peeked, err := peekSNI(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sni != "db.example.com" {
+		t.Errorf("got server name %q", sni)
+	}
+	if len(peeked) < 5 || peeked[0] != 0x16 {
+		t.Errorf("peeked bytes are not a TLS handshake record: %x", peeked)
+	}
+}
+
+// Other protocols are passed on without a server name
+func TestPeekSNIPlain(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	req := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	go func() {
+		client.Write(req)
+		client.Close()
+	}()
+
+	sni, peeked, err := peekSNI(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sni != "" {
+		t.Errorf("got server name %q", sni)
+	}
+	if !bytes.HasPrefix(req, peeked) || len(peeked) == 0 {
+		t.Errorf("peeked bytes %q are not the start of the request", peeked)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	d := &Desc{
+		Mode:          Sni,
+		LocalAddress:  "localhost:8443",
+		RemoteAddress: "fallback:443",
+		Routes: Routes{
+			"db.example.com":  "db:443",
+			"*.example.com":   "web:443",
+			"*.a.example.com": "a:443",
+		},
+	}
+	tests := []struct {
+		sni, noSNI, want string
+	}{
+		{"db.example.com", "", "db:443"},
+		{"www.example.com", "", "web:443"},
+		{"x.a.example.com", "", "a:443"},
+		{"x.y.example.com", "", "web:443"},
+		{"example.com", "", "fallback:443"},
+		{"", "", "fallback:443"},
+		{"", "plain:80", "plain:80"},
+		{"", noSNIReject, ""},
+	}
+	for _, tt := range tests {
+		d.NoSNI = tt.noSNI
+		tun := FromDesc(d)
+		if err := tun.validateAddrs(); err != nil {
+			t.Fatal(err)
+		}
+		tun.remoteAddr, _ = parseAddr(string(d.RemoteAddress), false)
+		if err := tun.prepareRoutes(); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if a := tun.route(tt.sni); a != nil {
+			got = a.addr
+		}
+		if got != tt.want {
+			t.Errorf("%q (no_sni %q): got %q, want %q", tt.sni, tt.noSNI, got, tt.want)
+		}
+	}
+
+	// Without a remote address, unknown names have no target
+	d.RemoteAddress, d.NoSNI = "", ""
+	tun := FromDesc(d)
+	if err := tun.validateAddrs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tun.prepareRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	if a := tun.route("other.org"); a != nil {
+		t.Errorf("got target %v for unknown name", a)
+	}
+}
+
+func TestPrepareRoutesInvalid(t *testing.T) {
+	for _, routes := range []Routes{
+		nil,
+		{"db.example.com": "db"},
+		{"db.example.com:443": "db:443"},
+		{"*": "db:443"},
+		{"db.*.com": "db:443"},
+	} {
+		tun := FromDesc(&Desc{Mode: Sni, Routes: routes})
+		if err := tun.prepareRoutes(); err == nil {
+			t.Errorf("%v: accepted", routes)
+		}
+	}
+}
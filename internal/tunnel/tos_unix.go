@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package tunnel
+
+import (
+	"strings"
+	"syscall"
+)
+
+// setTOS sets the IPv4 ToS byte or IPv6 traffic class of a socket
+func setTOS(network string, c syscall.RawConn, tos int) error {
+	var err error
+	ctrlErr := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package tunnel
+
+import (
+	"syscall"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// setTOS is a no-op on Windows, which ignores IP_TOS unless configured
+// via group policy
+func setTOS(_ string, _ syscall.RawConn, _ int) error {
+	log.Warningf("IPQoS is not supported on Windows, ignoring")
+	return nil
+}
@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HopTrace is the outcome of connecting to one hop of a jump chain
+type HopTrace struct {
+	Addr      string        // host name and port of the hop
+	User      string        // user authenticating to the hop
+	Dial      time.Duration // time until the TCP connection, and TLS if wrapped, was established
+	Handshake time.Duration // time of the SSH handshake including authentication
+	Err       error         // nil if the hop was reached
+	Stage     string        // step that failed, "tcp", "tls" or "ssh"
+}
+
+// Trace connects to the hops of a [user@]host one after the other, like
+// Connect does, and calls report for each. It stops at the first hop that
+// cannot be reached and returns its error. No forward is established and
+// all connections are closed before returning.
+func Trace(host string, report func(*HopTrace)) error {
+	hops, err := hostHops(host)
+	if err != nil {
+		return err
+	}
+	if len(hops) == 0 {
+		return fmt.Errorf("no connections specified")
+	}
+
+	var clients []*ssh.Client
+	defer func() {
+		// Close from the final hop on, as it is carried by the previous ones
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}()
+
+	var c *ssh.Client
+	for _, hop := range hops {
+		h := &HopTrace{Addr: fmt.Sprintf("%v:%v", hop.HostName, hop.Port), User: hop.User}
+		start := time.Now()
+		conn, err := dialHop(c, h.Addr, hop, nil)
+		h.Stage = "tcp"
+		if err == nil && hop.TLS != nil {
+			conn, err = wrapTLS(conn, hop)
+			h.Stage = "tls"
+		}
+		h.Dial = time.Since(start)
+		if err == nil {
+			start = time.Now()
+			c, err = handshakeHop(conn, h.Addr, hop)
+			h.Handshake = time.Since(start)
+			h.Stage = "ssh"
+		}
+		h.Err = err
+		report(h)
+		if err != nil {
+			return fmt.Errorf("could not connect to host %v: %w", h.Addr, err)
+		}
+		clients = append(clients, c)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package tunnel
+
+import (
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// expireAfterTTL closes the tunnel once its TTL has elapsed since it was
+// first opened, regardless of activity. The expiry is part of the
+// description, so that it is kept when the daemon restores the tunnel.
+func (t *Tunnel) expireAfterTTL() {
+	ttl := time.Duration(t.TTL) * time.Second
+	t.update(func(d *Desc) {
+		if d.Expires.IsZero() {
+			d.Expires = time.Now().Add(ttl)
+		}
+	})
+	timer := time.NewTimer(time.Until(t.Describe().Expires))
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-t.Closed:
+		case <-timer.C:
+			log.Infof("%v: closing after its TTL of %v", t.logName(), ttl)
+			t.Close()
+		}
+	}()
+}
@@ -0,0 +1,184 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// Values of the tun@openssh.com channel, see PROTOCOL in OpenSSH
+const (
+	tunChannelType      = "tun@openssh.com"
+	tunModePointToPoint = 1
+	tunUnitAny          = 0x7fffffff
+	// Address families prefixed to each packet, which OpenSSH always
+	// sends with their OpenBSD values
+	tunAFInet  = 2
+	tunAFInet6 = 24
+)
+
+type tunRequest struct {
+	Mode uint32
+	Unit uint32
+}
+
+// parseTunUnit parses a tun device number like ssh(1)'s TunnelDevice,
+// where an empty value or "any" lets the respective side choose.
+func parseTunUnit(s string) (uint32, error) {
+	if s == "" || s == "any" {
+		return tunUnitAny, nil
+	}
+	u, err := strconv.ParseUint(s, 10, 31)
+	if err != nil || u == tunUnitAny {
+		return 0, fmt.Errorf("invalid tun device %q", s)
+	}
+	return uint32(u), nil
+}
+
+// makeTun creates the local tun device, which is kept across re-connects,
+// and opens the channel to the server's device.
+func (t *Tunnel) makeTun() error {
+	created := false
+	if t.tun == nil {
+		dev, name, err := openTun(t.tunUnits[0])
+		if err != nil {
+			return err
+		}
+		log.Infof("%v: created tun device %v", t.logName(), name)
+		t.tun, created = dev, true
+	}
+
+	req := tunRequest{Mode: tunModePointToPoint, Unit: t.tunUnits[1]}
+	ch, reqs, err := t.client.OpenChannel(tunChannelType, ssh.Marshal(&req))
+	if err != nil {
+		if created {
+			t.closeTun()
+		}
+		return err
+	}
+	go ssh.DiscardRequests(reqs)
+	t.tunCh = ch
+	return nil
+}
+
+func (t *Tunnel) closeTun() {
+	if t.tun != nil {
+		t.tun.Close()
+		t.tun = nil
+	}
+}
+
+// handleTun bridges packets between the tun device and the channel until
+// either side fails. Both directions are done once it returns, so that the
+// device, which is kept across re-connects, is only ever read for one
+// channel.
+func (t *Tunnel) handleTun() {
+	var wg sync.WaitGroup
+	done := make(chan struct{}, 2)
+	wg.Go(func() {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 65535)
+		for {
+			n, err := t.tun.Read(buf)
+			if err == nil {
+				t.touch()
+				err = writePacket(t.tunCh, buf[:n])
+			}
+			if err != nil {
+				if !errors.Is(err, os.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded) &&
+					err != io.EOF {
+					t.errorf("could not forward packet: %v", err)
+				}
+				return
+			}
+		}
+	})
+	wg.Go(func() {
+		defer func() { done <- struct{}{} }()
+		r := bufio.NewReader(t.tunCh)
+		for {
+			pkt, err := readPacket(r)
+			if err == nil {
+				t.touch()
+				_, err = t.tun.Write(pkt)
+			}
+			if err != nil {
+				if err != io.EOF {
+					t.errorf("could not receive packet: %v", err)
+				}
+				return
+			}
+		}
+	})
+	<-done
+
+	// Stop the other direction. Reads of the device are interrupted by a
+	// deadline, as it stays open.
+	t.tunCh.Close()
+	dl, ok := t.tun.(interface{ SetReadDeadline(time.Time) error })
+	if ok {
+		dl.SetReadDeadline(time.Now())
+	}
+	wg.Wait()
+	if ok {
+		dl.SetReadDeadline(time.Time{})
+	}
+}
+
+// writePacket sends an IP packet, prefixed with its address family. The
+// packet is written at once so that it is sent as a single message, which
+// is how OpenSSH expects packets on the channel.
+func writePacket(w io.Writer, pkt []byte) error {
+	if len(pkt) == 0 {
+		return nil
+	}
+	var af uint32
+	switch pkt[0] >> 4 {
+	case 4:
+		af = tunAFInet
+	case 6:
+		af = tunAFInet6
+	default:
+		// Not an IP packet, drop it
+		return nil
+	}
+	buf := make([]byte, 4+len(pkt))
+	binary.BigEndian.PutUint32(buf, af)
+	copy(buf[4:], pkt)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readPacket reads the next IP packet from r. Channels in x/crypto do not
+// preserve message boundaries, so the length is taken from the IP header.
+func readPacket(r *bufio.Reader) ([]byte, error) {
+	hdr, err := r.Peek(10)
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch hdr[4] >> 4 {
+	case 4:
+		if n = int(binary.BigEndian.Uint16(hdr[6:8])); n < 20 {
+			return nil, fmt.Errorf("invalid IPv4 packet length %d", n)
+		}
+	case 6:
+		n = 40 + int(binary.BigEndian.Uint16(hdr[8:10]))
+	default:
+		return nil, fmt.Errorf("unknown IP version %d", hdr[4]>>4)
+	}
+	buf := make([]byte, 4+n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf[4:], nil
+}
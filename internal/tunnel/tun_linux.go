@@ -0,0 +1,46 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openTun creates a point-to-point tun device with the given unit number,
+// or the next free one if it is tunUnitAny.
+func openTun(unit uint32) (io.ReadWriteCloser, string, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not open /dev/net/tun: %v", err)
+	}
+
+	name := "tun%d"
+	if unit != tunUnitAny {
+		name = fmt.Sprintf("tun%d", unit)
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		if errors.Is(err, unix.EPERM) {
+			return nil, "", fmt.Errorf("creating tun devices requires CAP_NET_ADMIN")
+		}
+		return nil, "", fmt.Errorf("could not create tun device: %v", err)
+	}
+
+	// Non-blocking, so that closing the file interrupts pending reads
+	if err = unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	return os.NewFile(uintptr(fd), "/dev/net/tun"), ifr.Name(), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+func openTun(_ uint32) (io.ReadWriteCloser, string, error) {
+	return nil, "", fmt.Errorf("tun mode is not supported on %s", runtime.GOOS)
+}
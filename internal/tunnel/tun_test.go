@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func ipv4Packet(payload int) []byte {
+	p := make([]byte, 20+payload)
+	p[0] = 0x45
+	binary.BigEndian.PutUint16(p[2:4], uint16(len(p)))
+	return p
+}
+
+func ipv6Packet(payload int) []byte {
+	p := make([]byte, 40+payload)
+	p[0] = 0x60
+	binary.BigEndian.PutUint16(p[4:6], uint16(payload))
+	return p
+}
+
+func TestTunPacketRoundtrip(t *testing.T) {
+	pkts := [][]byte{ipv4Packet(8), ipv6Packet(100), ipv4Packet(0)}
+
+	var buf bytes.Buffer
+	for _, p := range pkts {
+		if err := writePacket(&buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if af := binary.BigEndian.Uint32(buf.Bytes()); af != tunAFInet {
+		t.Errorf("got address family %d, want %d", af, tunAFInet)
+	}
+
+	// Packets arrive as a stream and need to be split again
+	r := bufio.NewReader(&buf)
+	for i, want := range pkts {
+		got, err := readPacket(r)
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("packet %d: got %x, want %x", i, got, want)
+		}
+	}
+	if _, err := readPacket(r); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestTunReadInvalid(t *testing.T) {
+	bad := append([]byte{0, 0, 0, 2}, make([]byte, 20)...)
+	if _, err := readPacket(bufio.NewReader(bytes.NewReader(bad))); err == nil {
+		t.Error("expected error for unknown IP version")
+	}
+}
+
+func TestParseTunUnit(t *testing.T) {
+	cases := map[string]uint32{"": tunUnitAny, "any": tunUnitAny, "0": 0, "3": 3}
+	for in, want := range cases {
+		got, err := parseTunUnit(in)
+		if err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"-1", "tun0", "2147483647"} {
+		if _, err := parseTunUnit(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+// eofChannel is a channel the server closed right away
+type eofChannel struct{}
+
+func (eofChannel) Read([]byte) (int, error)    { return 0, io.EOF }
+func (eofChannel) Write(b []byte) (int, error) { return len(b), nil }
+func (eofChannel) Close() error                { return nil }
+func (eofChannel) CloseWrite() error           { return nil }
+func (eofChannel) Stderr() io.ReadWriter       { return new(bytes.Buffer) }
+
+func (eofChannel) SendRequest(string, bool, []byte) (bool, error) { return false, nil }
+
+// pipeTun is a tun device reading from one pipe and writing to another
+type pipeTun struct {
+	*os.File
+	w io.Writer
+}
+
+func (p pipeTun) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+// Tests that handleTun stops reading the device before returning, which
+// is read by the next connection after re-connecting
+func TestHandleTunStops(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	tun := &Tunnel{Desc: &Desc{}, tun: pipeTun{File: r, w: io.Discard}, tunCh: eofChannel{}}
+
+	done := make(chan struct{})
+	go func() {
+		tun.handleTun()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleTun did not return once the channel was closed")
+	}
+
+	if _, err := w.Write(ipv4Packet(0)); err != nil {
+		t.Fatal(err)
+	}
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := r.Read(make([]byte, 64)); err != nil || n != 20 {
+		t.Errorf("packet not left for the next connection: %d bytes, %v", n, err)
+	}
+}
@@ -2,12 +2,18 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alebeck/boring/internal/log"
@@ -20,23 +26,70 @@ const (
 	initReconnectWait = 500 * time.Millisecond
 	maxReconnectWait  = 1 * time.Minute
 	reconnectTimeout  = 15 * time.Minute
+	connAttemptDelay  = 1 * time.Second
+	// Wait for the server to stop listening on closing remote forwards
+	cancelForwardTimeout = 5 * time.Second
 )
 
+// CannotListen indicates that the address of a tunnel could not be bound
+var CannotListen = errors.New("cannot listen")
+
 // Desc describes a tunnel for user-facing purposes, e.g., in the config file
-// and in the TUI.
+// and in the TUI. Settings are tagged whether tunnels inherit them through
+// an alias, alias:"inherit", or not, alias:"own".
 type Desc struct {
-	Name          string      `toml:"name" json:"name"`
-	LocalAddress  StringOrInt `toml:"local" json:"local"`
-	RemoteAddress StringOrInt `toml:"remote" json:"remote"`
-	Host          string      `toml:"host" json:"host"`
-	User          string      `toml:"user" json:"user"`
-	IdentityFile  string      `toml:"identity" json:"identity"`
-	Port          StringOrInt `toml:"port" json:"port"`
-	KeepAlive     *int        `toml:"keep_alive" json:"keep_alive"`
-	Group         string      `toml:"group" json:"group"`
-	Mode          Mode        `toml:"mode" json:"mode"`
+	Name          string      `toml:"name" json:"name" alias:"own"`
+	LocalAddress  StringOrInt `toml:"local" json:"local" alias:"own"`
+	RemoteAddress StringOrInt `toml:"remote" json:"remote" alias:"own"`
+	AllocatedPort int         `toml:"-" json:"allocated_port,omitempty"`
+	Alias         string      `toml:"alias" json:"alias,omitempty" alias:"own"`
+	Host          string      `toml:"host" json:"host" alias:"inherit"`
+	User          string      `toml:"user" json:"user" alias:"inherit"`
+	IdentityFile  string      `toml:"identity" json:"identity" alias:"inherit"`
+	IdentityEnv   string      `toml:"identity_env" json:"identity_env,omitempty" alias:"inherit"`
+	PreferKeyType bool        `toml:"prefer_key_type" json:"prefer_key_type,omitempty" alias:"inherit"`
+	Port          StringOrInt `toml:"port" json:"port" alias:"inherit"`
+	KeepAlive     *int        `toml:"keep_alive" json:"keep_alive" alias:"inherit"`
+	Jitter        *float64    `toml:"reconnect_jitter" json:"reconnect_jitter,omitempty" alias:"inherit"`
+	RetrySchedule []int       `toml:"reconnect_schedule" json:"reconnect_schedule,omitempty" alias:"inherit"`
+	IdleTimeout   int         `toml:"idle_timeout" json:"idle_timeout,omitempty" alias:"own"`
+	TTL           int         `toml:"ttl" json:"ttl,omitempty" alias:"own"`
+	Schedule      string      `toml:"schedule" json:"schedule,omitempty" alias:"own"` // cron expression
+	Window        int         `toml:"schedule_window" json:"schedule_window,omitempty" alias:"own"`
+	PadInterval   int         `toml:"padding_interval" json:"padding_interval,omitempty" alias:"inherit"`
+	OnDemand      bool        `toml:"on_demand" json:"on_demand,omitempty" alias:"own"`
+	Resolver      string      `toml:"resolver" json:"resolver,omitempty" alias:"inherit"`
+	MaxConns      int         `toml:"max_connections" json:"max_connections,omitempty" alias:"own"`
+	MaxDialing    int         `toml:"max_dialing" json:"max_dialing,omitempty" alias:"own"`
+	AllowFrom     []string    `toml:"allow_from" json:"allow_from,omitempty" alias:"own"`
+	CopyBufSize   int         `toml:"copy_buffer_size" json:"copy_buffer_size,omitempty" alias:"inherit"`
+	Routes        Routes      `toml:"routes" json:"routes,omitempty" alias:"own"` // in sni mode
+	NoSNI         string      `toml:"no_sni" json:"no_sni,omitempty" alias:"own"`
+	ProxyProtocol int         `toml:"proxy_protocol" json:"proxy_protocol,omitempty" alias:"own"`
+	LocalCommand  string      `toml:"local_command" json:"local_command,omitempty" alias:"own"`
+	LocalCmdFatal bool        `toml:"local_command_fatal" json:"local_command_fatal,omitempty" alias:"own"`
+	Profile       string      `toml:"security_profile" json:"security_profile,omitempty" alias:"inherit"`
+	ClientVersion string      `toml:"client_version" json:"client_version,omitempty" alias:"inherit"`
+	LogFile       string      `toml:"log_file" json:"log_file,omitempty" alias:"inherit"`
+	Group         string      `toml:"group" json:"group" alias:"own"`
+	Disarmed      bool        `toml:"disarmed" json:"disarmed,omitempty" alias:"own"` // not listening until armed
+	Labels        Labels      `toml:"labels" json:"labels,omitempty" alias:"own"`
+	Mode          Mode        `toml:"mode" json:"mode" alias:"own"`
 	Status        Status      `toml:"-" json:"status"`
+	ListenerDown  bool        `toml:"-" json:"listener_down,omitempty"` // accepting failed
+	SSHDown       bool        `toml:"-" json:"ssh_down,omitempty"`      // keep-alive unanswered
+	Standby       bool        `toml:"-" json:"standby,omitempty"`       // on demand, not connected
+	Scheduled     bool        `toml:"-" json:"scheduled,omitempty"`     // outside of its schedule, not connected
+	NextOpen      time.Time   `toml:"-" json:"next_open,omitzero"`      // start of the current or next window
+	NextClose     time.Time   `toml:"-" json:"next_close,omitzero"`     // end of that window
+	Disconnect    *Disconnect `toml:"-" json:"disconnect,omitempty"`    // last given by the server
 	LastConn      time.Time   `toml:"-" json:"last_conn"`
+	Conns         int         `toml:"-" json:"conns"`
+	PeakConns     int         `toml:"-" json:"peak_conns"`
+	Queued        int         `toml:"-" json:"queued,omitempty"` // waiting for a dial slot
+	Negotiated    *Negotiated `toml:"-" json:"negotiated,omitempty"`
+	Banner        string      `toml:"-" json:"banner,omitempty"` // sent by the servers on auth
+	Expires       time.Time   `toml:"-" json:"expires,omitzero"` // set if TTL is
 }
 
 // Tunnel is a representation internal to the tunnel and daemon packages,
@@ -46,11 +99,37 @@ type Tunnel struct {
 	hops       []ssh_config.Hop
 	Closed     chan struct{}
 	stop       chan struct{}
+	stopOnce   sync.Once
+	lastActive atomic.Int64
+	mu         sync.Mutex    // guards the runtime state in Desc, see Describe
+	changed    chan struct{} // closed on the next change of that state, if watched
 	listener   net.Listener
 	wg         sync.WaitGroup
 	client     *ssh.Client
 	localAddr  *address
 	remoteAddr *address
+	resolver   *net.Resolver
+	allowFrom  []netip.Prefix
+	routes     map[string]*address // by server name, in sni mode
+	noSNI      *address            // nil unless NoSNI is an address
+	hostNames  ssh_config.HostNames
+	sched      *cron
+	windowEnd  chan struct{} // closed at the end of the current window
+	retryNow   chan struct{} // cuts short the wait before re-connecting
+	tun        io.ReadWriteCloser
+	tunCh      ssh.Channel
+	tunUnits   [2]uint32     // local and remote device numbers
+	aliveMax   int           // unanswered keep-alives before disconnecting
+	bound      net.Listener  // on demand, the listener kept across connections
+	connDone   chan struct{} // on demand, closed once the connection is gone
+	dialing    chan struct{} // slots for connections being established
+	gate       *gateListener // the listener if it can be disarmed
+	armMu      sync.Mutex    // guards gate and Disarmed
+	logFile    *log.File     // nil unless LogFile is set
+	connected  bool          // once connected, re-connects retry by themselves
+	draining   atomic.Bool   // closing once connections are done, see Drain
+	unbound    chan struct{} // closed once not listening while draining
+	unbindOnce sync.Once
 	*Desc
 }
 
@@ -59,45 +138,260 @@ type address struct {
 }
 
 func FromDesc(desc *Desc) *Tunnel {
-	return &Tunnel{Desc: desc}
+	return &Tunnel{Desc: desc, retryNow: make(chan struct{}, 1)}
+}
+
+// Describe returns a copy of the description of the tunnel, including its
+// runtime state, e.g. its status and connection counts, which the goroutines
+// of the tunnel change while it is running
+func (t *Tunnel) Describe() Desc {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *t.Desc
+}
+
+// update changes the runtime state of the tunnel, see Describe
+func (t *Tunnel) update(f func(d *Desc)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(t.Desc)
+	if t.changed != nil {
+		close(t.changed)
+		t.changed = nil
+	}
+}
+
+// watch returns a copy of the description like Describe, and a channel
+// that is closed once the runtime state changes
+func (t *Tunnel) watch() (Desc, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.changed == nil {
+		t.changed = make(chan struct{})
+	}
+	return *t.Desc, t.changed
+}
+
+// Name returns the name of the tunnel, which may change while it is
+// running, see Rename
+func (t *Tunnel) Name() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Desc.Name
+}
+
+// Rename changes the name of the tunnel, keeping it and its connections
+// running. Later log messages carry the new name.
+func (t *Tunnel) Rename(name string) {
+	t.update(func(d *Desc) { d.Name = name })
+}
+
+// status returns the status of the tunnel while it may be running
+func (t *Tunnel) status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Status
 }
 
 func (t *Tunnel) Open() (err error) {
+	if err = t.openLog(); err != nil {
+		return err
+	}
+	defer func() {
+		// Unless re-connecting, the tunnel is not running on failure
+		if err != nil && t.stop == nil {
+			t.closeLog()
+		}
+	}()
 	if !t.prepared {
 		if err = t.prepare(); err != nil {
 			return err
 		}
 	}
+	if t.OnDemand {
+		return t.openOnDemand()
+	}
+	if t.sched != nil && t.stop == nil {
+		return t.openScheduled()
+	}
 
-	if err = t.makeClient(); err != nil {
+	if err = t.connectClient(); err != nil {
 		return err
 	}
-	log.Debugf("%v: connected to server", t.Name)
 
-	if err = t.makeListener(); err != nil {
+	if t.Mode == Tun {
+		if err = t.makeTun(); err != nil {
+			t.client.Close()
+			return fmt.Errorf("cannot open tun: %v", err)
+		}
+	} else {
+		if err = t.makeListener(); err != nil {
+			t.client.Close()
+			return fmt.Errorf("%w: %w", CannotListen, err)
+		}
+		if t.Describe().Disarmed && t.armable() {
+			log.Infof("%v: disarmed, not listening until armed", t.logName())
+		} else {
+			log.Debugf("%v: listening on %v", t.logName(), t.listener.Addr())
+		}
+	}
+
+	if cmdErr := t.established(); cmdErr != nil {
+		t.closeListener()
+		t.closeTun()
 		t.client.Close()
-		return fmt.Errorf("cannot listen: %v", err)
+		return cmdErr
 	}
-	log.Debugf("%v: listening on %v", t.Name, t.listener.Addr())
 
 	if t.stop == nil {
 		t.stop = make(chan struct{})
 		t.Closed = make(chan struct{})
+		if t.IdleTimeout > 0 {
+			t.touch()
+			go t.watchIdle()
+		}
+		if t.TTL > 0 {
+			t.expireAfterTTL()
+		}
 	}
 
 	go t.run()
 
-	log.Infof("%v: opened tunnel", t.Name)
-	t.Status = Open
-	t.LastConn = time.Now()
+	log.Infof("%v: opened tunnel", t.logName())
+	t.update(func(d *Desc) {
+		d.Status = Open
+		d.ListenerDown, d.SSHDown = false, false
+		d.LastConn = time.Now()
+	})
 	return
 }
 
-func (t *Tunnel) prepare() error {
+// connectClient connects to the server through all hops
+func (t *Tunnel) connectClient() error {
+	if err := t.makeClient(); err != nil {
+		return err
+	}
+	n := negotiatedFrom(t.client, t.hops[len(t.hops)-1])
+	t.debugf("connected to server, negotiated %+v", *n)
+	if n.AuthKey != "" {
+		t.infof("authenticated with key %v", n.AuthKey)
+	}
+	banner := t.banners()
+	t.update(func(d *Desc) {
+		d.Disconnect, d.Negotiated, d.Banner = nil, n, banner
+	})
+	return nil
+}
+
+// banners logs and returns the banners that the servers of all hops sent
+// during authentication
+func (t *Tunnel) banners() string {
+	var b strings.Builder
+	for _, h := range t.hops {
+		if h.Banner == nil || h.Banner.String() == "" {
+			continue
+		}
+		msg := strings.TrimRight(h.Banner.String(), "\r\n")
+		t.infof("banner of %v:\n%s", h.HostName, msg)
+		b.WriteString(msg + "\n")
+	}
+	return b.String()
+}
+
+// established runs the local command once the tunnel is established,
+// returning its error only if it is fatal
+func (t *Tunnel) established() error {
+	if t.LocalCommand == "" {
+		return nil
+	}
+	if err := t.runLocalCommand(); err != nil {
+		if t.LocalCmdFatal {
+			return err
+		}
+		t.warningf("%v", err)
+	}
+	return nil
+}
+
+func (t *Tunnel) prepare() (err error) {
+	if err = t.resolveHops(); err != nil {
+		return err
+	}
+	if t.MaxDialing > 0 {
+		t.dialing = make(chan struct{}, t.MaxDialing)
+	}
+	if t.OnDemand && t.Mode != Local && t.Mode != Socks {
+		return fmt.Errorf("on_demand is only supported in local and socks modes")
+	}
+	if t.Disarmed && !t.armable() {
+		return fmt.Errorf("disarmed is only supported in local, socks, udp and sni modes without on_demand")
+	}
+	if t.ProxyProtocol != 0 && t.Mode != Local && t.Mode != Remote {
+		return fmt.Errorf("proxy_protocol is only supported in local and remote modes")
+	}
+	if t.ProxyProtocol < 0 || t.ProxyProtocol > 2 {
+		return fmt.Errorf("proxy_protocol must be 1 or 2, found %d", t.ProxyProtocol)
+	}
+	if err = validateCopyBuf(t.CopyBufSize); err != nil {
+		return err
+	}
+	if t.PadInterval != 0 && t.PadInterval < minPadInterval {
+		return fmt.Errorf("padding_interval must be at least %d second(s), found %d",
+			minPadInterval, t.PadInterval)
+	}
+	if err = t.prepareSchedule(); err != nil {
+		return err
+	}
+
+	if t.Mode == Tun {
+		return t.prepareTun()
+	}
+	if err = t.validateAddrs(); err != nil {
+		return err
+	}
+
+	allowShort := t.Mode == Remote || t.Mode == RemoteSocks
+	t.remoteAddr, err = parseAddr(string(t.RemoteAddress), allowShort)
+	if err != nil {
+		return fmt.Errorf("remote address: %v", err)
+	}
+
+	t.localAddr, err = parseAddr(string(t.LocalAddress), !allowShort)
+	if err != nil {
+		return fmt.Errorf("local address: %v", err)
+	}
+	if t.Mode == Udp {
+		if t.localAddr.net != "tcp" {
+			return fmt.Errorf("local address: must be a network address in udp mode")
+		}
+		t.localAddr.net = "udp"
+	}
+	if t.Mode == Sni {
+		if err = t.prepareRoutes(); err != nil {
+			return err
+		}
+	}
+
+	if err = t.prepareAllowFrom(); err != nil {
+		return err
+	}
+
+	if t.resolver, err = newResolver(t.Resolver); err != nil {
+		return fmt.Errorf("resolver: %v", err)
+	}
+
+	t.prepared = true
+
+	return nil
+}
+
+// resolveHops infers the series of hops from the SSH config, taking into
+// account values manually set by the user.
+func (t *Tunnel) resolveHops() error {
 	// We need to pass the user as it's needed for matching Match blocks
-	sc, err := ssh_config.ParseSSHConfig(t.Host, t.User)
+	sc, err := ssh_config.ResolveSSHConfig(t.Host, t.User, &t.hostNames)
 	if err != nil {
-		return fmt.Errorf("could not parse SSH config: %v", err)
+		return fmt.Errorf("could not parse SSH config: %w", err)
 	}
 
 	// Override values manually set by user
@@ -112,61 +406,69 @@ func (t *Tunnel) prepare() error {
 	if t.IdentityFile != "" {
 		sc.IdentityFiles = []string{t.IdentityFile}
 	}
-
-	// If t.Host could not be resolved from ssh config, take it literally
-	if sc.HostName == "" {
-		sc.HostName = t.Host
-	}
+	sc.IdentityEnv = t.IdentityEnv
+	sc.PreferKeyType = t.PreferKeyType
 
 	sc.EnsureUser()
+	sc.SecurityProfile = t.Profile
+	sc.ClientVersion = t.ClientVersion
+	t.aliveMax = sc.AliveCountMax
 
 	// Infer series of hops from ssh config
-	if t.hops, err = sc.ToHops(); err != nil {
-		return err
-	}
+	t.hops, err = sc.ToHops()
+	return err
+}
 
-	allowShort := t.Mode == Remote || t.Mode == RemoteSocks
-	t.remoteAddr, err = parseAddr(string(t.RemoteAddress), allowShort)
-	if err != nil {
-		return fmt.Errorf("remote address: %v", err)
+func (t *Tunnel) makeClient() error {
+	hops := t.hops
+	if t.connected {
+		// ConnectionAttempts only applies to the first connection, later
+		// ones are retried with backoff, see reconnectLoop
+		hops = slices.Clone(hops)
+		hops[0].Attempts = 1
 	}
-
-	t.localAddr, err = parseAddr(string(t.LocalAddress), !allowShort)
+	c, wait, err := dialHops(t.Name(), hops, t.stop)
 	if err != nil {
-		return fmt.Errorf("local address: %v", err)
+		return err
 	}
+	t.connected = true
 
-	t.prepared = true
+	// Wait for all wrapped clients to close in case of tunnel closing or reconnection
+	go t.waitFor(wait)
 
+	t.client = c
 	return nil
 }
 
-func (t *Tunnel) makeClient() error {
-	if len(t.hops) == 0 {
-		return fmt.Errorf("no connections specified")
+// dialHops connects through all hops in order and returns the client of the
+// final one. Closing it closes all intermediate clients, the returned function
+// blocks until this has happened. Closing stop ends waiting between attempts.
+func dialHops(name string, hops []ssh_config.Hop, stop <-chan struct{}) (*ssh.Client, func(), error) {
+	if len(hops) == 0 {
+		return nil, nil, fmt.Errorf("no connections specified")
 	}
 
 	var c *ssh.Client
 	var wg sync.WaitGroup
 
 	// Connect through all jump hosts
-	for _, j := range t.hops {
+	for _, j := range hops {
 		addr := fmt.Sprintf("%v:%v", j.HostName, j.Port)
-		n, err := wrapClient(c, addr, j.ClientConfig)
+		n, err := wrapClient(c, addr, j, stop)
 		if err != nil {
 			safeClose(c)
 			// Wait for all connections established until here to close
 			wg.Wait()
-			return fmt.Errorf("could not connect to host %v: %v", addr, err)
+			return nil, nil, fmt.Errorf("could not connect to host %v: %w", addr, err)
 		}
-		log.Debugf("%v: connected to host %v (client %p)", t.Name, j.HostName, n)
+		log.Debugf("%v: connected to host %v (client %p)", name, j.HostName, n)
 
 		// Add new client to wait group
 		wg.Add(1)
 		go func(n, c *ssh.Client) {
 			defer wg.Done()
 			n.Wait()
-			log.Debugf("%v: closed client %p to %v", t.Name, n, n.RemoteAddr())
+			log.Debugf("%v: closed client %p to %v", name, n, n.RemoteAddr())
 			// Close previous client when new one closes, this propagates
 			safeClose(c)
 		}(n, c)
@@ -174,120 +476,460 @@ func (t *Tunnel) makeClient() error {
 		c = n
 	}
 
-	// Wait for all wrapped clients to close in case of tunnel closing or reconnection
-	go t.waitFor(func() { wg.Wait() })
+	return c, wg.Wait, nil
+}
 
-	t.client = c
-	return nil
+// Client is an SSH connection made by Connect
+type Client struct {
+	*ssh.Client
+	AgentSock string // to forward to sessions, "" unless ForwardAgent is set
+}
+
+// Connect establishes an SSH connection to a [user@]host, resolving it
+// against the SSH config the same way tunnels do. Closing the returned
+// client closes all intermediate jump connections.
+func Connect(host string) (*Client, error) {
+	hops, err := hostHops(host)
+	if err != nil {
+		return nil, err
+	}
+	c, _, err := dialHops(host, hops, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Client: c, AgentSock: hops[len(hops)-1].AgentFwd}, nil
 }
 
-func wrapClient(old *ssh.Client, addr string, conf *ssh.ClientConfig) (*ssh.Client, error) {
-	if old == nil {
-		return ssh.Dial("tcp", addr, conf)
+// hostHops resolves the hops to a [user@]host like for a tunnel to it
+func hostHops(host string) ([]ssh_config.Hop, error) {
+	d := &Desc{Name: host, Host: host}
+	if u, h, ok := strings.Cut(host, "@"); ok {
+		d.User, d.Host = u, h
 	}
+	t := FromDesc(d)
+	if err := t.resolveHops(); err != nil {
+		return nil, err
+	}
+	return t.hops, nil
+}
 
-	conn, err := old.Dial("tcp", addr)
+func wrapClient(old *ssh.Client, addr string, hop ssh_config.Hop, stop <-chan struct{}) (*ssh.Client, error) {
+	conn, err := dialHop(old, addr, hop, stop)
 	if err != nil {
 		return nil, err
 	}
+	if hop.TLS != nil {
+		if conn, err = wrapTLS(conn, hop); err != nil {
+			return nil, err
+		}
+	}
+	return handshakeHop(conn, addr, hop)
+}
 
-	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
+// wrapTLS runs the TLS handshake with a hop that is wrapped in TLS, over
+// which the SSH connection is made
+func wrapTLS(conn net.Conn, hop ssh_config.Hop) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hop.Timeout)
+	defer cancel()
+	tc := tls.Client(conn, hop.TLS)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake: %w", err)
+	}
+	log.Debugf("%v: wrapped in %v", hop.HostName, tls.VersionName(tc.ConnectionState().Version))
+	return tc, nil
+}
+
+// dialHop opens the connection to a hop, through the client of the
+// previous one if old is not nil, see dialAttempts for stop
+func dialHop(old *ssh.Client, addr string, hop ssh_config.Hop, stop <-chan struct{}) (net.Conn, error) {
+	if old != nil {
+		return old.Dial("tcp", addr)
+	}
+	// Only the first hop has a socket of its own, the others are
+	// carried inside of it. If the host name resolves to several
+	// addresses, the dialer tries all of them within the timeout,
+	// racing IPv4 against IPv6 (RFC 6555).
+	d := net.Dialer{Timeout: hop.Timeout}
+	if hop.TOS != 0 {
+		d.Control = func(network, _ string, c syscall.RawConn) error {
+			return setTOS(network, c, hop.TOS)
+		}
+	}
+	addrs, err := lookupHop(addr, hop, stop)
 	if err != nil {
 		return nil, err
 	}
+	return dialAttempts(&d, addrs, hop.Attempts, stop)
+}
+
+// handshakeHop establishes the SSH connection to a hop over conn
+func handshakeHop(conn net.Conn, addr string, hop ssh_config.Hop) (*ssh.Client, error) {
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, hop.ClientConfig)
+	if err != nil {
+		if hint := ssh_config.SSHRSAHint(err); hint != "" {
+			return nil, fmt.Errorf("%w; %s", err, hint)
+		}
+		if hint := ssh_config.ClockSkewHint(err, hop.Certs); hint != "" {
+			log.Warningf("%v: %s", addr, hint)
+			return nil, fmt.Errorf("%w; %s", err, hint)
+		}
+		return nil, err
+	}
+	if hop.HostKeys != nil {
+		reqs = hop.HostKeys.Intercept(ncc, addr, reqs)
+	}
 
 	return ssh.NewClient(ncc, chans, reqs), nil
 }
 
+// lookupHop resolves the host name of hop with the resolver registered in
+// ssh_config, if any handles it, returning the addresses to dial. They are
+// only used for dialing, host keys are still checked against the host name.
+// The lookup takes at most the hop's connect timeout and ends when stop is
+// closed.
+func lookupHop(addr string, hop ssh_config.Hop, stop <-chan struct{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hop.Timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	hosts, ok, err := ssh_config.LookupHost(ctx, hop.HostName)
+	if !ok {
+		return []string{addr}, nil
+	}
+	if err == nil && len(hosts) == 0 {
+		err = fmt.Errorf("no addresses found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve %v: %w", hop.HostName, err)
+	}
+	addrs := make([]string, len(hosts))
+	for i, h := range hosts {
+		addrs[i] = net.JoinHostPort(h, strconv.Itoa(hop.Port))
+	}
+	return addrs, nil
+}
+
+// dialAttempts dials addrs up to n times, waiting a second in between like
+// ssh(1) does for ConnectionAttempts. Each attempt tries the addresses in
+// order until one connects. Only the TCP connection is retried,
+// authentication errors are final. Closing stop ends the wait, returning
+// the last error.
+func dialAttempts(d *net.Dialer, addrs []string, n int, stop <-chan struct{}) (conn net.Conn, err error) {
+	delay := time.NewTimer(connAttemptDelay)
+	defer delay.Stop()
+	for i := 1; ; i++ {
+		for _, addr := range addrs {
+			if conn, err = d.Dial("tcp", addr); err == nil {
+				return
+			}
+		}
+		if i >= n {
+			return
+		}
+		log.Debugf("connection attempt %d/%d to %v failed: %v", i, n, strings.Join(addrs, ", "), err)
+		delay.Reset(connAttemptDelay)
+		select {
+		case <-stop:
+			return nil, err
+		case <-delay.C:
+		}
+	}
+}
+
+// cancelForward closes the listener of remote forwards while the client
+// is still connected, which asks the server to stop listening, so that the
+// port is free once the tunnel is closed. Unresponsive servers are not
+// waited for long.
+func (t *Tunnel) cancelForward() {
+	if t.Mode != Remote && t.Mode != RemoteSocks {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		if err := t.listener.Close(); err != nil {
+			t.debugf("could not cancel forward: %v", err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(cancelForwardTimeout):
+		t.warningf("server did not confirm cancelling the forward")
+	}
+}
+
+// closeListener stops accepting connections, in tun mode it closes
+// the tun channel instead.
+func (t *Tunnel) closeListener() {
+	if t.Mode == Tun {
+		t.tunCh.Close()
+		return
+	}
+	t.listener.Close()
+}
+
 func (t *Tunnel) makeListener() (err error) {
 	if t.Mode == Remote || t.Mode == RemoteSocks {
-		t.listener, err = t.client.Listen(t.remoteAddr.net, t.remoteAddr.addr)
+		if t.remoteAddr.net == "unix" {
+			return t.listenRemoteUnix()
+		}
+		if !t.remoteAddr.dynamicPort() {
+			t.listener, err = t.client.Listen(t.remoteAddr.net, t.remoteAddr.addr)
+			return
+		}
+		// The server picks the port and reports it in its reply
+		if t.listener, err = t.client.Listen(t.remoteAddr.net, t.remoteAddr.addr); err != nil {
+			return fmt.Errorf("server did not allocate a remote port: %v", err)
+		}
+		port := t.listener.Addr().(*net.TCPAddr).Port
+		t.update(func(d *Desc) { d.AllocatedPort = port })
+		log.Infof("%v: server allocated remote port %d", t.logName(), port)
+	} else if !t.armable() {
+		t.listener, err = t.bindLocal()
 	} else {
-		t.listener, err = net.Listen(t.localAddr.net, t.localAddr.addr)
+		t.armMu.Lock()
+		defer t.armMu.Unlock()
+		gate := newGateListener(t.bindLocal, t.localAddr)
+		if !t.Disarmed {
+			if err = gate.arm(); err != nil {
+				return err
+			}
+		}
+		t.gate, t.listener = gate, gate
 	}
 	return
 }
 
-func (t *Tunnel) dial(network, addr string) (net.Conn, error) {
-	if t.Mode == Remote || t.Mode == RemoteSocks {
-		return net.Dial(network, addr)
+// listenRemoteUnix listens on a Unix socket on the server, which needs the
+// streamlocal-forward@openssh.com extension of OpenSSH. Closing the listener
+// cancels the forward, so that the server stops listening on the socket.
+func (t *Tunnel) listenRemoteUnix() (err error) {
+	if t.listener, err = t.client.ListenUnix(t.remoteAddr.addr); err != nil {
+		return fmt.Errorf("server refused to listen on Unix socket %s, it may not support "+
+			"forwarding Unix sockets, or the socket may exist already: %v", t.remoteAddr.addr, err)
+	}
+	return nil
+}
+
+// bindLocal binds the local listener of the tunnel
+func (t *Tunnel) bindLocal() (l net.Listener, err error) {
+	addr := t.localAddr.addr
+	if t.Resolver != "" {
+		if addr, err = resolveAddr(t.resolver, t.localAddr); err != nil {
+			return nil, err
+		}
+	}
+	if t.Mode == Udp {
+		l, err = listenUDP(addr)
+	} else {
+		l, err = listen(t.localAddr.net, addr)
+	}
+	if err != nil {
+		return nil, portInUse(t.localAddr.net, addr, err)
+	}
+	return l, nil
+}
+
+// dial connects to the forwarding target for a connection from the given
+// address. Remote targets are passed verbatim to the server, so host names
+// are resolved on the remote side, and from is announced as the origin.
+// Local targets are resolved using the tunnel's resolver.
+func (t *Tunnel) dial(from net.Addr, network, addr string) (net.Conn, error) {
+	defer t.dialSlot()()
+	switch {
+	case t.Mode == Remote || t.Mode == RemoteSocks:
+		d := net.Dialer{Resolver: t.resolver}
+		return d.Dial(network, addr)
+	case network == "tcp" || network == "tcp4" || network == "tcp6":
+		return dialFrom(t.client, from, addr)
 	}
 	return t.client.Dial(network, addr)
 }
 
+func (t *Tunnel) prepareTun() (err error) {
+	if t.tunUnits[0], err = parseTunUnit(string(t.LocalAddress)); err != nil {
+		return fmt.Errorf("local address: %v", err)
+	}
+	if t.tunUnits[1], err = parseTunUnit(string(t.RemoteAddress)); err != nil {
+		return fmt.Errorf("remote address: %v", err)
+	}
+	t.prepared = true
+	return nil
+}
+
 func (t *Tunnel) run() {
 	disconn := make(chan struct{})
+	var waitErr error
 	go func() {
-		t.client.Wait()
+		waitErr = t.client.Wait()
 		close(disconn)
 	}()
 
 	go t.waitFor(func() { t.keepAlive(disconn) })
+	if t.PadInterval > 0 {
+		go t.waitFor(func() { t.pad(disconn) })
+	}
 	go t.waitFor(func() { t.handleConns() })
 
-	stopped := false
+	stopped, ended := false, false
 	select {
 	case <-t.stop:
-		log.Infof("%v: received stop signal", t.Name)
+		log.Infof("%v: received stop signal", t.logName())
 		stopped = true
+		t.cancelForward()
+		if t.draining.Load() {
+			t.drain()
+		}
+		t.client.Close()
+	case <-t.windowEnd:
+		t.infof("disconnecting at the end of the scheduled window")
+		ended = true
+		t.cancelForward()
 		t.client.Close()
 	case <-disconn:
 	}
-	t.listener.Close()
+	t.closeListener()
+	t.update(func(d *Desc) { d.ListenerDown, d.SSHDown = true, true })
 	t.wg.Wait()
+
+	var wait time.Duration
+	reconnect := !stopped
 	if !stopped {
-		if err := t.reconnectLoop(); err != nil {
-			log.Errorf("%v: could not re-connect: %v", t.Name, err)
+		if dc := parseDisconnect(waitErr); dc != nil {
+			t.update(func(d *Desc) { d.Disconnect = dc })
+			t.warningf("server disconnected: %v", dc)
+			wait, reconnect = dc.reconnectWait()
+		}
+	}
+	if t.OnDemand {
+		// The listener stays bound, see serveOnDemand
+		t.standby()
+		return
+	}
+	if ended {
+		t.endWindow()
+		return
+	}
+	if reconnect {
+		if err := t.reconnectLoop(wait); errors.Is(err, errWindowEnd) {
+			t.infof("not re-connecting after the end of the scheduled window")
+		} else if err != nil {
+			log.Errorf("%v: could not re-connect: %v", t.logName(), err)
 		} else {
 			// Successfully re-connected
 			return
 		}
+	} else if !stopped {
+		log.Errorf("%v: not re-connecting, as the server would refuse", t.logName())
+	}
+	// Scheduled tunnels wait for the next window, see runSchedule
+	if t.sched != nil && !isDone(t.stop) {
+		t.endWindow()
+		return
 	}
-	t.Status = Closed
+	t.closeTun()
+	t.closeLog()
+	t.update(func(d *Desc) { d.Status = Closed })
 	close(t.Closed)
 }
 
+// keepAlive sends keep-alives and closes the client once aliveMax of them
+// went unanswered, which triggers the reconnection logic. If aliveMax is
+// 0, keep-alives are sent without waiting for replies and the client is
+// never closed, which keeps NAT mappings alive.
 func (t *Tunnel) keepAlive(cancel chan struct{}) {
 	// panics if nil, this should never happen
 	interv := *t.KeepAlive
 
 	if interv == 0 {
-		log.Infof("%v: disabling keep-alives since set to 0", t.Name)
+		t.infof("disabling keep-alives since set to 0")
 		return
 	}
 
+	// Only one request can be in flight, later ones would block on it
+	replies := make(chan error, 1)
+	pending := false
+	missed := 0
+
 	for {
 		select {
 		case <-cancel:
 			return
-		case <-time.After(time.Duration(interv) * time.Second):
-			_, _, err := t.client.SendRequest("keepalive@golang.org", true, nil)
+		case err := <-replies:
+			pending = false
 			if err != nil {
-				log.Errorf("%v: error sending keepalive: %v", t.Name, err)
-				// Close the client, this triggers the reconnection logic
+				t.errorf("error sending keepalive: %v", err)
 				t.client.Close()
 				return
 			}
-			log.Debugf("%v: sent keep-alive", t.Name)
+			missed = 0
+			t.update(func(d *Desc) { d.SSHDown = false })
+		case <-time.After(time.Duration(interv) * time.Second):
+			if t.aliveMax == 0 {
+				if _, _, err := t.client.SendRequest("keepalive@golang.org", false, nil); err != nil {
+					t.errorf("error sending keepalive: %v", err)
+					t.client.Close()
+					return
+				}
+				t.debugf("sent keep-alive")
+				continue
+			}
+			if pending {
+				missed++
+				t.update(func(d *Desc) { d.SSHDown = true })
+				t.warningf("no reply to keep-alive (%d/%d)", missed, t.aliveMax)
+				if missed >= t.aliveMax {
+					t.errorf("server not responding, disconnecting")
+					t.client.Close()
+					return
+				}
+				continue
+			}
+			pending = true
+			go func() {
+				_, _, err := t.client.SendRequest("keepalive@golang.org", true, nil)
+				replies <- err
+			}()
+			t.debugf("sent keep-alive")
 		}
 	}
 }
 
 func (t *Tunnel) handleConns() {
-	defer t.listener.Close()
-	defer t.client.Close()
-	if t.Mode == Local || t.Mode == Remote {
+	defer t.closeListener()
+	defer func() {
+		// While draining, run closes the client once connections are done
+		if !t.draining.Load() {
+			t.client.Close()
+		}
+	}()
+	if t.Mode == Tun {
+		t.handleTun()
+		return
+	}
+	if t.Mode == Local || t.Mode == Remote || t.Mode == Udp {
 		t.handleForward()
 		return
 	}
+	if t.Mode == Sni {
+		t.handleSNI()
+		return
+	}
 	t.handleSocks()
 }
 
 func (t *Tunnel) handleForward() {
 	for {
-		conn1, err := t.listener.Accept()
+		conn1, err := t.accept()
 		if err != nil {
-			log.Errorf("%v: could not accept: %v", t.Name, err)
+			t.errorf("could not accept: %v", err)
 			return
 		}
 		go t.waitFor(func() {
@@ -295,55 +937,125 @@ func (t *Tunnel) handleForward() {
 			if t.Mode == Remote || t.Mode == RemoteSocks {
 				addr = t.localAddr
 			}
-			conn2, err := t.dial(addr.net, addr.addr)
+			conn2, err := t.dial(conn1.RemoteAddr(), addr.net, addr.addr)
 			if err != nil {
-				log.Errorf("%v: could not dial: %v", t.Name, err)
+				t.errorf("could not dial: %v", err)
+				conn1.Close()
 				return
 			}
-			tunnel(conn1, conn2)
+			if t.ProxyProtocol > 0 {
+				h := proxyHeader(t.ProxyProtocol, conn1.RemoteAddr(), conn1.LocalAddr())
+				if _, err := conn2.Write(h); err != nil {
+					t.errorf("could not send PROXY header: %v", err)
+					conn1.Close()
+					conn2.Close()
+					return
+				}
+			}
+			tunnel(conn1, conn2, copyBufPool(t.CopyBufSize))
 		})
 	}
 }
 
-func tunnel(c1, c2 net.Conn) {
+// accept accepts the next connection on the tunnel's listener, wrapping
+// it for activity tracking if an idle timeout is set. Connections from
+// addresses not in allow_from, or beyond the tunnel's connection limit,
+// are rejected.
+func (t *Tunnel) accept() (net.Conn, error) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			t.update(func(d *Desc) { d.ListenerDown = true })
+			return nil, err
+		}
+		if !t.allowed(conn.RemoteAddr()) {
+			t.warningf("rejecting connection from %v, not in allow_from", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if !t.acquire() {
+			t.warningf("rejecting connection from %v, limit of %d reached",
+				conn.RemoteAddr(), t.MaxConns)
+			conn.Close()
+			continue
+		}
+		conn = &limitConn{Conn: conn, t: t}
+		if t.IdleTimeout <= 0 && t.PadInterval <= 0 && !t.OnDemand {
+			return conn, nil
+		}
+		t.touch()
+		return &activityConn{Conn: conn, t: t}, nil
+	}
+}
+
+// tunnel copies data between c1 and c2 until both directions are done.
+// EOF in one direction is passed on as a half-close, so that peers can
+// still respond after they finished sending; on errors, both are closed.
+// Buffers are taken from bufs.
+func tunnel(c1, c2 net.Conn, bufs *bufPool) {
 	defer c1.Close()
 	defer c2.Close()
-	done := make(chan struct{}, 2)
 
-	go func() {
-		io.Copy(c1, c2)
-		done <- struct{}{}
-	}()
-
-	go func() {
-		io.Copy(c2, c1)
-		done <- struct{}{}
-	}()
-
-	<-done
+	pipe := func(dst, src net.Conn) {
+		if _, err := bufs.copy(dst, src); err != nil {
+			c1.Close()
+			c2.Close()
+			return
+		}
+		proxy.CloseWrite(dst)
+	}
+	var wg sync.WaitGroup
+	wg.Go(func() { pipe(c1, c2) })
+	wg.Go(func() { pipe(c2, c1) })
+	wg.Wait()
 }
 
 func (t *Tunnel) handleSocks() {
-	serv := &proxy.Server{
-		Dialer: func(ctx context.Context, netw, addr string) (net.Conn, error) {
-			return t.dial(netw, addr)
-		},
-	}
+	cp := copyBufPool(t.CopyBufSize).copy
 	for {
-		conn, err := t.listener.Accept()
+		conn, err := t.accept()
 		if err != nil {
-			log.Errorf("%v: could not accept: %v", t.Name, err)
+			t.errorf("could not accept: %v", err)
 			return
 		}
+		serv := &proxy.Server{
+			Dialer: func(ctx context.Context, netw, addr string) (net.Conn, error) {
+				return t.dial(conn.RemoteAddr(), netw, addr)
+			},
+			Copy: cp,
+		}
 		go t.waitFor(func() { serv.ServeConn(conn) })
 	}
 }
 
-func (t *Tunnel) reconnectLoop() error {
-	t.Status = Reconn
+// reconnectLoop tries to re-connect with exponential backoff, the first
+// time after wait, or (essentially) immediately if it is zero
+func (t *Tunnel) reconnectLoop(first time.Duration) error {
+	// Requests from before are of no concern anymore. Those from after
+	// the status is published must not get lost, see RetryNow.
+	select {
+	case <-t.retryNow:
+	default:
+	}
+	t.update(func(d *Desc) { d.Status = Reconn })
 	timeout := time.After(reconnectTimeout)
-	wait := time.NewTimer(2 * time.Millisecond) // First time try (essent.) immediately
 	waitTime := initReconnectWait
+	d := 2 * time.Millisecond
+	if first > 0 {
+		d = first
+		if t.Jitter != nil {
+			d = jitter(first, *t.Jitter)
+		}
+		waitTime = max(waitTime, first)
+	}
+	attempt := 0
+	if len(t.RetrySchedule) > 0 {
+		d = max(d, t.scheduledWait(attempt))
+	}
+	if d > 2*time.Millisecond {
+		t.infof("re-connecting in %v", d.Round(time.Millisecond))
+	}
+	wait := time.NewTimer(d)
 
 	for {
 		select {
@@ -351,28 +1063,62 @@ func (t *Tunnel) reconnectLoop() error {
 			return fmt.Errorf("re-connect timeout")
 		case <-t.stop:
 			return fmt.Errorf("re-connect interrupted by stop signal")
+		case <-t.windowEnd:
+			return errWindowEnd
+		case <-t.retryNow:
+			t.infof("re-connecting now as requested")
+			wait.Stop()
+			waitTime, attempt = initReconnectWait, 0
 		case <-wait.C:
-			log.Infof("%v: try re-connect...", t.Name)
-			err := t.Open()
-			if err == nil {
-				return nil
-			}
-			log.Errorf("%v: could not re-connect: %v. Retrying in %v...",
-				t.Name, err, waitTime)
-			wait.Reset(waitTime)
-			waitTime *= 2
-			if waitTime > maxReconnectWait {
-				waitTime = maxReconnectWait
+		}
+
+		t.infof("try re-connect...")
+		err := t.Open()
+		if err == nil {
+			return nil
+		}
+		d := waitTime
+		if len(t.RetrySchedule) > 0 {
+			attempt++
+			d = t.scheduledWait(attempt)
+		} else {
+			if t.Jitter != nil {
+				d = jitter(waitTime, *t.Jitter)
 			}
+			waitTime = min(waitTime*2, maxReconnectWait)
 		}
+		t.errorf("could not re-connect: %v. Retrying in %v...",
+			err, d.Round(time.Millisecond))
+		wait.Reset(d)
 	}
 }
 
+// RetryNow makes a tunnel that waits to re-connect try right away, with
+// the backoff starting over. Returns false if it is not re-connecting.
+func (t *Tunnel) RetryNow() bool {
+	if t.status() != Reconn {
+		return false
+	}
+	select {
+	case t.retryNow <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// scheduledWait returns the wait before the given re-connect attempt
+// according to the retry schedule, whose last interval is repeated.
+// Scheduled waits are not randomized, as they are chosen deliberately.
+func (t *Tunnel) scheduledWait(attempt int) time.Duration {
+	i := min(attempt, len(t.RetrySchedule)-1)
+	return time.Duration(t.RetrySchedule[i]) * time.Second
+}
+
 func (t *Tunnel) Close() error {
-	if t.Status == Closed {
+	if t.status() == Closed {
 		return fmt.Errorf("trying to close a closed tunnel")
 	}
-	close(t.stop)
+	t.stopOnce.Do(func() { close(t.stop) })
 	return nil
 }
 
@@ -399,6 +1145,50 @@ func parseAddr(addr string, allowShort bool) (*address, error) {
 	return &address{addr, "unix"}, nil
 }
 
+// dynamicPort reports whether a is a tcp address with port 0, which asks
+// the server to allocate a port in remote forwarding.
+func (a *address) dynamicPort() bool {
+	if a.net != "tcp" {
+		return false
+	}
+	_, port, err := net.SplitHostPort(a.addr)
+	return err == nil && port == "0"
+}
+
+func (a *address) Network() string { return a.net }
+func (a *address) String() string  { return a.addr }
+
+// LocalPort returns the port that the tunnel binds locally, i.e., the port
+// of the local address in local, socks, udp and sni modes, or "" if it binds
+// none.
+func (d *Desc) LocalPort() string {
+	if d.Mode != Local && d.Mode != Socks && d.Mode != Udp && d.Mode != Sni {
+		return ""
+	}
+	a, err := parseAddr(d.LocalAddress.String(), true)
+	if err != nil || a.net != "tcp" {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(a.addr)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// ParsePortSelector returns the port of a selector of the form ":<port>",
+// which matches tunnels by their local port, see LocalPort.
+func ParsePortSelector(s string) (string, bool) {
+	port, ok := strings.CutPrefix(s, ":")
+	if !ok {
+		return "", false
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", false
+	}
+	return port, true
+}
+
 func safeClose(c *ssh.Client) {
 	if c != nil {
 		c.Close()
@@ -0,0 +1,217 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLocalPort(t *testing.T) {
+	tests := []struct {
+		local string
+		mode  Mode
+		want  string
+	}{
+		{"5432", Local, "5432"},
+		{"localhost:5432", Local, "5432"},
+		{"[::1]:5432", Socks, "5432"},
+		{"/tmp/sock", Local, ""},
+		{"localhost:5432", Remote, ""},
+		{"any", Tun, ""},
+	}
+	for _, tt := range tests {
+		d := &Desc{LocalAddress: StringOrInt(tt.local), Mode: tt.mode}
+		if got := d.LocalPort(); got != tt.want {
+			t.Errorf("%q in mode %d: got %q, want %q", tt.local, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestParsePortSelector(t *testing.T) {
+	for s, want := range map[string]string{":5432": "5432", ":65535": "65535"} {
+		if got, ok := ParsePortSelector(s); !ok || got != want {
+			t.Errorf("%q: got %q, %v", s, got, ok)
+		}
+	}
+	for _, s := range []string{"5432", ":", ":0", ":65536", ":db", "test"} {
+		if _, ok := ParsePortSelector(s); ok {
+			t.Errorf("%q: accepted", s)
+		}
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	return c1.(*net.TCPConn), c2.(*net.TCPConn)
+}
+
+// A client that closes its write side must still receive the response
+func TestTunnelHalfClose(t *testing.T) {
+	client, in := tcpPair(t)
+	out, server := tcpPair(t)
+	// Wrapped like accepted connections
+	tun := FromDesc(&Desc{Name: "test"})
+	tun.acquire()
+	done := make(chan struct{})
+	go func() {
+		tunnel(&activityConn{Conn: &limitConn{Conn: in, t: tun}, t: tun}, out, copyBufPool(0))
+		close(done)
+	}()
+
+	go func() {
+		req, _ := io.ReadAll(server)
+		server.Write(append([]byte("re: "), req...))
+		server.Close()
+	}()
+
+	client.Write([]byte("request"))
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "re: request" {
+		t.Errorf("got response %q", resp)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel did not finish after both directions closed")
+	}
+}
+
+// Connections beyond MaxDialing wait for a slot and are counted as queued
+func TestDialSlot(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", MaxDialing: 1})
+	tun.dialing = make(chan struct{}, tun.MaxDialing)
+
+	release := tun.dialSlot()
+	acquired := make(chan func())
+	go func() { acquired <- tun.dialSlot() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tun.Describe().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("second dial not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("second dial got a slot while the first one holds it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case r := <-acquired:
+		r()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second dial did not get the freed slot")
+	}
+	if q := tun.Describe().Queued; q != 0 {
+		t.Errorf("%d dials still queued", q)
+	}
+}
+
+func TestScheduledWait(t *testing.T) {
+	tun := &Tunnel{Desc: &Desc{RetrySchedule: []int{1, 5, 30}}}
+	want := []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, w := range want {
+		if d := tun.scheduledWait(i); d != w {
+			t.Errorf("attempt %d: got %v, want %v", i, d, w)
+		}
+	}
+}
+
+// Closing the tunnel ends the wait between connection attempts
+func TestDialAttemptsStop(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	start := time.Now()
+	if _, err := dialAttempts(&net.Dialer{}, []string{addr}, 10, stop); err == nil {
+		t.Fatal("expected error dialing closed port")
+	}
+	if d := time.Since(start); d > 2*connAttemptDelay {
+		t.Errorf("stopped after %v, should not wait for all attempts", d)
+	}
+}
+
+// Addresses that refuse connections are skipped within an attempt
+func TestDialAttemptsAddrs(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := dialAttempts(&net.Dialer{}, []string{closed.Addr().String(), l.Addr().String()}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+type blockingResolver struct{}
+
+func (blockingResolver) LookupHost(ctx context.Context, _ string) ([]string, bool, error) {
+	<-ctx.Done()
+	return nil, true, ctx.Err()
+}
+
+// Closing the tunnel ends a lookup by the registered resolver
+func TestLookupHopStop(t *testing.T) {
+	ssh_config.RegisterResolver(blockingResolver{})
+	t.Cleanup(func() { ssh_config.RegisterResolver(nil) })
+
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	hop := ssh_config.Hop{HostName: "host.test", Port: 22, ClientConfig: &ssh.ClientConfig{Timeout: time.Minute}}
+	start := time.Now()
+	if _, err := lookupHop("host.test:22", hop, stop); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected lookup to be canceled, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("stopped after %v, should not wait for the timeout", d)
+	}
+}
@@ -0,0 +1,219 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// In udp mode, datagrams are carried over TCP connections to the remote
+// address: each client, i.e. source address, gets a connection of its
+// own, on which every datagram is sent as a 16-bit big-endian length
+// followed by the payload, in both directions. This is the framing of DNS
+// over TCP (RFC 1035, section 4.2.2), so DNS servers can be forwarded to
+// directly, other services need a shim on the remote side.
+const (
+	// Flows without datagrams in either direction are closed after this
+	udpFlowTimeout = 30 * time.Second
+	// Datagrams queued per flow, further ones are dropped like on a full
+	// socket buffer
+	udpFlowQueue = 64
+	maxDatagram  = 65535
+)
+
+// udpListener accepts a connection for each new client sending datagrams
+// to any of its sockets
+type udpListener struct {
+	pcs   []net.PacketConn
+	flows map[string]*udpFlow
+	mu    sync.Mutex
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// listenUDP is like listen for UDP sockets, binding "localhost" to the
+// loopback address of each address family
+func listenUDP(addr string) (net.Listener, error) {
+	var pcs []net.PacketConn
+	err := bindLocal(addr, func(a string) (string, error) {
+		pc, err := net.ListenPacket("udp", a)
+		if err != nil {
+			return "", err
+		}
+		pcs = append(pcs, pc)
+		return pc.LocalAddr().String(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &udpListener{
+		pcs:   pcs,
+		flows: make(map[string]*udpFlow),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	for _, pc := range pcs {
+		go l.serve(pc)
+	}
+	return l, nil
+}
+
+// serve passes the datagrams received on pc to their flows
+func (l *udpListener) serve(pc net.PacketConn) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("could not read datagram: %v", err)
+			}
+			l.Close()
+			return
+		}
+
+		key := pc.LocalAddr().String() + "|" + addr.String()
+		l.mu.Lock()
+		f, ok := l.flows[key]
+		if !ok {
+			f = &udpFlow{
+				l:    l,
+				pc:   pc,
+				addr: addr,
+				key:  key,
+				in:   make(chan []byte, udpFlowQueue),
+				done: make(chan struct{}),
+			}
+			f.touch()
+			l.flows[key] = f
+		}
+		l.mu.Unlock()
+
+		select {
+		case f.in <- append([]byte(nil), buf[:n]...):
+		default:
+			log.Debugf("dropping datagram from %v, queue is full", addr)
+		}
+		if !ok {
+			select {
+			case l.conns <- f:
+			case <-l.done:
+				return
+			}
+		}
+	}
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all sockets, which ends all flows
+func (l *udpListener) Close() (err error) {
+	l.once.Do(func() {
+		close(l.done)
+		for _, pc := range l.pcs {
+			if e := pc.Close(); err == nil {
+				err = e
+			}
+		}
+	})
+	return
+}
+
+// Addr returns the address of the first socket
+func (l *udpListener) Addr() net.Addr {
+	return l.pcs[0].LocalAddr()
+}
+
+// udpFlow is the connection of a single client, reading and writing
+// framed datagrams
+type udpFlow struct {
+	l          *udpListener
+	pc         net.PacketConn
+	addr       net.Addr
+	key        string
+	in         chan []byte
+	rbuf, wbuf []byte
+	lastActive atomic.Int64
+	done       chan struct{}
+	once       sync.Once
+}
+
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+// Read returns the next datagrams with their length prefixed. Flows
+// cannot be half-closed, so once timed out, Read fails rather than
+// returning io.EOF, which closes the connection to the remote as well.
+func (f *udpFlow) Read(b []byte) (int, error) {
+	for len(f.rbuf) == 0 {
+		remaining := udpFlowTimeout - time.Since(time.Unix(0, f.lastActive.Load()))
+		if remaining <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		select {
+		case d := <-f.in:
+			f.touch()
+			f.rbuf = binary.BigEndian.AppendUint16(f.rbuf, uint16(len(d)))
+			f.rbuf = append(f.rbuf, d...)
+		case <-f.done:
+			return 0, net.ErrClosed
+		case <-f.l.done:
+			return 0, net.ErrClosed
+		case <-time.After(remaining):
+		}
+	}
+	n := copy(b, f.rbuf)
+	f.rbuf = f.rbuf[n:]
+	return n, nil
+}
+
+// Write sends each complete datagram in the framed data to the client
+func (f *udpFlow) Write(b []byte) (int, error) {
+	f.wbuf = append(f.wbuf, b...)
+	for len(f.wbuf) >= 2 {
+		n := int(binary.BigEndian.Uint16(f.wbuf))
+		if len(f.wbuf) < 2+n {
+			break
+		}
+		if _, err := f.pc.WriteTo(f.wbuf[2:2+n], f.addr); err != nil {
+			return 0, err
+		}
+		f.touch()
+		f.wbuf = f.wbuf[2+n:]
+	}
+	return len(b), nil
+}
+
+// Close ends the flow, further datagrams of the client start a new one
+func (f *udpFlow) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+		f.l.mu.Lock()
+		if f.l.flows[f.key] == f {
+			delete(f.l.flows, f.key)
+		}
+		f.l.mu.Unlock()
+	})
+	return nil
+}
+
+func (f *udpFlow) LocalAddr() net.Addr              { return f.pc.LocalAddr() }
+func (f *udpFlow) RemoteAddr() net.Addr             { return f.addr }
+func (f *udpFlow) SetDeadline(time.Time) error      { return nil }
+func (f *udpFlow) SetReadDeadline(time.Time) error  { return nil }
+func (f *udpFlow) SetWriteDeadline(time.Time) error { return nil }
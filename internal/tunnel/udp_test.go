@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Datagrams of a client are framed on its flow, framed replies are sent
+// back to it as datagrams
+func TestUDPListenerFlows(t *testing.T) {
+	l, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("udp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		return c
+	}
+	c1, c2 := dial(), dial()
+
+	c1.Write([]byte("a"))
+	c1.Write([]byte("bc"))
+	f1, err := l.Accept()
+	if err != nil {
+		t.Fatalf("could not accept: %v", err)
+	}
+	defer f1.Close()
+	want := []byte{0, 1, 'a', 0, 2, 'b', 'c'}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(f1, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("got %q (%v), want %q", got, err, want)
+	}
+
+	// Another client gets a flow of its own
+	c2.Write([]byte("d"))
+	f2, err := l.Accept()
+	if err != nil {
+		t.Fatalf("could not accept: %v", err)
+	}
+	defer f2.Close()
+	if f2.RemoteAddr().String() != c2.LocalAddr().String() {
+		t.Errorf("flow of %v, expected %v", f2.RemoteAddr(), c2.LocalAddr())
+	}
+
+	// A reply split across writes arrives as one datagram
+	f1.Write([]byte{0, 5, 'h', 'e'})
+	f1.Write([]byte{'l', 'l', 'o', 0, 0})
+	buf := make([]byte, 100)
+	n, err := c1.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("got %q (%v), want \"hello\"", buf[:n], err)
+	}
+	if n, err = c1.Read(buf); err != nil || n != 0 {
+		t.Errorf("expected empty datagram, got %q (%v)", buf[:n], err)
+	}
+
+	l.Close()
+	if _, err := f1.Read(buf); err == nil {
+		t.Error("expected read to fail after close")
+	}
+}
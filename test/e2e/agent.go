@@ -2,11 +2,15 @@ package e2e
 
 import (
 	"context"
-	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
+	"crypto/ed25519"
+	"crypto/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 const (
@@ -14,6 +18,45 @@ const (
 )
 
 func startAgent(sock string) (context.CancelFunc, error) {
+	kr, err := clientKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return serveAgent(sock, kr)
+}
+
+// startLateAgent starts an agent that initially only holds a key unknown to
+// the server, and receives the client key right after it was first listed,
+// like an agent that is still being populated during login.
+func startLateAgent(sock string) (context.CancelFunc, error) {
+	kr, err := clientKeyring()
+	if err != nil {
+		return nil, err
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	late := &lateKeyring{Agent: agent.NewKeyring(), full: kr}
+	if err := late.Add(agent.AddedKey{PrivateKey: other, Comment: "other"}); err != nil {
+		return nil, err
+	}
+	return serveAgent(sock, late)
+}
+
+type lateKeyring struct {
+	agent.Agent
+	full agent.Agent
+	once sync.Once
+}
+
+func (k *lateKeyring) List() ([]*agent.Key, error) {
+	keys, err := k.Agent.List()
+	k.once.Do(func() { k.Agent = k.full })
+	return keys, err
+}
+
+func clientKeyring() (agent.Agent, error) {
 	// Read and parse the private key
 	keyBytes, err := os.ReadFile(clientKeyFile)
 	if err != nil {
@@ -32,7 +75,10 @@ func startAgent(sock string) (context.CancelFunc, error) {
 	}); err != nil {
 		return nil, err
 	}
+	return kr, nil
+}
 
+func serveAgent(sock string, kr agent.Agent) (context.CancelFunc, error) {
 	// Create a Unix socket and serve the agent.
 	ln, err := net.Listen("unix", sock)
 	if err != nil {
@@ -1,6 +1,9 @@
 package e2e
 
 import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -79,7 +82,111 @@ func TestAgentIdsOnly(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to run CLI command: %v", err)
 	}
-	if c != 1 || !strings.Contains(out, "no key files found") {
+	if c != 4 || !strings.Contains(out, "no key files found") {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
 }
+
+// A key added to the agent after it was first queried must still be used,
+// by retrying authentication once.
+func TestAgentLateKey(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_no_id"
+	cfg.useAgent = true
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	cancel, err = startLateAgent(getEnv(env, "SSH_AUTH_SOCK"))
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	logs, _ := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if !strings.Contains(string(logs), "succeeded after re-querying ssh-agent") {
+		t.Fatalf("expected retry to be logged: %s", logs)
+	}
+}
+
+// Keys of all agents in BORING_AGENT_SOCKS are tried, skipping agents that
+// cannot be reached
+func TestAgentSocks(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_no_id"
+	cfg.useAgent = true
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	dir := filepath.Dir(getEnv(env, "SSH_AUTH_SOCK"))
+	other, client := filepath.Join(dir, "other.sock"), filepath.Join(dir, "client.sock")
+	env = append(env, "BORING_AGENT_SOCKS="+strings.Join(
+		[]string{filepath.Join(dir, "missing.sock"), other, client}, string(filepath.ListSeparator)))
+
+	// The agent of SSH_AUTH_SOCK is not running, so it must not be needed.
+	// The other agent first only lists a key unknown to the server.
+	cancel, err := startLateAgent(other)
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancel()
+	cancel, err = startAgent(client)
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancel()
+	cancel, err = daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// The key can be passed in an environment variable instead of a file
+func TestIdentityEnv(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_no_id"
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	key, err := os.ReadFile(clientKeyFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	env = append(env, "BORING_TEST_KEY="+base64.StdEncoding.EncodeToString(key))
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-identity-env")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
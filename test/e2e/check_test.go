@@ -0,0 +1,65 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "check")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	for _, h := range []string{"alive-zero", "alive-one"} {
+		if !strings.Contains(out, h) {
+			t.Errorf("expected %s to be checked: %s", h, out)
+		}
+	}
+
+	c, out, err = cliCommand(env, "check", "jump@127.0.0.1")
+	if err != nil || c != 0 {
+		t.Fatalf("exit code %d: %v, %s", c, err, out)
+	}
+}
+
+func TestCheckProblems(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = filepath.Join(t.TempDir(), "ssh_config")
+	data := `Host broken
+    HostName 127.0.0.1
+    IdentityFile ../testdata/keys/doesnotexist
+    IdentityFile ../testdata/keys/client
+    StrictHostKeyChecking no
+    ControlMaster auto
+`
+	if err := os.WriteFile(cfg.sshConfig, []byte(data), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "check")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code should be 1, got %d: %s", c, out)
+	}
+	out = stripANSI(out)
+	if !strings.Contains(out, "ControlMaster is not supported") ||
+		!strings.Contains(out, "doesnotexist not found") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
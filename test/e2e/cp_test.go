@@ -0,0 +1,108 @@
+package e2e
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, p string, data []byte, perm os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(p, data, perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(p, perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFile(t *testing.T, p string, data []byte, perm os.FileMode) {
+	t.Helper()
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("%s: content differs", p)
+	}
+	i, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Mode().Perm() != perm {
+		t.Errorf("%s: expected mode %v, got %v", p, perm, i.Mode().Perm())
+	}
+}
+
+// Test copying a directory to the server and a file back
+func TestCopy(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	src, remote := t.TempDir(), t.TempDir()
+	// Larger than a single SFTP packet
+	big := bytes.Repeat([]byte("boring"), 100_000)
+	writeFile(t, filepath.Join(src, "big"), big, 0640)
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "sub", "run.sh"), []byte("exit 0\n"), 0755)
+
+	c, out, err := cliCommand(env, "cp", "-r", src, "127.0.0.1:"+remote)
+	if err != nil || c != 0 {
+		t.Fatalf("upload failed: %v, %d: %s", err, c, out)
+	}
+	if !strings.Contains(out, "Copied 2 file(s)") {
+		t.Errorf("unexpected output: %s", out)
+	}
+	dst := filepath.Join(remote, filepath.Base(src))
+	checkFile(t, filepath.Join(dst, "big"), big, 0640)
+	checkFile(t, filepath.Join(dst, "sub", "run.sh"), []byte("exit 0\n"), 0755)
+
+	local := filepath.Join(t.TempDir(), "copy")
+	c, out, err = cliCommand(env, "cp", "127.0.0.1:"+filepath.Join(dst, "big"), local)
+	if err != nil || c != 0 {
+		t.Fatalf("download failed: %v, %d: %s", err, c, out)
+	}
+	checkFile(t, local, big, 0640)
+}
+
+func TestCopyDirNotRecursive(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "cp", t.TempDir(), "127.0.0.1:"+t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code %d, should be 1", c)
+	}
+	if !strings.Contains(out, "use -r") {
+		t.Errorf("output did not suggest -r: %s", out)
+	}
+}
+
+func TestCopyNoRemote(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "cp", "a", "./b:c")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code %d, should be 1", c)
+	}
+	if !strings.Contains(out, "must be remote") {
+		t.Errorf("output did not indicate missing remote: %s", out)
+	}
+}
@@ -1,10 +1,13 @@
 package e2e
 
 import (
+	"context"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -14,6 +17,7 @@ import (
 	"github.com/alebeck/boring/internal/daemon"
 	"github.com/alebeck/boring/internal/ipc"
 	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
 	"github.com/alebeck/boring/internal/tunnel"
 )
 
@@ -92,6 +96,46 @@ func TestDaemonLaunch(t *testing.T) {
 	testDaemonLaunch(t, env)
 }
 
+// Test that the daemon is started from the binary set by BORING_DAEMON_BIN
+func TestDaemonLaunchBin(t *testing.T) {
+	cfg := defaultConfig
+	cfg.noSpawn = false
+	cfg.debug = true
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	bin, err := filepath.Abs(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "boring")
+	if err := os.Symlink(bin, link); err != nil {
+		t.Fatal(err)
+	}
+	testDaemonLaunch(t, append(env, "BORING_DAEMON_BIN="+link))
+}
+
+func TestDaemonLaunchBinInvalid(t *testing.T) {
+	cfg := defaultConfig
+	cfg.noSpawn = false
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	bin := filepath.Join(t.TempDir(), "boring")
+	if err := os.WriteFile(bin, []byte("not executable"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, out, err := cliCommand(append(env, "BORING_DAEMON_BIN="+bin), "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 || !strings.Contains(out, "invalid BORING_DAEMON_BIN") {
+		t.Fatalf("expected invalid binary error, got exit code %d: %s", c, out)
+	}
+}
+
 // Test that we can recover from a situation where the socket exists
 // but is not bindable, this can happen after force shutdowns.
 func TestDaemonLaunchBadSocket(t *testing.T) {
@@ -266,3 +310,530 @@ func TestDaemonLaunchMismatch2(t *testing.T) {
 		t.Fatalf("expected incompatibility error, got: %s", out)
 	}
 }
+
+// Test that the daemon can replace itself while keeping its socket and tunnels,
+// and the connections established through them
+func TestDaemonReexec(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer l.Close()
+	conn, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(testMsg); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept connection: %v", err)
+	}
+	defer peer.Close()
+	echo := func(from, to net.Conn) {
+		t.Helper()
+		to.SetReadDeadline(time.Now().Add(connTimeout))
+		buf := make([]byte, len(testMsg))
+		if _, err := io.ReadFull(to, buf); err != nil || string(buf) != string(testMsg) {
+			t.Fatalf("expected %q, got %q: %v", testMsg, buf, err)
+		}
+		if from != nil {
+			if _, err := from.Write(testMsg); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+		}
+	}
+	echo(peer, peer)
+
+	logFile := getEnv(env, "BORING_LOG_FILE")
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("could not read log file: %v", err)
+	}
+	match := regexp.MustCompile(`PID (\d+)`).FindSubmatch(data)
+	if match == nil {
+		t.Fatalf("PID not in log: %s", data)
+	}
+	oldPid, _ := strconv.Atoi(string(match[1]))
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.Reexec})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !r.Success {
+		t.Fatalf("re-exec failed: %v", r.Error)
+	}
+	// The new daemon takes over the socket, make sure it's shut down
+	defer daemonCmd(env, daemon.Cmd{Kind: daemon.Shutdown})
+
+	// Wait for the tunnel to be restored by the new process
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err == nil && r.Tunnels["test"].Status == tunnel.Open {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel not restored after re-exec: %v, %+v", err, r)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	c, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// The connection from before is still served by the old process
+	echo(conn, conn)
+	echo(nil, peer)
+	if !pidRunning(oldPid) {
+		t.Fatalf("old daemon exited before its connection was done")
+	}
+
+	// New connections go to the new process
+	conn2, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn2.Close()
+	if err := testConnected(l, conn2); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The old process exits once its connection is done
+	conn.Close()
+	peer.Close()
+	deadline = time.Now().Add(2 * time.Second)
+	for pidRunning(oldPid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("old daemon still running after its connection was done")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Test that the daemon keeps serving if the new process does not take over
+func TestDaemonReexecFails(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	bin := filepath.Join(t.TempDir(), "boring")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	env = append(env, "BORING_DAEMON_BIN="+bin)
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.Reexec})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if r.Success {
+		t.Fatalf("expected re-exec to fail")
+	}
+
+	if r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List}); err != nil {
+		t.Fatalf("daemon not serving after failed re-exec: %v", err)
+	}
+	if r.Tunnels["test"].Status != tunnel.Open {
+		t.Fatalf("tunnel not open after failed re-exec: %+v", r.Tunnels)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// Test that the daemon reopens its log file on SIGHUP, as needed by logrotate
+func TestDaemonReopenLog(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	logFile := getEnv(env, "BORING_LOG_FILE")
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("could not read log file: %v", err)
+	}
+	match := regexp.MustCompile(`PID (\d+)`).FindSubmatch(data)
+	if match == nil {
+		t.Fatalf("PID not in log: %s", data)
+	}
+	pid, _ := strconv.Atoi(string(match[1]))
+
+	// Rotate like logrotate does
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatalf("could not move log file: %v", err)
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("could not send SIGHUP: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err = os.ReadFile(logFile)
+		if err == nil && strings.Contains(string(data), "Reopened log file") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log file not reopened: %v, %s", err, data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if !pidRunning(pid) {
+		t.Fatalf("daemon did not survive SIGHUP")
+	}
+}
+
+// Test that tunnels saved to the state file are restored by a new daemon
+func TestDaemonToken(t *testing.T) {
+	env, err := makeEnv(defaultConfig, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	env = append(env, "BORING_TOKEN_FILE="+tokenFile)
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	// Written right after the socket is created
+	var info os.FileInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if info, err = os.Stat(tokenFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token file not written: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("token file has mode %o, want 600", perm)
+	}
+
+	// The CLI presents the token
+	if c, out, _ := cliCommand(env, "open", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("could not send command: %v", err)
+	}
+	if r.Success || r.Error != daemon.InvalidToken.Error() || len(r.Tunnels) > 0 {
+		t.Fatalf("command without token not rejected: %+v", r)
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List, Token: strings.TrimSpace(string(token))})
+	if err != nil {
+		t.Fatalf("could not send command: %v", err)
+	}
+	if !r.Success || r.Tunnels["test"].Status != tunnel.Open {
+		t.Fatalf("command with token failed: %+v", r)
+	}
+
+	// Token files that others can access are not trusted
+	if err := os.Chmod(tokenFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, out, _ := cliCommand(env, "list")
+	if c == 0 || !strings.Contains(out, "accessible by other users") {
+		t.Fatalf("expected untrusted token error, got exit code %d: %s", c, out)
+	}
+}
+
+func TestDaemonStateFile(t *testing.T) {
+	env, err := makeEnv(defaultConfig, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	state := filepath.Join(t.TempDir(), "state.json")
+	env = append(env, "BORING_STATE_FILE="+state)
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+
+	c, out, err := cliCommand(env, "open", "test", "test2")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if c, out, _ = cliCommand(env, "close", "test2"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Stopping the daemon keeps the state
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("unix", getEnv(env, "BORING_SOCK"))
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("daemon did not stop")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	data, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatalf("could not read state: %v", err)
+	}
+	if !strings.Contains(string(data), `"name": "test"`) || strings.Contains(string(data), "test2") {
+		t.Fatalf("unexpected state: %s", data)
+	}
+
+	cancel, err = daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err == nil && r.Tunnels["test"].Status == tunnel.Open {
+			if _, ok := r.Tunnels["test2"]; ok {
+				t.Fatalf("closed tunnel was restored")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel not restored from state: %v, %+v", err, r)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// Test that a tunnel which cannot bind anymore is not restored
+func TestDaemonStateFileBindFails(t *testing.T) {
+	env, err := makeEnv(defaultConfig, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	state := filepath.Join(t.TempDir(), "state.json")
+	env = append(env, "BORING_STATE_FILE="+state)
+	data := `[{"name": "test", "host": "127.0.0.1", "local": "localhost:49711", "remote": "localhost:49712"}]`
+	if err := os.WriteFile(state, []byte(data), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:49711")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer l.Close()
+
+	cancel, err := daemonWithCancel(env)
+	if err != nil {
+		t.Fatalf("could not start daemon: %v", err)
+	}
+	defer cancel()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, _ := os.ReadFile(state)
+		if strings.TrimSpace(string(b)) == "[]" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel not dropped from state: %s", b)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil || len(r.Tunnels) != 0 {
+		t.Fatalf("expected no tunnels, got %v, %+v", err, r)
+	}
+}
+
+// Test the full Open -> List -> Close flow against a daemon running in-process
+func TestDaemonInProcess(t *testing.T) {
+	env := inProcessDaemon(t)
+
+	keepAlive := 0
+	desc := tunnel.Desc{
+		Name:          "inproc",
+		Host:          "127.0.0.1",
+		LocalAddress:  "localhost:49711",
+		RemoteAddress: "localhost:49712",
+		KeepAlive:     &keepAlive,
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.Open, Tunnel: &desc})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !r.Success {
+		t.Fatalf("could not open tunnel: %v", r.Error)
+	}
+
+	if r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if r.Tunnels["inproc"].Status != tunnel.Open {
+		t.Fatalf("tunnel not listed as open: %+v", r.Tunnels)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// There is no process to hand over to
+	if r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.Reexec}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if r.Success {
+		t.Fatalf("expected re-exec to fail in-process")
+	}
+
+	if r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.Close, Tunnel: &desc}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !r.Success {
+		t.Fatalf("could not close tunnel: %v", r.Error)
+	}
+
+	// The tunnel is removed asynchronously once closed
+	deadline := time.Now().Add(time.Second)
+	for {
+		r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err == nil && len(r.Tunnels) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel still listed after close: %v, %+v", err, r)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+type staticResolver map[string]string
+
+func (r staticResolver) LookupHost(_ context.Context, host string) ([]string, bool, error) {
+	ip, ok := r[host]
+	return []string{ip}, ok, nil
+}
+
+// Test that host names are resolved by a registered resolver hook
+func TestDaemonInProcessResolver(t *testing.T) {
+	env := inProcessDaemon(t)
+
+	ssh_config.RegisterResolver(staticResolver{"server.ts.test": "127.0.0.1"})
+	defer ssh_config.RegisterResolver(nil)
+
+	keepAlive := 0
+	desc := tunnel.Desc{
+		Name:          "magic",
+		Host:          "server.ts.test",
+		LocalAddress:  "localhost:49711",
+		RemoteAddress: "localhost:49712",
+		KeepAlive:     &keepAlive,
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.Open, Tunnel: &desc})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !r.Success {
+		t.Fatalf("could not open tunnel: %v", r.Error)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+func TestDaemonDebug(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+	if err := testConnected(l, conn); err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.Debug})
+	if err != nil {
+		t.Fatalf("could not get stats: %v", err)
+	}
+	s := r.Stats
+	if s == nil || s.Tunnels != 1 || s.Goroutines == 0 || s.Dump != "" {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if runtime.GOOS == "linux" && s.OpenFiles <= 0 {
+		t.Errorf("expected open files to be counted, got %d", s.OpenFiles)
+	}
+	if s.Conns["test"] != 1 {
+		t.Errorf("expected 1 connection on test, got %v", s.Conns)
+	}
+
+	c, out, _ := cliCommand(env, "debug", "--goroutines")
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	out = stripANSI(out)
+	if !regexp.MustCompile(`Tunnels\s+1\n`).MatchString(out) ||
+		!strings.Contains(out, "goroutine profile:") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
@@ -0,0 +1,51 @@
+package e2e
+
+import (
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "doctor")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	for _, re := range []string{`pass\s+Daemon\s+reachable`, `pass\s+Socket\s`, `pass\s+Config\s`} {
+		if !regexp.MustCompile(re).MatchString(stripANSI(out)) {
+			t.Errorf("output did not match %q: %s", re, out)
+		}
+	}
+}
+
+// A leftover non-socket file at the socket path must be reported
+func TestDoctorBadSocket(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	if err = os.WriteFile(getEnv(env, "BORING_SOCK"), []byte("test"), 0o600); err != nil {
+		t.Fatalf("could not create socket file: %v", err)
+	}
+
+	c, out, err := cliCommand(env, "doctor")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code should be 1, got %d: %s", c, out)
+	}
+	if !regexp.MustCompile(`fail\s+Socket\s.*not a socket`).MatchString(stripANSI(out)) {
+		t.Errorf("socket problem not reported: %s", out)
+	}
+}
@@ -0,0 +1,43 @@
+package e2e
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "graph", "jump@127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	// Both jump hosts come before the host itself
+	hops := []string{"user@127.0.0.1:58391", "test@127.0.0.1:58391", "jump@127.0.0.1:58391"}
+	last := -1
+	for _, h := range hops {
+		i := strings.Index(out, h)
+		if i <= last {
+			t.Fatalf("hop %s missing or out of order: %s", h, out)
+		}
+		last = i
+	}
+	if !strings.Contains(out, "auth: key ../testdata/keys/client") {
+		t.Errorf("output lacks the identity file: %s", out)
+	}
+
+	c, out, _ = cliCommand(env, "graph", "--dot", "jump@127.0.0.1")
+	if c != 0 || !strings.HasPrefix(out, `digraph "127.0.0.1" {`) || !strings.Contains(out, "hop1 -> hop2;") {
+		t.Errorf("exit code %d, unexpected graph: %s", c, out)
+	}
+
+	if c, out, _ = cliCommand(env, "graph", "looper@127.0.0.1"); c == 0 {
+		t.Errorf("expected failure for a jump loop: %s", out)
+	}
+}
@@ -3,6 +3,7 @@ package e2e
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -14,6 +15,8 @@ import (
 	"time"
 
 	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/ipc"
+	"github.com/alebeck/boring/internal/log"
 )
 
 const (
@@ -44,7 +47,7 @@ var defaultConfig = config{
 	useAgent:       false,
 }
 
-func makeEnv(c config, t *testing.T) ([]string, error) {
+func makeEnv(c config, t testing.TB) ([]string, error) {
 	tmpDir := t.TempDir()
 	logFile := filepath.Join(tmpDir, "boringd.log")
 	sockFile := filepath.Join(tmpDir, "boringd.sock")
@@ -142,7 +145,7 @@ func daemonWithCancel(env []string) (context.CancelFunc, error) {
 	}
 }
 
-func makeEnvWithDaemon(c config, t *testing.T) ([]string, context.CancelFunc, error) {
+func makeEnvWithDaemon(c config, t testing.TB) ([]string, context.CancelFunc, error) {
 	env, err := makeEnv(c, t)
 	if err != nil {
 		return nil, nil, err
@@ -158,6 +161,32 @@ func makeDefaultEnvWithDaemon(t *testing.T) ([]string, context.CancelFunc, error
 	return makeEnvWithDaemon(defaultConfig, t)
 }
 
+// inProcessDaemon serves the daemon protocol from within the test process.
+// Tunnels opened through it use the SSH config of defaultConfig.
+func inProcessDaemon(t *testing.T) []string {
+	t.Setenv("BORING_SSH_CONFIG", defaultConfig.sshConfig)
+	t.Setenv("SSH_AUTH_SOCK", "doesnotexist")
+
+	sock := filepath.Join(t.TempDir(), "boringd.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		daemon.Serve(ctx, ln)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return []string{"BORING_SOCK=" + sock}
+}
+
 func cliCommand(env []string, cmds ...string) (int, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
 	defer cancel()
@@ -174,6 +203,27 @@ func cliCommand(env []string, cmds ...string) (int, string, error) {
 	return 0, string(output), nil
 }
 
+// daemonCmd sends a raw command to the daemon of env and returns its response
+func daemonCmd(env []string, cmd daemon.Cmd) (*daemon.Resp, error) {
+	// Need this for IPC functions called below
+	log.Init(io.Discard, false, false)
+
+	conn, err := net.Dial("unix", getEnv(env, "BORING_SOCK"))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to daemon: %w", err)
+	}
+	defer conn.Close()
+
+	if err = ipc.Write(cmd, conn); err != nil {
+		return nil, err
+	}
+	var r daemon.Resp
+	if err = ipc.Read(&r, conn); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
 var ansiRegexp = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
 
 func stripANSI(s string) string {
@@ -0,0 +1,57 @@
+package e2e
+
+import (
+	"strings"
+	"testing"
+)
+
+// Without a terminal, the shell runs without a PTY, and its exit status
+// is passed on
+func TestShell(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "shell", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != shellExitStatus {
+		t.Errorf("exit code %d, should be %d: %s", c, shellExitStatus, out)
+	}
+	if !strings.Contains(out, "mock shell") {
+		t.Errorf("output did not come from the shell: %s", out)
+	}
+}
+
+// With ForwardAgent, the shell can use the local agent
+func TestShellForwardAgent(t *testing.T) {
+	cfg := defaultConfig
+	cfg.useAgent = true
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	cancel, err := startAgent(getEnv(env, "SSH_AUTH_SOCK"))
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "shell", "forward-agent")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != shellExitStatus {
+		t.Errorf("exit code %d, should be %d: %s", c, shellExitStatus, out)
+	}
+	if !strings.Contains(out, "agent keys: 1") {
+		t.Errorf("agent not forwarded: %s", out)
+	}
+
+	// Without ForwardAgent, the agent is not requested
+	if _, out, _ := cliCommand(env, "shell", "127.0.0.1"); strings.Contains(out, "agent keys") {
+		t.Errorf("agent forwarded without ForwardAgent: %s", out)
+	}
+}
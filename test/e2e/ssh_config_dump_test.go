@@ -0,0 +1,86 @@
+package e2e
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func TestSSHConfigDump(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "ssh-config", "jump@127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	var sc struct {
+		User          string   `json:"user"`
+		Port          int      `json:"port"`
+		KeyCheck      string   `json:"strict_host_key_checking"`
+		IdentityFiles []string `json:"identity_files"`
+		Jumps         []struct {
+			Host string `json:"host"`
+			User string `json:"user"`
+			Port int    `json:"port"`
+		} `json:"jumps"`
+	}
+	if err := json.Unmarshal([]byte(out), &sc); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	if sc.User != "jump" || sc.Port != 58391 || sc.KeyCheck != "yes" {
+		t.Errorf("unexpected config: %+v", sc)
+	}
+	if len(sc.IdentityFiles) != 1 || sc.IdentityFiles[0] != "../testdata/keys/client" {
+		t.Errorf("unexpected identity files: %v", sc.IdentityFiles)
+	}
+	if len(sc.Jumps) != 2 || sc.Jumps[0].User != "user" || sc.Jumps[1].Port != 58391 {
+		t.Errorf("unexpected jumps: %+v", sc.Jumps)
+	}
+}
+
+func TestSSHConfigDumpMissingArg(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, _, err := cliCommand(env, "ssh-config")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code should be 1, got %d", c)
+	}
+}
+
+func TestSSHConfigDumpOrigin(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "ssh-config", "--origin", "jump@127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	out = stripANSI(out)
+	for _, re := range []string{
+		`User\s+test\s+# \S*ssh_config:2`,
+		`ProxyJump\s+\S+\s+# \S*ssh_config:9`,
+		`IdentitiesOnly\s+no\s+# \(default\)`,
+	} {
+		if !regexp.MustCompile(re).MatchString(out) {
+			t.Errorf("output did not match %q: %s", re, out)
+		}
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 const (
@@ -18,8 +21,14 @@ const (
 	authorizedKeyFile = "../testdata/keys/client.pub"
 	caKeyFile         = "../testdata/keys/ca.pub"
 	caPrivKeyFile     = "../testdata/keys/ca"
+	authBanner        = "Authorized use only.\n"
 )
 
+// Host names only the server can resolve, like entries in its /etc/hosts
+var remoteHosts = map[string]string{
+	"db.remote-only.test": "127.0.0.1",
+}
+
 type tcpipForwardRequest struct {
 	Addr string
 	Port uint32
@@ -66,8 +75,32 @@ type sshServer struct {
 	pauseCond *sync.Cond
 
 	// these record received keep-alives
-	keepAliveMu sync.Mutex
-	keepAlives  int
+	keepAliveMu      sync.Mutex
+	keepAlives       int
+	ignoreKeepAlives atomic.Bool // don't reply, like an unresponsive server
+
+	// counts received padding requests
+	paddings atomic.Int32
+
+	// counts remote forwards cancelled by clients
+	cancelledForwards atomic.Int32
+
+	// identification strings of all clients that connected, as tunnels of
+	// other tests may still be re-connecting
+	clientVersions sync.Map
+
+	// omit the allocated port when forwarding port 0, like old servers
+	noPortAllocation atomic.Bool
+
+	// refuse forwards of Unix sockets, like servers without the extension
+	noStreamLocal atomic.Bool
+
+	// receives data sent over tun channels
+	tunData chan []byte
+
+	// originator of the last direct-tcpip channel by target address, as
+	// sshd logs it
+	origins sync.Map
 }
 
 func startServer() (s *sshServer, err error) {
@@ -123,9 +156,11 @@ func startServer() (s *sshServer, err error) {
 	s = &sshServer{}
 	s.config = &ssh.ServerConfig{
 		PublicKeyCallback: checker.Authenticate,
+		BannerCallback:    func(ssh.ConnMetadata) string { return authBanner },
 	}
 
 	s.conns = make(map[net.Conn]struct{})
+	s.tunData = make(chan []byte, 64)
 
 	s.pauseCond = sync.NewCond(&s.pauseMu)
 
@@ -174,21 +209,84 @@ func (s *sshServer) handleConn(conn net.Conn) {
 	if err != nil {
 		return
 	}
+	s.clientVersions.Store(string(c.ClientVersion()), true)
 
 	go func() {
+		// Listeners of remote forwards by address, to be cancelled
+		forwards := make(map[string]net.Listener)
 		for req := range reqs {
-			if req.Type == "tcpip-forward" {
-				// parse payload, reply true
+			if req.Type == "cancel-tcpip-forward" {
 				var payload tcpipForwardRequest
 				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
 					req.Reply(false, nil)
-					return
+					continue
+				}
+				key := fmt.Sprintf("%s:%d", payload.Addr, payload.Port)
+				l, ok := forwards[key]
+				if ok {
+					l.Close()
+					delete(forwards, key)
+					s.cancelledForwards.Add(1)
+				}
+				req.Reply(ok, nil)
+			} else if req.Type == "streamlocal-forward@openssh.com" ||
+				req.Type == "cancel-streamlocal-forward@openssh.com" {
+				var payload streamLocalForwardRequest
+				if s.noStreamLocal.Load() || ssh.Unmarshal(req.Payload, &payload) != nil {
+					req.Reply(false, nil)
+					continue
+				}
+				key := "unix:" + payload.SocketPath
+				if req.Type == "cancel-streamlocal-forward@openssh.com" {
+					l, ok := forwards[key]
+					if ok {
+						l.Close()
+						delete(forwards, key)
+						s.cancelledForwards.Add(1)
+					}
+					req.Reply(ok, nil)
+					continue
+				}
+				l, err := net.Listen("unix", payload.SocketPath)
+				if err != nil {
+					req.Reply(false, nil)
+					continue
 				}
+				forwards[key] = l
 				req.Reply(true, nil)
-				go listenAndForward(c, payload)
+				go forwardStreamLocal(c, l, payload.SocketPath)
+			} else if req.Type == "tcpip-forward" {
+				// listen before replying, so connections can be forwarded right away
+				var payload tcpipForwardRequest
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					req.Reply(false, nil)
+					return
+				}
+				l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", payload.Addr, payload.Port))
+				if err != nil {
+					fmt.Printf("failed to listen on %s:%d: %v\n", payload.Addr, payload.Port, err)
+					req.Reply(false, nil)
+					continue
+				}
+				var resp []byte
+				if payload.Port == 0 {
+					payload.Port = uint32(l.Addr().(*net.TCPAddr).Port)
+					if !s.noPortAllocation.Load() {
+						resp = ssh.Marshal(struct{ Port uint32 }{payload.Port})
+					}
+				}
+				forwards[fmt.Sprintf("%s:%d", payload.Addr, payload.Port)] = l
+				req.Reply(true, resp)
+				go forward(c, l, payload)
 			} else {
+				if req.Type == "ping@openssh.com" {
+					s.paddings.Add(1)
+				}
 				if req.Type == "keepalive@golang.org" {
 					s.incrementKeepAlives()
+					if s.ignoreKeepAlives.Load() {
+						continue
+					}
 				}
 				req.Reply(false, nil)
 			}
@@ -197,19 +295,27 @@ func (s *sshServer) handleConn(conn net.Conn) {
 
 	for newChannel := range chans {
 		if newChannel.ChannelType() == "direct-tcpip" {
+			go s.handleForwardedConnection(newChannel)
+		} else if newChannel.ChannelType() == "tun@openssh.com" {
 			channel, requests, err := newChannel.Accept()
 			if err != nil {
 				return
 			}
 			go ssh.DiscardRequests(requests)
-			go handleForwardedConnection(channel, newChannel.ExtraData())
+			go s.handleTun(channel)
+		} else if newChannel.ChannelType() == "session" {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go handleSession(c, channel, requests)
 		} else {
 			newChannel.Reject(ssh.UnknownChannelType, "no channels supported")
 		}
 	}
 }
 
-func listenAndForward(c *ssh.ServerConn, req tcpipForwardRequest) {
+func forward(c *ssh.ServerConn, l net.Listener, req tcpipForwardRequest) {
 	remote := c.RemoteAddr().(*net.TCPAddr)
 	payload := ssh.Marshal(forwardedTCPPayload{
 		Addr:       req.Addr,
@@ -217,12 +323,6 @@ func listenAndForward(c *ssh.ServerConn, req tcpipForwardRequest) {
 		OriginAddr: remote.IP.String(),
 		OriginPort: uint32(remote.Port),
 	})
-
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", req.Addr, req.Port))
-	if err != nil {
-		fmt.Printf("failed to listen on %s:%d: %v\n", req.Addr, req.Port, err)
-		return
-	}
 	defer l.Close()
 
 	// Close the listener when the server connection is closed
@@ -251,26 +351,153 @@ func listenAndForward(c *ssh.ServerConn, req tcpipForwardRequest) {
 	}
 }
 
-func handleForwardedConnection(channel ssh.Channel, extra []byte) {
-	defer channel.Close()
+type streamLocalForwardRequest struct {
+	SocketPath string
+}
+
+// forwardStreamLocal forwards connections to a Unix socket listener over
+// forwarded-streamlocal@openssh.com channels, like sshd does
+func forwardStreamLocal(c *ssh.ServerConn, l net.Listener, path string) {
+	payload := ssh.Marshal(struct{ SocketPath, Reserved string }{SocketPath: path})
+	defer l.Close()
+	go func() {
+		c.Wait()
+		l.Close()
+	}()
 
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			ch, reqs, err := c.OpenChannel("forwarded-streamlocal@openssh.com", payload)
+			if err != nil {
+				return
+			}
+			defer ch.Close()
+			go ssh.DiscardRequests(reqs)
+			go io.Copy(ch, conn)
+			io.Copy(conn, ch)
+		}()
+	}
+}
+
+// handleForwardedConnection connects to the target of a direct-tcpip
+// channel, which is only accepted if this succeeds, like sshd does
+func (s *sshServer) handleForwardedConnection(newChannel ssh.NewChannel) {
 	var payload forwardedTCPPayload
-	if err := ssh.Unmarshal(extra, &payload); err != nil {
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
 		fmt.Printf("failed to unmarshal forwarded-tcpip payload: %v\n", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
 		return
 	}
-	addr := net.JoinHostPort(payload.Addr, fmt.Sprintf("%d", payload.Port))
+	s.origins.Store(net.JoinHostPort(payload.Addr, fmt.Sprint(payload.Port)),
+		net.JoinHostPort(payload.OriginAddr, fmt.Sprint(payload.OriginPort)))
+	host := payload.Addr
+	if ip, ok := remoteHosts[host]; ok {
+		host = ip
+	}
+	addr := net.JoinHostPort(host, fmt.Sprintf("%d", payload.Port))
 
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		fmt.Printf("failed to connect to %s: %v\n", addr, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
 		return
 	}
 	defer conn.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
 	go io.Copy(conn, channel)
 	io.Copy(channel, conn)
 }
 
+// Exit status of the mock shell
+const shellExitStatus = 3
+
+// handleSession serves the sftp subsystem on the local file system, and a
+// shell echoing its input, which first reports the keys of the forwarded
+// agent if requested; other requests are refused
+func handleSession(c *ssh.ServerConn, channel ssh.Channel, reqs <-chan *ssh.Request) {
+	defer channel.Close()
+	fwdAgent := false
+	for req := range reqs {
+		if req.Type == "pty-req" || req.Type == "window-change" {
+			req.Reply(true, nil)
+			continue
+		}
+		if req.Type == "auth-agent-req@openssh.com" {
+			fwdAgent = true
+			req.Reply(true, nil)
+			continue
+		}
+		if req.Type == "shell" {
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			fmt.Fprintf(channel, "mock shell\n")
+			if fwdAgent {
+				fmt.Fprintf(channel, "agent keys: %d\n", forwardedKeys(c))
+			}
+			io.Copy(channel, channel)
+			status := struct{ Status uint32 }{shellExitStatus}
+			channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+			return
+		}
+		var name struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &name) != nil ||
+			name.Name != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		server.Serve()
+		server.Close()
+		return
+	}
+}
+
+// forwardedKeys returns the number of keys in the agent forwarded by the
+// client, or -1 if it cannot be reached
+func forwardedKeys(c *ssh.ServerConn) int {
+	ch, reqs, err := c.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return -1
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+	keys, err := agent.NewClient(ch).List()
+	if err != nil {
+		return -1
+	}
+	return len(keys)
+}
+
+func (s *sshServer) handleTun(channel ssh.Channel) {
+	defer channel.Close()
+	buf := make([]byte, 65535)
+	for {
+		n, err := channel.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case s.tunData <- append([]byte{}, buf[:n]...):
+		default: // nobody is listening
+		}
+	}
+}
+
 func (s *sshServer) cleanup() {
 	s.listener.Close()
 }
@@ -0,0 +1,72 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Test forwarding stdio to a remote address, like ssh -W
+func TestStdioForward(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	l, err := makeListener("localhost:49715")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+
+	// Echo a single message back, then close
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, len(testMsg))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		conn.Write(buf)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, "-W", "localhost:49715", "127.0.0.1")
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(testMsg)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("command failed: %v: %s", err, stderr.String())
+	}
+	if stdout.String() != string(testMsg) {
+		t.Errorf("expected %q on stdout, got %q", testMsg, stdout.String())
+	}
+}
+
+func TestStdioForwardMissingArgs(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "-W", "localhost:49715")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code %d, should be 1", c)
+	}
+	if !strings.Contains(out, "requires exactly one") {
+		t.Errorf("output did not indicate missing arguments: %s", out)
+	}
+}
@@ -0,0 +1,169 @@
+package e2e
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that every hop of a jump chain is reported
+func TestTrace(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "trace", "jump@127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if n := strings.Count(out, "pass"); n != 3 {
+		t.Errorf("expected 3 reachable hops, got %d: %s", n, out)
+	}
+	if !strings.Contains(out, "ssh as jump") {
+		t.Errorf("output did not show the user of the final hop: %s", out)
+	}
+}
+
+// Test that tracing stops at the hop where the chain breaks
+func TestTraceBroken(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "trace", "broken-chain")
+	out = stripANSI(out)
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code %d, should be 1: %s", c, out)
+	}
+	if !strings.Contains(out, "pass  1 127.0.0.1:58391") {
+		t.Errorf("output did not show the first hop as reachable: %s", out)
+	}
+	if !strings.Contains(out, "fail  2 127.0.0.1:58399") || !strings.Contains(out, "tcp failed") {
+		t.Errorf("output did not show where the chain breaks: %s", out)
+	}
+}
+
+// tlsProxy terminates TLS with a self-signed certificate and forwards the
+// connections to the mock server, like a TLS frontend on port 443 would.
+// It returns the port and the pin of the certificate's key.
+func tlsProxy(t *testing.T) (int, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ssh.example.com"},
+		DNSNames:     []string{"ssh.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c1, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c1.Close()
+				c2, err := net.Dial("tcp", "127.0.0.1:58391")
+				if err != nil {
+					return
+				}
+				defer c2.Close()
+				go io.Copy(c2, c1)
+				io.Copy(c1, c2)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Test that hops configured with TLS are reached through a TLS frontend,
+// and that a server key not matching the pin is rejected
+func TestTraceTLS(t *testing.T) {
+	port, pin := tlsProxy(t)
+	base, err := os.ReadFile(defaultConfig.sshConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig
+	cfg.sshConfig = filepath.Join(t.TempDir(), "ssh_config")
+	stanzas := fmt.Sprintf(`Host tls-wrapped
+    HostName 127.0.0.1
+    Port %d
+    StrictHostKeyChecking no
+    TLS yes
+    TLSServerName ssh.example.com
+    TLSPinnedPubKey %s
+Host tls-wrong-pin
+    HostName 127.0.0.1
+    Port %d
+    StrictHostKeyChecking no
+    TLS yes
+    TLSPinnedPubKey sha256//%s
+`, port, pin, port, base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+	if err := os.WriteFile(cfg.sshConfig, append([]byte(stanzas), base...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "trace", "tls-wrapped")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || !strings.Contains(out, "pass") {
+		t.Fatalf("exit code %d, should be 0: %s", c, out)
+	}
+
+	c, out, err = cliCommand(env, "trace", "tls-wrong-pin")
+	out = stripANSI(out)
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 || !strings.Contains(out, "tls failed") || !strings.Contains(out, "TLSPinnedPubKey") {
+		t.Errorf("expected the pin to be rejected: %s", out)
+	}
+}
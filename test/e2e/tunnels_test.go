@@ -1,17 +1,26 @@
 package e2e
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/tunnel"
+	"golang.org/x/crypto/ssh"
 	xproxy "golang.org/x/net/proxy"
 	"golang.org/x/sync/errgroup"
 )
@@ -166,6 +175,151 @@ func TestOpenAlreadyRunning(t *testing.T) {
 	}
 }
 
+// Tests that concurrent opens of the same tunnel open it only once
+func TestOpenConcurrentSameName(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	const n = 4
+	outs := make([]string, n)
+	var g errgroup.Group
+	for i := range n {
+		g.Go(func() error {
+			c, out, err := cliCommand(env, "open", "test")
+			if err != nil {
+				return err
+			}
+			if c != 0 {
+				return fmt.Errorf("exit code %d, should be 0: %s", c, out)
+			}
+			outs[i] = stripANSI(out)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	var opened int
+	for _, out := range outs {
+		if strings.Contains(out, "Opened tunnel 'test'") {
+			opened++
+		} else if !strings.Contains(out, "is already running") {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	}
+	if opened != 1 {
+		t.Fatalf("tunnel opened %d times, should be once: %q", opened, outs)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// BenchmarkOpenBatch opens and closes a batch of independent tunnels,
+// which the daemon connects concurrently
+func BenchmarkOpenBatch(b *testing.B) {
+	const n = 20
+	var buf strings.Builder
+	buf.WriteString("keep_alive = 0\n")
+	for i := range n {
+		fmt.Fprintf(&buf, "\n[[tunnels]]\nname = \"batch-%d\"\nhost = \"127.0.0.1\"\n"+
+			"local = %d\nremote = \"localhost:49712\"\n", i, 49800+i)
+	}
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(b.TempDir(), "config.toml")
+	if err := os.WriteFile(cfg.boringConfig, []byte(buf.String()), 0600); err != nil {
+		b.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, b)
+	if err != nil {
+		b.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	for b.Loop() {
+		for _, cmd := range []string{"open", "close"} {
+			c, out, err := cliCommand(env, cmd, "--all")
+			if err != nil {
+				b.Fatalf("failed to run CLI command: %v", err)
+			}
+			if c != 0 {
+				b.Fatalf("%s: exit code %d, should be 0: %s", cmd, c, out)
+			}
+		}
+	}
+}
+
+func TestOpenWait(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "--wait", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || !strings.Contains(stripANSI(out), "Opened tunnel 'test'") {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	// Running already, and ready
+	if c, out, _ = cliCommand(env, "open", "test", "-w"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if c, out, _ = cliCommand(env, "close", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Disarmed tunnels do not get ready, but keep running
+	c, out, _ = cliCommand(env, "open", "-w", "test-disarmed")
+	if c == 0 || !strings.Contains(out, "disarmed") {
+		t.Fatalf("exit code %d, expected failure for disarmed tunnel: %s", c, out)
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := r.Tunnels["test-disarmed"]; !ok {
+		t.Errorf("tunnel closed after failing to get ready: %+v", r.Tunnels)
+	}
+}
+
+// Tests that opening with --wait while another command opens the tunnel
+// waits for it, rather than reporting that it runs already
+func TestOpenWaitConcurrent(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	var g errgroup.Group
+	for range 4 {
+		g.Go(func() error {
+			c, out, err := cliCommand(env, "open", "--wait", "test")
+			if err != nil {
+				return err
+			}
+			if c != 0 {
+				return fmt.Errorf("exit code %d, should be 0: %s", c, out)
+			}
+			conn, err := net.Dial("tcp", "localhost:49711")
+			if err != nil {
+				return fmt.Errorf("not listening after waiting: %v", err)
+			}
+			return conn.Close()
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Tests that we only support valid forwarding specifications as in ssh -L/R
 func TestOpenBadRemoteConfig(t *testing.T) {
 	env, cancel, err := makeDefaultEnvWithDaemon(t)
@@ -278,8 +432,8 @@ func TestCloseNotRunning(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to run CLI command: %v", err)
 	}
-	if c != 1 {
-		t.Fatalf("exit code %d, should be 1", c)
+	if c != 2 {
+		t.Fatalf("exit code %d, should be 2", c)
 	}
 	if !strings.Contains(out, "No running tunnels match any provided pattern") {
 		t.Errorf("output did not indicate no running tunnels: %s", out)
@@ -332,6 +486,250 @@ func TestCloseNoPattern(t *testing.T) {
 	}
 }
 
+// Test renaming a running tunnel, which keeps its connections
+func TestRename(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	for _, n := range []string{"test", "test2"} {
+		if c, out, _ := cliCommand(env, "open", n); c != 0 {
+			t.Fatalf("exit code %d: %s", c, out)
+		}
+	}
+
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+
+	c, out, err := cliCommand(env, "rename", "test", "renamed")
+	if err != nil || c != 0 {
+		t.Fatalf("exit code %d: %v, %s", c, err, out)
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := r.Tunnels["test"]; ok {
+		t.Errorf("tunnel still listed under its old name")
+	}
+	if d, ok := r.Tunnels["renamed"]; !ok || d.Name != "renamed" {
+		t.Errorf("tunnel not listed under its new name: %v", r.Tunnels)
+	}
+	if err := testConnected(l, conn); err != nil {
+		t.Errorf("connection interrupted: %v", err)
+	}
+
+	// Names of running tunnels are not taken over
+	if c, out, _ := cliCommand(env, "rename", "renamed", "test2"); c != 1 ||
+		!strings.Contains(out, "already running") {
+		t.Errorf("exit code %d, expected 1: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "rename", "test", "other"); c != 2 {
+		t.Errorf("exit code %d, expected 2: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "rename", "renamed", "bad*name"); c != 1 {
+		t.Errorf("exit code %d, expected 1: %s", c, out)
+	}
+
+	conn.Close()
+	if c, out, _ := cliCommand(env, "close", "renamed"); c != 0 {
+		t.Errorf("exit code %d: %s", c, out)
+	}
+}
+
+// Test that running tunnels are exported as a config they can be opened from
+func TestExport(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "rename", "test", "renamed"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "export", "nothing*"); c != 2 {
+		t.Errorf("exit code %d, expected 2: %s", c, out)
+	}
+
+	path := filepath.Join(t.TempDir(), "exported.toml")
+	c, out, err := cliCommand(env, "export", "-o", path, "ren*")
+	if err != nil || c != 0 {
+		t.Fatalf("exit code %d: %v, %s", c, err, out)
+	}
+	if c, out, _ := cliCommand(env, "close", "renamed"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	env = setEnv(env, "BORING_CONFIG", path)
+	if c, out, _ := cliCommand(env, "open", "renamed"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// Test that a disarmed tunnel only listens while armed
+func TestArm(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test-disarmed"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if _, err := dial("localhost:49711"); err == nil {
+		t.Fatalf("disarmed tunnel is listening")
+	}
+	c, out, _ := cliCommand(env, "list")
+	if c != 0 || !strings.Contains(stripANSI(out), "disarmed") {
+		t.Errorf("exit code %d, tunnel not listed as disarmed: %s", c, out)
+	}
+
+	if c, out, _ := cliCommand(env, "arm", "test-disarmed"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	if c, out, _ := cliCommand(env, "disarm", "test-disarmed"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if _, err := dial("localhost:49711"); err == nil {
+		t.Errorf("disarmed tunnel is listening")
+	}
+
+	// Only running tunnels with a local listener can be armed
+	if c, out, _ := cliCommand(env, "arm", "test"); c != 2 {
+		t.Errorf("exit code %d, expected 2: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "open", "test-remote"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "disarm", "test-remote"); c != 1 {
+		t.Errorf("exit code %d, expected 1: %s", c, out)
+	}
+
+	for _, n := range []string{"test-disarmed", "test-remote"} {
+		if c, out, _ := cliCommand(env, "close", n); c != 0 {
+			t.Errorf("exit code %d: %s", c, out)
+		}
+	}
+}
+
+// Test that scheduled tunnels connect within their window only, and that
+// the list tells when
+func TestSchedule(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test-waiting"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if _, err := dial("localhost:49711"); err == nil {
+		t.Fatalf("tunnel is listening outside of its schedule")
+	}
+	c, out, _ := cliCommand(env, "list")
+	out = stripANSI(out)
+	if c != 0 || !strings.Contains(out, "waiting") || !strings.Contains(out, "test-waiting: connects at") {
+		t.Errorf("exit code %d, tunnel not listed as waiting: %s", c, out)
+	}
+	if !strings.Contains(out, "test-scheduled: scheduled by '* * * * *' once opened") {
+		t.Errorf("schedule of closed tunnel not listed: %s", out)
+	}
+	if c, out, _ := cliCommand(env, "close", "test-waiting"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Within the window, the tunnel connects right away
+	if c, out, _ := cliCommand(env, "open", "test-scheduled"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	c, out, _ = cliCommand(env, "list")
+	if c != 0 || !strings.Contains(stripANSI(out), "test-scheduled: disconnects at") {
+		t.Errorf("exit code %d, end of window not listed: %s", c, out)
+	}
+	if c, out, _ := cliCommand(env, "close", "test-scheduled"); c != 0 {
+		t.Errorf("exit code %d: %s", c, out)
+	}
+}
+
+func TestClosePort(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test", "test2")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	c, out, _ = cliCommand(env, "list", ":49711")
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	if len(lines) != 2 || strings.Fields(lines[1])[1] != "test" {
+		t.Fatalf("expected only tunnel test in list output: %s", out)
+	}
+
+	c, out, _ = cliCommand(env, "close", ":49711")
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !strings.Contains(stripANSI(out), "Closed tunnel 'test' on local port 49711.") {
+		t.Fatalf("output did not confirm closed tunnel: %s", out)
+	}
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("could not list tunnels: %v", err)
+	}
+	if _, ok := r.Tunnels["test"]; ok {
+		t.Fatalf("tunnel test still running")
+	}
+	test2, ok := r.Tunnels["test2"]
+	if !ok {
+		t.Fatalf("tunnel test2 was closed")
+	}
+
+	// Only the port of the command selects tunnels, not names like one
+	sel := tunnel.Desc{Name: ":" + test2.LocalPort()}
+	if r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.Close, Tunnel: &sel}); err != nil {
+		t.Fatalf("could not send close command: %v", err)
+	}
+	if r.Success {
+		t.Fatalf("closed tunnels by name %q", sel.Name)
+	}
+
+	c, out, _ = cliCommand(env, "close", ":49711")
+	if c != 2 || !strings.Contains(out, "tunnel not running on local port 49711") {
+		t.Fatalf("expected failure for unused port, got exit code %d: %s", c, out)
+	}
+}
+
 func TestCloseAll(t *testing.T) {
 	// TODO
 }
@@ -460,8 +858,8 @@ func TestListGroupFilterNotFound(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to run CLI command: %v", err)
 	}
-	if c != 1 {
-		t.Fatalf("exit code %d, expected 1", c)
+	if c != 2 {
+		t.Fatalf("exit code %d, expected 2", c)
 	}
 	if !strings.Contains(out, "No tunnels in group 'nonexistent'") {
 		t.Errorf("output did not indicate no tunnels in group: %s", out)
@@ -707,34 +1105,100 @@ func TestTunnelRemote(t *testing.T) {
 	testTunnel(t, "localhost:49712", "localhost:49711")
 }
 
-func TestOpenManualConfig(t *testing.T) {
-	cfg := defaultConfig
-	// Only provides known hosts, everything else has to be configured manually
-	cfg.sshConfig = "../testdata/config/ssh_config_kh_only"
+// Test that closing a remote forward cancels it on the server, so that
+// the remote port can be bound again right away
+func TestTunnelRemoteReopen(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	before := server.cancelledForwards.Load()
+	for i := range 3 {
+		if c, out, _ := cliCommand(env, "open", "test-remote"); c != 0 {
+			t.Fatalf("open %d: exit code %d: %s", i, c, out)
+		}
+		if c, out, _ := cliCommand(env, "close", "test-remote"); c != 0 {
+			t.Fatalf("close %d: exit code %d: %s", i, c, out)
+		}
+	}
+	if n := server.cancelledForwards.Load() - before; n != 3 {
+		t.Errorf("server cancelled %d forwards, expected 3", n)
+	}
+}
 
+// Test forwarding from a Unix socket on the server, whose listener is
+// cancelled on closing, and a server refusing it
+func TestTunnelRemoteUnix(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "remote.sock")
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(dir, "config.toml")
+	conf := fmt.Sprintf(`keep_alive = 0
+
+[[tunnels]]
+name = "test-remote-unix"
+mode = "remote"
+host = "127.0.0.1"
+local = "localhost:49711"
+remote = %q
+`, sock)
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
 	env, cancel, err := makeEnvWithDaemon(cfg, t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
 	defer cancel()
 
-	c, out, err := cliCommand(env, "open", "test-manual")
+	if c, out, _ := cliCommand(env, "open", "test-remote-unix"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	l, err := makeListener("localhost:49711")
 	if err != nil {
-		t.Fatalf("failed to run CLI command: %v", err)
+		t.Fatalf("%v", err.Error())
 	}
-	if c != 0 {
+	defer l.Close()
+	conn, err := net.DialTimeout("unix", sock, connTimeout)
+	if err != nil {
+		t.Fatalf("failed to dial remote socket: %v", err)
+	}
+	defer conn.Close()
+	if err := testConnected(l, conn); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	before := server.cancelledForwards.Load()
+	if c, out, _ := cliCommand(env, "close", "test-remote-unix"); c != 0 {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
+	if n := server.cancelledForwards.Load() - before; n != 1 {
+		t.Errorf("server cancelled %d forwards, expected 1", n)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("remote socket not removed: %v", err)
+	}
+
+	server.noStreamLocal.Store(true)
+	defer server.noStreamLocal.Store(false)
+	c, out, _ := cliCommand(env, "open", "test-remote-unix")
+	if c != 6 || !strings.Contains(out, "may not support forwarding Unix sockets") {
+		t.Errorf("exit code %d, expected 6 for a refused socket: %s", c, out)
+	}
 }
 
-func TestTunnelReconnect(t *testing.T) {
+// Test that remote port 0 lets the server allocate the port, which is
+// reported back to the client
+func TestTunnelRemoteDynamicPort(t *testing.T) {
 	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
 	defer cancel()
 
-	c, out, err := cliCommand(env, "open", "test")
+	c, out, err := cliCommand(env, "open", "test-remote-dynamic")
 	if err != nil {
 		t.Fatalf("failed to run CLI command: %v", err)
 	}
@@ -742,20 +1206,97 @@ func TestTunnelReconnect(t *testing.T) {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
 
-	time.Sleep(50 * time.Millisecond) // Give the tunnel some time to establish
-
-	server.pause()
-	server.closeAll()
-
-	// verify tunnel is in Reconn state
-	c, out, err = cliCommand(env, "list")
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
 	if err != nil {
-		t.Fatalf("failed to run CLI command: %v", err)
+		t.Fatalf("%v", err)
 	}
-	if c != 0 {
-		t.Fatalf("exit code %d: %s", c, out)
+	port := r.Tunnels["test-remote-dynamic"].AllocatedPort
+	if port == 0 {
+		t.Fatalf("expected allocated port, got %+v", r.Tunnels["test-remote-dynamic"])
 	}
-	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+
+	c, out, err = cliCommand(env, "list")
+	if err != nil || c != 0 {
+		t.Fatalf("list failed with exit code %d: %v, %s", c, err, out)
+	}
+	if want := fmt.Sprintf("(port %d)", port); !strings.Contains(out, want) {
+		t.Errorf("expected %q in list output: %s", want, out)
+	}
+
+	testTunnel(t, fmt.Sprintf("localhost:%d", port), "localhost:49711")
+}
+
+func TestTunnelRemoteDynamicPortUnsupported(t *testing.T) {
+	server.noPortAllocation.Store(true)
+	defer server.noPortAllocation.Store(false)
+
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-remote-dynamic")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c == 0 {
+		t.Fatalf("expected open to fail: %s", out)
+	}
+	if !strings.Contains(out, "server did not allocate a remote port") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestOpenManualConfig(t *testing.T) {
+	cfg := defaultConfig
+	// Only provides known hosts, everything else has to be configured manually
+	cfg.sshConfig = "../testdata/config/ssh_config_kh_only"
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-manual")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+func TestTunnelReconnect(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	time.Sleep(50 * time.Millisecond) // Give the tunnel some time to establish
+
+	server.pause()
+	server.closeAll()
+
+	// verify tunnel is in Reconn state
+	c, out, err = cliCommand(env, "list")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
 
 	if strings.Fields(lines[1])[0] != "reconn" {
 		t.Errorf("test tunnel not reconnecting in list: %s", out)
@@ -768,6 +1309,50 @@ func TestTunnelReconnect(t *testing.T) {
 	testTunnel(t, "localhost:49711", "localhost:49712")
 }
 
+// Test that a tunnel waiting to re-connect can be made to try right away
+func TestTunnelReconnectNow(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test-backoff"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	c, out, err := cliCommand(env, "reconnect", "test-backoff")
+	if err != nil || c != 0 || !strings.Contains(out, "nothing to do") {
+		t.Errorf("expected no-op for connected tunnel, exit code %d: %v, %s", c, err, out)
+	}
+	if c, out, _ := cliCommand(env, "reconnect", "other"); c != 2 {
+		t.Errorf("exit code %d, expected 2: %s", c, out)
+	}
+
+	time.Sleep(50 * time.Millisecond) // Give the tunnel some time to establish
+	server.pause()
+	server.closeAll()
+	time.Sleep(100 * time.Millisecond)
+	server.resume()
+
+	// Without the command, the next attempt would be in an hour
+	c, out, err = cliCommand(env, "reconnect", "test-backoff")
+	if err != nil || c != 0 || !strings.Contains(out, "Re-connecting") {
+		t.Fatalf("exit code %d: %v, %s", c, err, out)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err == nil && r.Tunnels["test-backoff"].Status == tunnel.Open {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel not re-connected: %v, %+v", err, r)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
 func TestTunnelReconnectAbort(t *testing.T) {
 	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
@@ -937,86 +1522,929 @@ func TestTunnelKeepAlive(t *testing.T) {
 	}
 }
 
-// Test connecting to a server that presents an SSH host certificate,
-// trusted via an @cert-authority known_hosts entry.
-func TestTunnelHostCert(t *testing.T) {
-	cfg := defaultConfig
-	cfg.sshConfig = "../testdata/config/ssh_config_hostcert"
+// Test routing TLS connections by their server name
+func TestTunnelSNI(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
 
-	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if c, out, _ := cliCommand(env, "open", "test-sni"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	go tls.Client(conn, &tls.Config{ServerName: "a.test"}).Handshake()
+
+	target, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept connection: %v", err)
+	}
+	target.SetReadDeadline(time.Now().Add(connTimeout))
+	buf := make([]byte, 512)
+	n, err := target.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if buf[0] != 0x16 || !bytes.Contains(buf[:n], []byte("a.test")) {
+		t.Errorf("ClientHello not forwarded: %x", buf[:n])
+	}
+	target.Close()
+	conn.Close()
+
+	// Connections without SNI are rejected as configured
+	conn, err = dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	conn.SetReadDeadline(time.Now().Add(connTimeout))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Errorf("connection without SNI not closed: %v", err)
+	}
+	conn.Close()
+
+	if c, out, _ := cliCommand(env, "close", "test-sni"); c != 0 {
+		t.Errorf("exit code %d: %s", c, out)
+	}
+}
+
+func TestTunnelLocalCommand(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
 	defer cancel()
 
-	c, out, err := cliCommand(env, "open", "test")
+	// A failing command is only logged by default
+	if c, out, _ := cliCommand(env, "open", "test-local-command"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	logs, err := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
 	if err != nil {
-		t.Fatalf("failed to run CLI command: %v", err)
+		t.Fatal(err)
 	}
-	if c != 0 {
+	if !strings.Contains(string(logs), "local command: test-local-command up on port 49711, 100%") ||
+		!strings.Contains(string(logs), "local command failed: exit status 1") {
+		t.Fatalf("local command output not logged: %s", logs)
+	}
+	if c, out, _ := cliCommand(env, "close", "test-local-command"); c != 0 {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
-	if !strings.Contains(strings.ToLower(out), "opened tunnel") {
-		t.Errorf("output did not indicate success: %s", out)
+
+	c, out, _ := cliCommand(env, "open", "test-local-command-fatal")
+	if c == 0 || !strings.Contains(out, "local command failed: exit status 3") {
+		t.Fatalf("expected open to fail, got exit code %d: %s", c, out)
+	}
+	// The local port is free again
+	l, err := net.Listen("tcp", "localhost:49711")
+	if err != nil {
+		t.Fatalf("local port still in use: %v", err)
 	}
+	l.Close()
 }
 
-// Test SSH certificate authentication
-func TestTunnelCert(t *testing.T) {
-	cfg := defaultConfig
-	cfg.sshConfig = "../testdata/config/ssh_config_cert"
+func TestTunnelPadding(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
 
-	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	// Padding is off by default
+	if c, out, _ := cliCommand(env, "open", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	server.paddings.Store(0)
+	time.Sleep(200 * time.Millisecond)
+	if n := server.paddings.Load(); n != 0 {
+		t.Fatalf("expected no padding, got %d requests", n)
+	}
+	if c, out, _ := cliCommand(env, "close", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	if c, out, _ := cliCommand(env, "open", "test-padding"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	server.paddings.Store(0)
+	time.Sleep(2500 * time.Millisecond)
+	if n := server.paddings.Load(); n < 2 {
+		t.Fatalf("expected padding while idle, got %d requests", n)
+	}
+}
+
+// Test that datagrams are carried framed over TCP in udp mode, the remote
+// echoes the frames back
+func TestTunnelUDP(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
 	defer cancel()
 
-	c, out, err := cliCommand(env, "open", "test")
+	l, err := makeListener("localhost:49712")
 	if err != nil {
-		t.Fatalf("failed to run CLI command: %v", err)
+		t.Fatalf("%v", err.Error())
 	}
-	if c != 0 {
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	if c, out, _ := cliCommand(env, "open", "test-udp"); c != 0 {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connTimeout))
+	buf := make([]byte, 100)
+	for _, msg := range []string{"ping", "pong"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("expected %q, got %q", msg, buf[:n])
+		}
+	}
 }
 
-// Test sibling cert (*-cert.pub) fallback
-func TestTunnelSiblingCert(t *testing.T) {
-	cfg := defaultConfig
-	cfg.sshConfig = "../testdata/config/ssh_config_sibling_cert"
+func TestTunnelClientVersion(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
 
-	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if c, out, _ := cliCommand(env, "open", "test-client-version"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if _, ok := server.clientVersions.Load("SSH-2.0-OpenSSH_9.9"); !ok {
+		t.Fatal("server did not see the client version")
+	}
+}
+
+// Test that the client address is announced to the target in a PROXY header
+func TestTunnelProxyProtocol(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
 	defer cancel()
 
-	c, out, err := cliCommand(env, "open", "test")
+	if c, out, _ := cliCommand(env, "open", "test-proxy-protocol"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	l, err := makeListener("127.0.0.1:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("127.0.0.1:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+	conn.Write(testMsg)
+
+	target, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept connection: %v", err)
+	}
+	defer target.Close()
+	target.SetReadDeadline(time.Now().Add(connTimeout))
+
+	port := conn.LocalAddr().(*net.TCPAddr).Port
+	want := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d 49711\r\n%s", port, testMsg)
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(target, buf); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if string(buf) != want {
+		t.Errorf("expected %q, got %q", want, buf)
+	}
+}
+
+// Test that with ServerAliveCountMax 0, keep-alives are sent but missing
+// replies never tear down the connection
+func TestTunnelKeepAliveNoCountMax(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-keepalive-nomax")
 	if err != nil {
 		t.Fatalf("failed to run CLI command: %v", err)
 	}
 	if c != 0 {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
-}
 
-// Test that no cert is used if none is compatible
-func TestTunnelIgnoreWrongCert(t *testing.T) {
-	cfg := defaultConfig
-	cfg.sshConfig = "../testdata/config/ssh_config_wrong_cert"
+	server.ignoreKeepAlives.Store(true)
+	defer server.ignoreKeepAlives.Store(false)
+	server.resetKeepAlives()
 
-	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	time.Sleep(3500 * time.Millisecond)
+
+	server.keepAliveMu.Lock()
+	n := server.keepAlives
+	server.keepAliveMu.Unlock()
+	if n < 3 {
+		t.Errorf("expected keep-alives to be sent every second, got %d", n)
+	}
+
+	logs, _ := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+	if strings.Contains(string(logs), "disconnecting") {
+		t.Fatalf("tunnel was torn down: %s", logs)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// Test that the connection is torn down after ServerAliveCountMax
+// unanswered keep-alives
+func TestTunnelKeepAliveCountMax(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
 	defer cancel()
 
-	c, out, err := cliCommand(env, "open", "test")
+	c, out, err := cliCommand(env, "open", "test-keepalive-max")
 	if err != nil {
 		t.Fatalf("failed to run CLI command: %v", err)
 	}
 	if c != 0 {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
+
+	server.ignoreKeepAlives.Store(true)
+	defer server.ignoreKeepAlives.Store(false)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		logs, _ := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+		if strings.Contains(string(logs), "server not responding") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnel not torn down: %s", logs)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Test that an unanswered keep-alive marks SSH as down while the listener
+// still accepts
+func TestTunnelKeepAliveSSHDown(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test-keepalive"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("could not list tunnels: %v", err)
+	}
+	if d := r.Tunnels["test-keepalive"]; d.SSHDown || d.ListenerDown {
+		t.Fatalf("fresh tunnel not healthy: %+v", d)
+	}
+
+	server.ignoreKeepAlives.Store(true)
+	defer server.ignoreKeepAlives.Store(false)
+
+	deadline := time.Now().Add(3500 * time.Millisecond)
+	for {
+		r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err != nil {
+			t.Fatalf("could not list tunnels: %v", err)
+		}
+		d := r.Tunnels["test-keepalive"]
+		if d.SSHDown {
+			if d.ListenerDown {
+				t.Fatalf("listener reported down: %+v", d)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("SSH not reported down: %+v", d)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	c, out, _ := cliCommand(env, "list")
+	if c != 0 || !strings.Contains(stripANSI(out), "ssh-down") {
+		t.Fatalf("list does not show SSH down, exit code %d: %s", c, out)
+	}
+}
+
+// Test connecting to a server that presents an SSH host certificate,
+// trusted via an @cert-authority known_hosts entry.
+func TestTunnelHostCert(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_hostcert"
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !strings.Contains(strings.ToLower(out), "opened tunnel") {
+		t.Errorf("output did not indicate success: %s", out)
+	}
+}
+
+// Test SSH certificate authentication
+func TestTunnelCert(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_cert"
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+// Test sibling cert (*-cert.pub) fallback
+func TestTunnelSiblingCert(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_sibling_cert"
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+// Test that no cert is used if none is compatible
+func TestTunnelIgnoreWrongCert(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_wrong_cert"
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+// Test that the algorithms negotiated during the handshake are reported
+func TestTunnelNegotiated(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	n := r.Tunnels["test"].Negotiated
+	if n == nil {
+		t.Fatalf("no negotiated algorithms in response")
+	}
+	if n.KeyExchange == "" || n.HostKey == "" || n.Cipher == "" {
+		t.Errorf("incomplete negotiated algorithms: %+v", *n)
+	}
+	if !strings.HasPrefix(n.ServerVersion, "SSH-2.0-") {
+		t.Errorf("unexpected server version: %q", n.ServerVersion)
+	}
+
+	key, err := loadAuthorizedKey(authorizedKeyFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := ssh.FingerprintSHA256(key); !strings.HasSuffix(n.AuthKey, want) {
+		t.Errorf("expected auth key %s, got %q", want, n.AuthKey)
+	}
+}
+
+// Test that a tunnel without any activity is closed after its idle timeout
+func TestTunnelIdleTimeout(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-idle")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	// Activity resets the idle timer
+	time.Sleep(600 * time.Millisecond)
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	time.Sleep(600 * time.Millisecond)
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := r.Tunnels["test-idle"]; !ok {
+		t.Fatalf("tunnel closed despite activity")
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := r.Tunnels["test-idle"]; ok {
+		t.Fatalf("tunnel still running after idle timeout")
+	}
+}
+
+// Test that the banner sent on authentication is shown on open
+func TestTunnelBanner(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, _ := cliCommand(env, "open", "test")
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !strings.Contains(out, authBanner) {
+		t.Errorf("banner not shown: %s", out)
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b := r.Tunnels["test"].Banner; b != authBanner {
+		t.Errorf("got banner %q, want %q", b, authBanner)
+	}
+}
+
+// Test that a tunnel is closed after its TTL despite activity
+func TestTunnelTTL(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test-ttl"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if r.Tunnels["test-ttl"].Expires.IsZero() {
+		t.Errorf("expiry not set")
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	time.Sleep(900 * time.Millisecond)
+
+	r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, ok := r.Tunnels["test-ttl"]; ok {
+		t.Fatalf("tunnel still running after its TTL")
+	}
+}
+
+// Test that the remote target is resolved by the server, not locally
+func TestTunnelRemoteOnlyHost(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if _, err := net.LookupHost("db.remote-only.test"); err == nil {
+		t.Skip("remote-only host name resolves locally")
+	}
+
+	c, out, err := cliCommand(env, "open", "test-remote-host")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	testTunnel(t, "localhost:49711", "localhost:49712")
+}
+
+// Test that connections beyond a tunnel's limit are rejected
+func TestTunnelMaxConnections(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-limit")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+
+	// First connection takes the only slot, keep both of its ends open
+	conn1, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	if _, err := conn1.Write(testMsg); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	srv1, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept connection: %v", err)
+	}
+	defer srv1.Close()
+
+	// Second one is closed right away
+	conn2, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(connTimeout))
+	if _, err := conn2.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected connection beyond limit to be closed")
+	}
+
+	r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if d := r.Tunnels["test-limit"]; d.Conns != 1 || d.PeakConns != 1 {
+		t.Errorf("expected 1 current and peak connection, got %d, %d", d.Conns, d.PeakConns)
+	}
+
+	// Closing the first connection frees the slot
+	conn1.Close()
+	srv1.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		r, err = daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err == nil && r.Tunnels["test-limit"].Conns == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection slot not freed: %v, %+v", err, r)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	conn3, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn3.Close()
+	if err := testConnected(l, conn3); err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+}
+
+// Test that connections are only forwarded from addresses in allow_from
+// Forwarded connections are announced to the server as coming from the
+// client that connected to the tunnel, like ssh(1) does
+func TestTunnelOriginator(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, _ := cliCommand(env, "open", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("127.0.0.1:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+	if err := testConnected(l, conn); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	origin, _ := server.origins.Load("localhost:49712")
+	if want := conn.LocalAddr().String(); origin != want {
+		t.Errorf("expected originator %s, got %v", want, origin)
+	}
+}
+
+func TestTunnelAllowFrom(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-allow")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	if c, out, err = cliCommand(env, "close", "test-allow"); err != nil || c != 0 {
+		t.Fatalf("failed to close: %v, %s", err, out)
+	}
+	if c, out, err = cliCommand(env, "open", "test-deny"); err != nil || c != 0 {
+		t.Fatalf("failed to open: %v, %s", err, out)
+	}
+
+	l, err := makeListener("localhost:49712")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+	conn, err := dial("localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(connTimeout))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected connection from outside allow_from to be closed")
+	}
+}
+
+// Test that packets routed into the tun device are sent over the channel.
+// Needs privileges to create and configure the device.
+func TestTunnelTun(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("tun mode requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("ip command not available")
+	}
+
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-tun")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		if strings.Contains(out, "tun") {
+			t.Skipf("cannot create tun device: %s", out)
+		}
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	for _, args := range [][]string{
+		{"addr", "add", "10.231.0.1", "peer", "10.231.0.2", "dev", "tun7"},
+		{"link", "set", "tun7", "up"},
+	} {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			t.Skipf("cannot configure tun device: %v: %s", err, out)
+		}
+	}
+
+	conn, err := net.Dial("udp", "10.231.0.2:9999")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(testMsg); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The kernel may send other packets first, e.g., IPv6 router solicitations,
+	// and the channel does not preserve packet boundaries
+	var received []byte
+	timeout := time.After(connTimeout)
+	for !bytes.Contains(received, testMsg) {
+		select {
+		case data := <-server.tunData:
+			received = append(received, data...)
+		case <-timeout:
+			t.Fatalf("packet not received by server")
+		}
+	}
+}
+
+// Failures are told apart by the exit code, see cmd/boring/exit.go
+func TestOpenExitCodes(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	l, err := net.Listen("tcp", "localhost:49711")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer l.Close()
+
+	cases := []struct {
+		name string
+		code int
+	}{
+		{"test", 6},
+		{"test-bad-key", 4},
+		{"test-unknown-host", 5},
+		{"test-unreachable", 7},
+		{"test-jump-loop", 8},
+		{"doesnotexist", 2},
+	}
+	for _, c := range cases {
+		code, out, err := cliCommand(env, "open", c.name)
+		if err != nil {
+			t.Fatalf("failed to run CLI command: %v", err)
+		}
+		if code != c.code {
+			t.Errorf("%s: exit code %d, expected %d: %s", c.name, code, c.code, out)
+		}
+	}
+
+	// Different failures fall back to the generic code
+	code, out, _ := cliCommand(env, "open", "test", "test-bad-key")
+	if code != 1 {
+		t.Errorf("exit code %d, expected 1: %s", code, out)
+	}
+}
+
+// On demand, the SSH connection is only established once a connection is
+// accepted, and closed again when idle
+func TestTunnelOnDemand(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	standby := func() bool {
+		r, err := daemonCmd(env, daemon.Cmd{Kind: daemon.List})
+		if err != nil {
+			t.Fatalf("could not list tunnels: %v", err)
+		}
+		d, ok := r.Tunnels["test-on-demand"]
+		if !ok || d.Status != tunnel.Open {
+			t.Fatalf("tunnel not open: %+v", r.Tunnels)
+		}
+		return d.Standby
+	}
+
+	c, out, err := cliCommand(env, "open", "test-on-demand")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !standby() {
+		t.Fatalf("tunnel connected before first connection")
+	}
+
+	for i := range 2 {
+		testTunnel(t, "localhost:49711", "localhost:49712")
+		if standby() {
+			t.Fatalf("tunnel not connected after connection %d", i+1)
+		}
+		// Disconnects after the idle timeout, but keeps listening
+		deadline := time.Now().Add(5 * time.Second)
+		for !standby() {
+			if time.Now().After(deadline) {
+				t.Fatalf("tunnel did not disconnect when idle")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	c, out, _ = cliCommand(env, "close", "test-on-demand")
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if _, err := net.Dial("tcp", "localhost:49711"); err == nil {
+		t.Fatalf("listener still bound after closing")
+	}
+}
+
+// Test that a tunnel whose port is taken fails with a clear error, naming
+// the process holding it
+func TestTunnelPortInUse(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:49711")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+
+	c, out, err := cliCommand(env, "open", "test-manual")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 6 {
+		t.Fatalf("exit code %d, should be 6: %s", c, out)
+	}
+	want := "port 49711 already in use"
+	if runtime.GOOS == "linux" {
+		want += fmt.Sprintf(" by %s (PID %d)", filepath.Base(os.Args[0]), os.Getpid())
+	}
+	if !strings.Contains(out, want) {
+		t.Errorf("output does not contain %q: %s", want, out)
+	}
 }